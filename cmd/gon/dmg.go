@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/mitchellh/gon/internal/config"
+	"github.com/mitchellh/gon/package/dmg"
+)
+
+// dmgOptions builds the options for creating the dmg from the configuration.
+func dmgOptions(cfg *config.Config, logger hclog.Logger) (*dmg.Options, error) {
+	opts := &dmg.Options{
+		Files:         cfg.Source,
+		Root:          cfg.Dmg.Root,
+		OutputPath:    cfg.Dmg.OutputPath,
+		VolumeName:    cfg.Dmg.VolumeName,
+		Background:    cfg.Dmg.Background,
+		VolumeIcon:    cfg.Dmg.VolumeIcon,
+		IconSize:      cfg.Dmg.IconSize,
+		HideExtension: cfg.Dmg.HideExtension,
+		EULA:          cfg.Dmg.EULA,
+		Format:        cfg.Dmg.Format,
+		Filesystem:    cfg.Dmg.Filesystem,
+		Size:          cfg.Dmg.Size,
+		Logger:        logger.Named("dmg"),
+	}
+
+	switch mode := dmg.Mode(cfg.Dmg.Mode); mode {
+	case dmg.ModeAuto, dmg.ModeFinder, dmg.ModeHeadless:
+		opts.Mode = mode
+	default:
+		return nil, fmt.Errorf("dmg: `mode` must be \"finder\" or \"headless\", got %q", mode)
+	}
+
+	var err error
+	if opts.WindowPosition, err = dmgPoint("window_position", cfg.Dmg.WindowPosition); err != nil {
+		return nil, err
+	}
+	if opts.WindowSize, err = dmgPoint("window_size", cfg.Dmg.WindowSize); err != nil {
+		return nil, err
+	}
+	if opts.ApplicationsLink, err = dmgPoint("applications_link", cfg.Dmg.ApplicationsLink); err != nil {
+		return nil, err
+	}
+
+	for _, f := range cfg.Dmg.Files {
+		mode, err := packageFileMode("dmg", f)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.ExtraFiles = append(opts.ExtraFiles, dmg.File{
+			Src:  f.Src,
+			Dst:  f.Dst,
+			Mode: mode,
+		})
+	}
+
+	for _, icon := range cfg.Dmg.Icon {
+		opts.Icons = append(opts.Icons, dmg.Icon{
+			Name:  icon.Name,
+			Point: dmg.Point{X: icon.X, Y: icon.Y},
+		})
+	}
+
+	return opts, nil
+}
+
+// dmgPoint returns the point of a two-element list setting, or nil if
+// it isn't set. name is used for error messages.
+func dmgPoint(name string, v []int) (*dmg.Point, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if len(v) != 2 {
+		return nil, fmt.Errorf("dmg: `%s` must be a list of two numbers, got %d", name, len(v))
+	}
+
+	return &dmg.Point{X: v[0], Y: v[1]}, nil
+}
@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/fatih/color"
 	"github.com/hashicorp/go-hclog"
 
 	"github.com/mitchellh/gon/internal/config"
+	"github.com/mitchellh/gon/internal/filetype"
 	"github.com/mitchellh/gon/notarize"
+	"github.com/mitchellh/gon/preflight"
 	"github.com/mitchellh/gon/staple"
 )
 
@@ -22,8 +28,19 @@ type item struct {
 	BundleId string
 
 	// Staple is true if we should perform stapling on this file. Not
-	// all files support stapling so the default depends on the type of file.
-	Staple bool
+	// all files support stapling so if this is nil, the default depends on
+	// the type of file and is set by detect. For zip archives, the apps,
+	// installer packages, and disk images within the archive are stapled
+	// and the archive is recreated.
+	Staple *bool
+
+	// Kind is the type of the file, set by detect.
+	Kind filetype.Type
+
+	// Identity and TeamID are the signing identity the file was signed
+	// with by gon and its team ID, if known. These are for the manifest.
+	Identity string
+	TeamID   string
 
 	// state is the current state of this item.
 	State itemState
@@ -33,6 +50,7 @@ type item struct {
 type itemState struct {
 	Notarized     bool
 	NotarizeError error
+	RequestUUID   string
 
 	Stapled     bool
 	StapleError error
@@ -46,6 +64,10 @@ type processOptions struct {
 	// Prefix is the prefix string for output
 	Prefix string
 
+	// PreflightWarn downgrades preflight check failures to warnings so
+	// that files are submitted for notarization anyway.
+	PreflightWarn bool
+
 	// OutputLock protects access to the terminal output.
 	//
 	// UploadLock protects simultaneous notary submission.
@@ -53,6 +75,42 @@ type processOptions struct {
 	UploadLock *sync.Mutex
 }
 
+// detect detects the type of the file and sets the default for stapling.
+// An error is returned if the file can't be notarized or stapled, so that
+// this is caught before anything is uploaded.
+func (i *item) detect() error {
+	kind, err := filetype.Detect(i.Path)
+	if err != nil {
+		return err
+	}
+	if kind == filetype.MachO {
+		return fmt.Errorf("%s: bare Mach-O binaries can't be notarized; "+
+			"package the binary in a zip, dmg, or pkg first (see the `zip`, `dmg`, and `pkg` options)", i.Path)
+	}
+	i.Kind = kind
+
+	stapleable := kind.Stapleable()
+	if kind == filetype.Zip {
+		stapleable, err = filetype.ZipStapleable(i.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	if i.Staple == nil {
+		i.Staple = &stapleable
+	}
+	if *i.Staple && !stapleable {
+		if kind == filetype.Zip {
+			return fmt.Errorf("%s: `staple` is set but the zip archive has no app, pkg, or dmg to staple", i.Path)
+		}
+
+		return fmt.Errorf("%s: `staple` is set but files of type %q can't be stapled", i.Path, kind)
+	}
+
+	return nil
+}
+
 // notarize notarize & staples the item.
 func (i *item) notarize(ctx context.Context, opts *processOptions) error {
 	lock := opts.OutputLock
@@ -63,9 +121,33 @@ func (i *item) notarize(ctx context.Context, opts *processOptions) error {
 		bundleId = opts.Config.BundleId
 	}
 
+	// Check for problems that notarization will reject before we upload
+	if err := i.preflight(ctx, opts); err != nil {
+		i.State.NotarizeError = err
+		return err
+	}
+
+	// App bundles must be archived to be submitted. The app itself is
+	// stapled afterwards.
+	file := i.Path
+	if i.Kind == filetype.App {
+		td, err := ioutil.TempDir("", "gon-notarize-app")
+		if err != nil {
+			i.State.NotarizeError = err
+			return err
+		}
+		defer os.RemoveAll(td)
+
+		file, err = zipApp(ctx, i.Path, td, opts.Logger)
+		if err != nil {
+			i.State.NotarizeError = err
+			return err
+		}
+	}
+
 	// Start notarization
-	_, _, err := notarize.Notarize(ctx, &notarize.Options{
-		File:        i.Path,
+	info, _, err := notarize.Notarize(ctx, &notarize.Options{
+		File:        file,
 		DeveloperId: opts.Config.AppleId.Username,
 		Password:    opts.Config.AppleId.Password,
 		Provider:    opts.Config.AppleId.Provider,
@@ -77,6 +159,9 @@ func (i *item) notarize(ctx context.Context, opts *processOptions) error {
 	// Save the error state. We don't save the notarization result yet
 	// because we don't know it for sure until we retrieve the log information.
 	i.State.NotarizeError = err
+	if info != nil {
+		i.State.RequestUUID = info.RequestUUID
+	}
 
 	// If we had an error, we mention immediate we have an error.
 	if err != nil {
@@ -97,7 +182,7 @@ func (i *item) notarize(ctx context.Context, opts *processOptions) error {
 	lock.Unlock()
 
 	// If we aren't stapling we exit now
-	if !i.Staple {
+	if i.Staple == nil || !*i.Staple {
 		return nil
 	}
 
@@ -105,10 +190,14 @@ func (i *item) notarize(ctx context.Context, opts *processOptions) error {
 	lock.Lock()
 	color.New(color.Bold).Fprintf(os.Stdout, "    %sStapling...\n", opts.Prefix)
 	lock.Unlock()
-	err = staple.Staple(ctx, &staple.Options{
-		File:   i.Path,
-		Logger: opts.Logger.Named("staple"),
-	})
+	if i.Kind == filetype.Zip {
+		err = i.stapleZip(ctx, opts)
+	} else {
+		err = staple.Staple(ctx, &staple.Options{
+			File:   i.Path,
+			Logger: opts.Logger.Named("staple"),
+		})
+	}
 
 	// Save our state
 	i.State.Stapled = err == nil
@@ -128,6 +217,100 @@ func (i *item) notarize(ctx context.Context, opts *processOptions) error {
 	return nil
 }
 
+// stapleZip staples the notarization ticket to the apps, installer
+// packages, and disk images within a zip archive, since a zip itself can't
+// be stapled. The archive is extracted, each of these is stapled, and the
+// archive is recreated with the same layout.
+func (i *item) stapleZip(ctx context.Context, opts *processOptions) error {
+	return rezip(ctx, i.Path, opts.Logger, func(files []string) error {
+		// Find everything we can staple. We don't look inside of apps
+		// since their ticket covers everything within them.
+		var targets []string
+		for _, f := range files {
+			err := filepath.Walk(f, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+
+				switch strings.ToLower(filepath.Ext(path)) {
+				case ".app", ".pkg", ".dmg":
+					targets = append(targets, path)
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+				}
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(targets) == 0 {
+			return fmt.Errorf("%s: the zip archive has no app, pkg, or dmg to staple", i.Path)
+		}
+
+		for _, target := range targets {
+			err := staple.Staple(ctx, &staple.Options{
+				File:   target,
+				Logger: opts.Logger.Named("staple"),
+			})
+			if err != nil {
+				return fmt.Errorf("%s: error stapling %s: %s",
+					i.Path, filepath.Base(target), err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// preflight runs the preflight checks for the item and outputs any issues.
+// An error is returned if any check failed and failures aren't downgraded
+// to warnings.
+func (i *item) preflight(ctx context.Context, opts *processOptions) error {
+	lock := opts.OutputLock
+
+	issues, err := preflight.Check(ctx, &preflight.Options{
+		Path:   i.Path,
+		Logger: opts.Logger.Named("preflight"),
+	})
+	if err != nil {
+		lock.Lock()
+		color.New(color.FgRed).Fprintf(os.Stdout, "    %sError running preflight checks\n", opts.Prefix)
+		lock.Unlock()
+		return err
+	}
+
+	failed := preflight.Failed(issues)
+	if failed && opts.PreflightWarn {
+		for idx := range issues {
+			issues[idx].Severity = preflight.SeverityWarning
+		}
+		failed = false
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	for _, issue := range issues {
+		c := color.New(color.FgYellow)
+		if issue.Severity == preflight.SeverityError {
+			c = color.New(color.FgRed)
+		}
+
+		c.Fprintf(os.Stdout, "    %s%s: %s: %s\n", opts.Prefix, issue.Severity, issue.Path, issue.Message)
+	}
+
+	if failed {
+		color.New(color.FgRed).Fprintf(os.Stdout,
+			"    %sPreflight checks failed, not submitting for notarization\n", opts.Prefix)
+		return fmt.Errorf("%s: preflight checks failed; notarization would be rejected "+
+			"(run with -preflight-warn to submit anyway)", i.Path)
+	}
+
+	return nil
+}
+
 // String implements Stringer
 func (i *item) String() string {
 	result := i.Path
@@ -14,9 +14,13 @@ import (
 	"github.com/hashicorp/go-multierror"
 
 	"github.com/mitchellh/gon/internal/config"
+	"github.com/mitchellh/gon/keychain"
+	"github.com/mitchellh/gon/package/app"
 	"github.com/mitchellh/gon/package/dmg"
+	"github.com/mitchellh/gon/package/pkg"
 	"github.com/mitchellh/gon/package/zip"
 	"github.com/mitchellh/gon/sign"
+	"github.com/mitchellh/gon/universal"
 )
 
 // Set by build process
@@ -44,9 +48,12 @@ func realMain() int {
 
 	var logLevel string
 	var logJSON bool
+	var preflightWarn bool
 	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	flags.BoolVar(&logJSON, "log-json", false, "Output logs in JSON format for machine readability.")
 	flags.StringVar(&logLevel, "log-level", "", "Log level to output. Defaults to no logging.")
+	flags.BoolVar(&preflightWarn, "preflight-warn", false,
+		"Report preflight check failures as warnings and notarize anyway.")
 	flags.Parse(os.Args[1:])
 	args := flags.Args()
 
@@ -61,6 +68,11 @@ func realMain() int {
 		JSONFormat: logJSON,
 	})
 
+	// The verify subcommand verifies already signed files
+	if len(args) > 0 && args[0] == "verify" {
+		return verifyMain(args[1:], logger)
+	}
+
 	// We expect a configuration file
 	if len(args) != 1 {
 		fmt.Fprintf(os.Stdout, color.RedString("❗️ Path to configuration expected.\n\n"))
@@ -79,6 +91,12 @@ func realMain() int {
 	// request per file here.
 	var items []*item
 
+	// Files of notarize blocks with "sign" set are signed before notarizing
+	signNotarize := false
+	for _, c := range cfg.Notarize {
+		signNotarize = signNotarize || c.Sign
+	}
+
 	// A bunch of validation
 	if len(cfg.Source) > 0 {
 		if cfg.BundleId == "" {
@@ -98,6 +116,70 @@ func realMain() int {
 					"`sign` configuration to sign the input files.\n")
 			return 1
 		}
+
+		if cfg.Sign.Native && cfg.Keychain != nil {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `keychain` can't be used with native signing\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"Native signing reads the certificate from `pkcs12_file` in the `sign`\n"+
+					"block and doesn't use a keychain.\n")
+			return 1
+		}
+
+		if cfg.Pkg != nil && !cfg.Pkg.Native && cfg.Sign.InstallerIdentity == "" {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `installer_identity` in `sign` required with `pkg` set\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"Installer packages are signed with a \"Developer ID Installer\" certificate,\n"+
+					"which must be set as the `installer_identity` in the `sign` block.\n")
+			return 1
+		}
+
+		if cfg.Sign.Native && cfg.Pkg != nil && !cfg.Pkg.Native {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `pkg` requires `native = true` with native signing\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"Installer packages are built and signed with pkgbuild and productsign\n"+
+					"on macOS. Set `native = true` in the `pkg` block to build the package\n"+
+					"in pure Go instead.\n")
+			return 1
+		}
+
+		if cfg.Pkg != nil && cfg.Pkg.Native && cfg.Pkg.PKCS12File == "" {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `pkcs12_file` in `pkg` required with `native = true`\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"Native packages are signed with the \"Developer ID Installer\" certificate\n"+
+					"and private key in the `pkcs12_file` of the `pkg` block.\n")
+			return 1
+		}
+
+		if cfg.Pkg != nil && !cfg.Pkg.Native && cfg.Pkg.PKCS12File != "" {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `pkcs12_file` in `pkg` requires `native = true`\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"Packages built with pkgbuild are signed with the `installer_identity`\n"+
+					"of the `sign` block from the keychain.\n")
+			return 1
+		}
+
+		if cfg.Sign.Native && cfg.App != nil {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `app` can't be used with native signing\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"Native signing only supports Mach-O binaries, so the app bundle can't\n"+
+					"be signed. Sign with codesign on macOS instead.\n")
+			return 1
+		}
+
+		if cfg.Sign.Native && cfg.Dmg != nil {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `dmg` can't be used with native signing\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"Native signing only supports Mach-O binaries, so the dmg can't be signed.\n"+
+					"Use `zip` instead, or sign with codesign on macOS.\n")
+			return 1
+		}
 	} else {
 		if len(cfg.Notarize) == 0 {
 			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout, "❗️ No source files specified\n")
@@ -126,6 +208,103 @@ func realMain() int {
 					"source files specified, then there is nothing to package.\n")
 			return 1
 		}
+
+		if cfg.Pkg != nil {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `pkg` can only be set while `source` is also set\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"Pkg packaging is only supported when `source` is specified. This is\n"+
+					"because the `pkg` option packages the source files. If there are no\n"+
+					"source files specified, then there is nothing to package.\n")
+			return 1
+		}
+
+		if cfg.Universal != nil {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `universal` can only be set while `source` is also set\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"Universal binaries are created from the `source` files. If there are no\n"+
+					"source files specified, then there is nothing to merge.\n")
+			return 1
+		}
+
+		if cfg.App != nil {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `app` can only be set while `source` is also set\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"App bundles are created from the `source` files. If there are no\n"+
+					"source files specified, then there is nothing to bundle.\n")
+			return 1
+		}
+
+		if cfg.Keychain != nil && !signNotarize {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `keychain` can only be set while `source` is also set\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"The keychain is only used to sign the `source` files or the files of\n"+
+					"`notarize` blocks with `sign = true`. Otherwise, there is nothing to sign.\n")
+			return 1
+		}
+	}
+
+	if signNotarize {
+		if cfg.Sign == nil {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `sign` configuration required with `sign = true` in `notarize`\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"Files to notarize are signed with the identities in the `sign` block.\n")
+			return 1
+		}
+
+		if cfg.Sign.Native {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `sign = true` in `notarize` can't be used with native signing\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"Native signing only supports Mach-O binaries, so apps, disk images,\n"+
+					"installers, and zip archives must be signed with codesign on macOS.\n")
+			return 1
+		}
+	}
+
+	if cfg.Sign != nil {
+		if cfg.Sign.Keychain != "" && cfg.Keychain != nil {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `keychain` in `sign` can't be used with the `keychain` block\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"The `keychain` block creates a temporary keychain to sign with. Set\n"+
+					"`keychain` in the `sign` block only to sign with an existing keychain.\n")
+			return 1
+		}
+
+		if cfg.Sign.Timestamp == sign.TimestampNone &&
+			(cfg.Zip != nil || cfg.Dmg != nil || cfg.Pkg != nil || len(cfg.Notarize) > 0) {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `timestamp = \"none\"` can't be used when notarizing\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"Notarization requires a secure timestamp in the signature. Signing\n"+
+					"without a timestamp is only useful for local development builds, so\n"+
+					"remove the `zip`, `dmg`, `pkg`, and `notarize` configuration to use it.\n")
+			return 1
+		}
+
+		if ts := cfg.Sign.Timestamp; ts != "" && ts != sign.TimestampNone && signsInstaller(cfg) {
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+				"❗️ `timestamp` can't be a URL when signing an installer package\n")
+			color.New(color.FgRed).Fprintf(os.Stdout,
+				"Installer packages are signed with productsign, which only uses Apple's\n"+
+					"timestamp server. Remove `timestamp` from the `sign` block, or set\n"+
+					"`native = true` in the `pkg` block to sign the package natively.\n")
+			return 1
+		}
+	}
+
+	if cfg.Manifest != nil && cfg.Manifest.OutputPath == "" && cfg.Manifest.ChecksumsPath == "" {
+		color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+			"❗️ `output_path` or `checksums_path` required in `manifest`\n")
+		color.New(color.FgRed).Fprintf(os.Stdout,
+			"The `manifest` block writes a JSON manifest to `output_path` and a\n"+
+				"SHA256SUMS file to `checksums_path`. Set at least one of these.\n")
+		return 1
 	}
 
 	// Notarize is an alternative to "Source", where you specify
@@ -175,17 +354,127 @@ func realMain() int {
 		cfg.AppleId.Provider = os.Getenv("AC_PROVIDER")
 	}
 
+	// Merge per-architecture binaries into universal binaries. The
+	// merged binaries replace their inputs for the rest of the run.
+	if cfg.Universal != nil {
+		color.New(color.Bold).Fprintf(os.Stdout, "==> %s  Creating universal binaries...\n", iconPackage)
+		files, err := universal.Create(context.Background(), &universal.Options{
+			Files:     cfg.Source,
+			OutputDir: cfg.Universal.OutputDir,
+			Logger:    logger.Named("universal"),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stdout, color.RedString("❗️ Error creating universal binaries:\n\n%s\n", err))
+			return 1
+		}
+		inputs := map[string]struct{}{}
+		for _, f := range cfg.Source {
+			inputs[f] = struct{}{}
+		}
+		for _, f := range files {
+			if _, ok := inputs[f]; !ok {
+				color.New().Fprintf(os.Stdout, "    Universal binary created: %s\n", f)
+			}
+		}
+
+		// Per-file signing options of the merged binaries apply to the
+		// universal binary instead.
+		err = renameSignFiles(cfg.Sign, universalRenames(cfg.Source, files, cfg.Universal.OutputDir))
+		if err != nil {
+			fmt.Fprintf(os.Stdout, color.RedString("❗️ Error creating universal binaries:\n\n%s\n", err))
+			return 1
+		}
+
+		cfg.Source = files
+	}
+
+	// Wrap the binaries in an application bundle. The bundle replaces the
+	// binaries for the rest of the run.
+	if cfg.App != nil {
+		color.New(color.Bold).Fprintf(os.Stdout, "==> %s  Creating app bundle...\n", iconPackage)
+		err := app.Create(context.Background(), &app.Options{
+			Files:                cfg.Source,
+			OutputPath:           cfg.App.OutputPath,
+			BundleID:             cfg.BundleId,
+			Name:                 cfg.App.Name,
+			Executable:           cfg.App.Executable,
+			Version:              cfg.App.Version,
+			Build:                cfg.App.Build,
+			Icon:                 cfg.App.Icon,
+			Resources:            cfg.App.Resources,
+			MinimumSystemVersion: cfg.App.MinimumSystemVersion,
+			Agent:                cfg.App.Agent,
+			URLSchemes:           cfg.App.URLSchemes,
+			UsageDescriptions:    cfg.App.UsageDescriptions,
+			Logger:               logger.Named("app"),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stdout, color.RedString("❗️ Error creating app bundle:\n\n%s\n", err))
+			return 1
+		}
+		color.New().Fprintf(os.Stdout, "    App bundle created: %s\n", cfg.App.OutputPath)
+
+		// Per-file signing options of the binaries apply to their copies
+		// in the bundle instead.
+		rename := appRenames(cfg.Source, cfg.App.OutputPath, cfg.App.Executable)
+		if err := renameSignFiles(cfg.Sign, rename); err != nil {
+			fmt.Fprintf(os.Stdout, color.RedString("❗️ Error creating app bundle:\n\n%s\n", err))
+			return 1
+		}
+
+		cfg.Source = []string{cfg.App.OutputPath}
+	}
+
+	// Create the temporary keychain. This is deleted when we return,
+	// even if signing fails.
+	var keychainPath, identity, teamID string
+	if cfg.Keychain != nil {
+		color.New(color.Bold).Fprintf(os.Stdout, "==> %s  Creating keychain...\n", iconKeychain)
+		kc, err := keychain.Create(context.Background(), &keychain.Options{
+			PKCS12File:     cfg.Keychain.PKCS12File,
+			PKCS12Password: pkcs12Password(cfg.Keychain.PKCS12Password),
+			Path:           cfg.Keychain.Path,
+			Password:       cfg.Keychain.Password,
+			Logger:         logger.Named("keychain"),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stdout, color.RedString("❗️ Error creating keychain:\n\n%s\n", err))
+			return 1
+		}
+		defer func() {
+			if err := kc.Delete(context.Background()); err != nil {
+				fmt.Fprintf(os.Stdout, color.RedString("❗️ Error deleting keychain:\n\n%s\n", err))
+			}
+		}()
+		color.New().Fprintf(os.Stdout, "    Keychain created: %s\n", kc.Path)
+
+		keychainPath = kc.Path
+	} else if cfg.Sign != nil {
+		keychainPath = cfg.Sign.Keychain
+	}
+
 	// If we're in source mode, then sign & package as configured
 	if len(cfg.Source) > 0 {
 		if cfg.Sign != nil {
 			// Perform codesigning
 			color.New(color.Bold).Fprintf(os.Stdout, "==> %s  Signing files...\n", iconSign)
-			err = sign.Sign(context.Background(), &sign.Options{
-				Files:        cfg.Source,
-				Identity:     cfg.Sign.ApplicationIdentity,
-				Entitlements: cfg.Sign.EntitlementsFile,
-				Logger:       logger.Named("sign"),
-			})
+			signOpts, cleanup, err := signOptions(cfg, logger)
+			defer cleanup()
+			if err != nil {
+				fmt.Fprintf(os.Stdout, color.RedString("❗️ Error configuring signing:\n\n%s\n", err))
+				return 1
+			}
+			signOpts.Keychain = keychainPath
+
+			identity, teamID, err = resolveIdentity(cfg.Sign, keychainPath, logger)
+			if err != nil {
+				fmt.Fprintf(os.Stdout, color.RedString("❗️ Error finding signing identity:\n\n%s\n", err))
+				return 1
+			}
+			signOpts.Identity = identity
+			signOpts.TeamID = teamID
+
+			err = sign.Sign(context.Background(), signOpts)
 			if err != nil {
 				fmt.Fprintf(os.Stdout, color.RedString("❗️ Error signing files:\n\n%s\n", err))
 				return 1
@@ -196,43 +485,52 @@ func realMain() int {
 		// Create a zip
 		if cfg.Zip != nil {
 			color.New(color.Bold).Fprintf(os.Stdout, "==> %s  Creating Zip archive...\n", iconPackage)
-			err = zip.Zip(context.Background(), &zip.Options{
-				Files:      cfg.Source,
-				OutputPath: cfg.Zip.OutputPath,
-			})
+			opts, err := zipOptions(cfg, logger)
 			if err != nil {
 				fmt.Fprintf(os.Stdout, color.RedString("❗️ Error creating zip archive:\n\n%s\n", err))
 				return 1
 			}
+			if err := zip.Zip(context.Background(), opts); err != nil {
+				fmt.Fprintf(os.Stdout, color.RedString("❗️ Error creating zip archive:\n\n%s\n", err))
+				return 1
+			}
 			color.New(color.Bold, color.FgGreen).Fprintf(os.Stdout, "    Zip archive created with signed files\n")
 
 			// Queue to notarize
-			items = append(items, &item{Path: cfg.Zip.OutputPath})
+			it := &item{Path: cfg.Zip.OutputPath}
+			if cfg.Sign != nil {
+				it.Identity = cfg.Sign.ApplicationIdentity
+				it.TeamID = identityTeamID(it.Identity, teamID)
+			}
+			items = append(items, it)
 		}
 
 		// Create a dmg
 		if cfg.Dmg != nil && cfg.Sign != nil {
 			// First create the dmg itself. This passes in the signed files.
 			color.New(color.Bold).Fprintf(os.Stdout, "==> %s  Creating dmg...\n", iconPackage)
-			color.New().Fprintf(os.Stdout, "    This will open Finder windows momentarily.\n")
-			err = dmg.Dmg(context.Background(), &dmg.Options{
-				Files:      cfg.Source,
-				OutputPath: cfg.Dmg.OutputPath,
-				VolumeName: cfg.Dmg.VolumeName,
-				Logger:     logger.Named("dmg"),
-			})
+			opts, err := dmgOptions(cfg, logger)
 			if err != nil {
 				fmt.Fprintf(os.Stdout, color.RedString("❗️ Error creating dmg:\n\n%s\n", err))
 				return 1
 			}
+			if opts.ResolveMode() == dmg.ModeFinder {
+				color.New().Fprintf(os.Stdout, "    This will open Finder windows momentarily.\n")
+			}
+			if err := dmg.Dmg(context.Background(), opts); err != nil {
+				fmt.Fprintf(os.Stdout, color.RedString("❗️ Error creating dmg:\n\n%s\n", err))
+				return 1
+			}
 			color.New().Fprintf(os.Stdout, "    Dmg file created: %s\n", cfg.Dmg.OutputPath)
 
 			// Next we need to sign the actual DMG as well
 			color.New().Fprintf(os.Stdout, "    Signing dmg...\n")
 			err = sign.Sign(context.Background(), &sign.Options{
-				Files:    []string{cfg.Dmg.OutputPath},
-				Identity: cfg.Sign.ApplicationIdentity,
-				Logger:   logger.Named("dmg"),
+				Files:     []string{cfg.Dmg.OutputPath},
+				Identity:  identity,
+				Keychain:  keychainPath,
+				Timestamp: cfg.Sign.Timestamp,
+				Logger:    logger.Named("dmg"),
 			})
 			if err != nil {
 				fmt.Fprintf(os.Stdout, color.RedString("❗️ Error signing dmg:\n\n%s\n", err))
@@ -241,20 +539,119 @@ func realMain() int {
 			color.New(color.Bold, color.FgGreen).Fprintf(os.Stdout, "    Dmg created and signed\n")
 
 			// Queue to notarize
-			items = append(items, &item{Path: cfg.Dmg.OutputPath, Staple: true})
+			items = append(items, &item{
+				Path:     cfg.Dmg.OutputPath,
+				Identity: cfg.Sign.ApplicationIdentity,
+				TeamID:   identityTeamID(cfg.Sign.ApplicationIdentity, teamID),
+			})
+		}
+
+		// Create a pkg
+		if cfg.Pkg != nil && cfg.Sign != nil {
+			color.New(color.Bold).Fprintf(os.Stdout, "==> %s  Creating pkg...\n", iconPackage)
+			identifier := cfg.Pkg.Identifier
+			if identifier == "" {
+				identifier = cfg.BundleId
+			}
+			opts := &pkg.Options{
+				Files:           cfg.Source,
+				OutputPath:      cfg.Pkg.OutputPath,
+				Identifier:      identifier,
+				Version:         cfg.Pkg.Version,
+				InstallLocation: cfg.Pkg.InstallLocation,
+				Scripts:         cfg.Pkg.Scripts,
+				Product:         cfg.Pkg.Product,
+				Logger:          logger.Named("pkg"),
+			}
+			if err := nativePkgOptions(cfg, opts); err != nil {
+				fmt.Fprintf(os.Stdout, color.RedString("❗️ Error creating pkg:\n\n%s\n", err))
+				return 1
+			}
+			if err := pkg.Pkg(context.Background(), opts); err != nil {
+				fmt.Fprintf(os.Stdout, color.RedString("❗️ Error creating pkg:\n\n%s\n", err))
+				return 1
+			}
+			color.New().Fprintf(os.Stdout, "    Pkg file created: %s\n", cfg.Pkg.OutputPath)
+
+			// Installer packages are signed with the installer identity.
+			// Native packages are signed while they're built.
+			if !opts.Native {
+				color.New().Fprintf(os.Stdout, "    Signing pkg...\n")
+				err = sign.SignInstaller(context.Background(), &sign.InstallerOptions{
+					Path:      cfg.Pkg.OutputPath,
+					Identity:  cfg.Sign.InstallerIdentity,
+					Keychain:  keychainPath,
+					Timestamp: cfg.Sign.Timestamp,
+					Logger:    logger.Named("pkg"),
+				})
+				if err != nil {
+					fmt.Fprintf(os.Stdout, color.RedString("❗️ Error signing pkg:\n\n%s\n", err))
+					return 1
+				}
+			}
+			color.New(color.Bold, color.FgGreen).Fprintf(os.Stdout, "    Pkg created and signed\n")
+
+			// Queue to notarize
+			items = append(items, &item{
+				Path:     cfg.Pkg.OutputPath,
+				Identity: cfg.Sign.InstallerIdentity,
+				TeamID:   identityTeamID(cfg.Sign.InstallerIdentity, ""),
+			})
+		}
+	}
+
+	// Sign the files of notarize blocks with "sign" set
+	if signNotarize {
+		color.New(color.Bold).Fprintf(os.Stdout, "==> %s  Signing files to notarize...\n", iconSign)
+		if identity == "" {
+			identity, teamID, err = resolveIdentity(cfg.Sign, keychainPath, logger)
+			if err != nil {
+				fmt.Fprintf(os.Stdout, color.RedString("❗️ Error finding signing identity:\n\n%s\n", err))
+				return 1
+			}
 		}
+
+		// The items of notarize blocks are queued first, in order.
+		for idx, c := range cfg.Notarize {
+			if !c.Sign {
+				continue
+			}
+
+			name, err := signNotarizeFile(context.Background(), cfg.Sign, c.Path, identity, keychainPath, logger)
+			if err != nil {
+				fmt.Fprintf(os.Stdout, color.RedString("❗️ Error signing %s:\n\n%s\n", c.Path, err))
+				return 1
+			}
+			items[idx].Identity = name
+			if name == cfg.Sign.ApplicationIdentity {
+				items[idx].TeamID = identityTeamID(name, teamID)
+			} else {
+				items[idx].TeamID = identityTeamID(name, "")
+			}
+			color.New().Fprintf(os.Stdout, "    Signed: %s\n", c.Path)
+		}
+		color.New(color.Bold, color.FgGreen).Fprintf(os.Stdout, "    Code signing successful\n")
 	}
 
 	// If we have no items to notarize then its probably an error in the configuration.
 	if len(items) == 0 {
 		color.New(color.Bold, color.FgYellow).Fprintf(os.Stdout, "\n⚠️  No items to notarize\n")
 		color.New(color.FgYellow).Fprintf(os.Stdout,
-			"You must specify a 'notarize' section or a 'source' section plus a 'zip' or 'dmg' section "+
+			"You must specify a 'notarize' section or a 'source' section plus a 'zip', 'dmg', or 'pkg' section "+
 				"in your configuration to enable packaging and notarization. Without these sections, gon\n"+
 				"will only sign your input files in 'source'.\n")
 		return 0
 	}
 
+	// Detect the type of each file so that files that can't be notarized
+	// are rejected before anything is uploaded.
+	for _, f := range items {
+		if err := f.detect(); err != nil {
+			fmt.Fprintf(os.Stdout, color.RedString("❗️ Error notarizing:\n\n%s\n", err))
+			return 1
+		}
+	}
+
 	// Notarize
 	color.New(color.Bold).Fprintf(os.Stdout, "==> %s  Notarizing...\n", iconNotarize)
 	if len(items) > 1 {
@@ -277,11 +674,12 @@ func realMain() int {
 			defer wg.Done()
 
 			err := items[idx].notarize(context.Background(), &processOptions{
-				Config:     cfg,
-				Logger:     logger,
-				Prefix:     prefixes[idx],
-				OutputLock: &lock,
-				UploadLock: &uploadLock,
+				Config:        cfg,
+				Logger:        logger,
+				Prefix:        prefixes[idx],
+				PreflightWarn: preflightWarn,
+				OutputLock:    &lock,
+				UploadLock:    &uploadLock,
 			})
 
 			if err != nil {
@@ -301,6 +699,20 @@ func realMain() int {
 		return 1
 	}
 
+	// Write the checksums and metadata of the notarized files
+	if cfg.Manifest != nil {
+		color.New(color.Bold).Fprintf(os.Stdout, "==> %s  Writing manifest...\n", iconManifest)
+		if err := writeManifest(cfg.Manifest, items); err != nil {
+			fmt.Fprintf(os.Stdout, color.RedString("❗️ Error writing manifest:\n\n%s\n", err))
+			return 1
+		}
+		for _, path := range []string{cfg.Manifest.OutputPath, cfg.Manifest.ChecksumsPath} {
+			if path != "" {
+				color.New().Fprintf(os.Stdout, "    Written: %s\n", path)
+			}
+		}
+	}
+
 	// Success, output all the files that were notarized again to remind the user
 	color.New(color.Bold, color.FgGreen).Fprintf(os.Stdout, "\nNotarization complete! Notarized files:\n")
 	for _, f := range items {
@@ -319,12 +731,19 @@ const help = `
 gon signs, notarizes, and packages binaries for macOS.
 
 Usage: %[1]s [flags] CONFIG
+       %[1]s [flags] verify [-offline] PATH...
 
 A configuration file is required to use gon. If a "-" is specified, gon
 will attempt to read the configuration from stdin. Configuration is in HCL
 or JSON format. The JSON format makes it particularly easy to machine-generate
 the configuration and pass it into gon.
 
+The "verify" command verifies the code signature, Gatekeeper assessment,
+and stapled notarization ticket of already signed files and outputs a
+pass/fail report. This is useful as a final check in release pipelines.
+With -offline, Mach-O binaries are verified without any macOS tools so
+that this can run on any platform. Gatekeeper and stapling aren't checked.
+
 For example configurations as well as full help text, see the README on GitHub:
 http://github.com/mitchellh/gon
 
@@ -332,5 +751,8 @@ Flags:
 `
 
 const iconSign = `✏️`
+const iconKeychain = `🔑`
 const iconPackage = `📦`
 const iconNotarize = `🍎`
+const iconVerify = `🔍`
+const iconManifest = `📝`
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/mitchellh/gon/internal/config"
+	"github.com/mitchellh/gon/internal/manifest"
+)
+
+// writeManifest writes the JSON manifest and checksums of the notarized
+// items as configured. This must be called after the items are notarized
+// and stapled, since stapling modifies the files.
+func writeManifest(cfg *config.Manifest, items []*item) error {
+	var artifacts []*manifest.Artifact
+	for _, i := range items {
+		a, err := manifest.NewArtifact(i.Path)
+		if err != nil {
+			return err
+		}
+
+		a.Type = string(i.Kind)
+		a.NotarizationID = i.State.RequestUUID
+		a.Stapled = i.State.Stapled
+		a.SigningIdentity = i.Identity
+		a.TeamID = i.TeamID
+		artifacts = append(artifacts, a)
+	}
+
+	if cfg.OutputPath != "" {
+		if err := manifest.WriteJSON(cfg.OutputPath, artifacts); err != nil {
+			return err
+		}
+	}
+	if cfg.ChecksumsPath != "" {
+		if err := manifest.WriteChecksums(cfg.ChecksumsPath, artifacts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/mitchellh/gon/internal/config"
+	"github.com/mitchellh/gon/internal/fat"
+	"github.com/mitchellh/gon/internal/filetype"
+	"github.com/mitchellh/gon/keychain"
+	"github.com/mitchellh/gon/package/pkg"
+	"github.com/mitchellh/gon/sign"
+	"github.com/mitchellh/gon/universal"
+)
+
+// signOptions builds the options for signing the source files from the
+// configuration. The returned cleanup function must always be called,
+// even if an error is returned, to remove any temporary files.
+func signOptions(cfg *config.Config, logger hclog.Logger) (*sign.Options, func(), error) {
+	var tempFiles []string
+	cleanup := func() {
+		for _, f := range tempFiles {
+			os.Remove(f)
+		}
+	}
+
+	// ents returns the path to the entitlements to use given the file
+	// and inline settings, writing the inline settings to a temporary file
+	// if necessary. name is used for error messages.
+	ents := func(name, file string, raw map[string]interface{}) (string, error) {
+		if raw == nil {
+			return file, nil
+		}
+		if file != "" {
+			return "", fmt.Errorf(
+				"%s: only one of `entitlements_file` or `entitlements` may be set", name)
+		}
+
+		e := sign.Entitlements(raw)
+		warnings, err := e.Validate()
+		for _, w := range warnings {
+			color.New(color.FgYellow).Fprintf(os.Stdout, "    ⚠️  %s: %s\n", name, w)
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", name, err)
+		}
+
+		path, err := e.TempFile()
+		if err != nil {
+			return "", err
+		}
+		tempFiles = append(tempFiles, path)
+
+		logger.Debug("wrote inline entitlements", "name", name, "path", path)
+		return path, nil
+	}
+
+	raw, err := config.DecodeEntitlements(cfg.Sign.Entitlements)
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("sign: %s", err)
+	}
+	entPath, err := ents("sign", cfg.Sign.EntitlementsFile, raw)
+	if err != nil {
+		return nil, cleanup, err
+	}
+
+	fileOpts := map[string]*sign.FileOptions{}
+	for _, f := range cfg.Sign.File {
+		name := fmt.Sprintf("file %q", f.Path)
+		raw, err := config.DecodeEntitlements(f.Entitlements)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("%s: %s", name, err)
+		}
+		path, err := ents(name, f.EntitlementsFile, raw)
+		if err != nil {
+			return nil, cleanup, err
+		}
+
+		fileOpts[f.Path] = &sign.FileOptions{
+			Entitlements:   path,
+			Identifier:     f.Identifier,
+			RuntimeOptions: f.Options,
+			Requirements:   f.Requirements,
+		}
+	}
+
+	opts := &sign.Options{
+		Files:               cfg.Source,
+		Identity:            cfg.Sign.ApplicationIdentity,
+		Entitlements:        entPath,
+		Identifier:          cfg.Sign.Identifier,
+		Prefix:              cfg.Sign.Prefix,
+		Requirements:        cfg.Sign.Requirements,
+		RuntimeOptions:      cfg.Sign.RuntimeOptions,
+		PreserveMetadata:    cfg.Sign.PreserveMetadata,
+		Timestamp:           cfg.Sign.Timestamp,
+		Keychain:            cfg.Sign.Keychain,
+		FileOptions:         fileOpts,
+		ProvisioningProfile: cfg.Sign.ProvisioningProfile,
+		Concurrency:         cfg.Sign.Concurrency,
+		Native:              cfg.Sign.Native,
+		Logger:              logger.Named("sign"),
+	}
+	if err := nativeOptions(cfg.Sign, opts); err != nil {
+		return nil, cleanup, err
+	}
+
+	return opts, cleanup, nil
+}
+
+// nativeOptions sets the native signing options from the configuration.
+func nativeOptions(cfg *config.Sign, opts *sign.Options) error {
+	if !cfg.Native {
+		if cfg.PKCS12File != "" {
+			return fmt.Errorf("sign: `pkcs12_file` requires `native = true`")
+		}
+
+		return nil
+	}
+
+	if cfg.Keychain != "" {
+		return fmt.Errorf("sign: `keychain` can't be used with native signing")
+	}
+
+	if cfg.PKCS12File == "" {
+		if cfg.ApplicationIdentity != "-" {
+			return fmt.Errorf("sign: native signing requires `pkcs12_file`, " +
+				"or `application_identity = \"-\"` for ad-hoc signing")
+		}
+
+		return nil
+	}
+
+	cert, err := sign.LoadPKCS12(cfg.PKCS12File, pkcs12Password(cfg.PKCS12Password))
+	if err != nil {
+		return fmt.Errorf("sign: %s", err)
+	}
+	opts.Certificate = cert
+	return nil
+}
+
+// nativePkgOptions sets the options for building and signing the package
+// natively from the configuration. The signature is timestamped like the
+// signatures of the source files.
+func nativePkgOptions(cfg *config.Config, opts *pkg.Options) error {
+	if !cfg.Pkg.Native {
+		return nil
+	}
+
+	cert, err := sign.LoadPKCS12(cfg.Pkg.PKCS12File, pkcs12Password(cfg.Pkg.PKCS12Password))
+	if err != nil {
+		return fmt.Errorf("pkg: %s", err)
+	}
+	opts.Native = true
+	opts.Certificate = cert
+
+	switch cfg.Sign.Timestamp {
+	case "":
+		opts.TimestampURL = sign.AppleTimestampURL
+	case sign.TimestampNone:
+	default:
+		opts.TimestampURL = cfg.Sign.Timestamp
+	}
+
+	return nil
+}
+
+// pkcs12Password returns the password of a PKCS#12 file from the
+// configured value, which may be '@env:<name>'. An empty value reads
+// the PKCS12_PASSWORD environment variable.
+func pkcs12Password(v string) string {
+	if v == "" {
+		v = "@env:PKCS12_PASSWORD"
+	}
+	if strings.HasPrefix(v, "@env:") {
+		return os.Getenv(strings.TrimPrefix(v, "@env:"))
+	}
+
+	return v
+}
+
+// resolveIdentity finds the signing identity in the keychain so that a
+// wrong or expired identity is reported before signing. The result is the
+// SHA-1 hash of the identity, which is passed to codesign so that it signs
+// with exactly the identity we checked, and the team ID of the identity if
+// known. If the identity can't be looked up, such as when security isn't
+// available, the configured value is returned.
+func resolveIdentity(cfg *config.Sign, keychainPath string, logger hclog.Logger) (string, string, error) {
+	name := cfg.ApplicationIdentity
+	if cfg.Native || name == "-" {
+		return name, "", nil
+	}
+	if _, err := exec.LookPath("security"); err != nil {
+		logger.Warn("security not found, not checking the signing identity")
+		return name, "", nil
+	}
+
+	identities, err := keychain.FindIdentities(context.Background(), &keychain.IdentityOptions{
+		Keychain: keychainPath,
+		Logger:   logger.Named("keychain"),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	identity, err := keychain.ResolveIdentity(identities, name)
+	if err != nil {
+		return "", "", err
+	}
+	for _, w := range identity.Warnings() {
+		color.New(color.FgYellow).Fprintf(os.Stdout, "    ⚠️  %s\n", w)
+	}
+
+	logger.Info("resolved signing identity", "name", identity.Name, "sha1", identity.SHA1)
+	color.New().Fprintf(os.Stdout, "    Identity: %s\n", identity.Name)
+	return identity.SHA1, identity.TeamID, nil
+}
+
+// renameSignFiles updates the paths of the `file` blocks of the sign
+// configuration for source files that were replaced by another file, such
+// as by the universal or app steps. rename maps the old path of a source
+// file to its new path. Paths are compared cleaned.
+func renameSignFiles(cfg *config.Sign, rename map[string]string) error {
+	if cfg == nil {
+		return nil
+	}
+
+	clean := map[string]string{}
+	for from, to := range rename {
+		clean[filepath.Clean(from)] = to
+	}
+
+	renamed := map[string]string{}
+	for idx := range cfg.File {
+		f := &cfg.File[idx]
+		to, ok := clean[filepath.Clean(f.Path)]
+		if !ok {
+			continue
+		}
+		if other, ok := renamed[to]; ok {
+			return fmt.Errorf("file %q and file %q in `sign` both apply to %s, "+
+				"only one can be set", other, f.Path, to)
+		}
+
+		renamed[to] = f.Path
+		f.Path = to
+	}
+
+	return nil
+}
+
+// universalRenames returns the source files that were merged into the
+// universal binaries files, mapped to the path of their universal binary.
+func universalRenames(source, files []string, outputDir string) map[string]string {
+	outputs := map[string]struct{}{}
+	for _, f := range files {
+		outputs[f] = struct{}{}
+	}
+
+	result := map[string]string{}
+	for _, f := range source {
+		output := filepath.Join(outputDir, universal.Key(f))
+		if _, ok := outputs[output]; ok && output != f {
+			result[f] = output
+		}
+	}
+
+	return result
+}
+
+// appRenames returns the source files that were copied into the app bundle
+// at outputPath, mapped to the path their signing options apply to. The
+// main executable is signed as part of signing the bundle, so its options
+// apply to the bundle. The other files are signed in Contents/MacOS.
+func appRenames(source []string, outputPath, executable string) map[string]string {
+	if executable == "" && len(source) > 0 {
+		executable = filepath.Base(source[0])
+	}
+
+	result := map[string]string{}
+	for _, f := range source {
+		if filepath.Base(f) == executable {
+			result[f] = outputPath
+			continue
+		}
+
+		result[f] = filepath.Join(outputPath, "Contents", "MacOS", filepath.Base(f))
+	}
+
+	return result
+}
+
+// identityTeamID returns the team ID of a signing identity for the
+// manifest. This is the team ID resolved from the keychain if it's known,
+// otherwise it's taken from the name of the identity.
+func identityTeamID(name, resolved string) string {
+	if resolved != "" {
+		return resolved
+	}
+
+	return keychain.TeamID(name)
+}
+
+// signsInstaller returns true if an installer package is signed with
+// productsign: the pkg built from the source files, unless it's built
+// natively, or a pkg of a notarize block with `sign = true`.
+func signsInstaller(cfg *config.Config) bool {
+	if cfg.Pkg != nil && !cfg.Pkg.Native {
+		return true
+	}
+
+	for _, c := range cfg.Notarize {
+		if !c.Sign {
+			continue
+		}
+		if kind, err := filetype.Detect(c.Path); err == nil && kind == filetype.Pkg {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signNotarizeFile signs a file of a notarize block before it is submitted.
+// Installer packages are signed with productsign and the installer identity.
+// Everything else is signed with codesign and the application identity. For
+// zip archives, the code and installer packages within are signed the same
+// way and the archive is recreated. The name of the identity the file was
+// signed with is returned.
+func signNotarizeFile(ctx context.Context, cfg *config.Sign, path, identity, keychainPath string, logger hclog.Logger) (string, error) {
+	kind, err := filetype.Detect(path)
+	if err != nil {
+		return "", err
+	}
+	if !kind.Notarizable() {
+		return "", fmt.Errorf("%s: only apps, dmgs, pkgs, and zips can be signed for notarization", path)
+	}
+
+	signPkg := func(path string) error {
+		return sign.SignInstaller(ctx, &sign.InstallerOptions{
+			Path:      path,
+			Identity:  cfg.InstallerIdentity,
+			Keychain:  keychainPath,
+			Timestamp: cfg.Timestamp,
+			Logger:    logger.Named("sign"),
+		})
+	}
+	if kind == filetype.Pkg {
+		return cfg.InstallerIdentity, signPkg(path)
+	}
+
+	opts := &sign.Options{
+		Files:            []string{path},
+		Identity:         identity,
+		Keychain:         keychainPath,
+		Timestamp:        cfg.Timestamp,
+		Prefix:           cfg.Prefix,
+		RuntimeOptions:   cfg.RuntimeOptions,
+		PreserveMetadata: cfg.PreserveMetadata,
+		Concurrency:      cfg.Concurrency,
+		Logger:           logger.Named("sign"),
+	}
+	if kind != filetype.Zip {
+		return cfg.ApplicationIdentity, sign.Sign(ctx, opts)
+	}
+
+	// Sign the code and installer packages in the zip and archive them
+	// again. rezip keeps the layout, so an app in the zip is still under
+	// "Foo.app/" at the root.
+	result := cfg.ApplicationIdentity
+	return result, rezip(ctx, path, logger, func(files []string) error {
+		code, pkgs, err := zipSignTargets(files)
+		if err != nil {
+			return err
+		}
+		if len(code) == 0 && len(pkgs) == 0 {
+			return fmt.Errorf("%s: the zip archive has no code or installer packages to sign", path)
+		}
+
+		if len(code) > 0 {
+			opts.Files = code
+			if err := sign.Sign(ctx, opts); err != nil {
+				return err
+			}
+		} else {
+			result = cfg.InstallerIdentity
+		}
+		for _, p := range pkgs {
+			if err := signPkg(p); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// zipSignTargets returns the files extracted from a zip archive that must
+// be signed: bundles, Mach-O binaries, and disk images are signed with
+// codesign, and installer packages with productsign. Other directories are
+// searched for these, and any other files, such as a README, are skipped
+// since a signature of them would be stored in extended attributes that
+// the archive doesn't keep.
+func zipSignTargets(files []string) (code, pkgs []string, err error) {
+	for _, f := range files {
+		err := filepath.Walk(f, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case info.IsDir() && sign.IsBundle(path):
+				code = append(code, path)
+				return filepath.SkipDir
+
+			case !info.Mode().IsRegular():
+
+			case fat.IsMachOFile(path):
+				code = append(code, path)
+
+			default:
+				switch kind, _ := filetype.Detect(path); kind {
+				case filetype.Dmg:
+					code = append(code, path)
+				case filetype.Pkg:
+					pkgs = append(pkgs, path)
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return code, pkgs, nil
+}
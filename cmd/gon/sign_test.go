@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitchellh/gon/internal/config"
+	"github.com/mitchellh/gon/internal/dittotest"
+	"github.com/mitchellh/gon/internal/machotest"
+	"github.com/mitchellh/gon/package/app"
+	"github.com/mitchellh/gon/package/zip"
+	"github.com/mitchellh/gon/sign"
+)
+
+func TestAppRenames(t *testing.T) {
+	td := t.TempDir()
+	binary := machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64})
+	main := filepath.Join(td, "dist", "foo")
+	helper := filepath.Join(td, "dist", "foo-helper")
+	require.NoError(t, os.MkdirAll(filepath.Dir(main), 0755))
+	require.NoError(t, ioutil.WriteFile(main, binary, 0755))
+	require.NoError(t, ioutil.WriteFile(helper, binary, 0755))
+
+	output := filepath.Join(td, "Foo.app")
+	require.NoError(t, app.Create(context.Background(), &app.Options{
+		Files:      []string{helper, main},
+		OutputPath: output,
+		BundleID:   "com.example.foo",
+		Executable: "foo",
+	}))
+
+	cfg := &config.Config{
+		Source: []string{helper, main},
+		Sign: &config.Sign{
+			ApplicationIdentity: "foo",
+			File: []config.SignFile{
+				{Path: main, Identifier: "com.example.foo.main"},
+				{Path: helper, Identifier: "com.example.foo.helper"},
+			},
+		},
+	}
+	rename := appRenames(cfg.Source, output, "foo")
+	require.Equal(t, map[string]string{
+		main:   output,
+		helper: filepath.Join(output, "Contents", "MacOS", "foo-helper"),
+	}, rename)
+	require.NoError(t, renameSignFiles(cfg.Sign, rename))
+	cfg.Source = []string{output}
+
+	// The options of the main executable apply to the bundle, which is
+	// signed with it, and the helper is signed within the bundle.
+	opts, cleanup, err := signOptions(cfg, hclog.NewNullLogger())
+	defer cleanup()
+	require.NoError(t, err)
+	plan, err := sign.NewPlan(opts)
+	require.NoError(t, err)
+
+	identifiers := map[string]string{}
+	for _, c := range plan.Components {
+		identifiers[c.Path] = c.Identifier
+	}
+	require.Equal(t, map[string]string{
+		output: "com.example.foo.main",
+		filepath.Join(output, "Contents", "MacOS", "foo-helper"): "com.example.foo.helper",
+	}, identifiers)
+}
+
+func TestZipSignTargets(t *testing.T) {
+	dittotest.Install(t)
+
+	td := t.TempDir()
+	binary := machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64})
+	for path, data := range map[string][]byte{
+		"dist/foo":                        binary,
+		"dist/README":                     []byte("hello"),
+		"dist/lib/libfoo.dylib":           binary,
+		"dist/lib/notes.txt":              []byte("hello"),
+		"dist/Foo.app/Contents/MacOS/Foo": binary,
+		"dist/foo.pkg":                    []byte("xar!\x00\x1c\x00\x01"),
+	} {
+		path = filepath.Join(td, filepath.FromSlash(path))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, data, 0755))
+	}
+
+	var files []string
+	fis, err := ioutil.ReadDir(filepath.Join(td, "dist"))
+	require.NoError(t, err)
+	for _, fi := range fis {
+		files = append(files, filepath.Join(td, "dist", fi.Name()))
+	}
+	path := filepath.Join(td, "foo.zip")
+	require.NoError(t, zip.Zip(context.Background(), &zip.Options{
+		Files:      files,
+		OutputPath: path,
+	}))
+
+	// Only code and installer packages are signed. Plain directories are
+	// searched, but bundles are signed as a whole.
+	var code, pkgs []string
+	require.NoError(t, rezip(context.Background(), path, hclog.NewNullLogger(), func(files []string) error {
+		contents := filepath.Dir(files[0])
+		c, p, err := zipSignTargets(files)
+		for _, f := range c {
+			code = append(code, filepath.ToSlash(f[len(contents)+1:]))
+		}
+		for _, f := range p {
+			pkgs = append(pkgs, filepath.ToSlash(f[len(contents)+1:]))
+		}
+		return err
+	}))
+	require.Equal(t, []string{"Foo.app", "foo", "lib/libfoo.dylib"}, code)
+	require.Equal(t, []string{"foo.pkg"}, pkgs)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/mitchellh/gon/verify"
+)
+
+// verifyMain is the entrypoint for the "verify" subcommand. It verifies
+// each path and outputs a pass/fail report. The exit code is non-zero if any
+// path failed verification.
+func verifyMain(args []string, logger hclog.Logger) int {
+	var offline bool
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	flags.BoolVar(&offline, "offline", false,
+		"Verify Mach-O binaries in pure Go without macOS tools. This works on any platform.")
+	flags.Parse(args)
+	paths := flags.Args()
+
+	if len(paths) == 0 {
+		fmt.Fprintf(os.Stdout, color.RedString("❗️ At least one path to verify expected.\n"))
+		return 1
+	}
+
+	color.New(color.Bold).Fprintf(os.Stdout, "==> %s  Verifying...\n", iconVerify)
+
+	failed := 0
+	for _, path := range paths {
+		opts := &verify.Options{
+			Path:   path,
+			Logger: logger.Named("verify"),
+		}
+
+		var result *verify.Result
+		var err error
+		if offline {
+			result, err = verify.Offline(opts)
+		} else {
+			result, err = verify.Verify(context.Background(), opts)
+		}
+		if err != nil {
+			failed++
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout, "    ❌ %s\n", path)
+			color.New(color.FgRed).Fprintf(os.Stdout, "       Error: %s\n", err)
+			continue
+		}
+
+		if result.Passed() {
+			color.New(color.Bold, color.FgGreen).Fprintf(os.Stdout, "    ✅ %s\n", path)
+		} else {
+			failed++
+			color.New(color.Bold, color.FgRed).Fprintf(os.Stdout, "    ❌ %s\n", path)
+		}
+
+		if info := result.Info; info != nil {
+			identity := info.Identity()
+			if info.AdHoc {
+				identity = "ad-hoc"
+			}
+			color.New().Fprintf(os.Stdout, "       Identity:   %s\n", identity)
+			if info.TeamID != "" {
+				color.New().Fprintf(os.Stdout, "       Team ID:    %s\n", info.TeamID)
+			}
+			if len(info.FlagNames) > 0 {
+				color.New().Fprintf(os.Stdout, "       Flags:      %s\n", strings.Join(info.FlagNames, ","))
+			}
+			if !info.Timestamp.IsZero() {
+				color.New().Fprintf(os.Stdout, "       Timestamp:  %s\n", info.Timestamp)
+			}
+			if info.RuntimeVersion != "" {
+				color.New().Fprintf(os.Stdout, "       Runtime:    %s\n", info.RuntimeVersion)
+			}
+		}
+
+		cpus := make([]string, 0, len(result.CDHashes))
+		for cpu := range result.CDHashes {
+			cpus = append(cpus, cpu)
+		}
+		sort.Strings(cpus)
+		for _, cpu := range cpus {
+			color.New().Fprintf(os.Stdout, "       CDHash:     %s (%s)\n", result.CDHashes[cpu], cpu)
+		}
+
+		for _, c := range result.Checks {
+			switch {
+			case c.Skipped:
+				color.New().Fprintf(os.Stdout, "       - %s: skipped (%s)\n", c.Name, c.Output)
+
+			case c.Passed:
+				color.New(color.FgGreen).Fprintf(os.Stdout, "       ✓ %s: passed\n", c.Name)
+
+			default:
+				color.New(color.FgRed).Fprintf(os.Stdout, "       ✗ %s: failed\n", c.Name)
+				for _, line := range strings.Split(c.Output, "\n") {
+					color.New(color.FgRed).Fprintf(os.Stdout, "         %s\n", line)
+				}
+			}
+		}
+	}
+
+	if failed > 0 {
+		color.New(color.Bold, color.FgRed).Fprintf(os.Stdout,
+			"\n❗️ %d of %d files failed verification\n", failed, len(paths))
+		return 1
+	}
+
+	color.New(color.Bold, color.FgGreen).Fprintf(os.Stdout, "\nVerification complete! All files passed.\n")
+	return 0
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/mitchellh/gon/internal/config"
+	"github.com/mitchellh/gon/package/zip"
+)
+
+// zipOptions builds the options for creating the zip from the configuration.
+func zipOptions(cfg *config.Config, logger hclog.Logger) (*zip.Options, error) {
+	opts := &zip.Options{
+		Files:      cfg.Source,
+		OutputPath: cfg.Zip.OutputPath,
+		Logger:     logger.Named("zip"),
+	}
+
+	for _, f := range cfg.Zip.Files {
+		mode, err := packageFileMode("zip", f)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.ExtraFiles = append(opts.ExtraFiles, zip.File{
+			Src:  f.Src,
+			Dst:  f.Dst,
+			Mode: mode,
+		})
+	}
+
+	return opts, nil
+}
+
+// packageFileMode parses the octal mode of an additional package file.
+// block is the name of the package block, used for error messages.
+func packageFileMode(block string, f config.PackageFile) (os.FileMode, error) {
+	if f.Mode == "" {
+		return 0, nil
+	}
+
+	mode, err := strconv.ParseUint(f.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("%s: `mode` of %q must be octal permissions such as \"0644\", got %q",
+			block, f.Dst, f.Mode)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// zipApp archives the app bundle at path into dir for submission to the
+// notary service and returns the path to the archive. The archive has the
+// bundle directory at its root, such as "Foo.app/", like an archive created
+// with `ditto -c -k --keepParent`.
+func zipApp(ctx context.Context, path, dir string, logger hclog.Logger) (string, error) {
+	result := filepath.Join(dir, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))+".zip")
+	err := zip.Zip(ctx, &zip.Options{
+		Files:      []string{path},
+		OutputPath: result,
+		Logger:     logger.Named("zip"),
+	})
+
+	return result, err
+}
+
+// rezip extracts the zip archive at path to a temporary directory, calls f
+// with the top-level files of the archive, and then archives them again
+// with the same layout. The original is only replaced if everything
+// succeeds, so it's intact if anything fails.
+func rezip(ctx context.Context, path string, logger hclog.Logger, f func([]string) error) error {
+	td, err := ioutil.TempDir("", "gon-rezip")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(td)
+
+	contents := filepath.Join(td, "contents")
+	err = zip.Extract(ctx, &zip.ExtractOptions{
+		Path:      path,
+		OutputDir: contents,
+		Logger:    logger.Named("zip"),
+	})
+	if err != nil {
+		return err
+	}
+
+	fis, err := ioutil.ReadDir(contents)
+	if err != nil {
+		return err
+	}
+	var files []string
+	for _, fi := range fis {
+		files = append(files, filepath.Join(contents, fi.Name()))
+	}
+	if err := f(files); err != nil {
+		return err
+	}
+
+	output := filepath.Join(td, filepath.Base(path))
+	err = zip.Zip(ctx, &zip.Options{
+		Files:      files,
+		OutputPath: output,
+		Logger:     logger.Named("zip"),
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
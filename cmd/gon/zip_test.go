@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitchellh/gon/internal/dittotest"
+	"github.com/mitchellh/gon/package/zip"
+)
+
+func TestMain(m *testing.M) {
+	dittotest.Main()
+	os.Exit(m.Run())
+}
+
+func TestRezip(t *testing.T) {
+	dittotest.Install(t)
+
+	td := t.TempDir()
+	for path, mode := range map[string]os.FileMode{
+		"Foo.app/Contents/Info.plist":      0644,
+		"Foo.app/Contents/MacOS/foo":       0755,
+		"Foo.app/Contents/Resources/a.txt": 0644,
+		"bar":                              0755,
+	} {
+		path = filepath.Join(td, filepath.FromSlash(path))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte("hello"), mode))
+	}
+
+	path := filepath.Join(td, "foo.zip")
+	require.NoError(t, zip.Zip(context.Background(), &zip.Options{
+		Files:      []string{filepath.Join(td, "Foo.app"), filepath.Join(td, "bar")},
+		OutputPath: path,
+	}))
+	expected := dittotest.Entries(t, path)
+	require.Contains(t, expected, "Foo.app/Contents/MacOS/foo")
+
+	// Rezipping without changes, like stapling a zip with nothing to
+	// staple, must keep the layout of the archive.
+	var names []string
+	require.NoError(t, rezip(context.Background(), path, hclog.NewNullLogger(), func(files []string) error {
+		for _, f := range files {
+			names = append(names, filepath.Base(f))
+		}
+		return nil
+	}))
+	require.Equal(t, []string{"Foo.app", "bar"}, names)
+	require.Equal(t, expected, dittotest.Entries(t, path))
+}
+
+func TestZipApp(t *testing.T) {
+	dittotest.Install(t)
+
+	td := t.TempDir()
+	path := filepath.Join(td, "Foo.app", "Contents", "MacOS", "foo")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte("hello"), 0755))
+
+	output := t.TempDir()
+	result, err := zipApp(context.Background(), filepath.Join(td, "Foo.app"), output, hclog.NewNullLogger())
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(output, "Foo.zip"), result)
+	require.Equal(t, []string{
+		"Foo.app/",
+		"Foo.app/Contents/",
+		"Foo.app/Contents/MacOS/",
+		"Foo.app/Contents/MacOS/foo",
+	}, dittotest.Entries(t, result))
+}
@@ -0,0 +1,311 @@
+package codesig
+
+import (
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Magic numbers of the blobs within a code signature.
+const (
+	magicRequirements    = 0xfade0c01
+	magicCodeDirectory   = 0xfade0c02
+	magicEmbeddedSig     = 0xfade0cc0
+	magicEntitlements    = 0xfade7171
+	magicEntitlementsDER = 0xfade7172
+	magicBlobWrapper     = 0xfade0b01
+)
+
+// Slots of the blobs within the SuperBlob. Slots 1 through 7 are also
+// the special slots of the CodeDirectory that hold the hashes of the
+// corresponding blob.
+const (
+	slotCodeDirectory   = 0
+	slotInfoPlist       = 1
+	slotRequirements    = 2
+	slotResources       = 3
+	slotEntitlements    = 5
+	slotEntitlementsDER = 7
+
+	slotAlternateCodeDirectories    = 0x1000
+	slotAlternateCodeDirectoriesEnd = 0x1005
+	slotSignature                   = 0x10000
+)
+
+// Hash types of a CodeDirectory.
+const (
+	hashTypeSHA1      = 1
+	hashTypeSHA256    = 2
+	hashTypeSHA256_20 = 3
+	hashTypeSHA384    = 4
+)
+
+// CodeDirectory flags.
+const (
+	FlagAdHoc        = 0x00000002
+	FlagRuntime      = 0x00010000
+	FlagLinkerSigned = 0x00020000
+)
+
+// flagNames are the names of the CodeDirectory flags as reported by codesign.
+var flagNames = []struct {
+	Flag uint32
+	Name string
+}{
+	{0x00000001, "host"},
+	{FlagAdHoc, "adhoc"},
+	{0x00000100, "hard"},
+	{0x00000200, "kill"},
+	{0x00000400, "expires"},
+	{0x00000800, "restrict"},
+	{0x00001000, "enforcement"},
+	{0x00002000, "library-validation"},
+	{FlagRuntime, "runtime"},
+	{FlagLinkerSigned, "linker-signed"},
+}
+
+// CodeDirectory is a parsed CodeDirectory blob. The CodeDirectory holds
+// the hashes of every page of the code and of the other blobs of the
+// signature. It is the data that is actually signed.
+type CodeDirectory struct {
+	// Version is the version of the CodeDirectory format, such as 0x20500.
+	Version uint32
+
+	// Flags are the code signing flags, such as FlagRuntime.
+	Flags uint32
+
+	// Identifier is the signing identifier, such as "com.example.app".
+	Identifier string
+
+	// TeamID is the team identifier. This is empty for ad-hoc signatures.
+	TeamID string
+
+	// HashType is the hash algorithm used for all hashes in the directory.
+	HashType crypto.Hash
+
+	// PageSize is the size of each page of code that is hashed. If this
+	// is zero then the code is hashed as a single page.
+	PageSize int
+
+	// CodeLimit is the length of the code that is hashed.
+	CodeLimit int64
+
+	// RuntimeVersion is the SDK version the hardened runtime was enabled
+	// with, encoded as 0xMMmmpp. This is zero if not set.
+	RuntimeVersion uint32
+
+	// SpecialSlots are the hashes of the other blobs, indexed by slot
+	// number. Index zero is unused.
+	SpecialSlots [][]byte
+
+	// CodeSlots are the hashes of each page of code.
+	CodeSlots [][]byte
+
+	// Raw is the raw blob, including the header.
+	Raw []byte
+
+	// hashSize is the size of each hash, which may be truncated.
+	hashSize int
+}
+
+// FlagNames returns the human-friendly names of the set flags.
+func (cd *CodeDirectory) FlagNames() []string {
+	var result []string
+	for _, f := range flagNames {
+		if cd.Flags&f.Flag != 0 {
+			result = append(result, f.Name)
+		}
+	}
+
+	return result
+}
+
+// CDHash returns the code directory hash, which uniquely identifies the
+// signed code. This is the hash of the raw CodeDirectory truncated to
+// 20 bytes, matching the value reported by codesign.
+func (cd *CodeDirectory) CDHash() []byte {
+	h := cd.HashType.New()
+	h.Write(cd.Raw)
+	return h.Sum(nil)[:20]
+}
+
+// RuntimeVersionString returns the hardened runtime version as a dotted
+// string such as "10.15.0" or an empty string if it isn't set.
+func (cd *CodeDirectory) RuntimeVersionString() string {
+	return versionString(cd.RuntimeVersion)
+}
+
+// hash returns the hash of data using the hash type of the CodeDirectory,
+// truncated to the hash size.
+func (cd *CodeDirectory) hash(data []byte) []byte {
+	h := cd.HashType.New()
+	h.Write(data)
+	return h.Sum(nil)[:cd.hashSize]
+}
+
+// parseCodeDirectory parses a CodeDirectory blob.
+func parseCodeDirectory(blob []byte) (*CodeDirectory, error) {
+	if len(blob) < 44 {
+		return nil, errors.New("code directory is truncated")
+	}
+
+	be := binary.BigEndian
+	cd := &CodeDirectory{
+		Version:   be.Uint32(blob[8:]),
+		Flags:     be.Uint32(blob[12:]),
+		CodeLimit: int64(be.Uint32(blob[32:])),
+		hashSize:  int(blob[36]),
+		Raw:       blob,
+	}
+
+	hashOffset := int(be.Uint32(blob[16:]))
+	identOffset := int(be.Uint32(blob[20:]))
+	nSpecialSlots := int(be.Uint32(blob[24:]))
+	nCodeSlots := int(be.Uint32(blob[28:]))
+
+	switch blob[37] {
+	case hashTypeSHA1:
+		cd.HashType = crypto.SHA1
+	case hashTypeSHA256, hashTypeSHA256_20:
+		cd.HashType = crypto.SHA256
+	case hashTypeSHA384:
+		cd.HashType = crypto.SHA384
+	default:
+		return nil, fmt.Errorf("unknown code directory hash type %d", blob[37])
+	}
+	if cd.hashSize == 0 || cd.hashSize > cd.HashType.Size() {
+		return nil, fmt.Errorf("invalid code directory hash size %d", cd.hashSize)
+	}
+
+	if blob[39] > 0 {
+		cd.PageSize = 1 << blob[39]
+	}
+
+	var err error
+	cd.Identifier, err = cString(blob, identOffset)
+	if err != nil {
+		return nil, fmt.Errorf("error reading identifier: %s", err)
+	}
+
+	if cd.Version >= 0x20200 && len(blob) >= 52 {
+		if off := int(be.Uint32(blob[48:])); off != 0 {
+			cd.TeamID, err = cString(blob, off)
+			if err != nil {
+				return nil, fmt.Errorf("error reading team identifier: %s", err)
+			}
+		}
+	}
+
+	if cd.Version >= 0x20300 && len(blob) >= 64 {
+		if limit := be.Uint64(blob[56:]); limit != 0 {
+			cd.CodeLimit = int64(limit)
+		}
+	}
+
+	if cd.Version >= 0x20500 && len(blob) >= 92 {
+		cd.RuntimeVersion = be.Uint32(blob[88:])
+	}
+
+	// Read the hashes. Special slots are stored in reverse order before
+	// the hash offset.
+	start := hashOffset - nSpecialSlots*cd.hashSize
+	end := hashOffset + nCodeSlots*cd.hashSize
+	if start < 0 || end > len(blob) || start > hashOffset {
+		return nil, errors.New("code directory hashes are out of bounds")
+	}
+
+	cd.SpecialSlots = make([][]byte, nSpecialSlots+1)
+	for i := 1; i <= nSpecialSlots; i++ {
+		off := hashOffset - i*cd.hashSize
+		cd.SpecialSlots[i] = blob[off : off+cd.hashSize]
+	}
+
+	cd.CodeSlots = make([][]byte, nCodeSlots)
+	for i := range cd.CodeSlots {
+		off := hashOffset + i*cd.hashSize
+		cd.CodeSlots[i] = blob[off : off+cd.hashSize]
+	}
+
+	return cd, nil
+}
+
+// superBlob is a parsed embedded signature SuperBlob. The key is the slot
+// and the value is the raw blob, including its header.
+type superBlob map[uint32][]byte
+
+// parseSuperBlob parses the embedded signature SuperBlob at the start of data.
+func parseSuperBlob(data []byte) (superBlob, error) {
+	be := binary.BigEndian
+	if len(data) < 12 {
+		return nil, errors.New("code signature is truncated")
+	}
+	if magic := be.Uint32(data); magic != magicEmbeddedSig {
+		return nil, fmt.Errorf("unknown code signature magic 0x%x", magic)
+	}
+
+	length := int(be.Uint32(data[4:]))
+	count := int(be.Uint32(data[8:]))
+	if length > len(data) || 12+count*8 > length {
+		return nil, errors.New("code signature is truncated")
+	}
+	data = data[:length]
+
+	result := make(superBlob, count)
+	for i := 0; i < count; i++ {
+		idx := data[12+i*8:]
+		slot := be.Uint32(idx)
+		off := int(be.Uint32(idx[4:]))
+		if off+8 > len(data) {
+			return nil, fmt.Errorf("code signature blob %d is out of bounds", slot)
+		}
+
+		blobLen := int(be.Uint32(data[off+4:]))
+		if blobLen < 8 || off+blobLen > len(data) {
+			return nil, fmt.Errorf("code signature blob %d is out of bounds", slot)
+		}
+
+		result[slot] = data[off : off+blobLen]
+	}
+
+	return result, nil
+}
+
+// payload returns the data of the blob in the given slot without the
+// header, verifying the magic. The result is nil if the slot is empty.
+func (sb superBlob) payload(slot, magic uint32) ([]byte, error) {
+	blob, ok := sb[slot]
+	if !ok {
+		return nil, nil
+	}
+
+	if m := binary.BigEndian.Uint32(blob); m != magic {
+		return nil, fmt.Errorf("code signature blob %d has unexpected magic 0x%x", slot, m)
+	}
+
+	return blob[8:], nil
+}
+
+// cString reads a NUL-terminated string at the given offset.
+func cString(data []byte, off int) (string, error) {
+	if off < 0 || off >= len(data) {
+		return "", errors.New("offset out of bounds")
+	}
+
+	for i := off; i < len(data); i++ {
+		if data[i] == 0 {
+			return string(data[off:i]), nil
+		}
+	}
+
+	return "", errors.New("string is not terminated")
+}
+
+// versionString formats a version encoded as 0xMMMMmmpp.
+func versionString(v uint32) string {
+	if v == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%d.%d.%d", v>>16, (v>>8)&0xff, v&0xff)
+}
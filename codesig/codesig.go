@@ -0,0 +1,422 @@
+// Package codesig reads and verifies the embedded code signatures of
+// Mach-O binaries.
+//
+// Unlike the verify package, this is implemented in pure Go and doesn't
+// require `codesign`, so it can be used on any platform. It can't perform
+// a Gatekeeper assessment or check the notarization status of a file.
+package codesig
+
+import (
+	"bytes"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"debug/macho"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"howett.net/plist"
+
+	"github.com/mitchellh/gon/internal/cms"
+	"github.com/mitchellh/gon/internal/fat"
+)
+
+// ErrNotMachO is returned when the file to open isn't a Mach-O binary.
+var ErrNotMachO = errors.New("file is not a Mach-O binary")
+
+// loadCmdCodeSignature is LC_CODE_SIGNATURE, which isn't defined by
+// debug/macho.
+const loadCmdCodeSignature macho.LoadCmd = 0x1d
+
+// Load commands that contain the SDK version, which also aren't defined
+// by debug/macho.
+const (
+	loadCmdVersionMinMacOS macho.LoadCmd = 0x24
+	loadCmdBuildVersion    macho.LoadCmd = 0x32
+)
+
+// File is a thin or universal (fat) Mach-O binary.
+type File struct {
+	// Universal is true if the file is a universal binary.
+	Universal bool
+
+	// Arches are the architectures in the file. A thin binary has
+	// exactly one architecture.
+	Arches []*Arch
+}
+
+// Arch is a single architecture of a Mach-O binary.
+type Arch struct {
+	// CPU is the name of the CPU, such as "x86_64" or "arm64".
+	CPU string
+
+	// Type is the Mach-O file type, such as macho.TypeExec.
+	Type macho.Type
+
+	// SDK is the version of the macOS SDK the architecture was built
+	// with, encoded as 0xMMMMmmpp. This is zero if it isn't known.
+	SDK uint32
+
+	// Offset and Size are the location of the architecture within the file.
+	Offset int64
+	Size   int64
+
+	// Signature is the code signature. This is nil if the architecture
+	// is unsigned.
+	Signature *Signature
+
+	// data is the contents of the architecture.
+	data []byte
+}
+
+// Signature is the embedded code signature of a single architecture.
+type Signature struct {
+	// CodeDirectory is the primary CodeDirectory of the signature, which
+	// is the one with the strongest hash. CodeDirectories are all the code
+	// directories in the signature, starting with the one in the code
+	// directory slot. Signatures usually have a SHA-1 and SHA-256 directory.
+	CodeDirectory   *CodeDirectory
+	CodeDirectories []*CodeDirectory
+
+	// Requirements is the raw requirements blob, including its header.
+	Requirements []byte
+
+	// Entitlements is the XML plist of the embedded entitlements, and
+	// EntitlementsDER are the DER-encoded entitlements. Either may be nil.
+	Entitlements    []byte
+	EntitlementsDER []byte
+
+	// CMS is the raw CMS signature. This is empty for ad-hoc signatures.
+	CMS []byte
+
+	// SignedData is the parsed CMS signature. This is nil for ad-hoc
+	// signatures.
+	SignedData *cms.SignedData
+
+	// Certificates is the certificate chain of the signer, starting with
+	// the signing certificate.
+	Certificates []*x509.Certificate
+
+	// Timestamp is the secure timestamp of the signature. This is zero
+	// if there is no secure timestamp.
+	Timestamp time.Time
+
+	// SigningTime is the (insecure) signing time claimed by the signer.
+	SigningTime time.Time
+
+	// blobs are all the blobs of the signature, keyed by slot.
+	blobs superBlob
+}
+
+// Identifier returns the signing identifier.
+func (s *Signature) Identifier() string {
+	return s.CodeDirectory.Identifier
+}
+
+// TeamID returns the team identifier of the signature.
+func (s *Signature) TeamID() string {
+	return s.CodeDirectory.TeamID
+}
+
+// Flags returns the code signing flags of the signature.
+func (s *Signature) Flags() uint32 {
+	return s.CodeDirectory.Flags
+}
+
+// AdHoc returns true if this is an ad-hoc signature, which has no
+// signing certificate.
+func (s *Signature) AdHoc() bool {
+	return s.CodeDirectory.Flags&FlagAdHoc != 0
+}
+
+// Runtime returns true if the hardened runtime is enabled.
+func (s *Signature) Runtime() bool {
+	return s.CodeDirectory.Flags&FlagRuntime != 0
+}
+
+// SDKString returns the SDK version as a dotted string such as "10.15.0"
+// or an empty string if it isn't known.
+func (a *Arch) SDKString() string {
+	return versionString(a.SDK)
+}
+
+// CDHash returns the hex-encoded code directory hash of the primary
+// CodeDirectory, matching the CDHash reported by `codesign -dvvv`.
+func (s *Signature) CDHash() string {
+	return hex.EncodeToString(s.CodeDirectory.CDHash())
+}
+
+// Identity returns the common name of the signing certificate or an
+// empty string if there is none.
+func (s *Signature) Identity() string {
+	if len(s.Certificates) == 0 {
+		return ""
+	}
+
+	return s.Certificates[0].Subject.CommonName
+}
+
+// ParsedEntitlements returns the embedded entitlements as a map. The
+// result is nil if there are no entitlements.
+func (s *Signature) ParsedEntitlements() (map[string]interface{}, error) {
+	if len(s.Entitlements) == 0 {
+		return nil, nil
+	}
+
+	var result map[string]interface{}
+	if _, err := plist.Unmarshal(s.Entitlements, &result); err != nil {
+		return nil, fmt.Errorf("error parsing entitlements: %s", err)
+	}
+
+	return result, nil
+}
+
+// Open opens the Mach-O binary at the given path and reads its signatures.
+func Open(path string) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFile(data)
+}
+
+// NewFile reads the signatures of the Mach-O binary with the given contents.
+// ErrNotMachO is returned if data isn't a Mach-O binary.
+func NewFile(data []byte) (*File, error) {
+	if !fat.IsMachO(data) {
+		return nil, ErrNotMachO
+	}
+
+	r := bytes.NewReader(data)
+
+	ff, err := macho.NewFatFile(r)
+	switch {
+	case err == nil:
+		defer ff.Close()
+
+		result := &File{Universal: true}
+		for _, fa := range ff.Arches {
+			end := int64(fa.Offset) + int64(fa.Size)
+			if end > int64(len(data)) {
+				return nil, fmt.Errorf("architecture %s is out of bounds", cpuName(fa.Cpu, fa.SubCpu))
+			}
+
+			arch, err := newArch(fa.File, data[fa.Offset:end])
+			if err != nil {
+				return nil, err
+			}
+			arch.Offset = int64(fa.Offset)
+			result.Arches = append(result.Arches, arch)
+		}
+
+		return result, nil
+
+	case err == macho.ErrNotFat:
+		f, err := macho.NewFile(r)
+		if err != nil {
+			if _, ok := err.(*macho.FormatError); ok {
+				return nil, ErrNotMachO
+			}
+
+			return nil, err
+		}
+		defer f.Close()
+
+		arch, err := newArch(f, data)
+		if err != nil {
+			return nil, err
+		}
+
+		return &File{Arches: []*Arch{arch}}, nil
+
+	default:
+		// Anything with the fat magic that doesn't have a valid fat
+		// header, such as a Java class file, isn't a Mach-O binary.
+		if _, ok := err.(*macho.FormatError); ok || err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotMachO
+		}
+
+		return nil, err
+	}
+}
+
+// newArch reads the signature of a single architecture.
+func newArch(f *macho.File, data []byte) (*Arch, error) {
+	arch := &Arch{
+		CPU:  cpuName(f.Cpu, f.SubCpu),
+		Type: f.Type,
+		Size: int64(len(data)),
+		data: data,
+	}
+
+	bo := f.ByteOrder
+	for _, l := range f.Loads {
+		raw := l.Raw()
+		if len(raw) < 16 {
+			continue
+		}
+
+		switch macho.LoadCmd(bo.Uint32(raw)) {
+		case loadCmdVersionMinMacOS:
+			arch.SDK = bo.Uint32(raw[12:])
+
+		case loadCmdBuildVersion:
+			if len(raw) >= 20 {
+				arch.SDK = bo.Uint32(raw[16:])
+			}
+
+		case loadCmdCodeSignature:
+			off := int64(bo.Uint32(raw[8:]))
+			size := int64(bo.Uint32(raw[12:]))
+			if off+size > int64(len(data)) {
+				return nil, fmt.Errorf("%s: code signature is out of bounds", arch.CPU)
+			}
+
+			sig, err := parseSignature(data[off : off+size])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", arch.CPU, err)
+			}
+			arch.Signature = sig
+		}
+	}
+
+	return arch, nil
+}
+
+// parseSignature parses the embedded signature SuperBlob.
+func parseSignature(data []byte) (*Signature, error) {
+	blobs, err := parseSuperBlob(data)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := &Signature{blobs: blobs}
+
+	// Read all the code directories. The one in the code directory slot
+	// is first, followed by any alternates.
+	if _, ok := blobs[slotCodeDirectory]; !ok {
+		return nil, errors.New("code signature has no code directory")
+	}
+	slots := []uint32{slotCodeDirectory}
+	for slot := uint32(slotAlternateCodeDirectories); slot < slotAlternateCodeDirectoriesEnd; slot++ {
+		slots = append(slots, slot)
+	}
+	for _, slot := range slots {
+		blob, ok := blobs[slot]
+		if !ok {
+			continue
+		}
+		if magic := binary.BigEndian.Uint32(blob); magic != magicCodeDirectory {
+			return nil, fmt.Errorf("code directory %d has unexpected magic 0x%x", slot, magic)
+		}
+
+		cd, err := parseCodeDirectory(blob)
+		if err != nil {
+			return nil, err
+		}
+		sig.CodeDirectories = append(sig.CodeDirectories, cd)
+	}
+
+	sig.CodeDirectory = sig.CodeDirectories[0]
+	for _, cd := range sig.CodeDirectories[1:] {
+		if cd.HashType.Size() > sig.CodeDirectory.HashType.Size() {
+			sig.CodeDirectory = cd
+		}
+	}
+
+	if _, err := blobs.payload(slotRequirements, magicRequirements); err != nil {
+		return nil, err
+	}
+	sig.Requirements = blobs[slotRequirements]
+	if sig.Entitlements, err = blobs.payload(slotEntitlements, magicEntitlements); err != nil {
+		return nil, err
+	}
+	if sig.EntitlementsDER, err = blobs.payload(slotEntitlementsDER, magicEntitlementsDER); err != nil {
+		return nil, err
+	}
+	if sig.CMS, err = blobs.payload(slotSignature, magicBlobWrapper); err != nil {
+		return nil, err
+	}
+
+	// Ad-hoc signatures have an empty CMS blob or none at all.
+	if len(sig.CMS) == 0 {
+		return sig, nil
+	}
+
+	sig.SignedData, err = cms.Parse(sig.CMS)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sig.SignedData.Signers) > 0 {
+		signer := sig.SignedData.Signers[0]
+		sig.Certificates = chain(signer.Certificate, sig.SignedData.Certificates)
+
+		if t, ok := signer.SigningTime(); ok {
+			sig.SigningTime = t
+		}
+
+		info, _, err := signer.Timestamp()
+		if err != nil {
+			return nil, err
+		}
+		if info != nil {
+			sig.Timestamp = info.GenTime
+		}
+	}
+
+	return sig, nil
+}
+
+// chain orders the certificates into a chain starting with cert.
+func chain(cert *x509.Certificate, certs []*x509.Certificate) []*x509.Certificate {
+	var result []*x509.Certificate
+	for cert != nil && len(result) <= len(certs) {
+		result = append(result, cert)
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			break
+		}
+
+		var next *x509.Certificate
+		for _, c := range certs {
+			if bytes.Equal(c.RawSubject, cert.RawIssuer) {
+				next = c
+				break
+			}
+		}
+		cert = next
+	}
+
+	return result
+}
+
+// cpuName returns the name of the CPU as used by Apple's tools.
+func cpuName(cpu macho.Cpu, sub uint32) string {
+	switch cpu {
+	case macho.Cpu386:
+		return "i386"
+	case macho.CpuAmd64:
+		return "x86_64"
+	case macho.CpuArm:
+		return "arm"
+	case macho.CpuArm64:
+		// The high bits of the subtype are capability flags.
+		if sub&0xffffff == 2 {
+			return "arm64e"
+		}
+		return "arm64"
+	case macho.CpuPpc:
+		return "ppc"
+	case macho.CpuPpc64:
+		return "ppc64"
+	default:
+		return cpu.String()
+	}
+}
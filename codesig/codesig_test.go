@@ -0,0 +1,192 @@
+package codesig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"debug/macho"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitchellh/gon/internal/machotest"
+)
+
+func TestNewFile_notMachO(t *testing.T) {
+	_, err := NewFile([]byte("#!/bin/sh\necho hello\n"))
+	require.Equal(t, ErrNotMachO, err)
+}
+
+func TestNewFile_javaClass(t *testing.T) {
+	// Java class files start with the same magic as universal binaries,
+	// followed by the class file version (52 is Java 8).
+	_, err := NewFile([]byte{
+		0xca, 0xfe, 0xba, 0xbe, 0x00, 0x00, 0x00, 0x34,
+		0x00, 0x0d, 0x0a, 0x00, 0x03, 0x00, 0x0a, 0x07,
+	})
+	require.Equal(t, ErrNotMachO, err)
+}
+
+func TestNewFile_unsigned(t *testing.T) {
+	f, err := NewFile(machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64}))
+	require.NoError(t, err)
+	require.False(t, f.Universal)
+	require.Len(t, f.Arches, 1)
+	require.Equal(t, "x86_64", f.Arches[0].CPU)
+	require.Nil(t, f.Arches[0].Signature)
+	require.Equal(t, ErrNotSigned, f.Arches[0].Verify())
+}
+
+func TestNewFile_adhoc(t *testing.T) {
+	f, err := NewFile(machotest.Build(t, &machotest.Options{
+		CPU:  machotest.CPUARM64,
+		Sign: &machotest.Sign{Identifier: "com.example.adhoc"},
+	}))
+	require.NoError(t, err)
+	require.Len(t, f.Arches, 1)
+
+	arch := f.Arches[0]
+	require.Equal(t, "arm64", arch.CPU)
+	require.NotNil(t, arch.Signature)
+
+	sig := arch.Signature
+	require.True(t, sig.AdHoc())
+	require.False(t, sig.Runtime())
+	require.Equal(t, "com.example.adhoc", sig.Identifier())
+	require.Equal(t, "", sig.TeamID())
+	require.Equal(t, "", sig.Identity())
+	require.Nil(t, sig.SignedData)
+	require.Empty(t, sig.Certificates)
+	require.NoError(t, f.Verify())
+}
+
+func TestNewFile_developerID(t *testing.T) {
+	signer := machotest.Identity(t)
+	timestamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	f, err := NewFile(machotest.Build(t, &machotest.Options{
+		CPU: machotest.CPUAMD64,
+		SDK: 0x000a0f00,
+		Sign: &machotest.Sign{
+			Identifier:   "com.example.app",
+			TeamID:       "TEAMID1234",
+			Flags:        FlagRuntime,
+			Runtime:      0x000a0f00,
+			Entitlements: []byte(machotest.Entitlements),
+			Alternate:    true,
+			Signer:       signer,
+			Timestamp:    timestamp,
+		},
+	}))
+	require.NoError(t, err)
+	require.Len(t, f.Arches, 1)
+
+	require.Equal(t, macho.TypeExec, f.Arches[0].Type)
+	require.Equal(t, "10.15.0", f.Arches[0].SDKString())
+
+	sig := f.Arches[0].Signature
+	require.NotNil(t, sig)
+	require.False(t, sig.AdHoc())
+	require.True(t, sig.Runtime())
+	require.Equal(t, []string{"runtime"}, sig.CodeDirectory.FlagNames())
+	require.Equal(t, "10.15.0", sig.CodeDirectory.RuntimeVersionString())
+	require.Equal(t, "com.example.app", sig.Identifier())
+	require.Equal(t, "TEAMID1234", sig.TeamID())
+
+	// The SHA-256 alternate directory is the primary one
+	require.Len(t, sig.CodeDirectories, 2)
+	require.Equal(t, crypto.SHA1, sig.CodeDirectories[0].HashType)
+	require.Equal(t, crypto.SHA256, sig.CodeDirectory.HashType)
+	cdhash := sha256.Sum256(sig.CodeDirectory.Raw)
+	require.Equal(t, hex.EncodeToString(cdhash[:20]), sig.CDHash())
+
+	ents, err := sig.ParsedEntitlements()
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"com.apple.security.cs.allow-jit": true,
+	}, ents)
+
+	require.Len(t, sig.Certificates, 2)
+	require.Equal(t, signer.Certificate, sig.Certificates[0])
+	require.Equal(t, signer.Chain[0], sig.Certificates[1])
+	require.Equal(t, "Developer ID Application: Example (TEAMID1234)", sig.Identity())
+	require.True(t, timestamp.Equal(sig.Timestamp))
+	require.True(t, timestamp.Equal(sig.SigningTime))
+
+	require.NoError(t, f.Verify())
+}
+
+func TestNewFile_universal(t *testing.T) {
+	sign := &machotest.Sign{Identifier: "com.example.app"}
+	data := machotest.Fat(
+		machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64, Sign: sign}),
+		machotest.Build(t, &machotest.Options{CPU: machotest.CPUARM64, Sign: sign}),
+	)
+
+	f, err := NewFile(data)
+	require.NoError(t, err)
+	require.True(t, f.Universal)
+	require.Len(t, f.Arches, 2)
+	require.Equal(t, "x86_64", f.Arches[0].CPU)
+	require.Equal(t, "arm64", f.Arches[1].CPU)
+	require.Equal(t, int64(0x4000), f.Arches[0].Offset)
+	require.NotEqual(t, f.Arches[0].Signature.CDHash(), f.Arches[1].Signature.CDHash())
+	require.NoError(t, f.Verify())
+}
+
+func TestVerify_modified(t *testing.T) {
+	opts := &machotest.Options{
+		CPU: machotest.CPUAMD64,
+		Sign: &machotest.Sign{
+			Identifier:   "com.example.app",
+			Entitlements: []byte(machotest.Entitlements),
+			Signer:       machotest.Identity(t),
+		},
+	}
+
+	cases := []struct {
+		Name   string
+		Modify func([]byte)
+		Err    string
+	}{
+		{
+			"code",
+			func(data []byte) { data[machotest.CodeLimit-1] ^= 0xff },
+			"hash of page 1",
+		},
+
+		{
+			"entitlements",
+			func(data []byte) {
+				idx := bytes.Index(data, []byte("allow-jit"))
+				data[idx] = 'X'
+			},
+			"hash of blob 5",
+		},
+
+		{
+			"identifier",
+			func(data []byte) {
+				idx := bytes.Index(data, []byte("com.example.app"))
+				data[idx] = 'X'
+			},
+			"CMS signature",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			data := machotest.Build(t, opts)
+			tt.Modify(data)
+
+			f, err := NewFile(data)
+			require.NoError(t, err)
+
+			err = f.Verify()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.Err)
+		})
+	}
+}
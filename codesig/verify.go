@@ -0,0 +1,116 @@
+package codesig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrNotSigned is returned when verifying an architecture that has no
+// code signature.
+var ErrNotSigned = errors.New("code is not signed")
+
+// Verify verifies the signatures of every architecture in the file.
+func (f *File) Verify() error {
+	for _, arch := range f.Arches {
+		if err := arch.Verify(); err != nil {
+			return fmt.Errorf("%s: %s", arch.CPU, err)
+		}
+	}
+
+	return nil
+}
+
+// Verify verifies the signature of the architecture. This verifies that
+// the hashes of every code directory match the code and the signature's
+// blobs and that the CMS signature is valid for the code directory.
+//
+// The hashes of the Info.plist and sealed resources of a bundle aren't
+// verified since they aren't part of the binary. The certificate chain of
+// the signer isn't verified either since that depends on the trust
+// settings of the machine running the code.
+func (a *Arch) Verify() error {
+	sig := a.Signature
+	if sig == nil {
+		return ErrNotSigned
+	}
+
+	for _, cd := range sig.CodeDirectories {
+		if err := a.verifyCodeDirectory(cd); err != nil {
+			return err
+		}
+	}
+
+	if len(sig.CMS) == 0 {
+		if !sig.AdHoc() {
+			return errors.New("signature has no CMS signature but isn't ad-hoc")
+		}
+
+		return nil
+	}
+
+	if err := sig.SignedData.Verify(sig.CodeDirectories[0].Raw); err != nil {
+		return fmt.Errorf("error verifying CMS signature: %s", err)
+	}
+
+	return nil
+}
+
+// verifyCodeDirectory verifies the hashes of a single code directory.
+func (a *Arch) verifyCodeDirectory(cd *CodeDirectory) error {
+	if cd.CodeLimit > int64(len(a.data)) {
+		return fmt.Errorf("code limit %d is beyond the end of the file", cd.CodeLimit)
+	}
+	code := a.data[:cd.CodeLimit]
+
+	pageSize := int64(cd.PageSize)
+	if pageSize == 0 {
+		pageSize = cd.CodeLimit
+	}
+
+	var pages int64
+	if pageSize > 0 {
+		pages = (cd.CodeLimit + pageSize - 1) / pageSize
+	}
+	if pages != int64(len(cd.CodeSlots)) {
+		return fmt.Errorf("code directory has %d pages, expected %d", len(cd.CodeSlots), pages)
+	}
+
+	for i, expected := range cd.CodeSlots {
+		start := int64(i) * pageSize
+		end := start + pageSize
+		if end > cd.CodeLimit {
+			end = cd.CodeLimit
+		}
+
+		if !bytes.Equal(cd.hash(code[start:end]), expected) {
+			return fmt.Errorf("hash of page %d (offset %d) doesn't match", i, start)
+		}
+	}
+
+	for _, slot := range []int{slotRequirements, slotEntitlements, slotEntitlementsDER} {
+		blob, ok := a.Signature.blobs[uint32(slot)]
+		if slot >= len(cd.SpecialSlots) {
+			if ok {
+				return fmt.Errorf("blob %d isn't covered by the code directory", slot)
+			}
+
+			continue
+		}
+
+		expected := cd.SpecialSlots[slot]
+		if !ok {
+			if !bytes.Equal(expected, make([]byte, len(expected))) {
+				return fmt.Errorf("blob %d is missing", slot)
+			}
+
+			continue
+		}
+
+		if !bytes.Equal(cd.hash(blob), expected) {
+			return fmt.Errorf("hash of blob %d doesn't match", slot)
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,6 @@
 module github.com/mitchellh/gon
 
-go 1.13
+go 1.17
 
 require (
 	github.com/davecgh/go-spew v1.1.1
@@ -10,5 +10,20 @@ require (
 	github.com/hashicorp/hcl/v2 v2.0.0
 	github.com/sebdah/goldie v1.0.0
 	github.com/stretchr/testify v1.3.0
+	github.com/zclconf/go-cty v1.1.0
+	golang.org/x/crypto v0.14.0
 	howett.net/plist v0.0.0-20181124034731-591f970eefbb
 )
+
+require (
+	github.com/agext/levenshtein v1.2.1 // indirect
+	github.com/apparentlymart/go-textseg v1.0.0 // indirect
+	github.com/google/go-cmp v0.2.0 // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/mattn/go-colorable v0.1.4 // indirect
+	github.com/mattn/go-isatty v0.0.10 // indirect
+	github.com/mitchellh/go-wordwrap v0.0.0-20150314170334-ad45545899c7 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+)
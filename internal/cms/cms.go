@@ -0,0 +1,490 @@
+// Package cms implements parsing and creation of the subset of the
+// Cryptographic Message Syntax (RFC 5652) SignedData structure used by
+// Apple code signatures, installer packages, and provisioning profiles.
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Object identifiers used within CMS structures.
+var (
+	OIDData            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	OIDSignedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	OIDTSTInfo         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	OIDContentType     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	OIDMessageDigest   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	OIDSigningTime     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	OIDTimestampToken  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	OIDAppleCDHashes   = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 9, 1}
+	OIDAppleCDHashes2  = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 9, 2}
+	OIDDigestSHA1      = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	OIDDigestSHA256    = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	OIDDigestSHA384    = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	OIDDigestSHA512    = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	OIDEncryptionRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	OIDECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// SignedData is a parsed CMS SignedData structure.
+type SignedData struct {
+	// Content is the encapsulated content. This is nil if the signature
+	// is detached and the content must be supplied separately.
+	Content []byte
+
+	// ContentType is the type of the encapsulated content.
+	ContentType asn1.ObjectIdentifier
+
+	// Certificates are all the certificates included in the structure.
+	Certificates []*x509.Certificate
+
+	// Signers are the signers of the content.
+	Signers []*SignerInfo
+}
+
+// SignerInfo is a single signer of a SignedData structure.
+type SignerInfo struct {
+	// Certificate is the certificate of the signer, found in the
+	// certificates of the SignedData structure. This may be nil if the
+	// signer certificate wasn't included.
+	Certificate *x509.Certificate
+
+	// DigestAlgorithm is the hash used for the message digest.
+	DigestAlgorithm crypto.Hash
+
+	// SignatureAlgorithm is the algorithm identifier of the signature.
+	SignatureAlgorithm asn1.ObjectIdentifier
+
+	// Signature is the raw signature value.
+	Signature []byte
+
+	// SignedAttributes and UnsignedAttributes are the attributes of
+	// the signer.
+	SignedAttributes   []Attribute
+	UnsignedAttributes []Attribute
+
+	// rawSignedAttributes is the DER encoding of the signed attributes
+	// as a SET, which is the data that is actually signed.
+	rawSignedAttributes []byte
+}
+
+// Attribute is a single CMS attribute.
+type Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue
+}
+
+// TSTInfo is the content of a RFC 3161 timestamp token.
+type TSTInfo struct {
+	Policy        asn1.ObjectIdentifier
+	HashAlgorithm crypto.Hash
+	HashedMessage []byte
+	SerialNumber  *big.Int
+	GenTime       time.Time
+	Nonce         *big.Int
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type issuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// MessageImprint is the hash of the data being timestamped.
+type MessageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint MessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time     `asn1:"generalized"`
+	Accuracy       asn1.RawValue `asn1:"optional"`
+	Ordering       bool          `asn1:"optional"`
+	Nonce          *big.Int      `asn1:"optional"`
+	TSA            asn1.RawValue `asn1:"optional,tag:0"`
+	Extensions     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// Parse parses a DER-encoded ContentInfo structure containing SignedData.
+func Parse(der []byte) (*SignedData, error) {
+	var ci contentInfo
+	rest, err := asn1.Unmarshal(der, &ci)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CMS content info: %s", err)
+	}
+	if len(bytes.TrimRight(rest, "\x00")) > 0 {
+		return nil, errors.New("trailing data after CMS content info")
+	}
+	if !ci.ContentType.Equal(OIDSignedData) {
+		return nil, fmt.Errorf("CMS content is not signed data: %s", ci.ContentType)
+	}
+
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("error parsing CMS signed data: %s", err)
+	}
+
+	result := &SignedData{ContentType: sd.EncapContentInfo.EContentType}
+	if len(sd.EncapContentInfo.EContent.Bytes) > 0 {
+		// The content is an OCTET STRING, possibly constructed.
+		var content []byte
+		if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent.Bytes, &content); err != nil {
+			return nil, fmt.Errorf("error parsing CMS content: %s", err)
+		}
+		result.Content = content
+	}
+
+	if len(sd.Certificates.Bytes) > 0 {
+		certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing CMS certificates: %s", err)
+		}
+		result.Certificates = certs
+	}
+
+	for _, si := range sd.SignerInfos {
+		signer := &SignerInfo{
+			SignatureAlgorithm: si.SignatureAlgorithm.Algorithm,
+			Signature:          si.Signature,
+		}
+
+		signer.DigestAlgorithm, err = HashForOID(si.DigestAlgorithm.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(si.SignedAttrs.Bytes) > 0 {
+			signer.SignedAttributes, err = parseAttributes(si.SignedAttrs.Bytes)
+			if err != nil {
+				return nil, err
+			}
+
+			// The signature is over the DER encoding of the attributes
+			// with the SET tag rather than the implicit [0] tag.
+			signer.rawSignedAttributes = append([]byte{}, si.SignedAttrs.FullBytes...)
+			signer.rawSignedAttributes[0] = 0x31
+		}
+
+		if len(si.UnsignedAttrs.Bytes) > 0 {
+			signer.UnsignedAttributes, err = parseAttributes(si.UnsignedAttrs.Bytes)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Find the signer certificate. We only support the issuer and
+		// serial form of the signer identifier which is all Apple uses.
+		var ias issuerAndSerial
+		if _, err := asn1.Unmarshal(si.SID.FullBytes, &ias); err == nil {
+			for _, c := range result.Certificates {
+				if c.SerialNumber.Cmp(ias.SerialNumber) == 0 &&
+					bytes.Equal(c.RawIssuer, ias.Issuer.FullBytes) {
+					signer.Certificate = c
+					break
+				}
+			}
+		}
+
+		result.Signers = append(result.Signers, signer)
+	}
+
+	return result, nil
+}
+
+// parseAttributes parses the concatenated DER-encoded attributes.
+func parseAttributes(der []byte) ([]Attribute, error) {
+	var result []Attribute
+	for len(der) > 0 {
+		var attr attribute
+		rest, err := asn1.Unmarshal(der, &attr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing CMS attribute: %s", err)
+		}
+		der = rest
+
+		var values []asn1.RawValue
+		raw := attr.Values.Bytes
+		for len(raw) > 0 {
+			var v asn1.RawValue
+			rest, err := asn1.Unmarshal(raw, &v)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing CMS attribute value: %s", err)
+			}
+			raw = rest
+			values = append(values, v)
+		}
+
+		result = append(result, Attribute{Type: attr.Type, Values: values})
+	}
+
+	return result, nil
+}
+
+// Attribute returns the first value of the signed or unsigned attribute
+// with the given type.
+func (s *SignerInfo) Attribute(oid asn1.ObjectIdentifier) (asn1.RawValue, bool) {
+	for _, list := range [][]Attribute{s.SignedAttributes, s.UnsignedAttributes} {
+		for _, attr := range list {
+			if attr.Type.Equal(oid) && len(attr.Values) > 0 {
+				return attr.Values[0], true
+			}
+		}
+	}
+
+	return asn1.RawValue{}, false
+}
+
+// SigningTime returns the signing time attribute. This is the time
+// claimed by the signer and isn't secure. See Timestamp for a secure time.
+func (s *SignerInfo) SigningTime() (time.Time, bool) {
+	v, ok := s.Attribute(OIDSigningTime)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	var t time.Time
+	if _, err := asn1.Unmarshal(v.FullBytes, &t); err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// Timestamp returns the RFC 3161 timestamp token countersignature of
+// this signer, if there is one. The returned SignedData is the timestamp
+// token itself which contains the certificates of the timestamp authority.
+// The timestamp is verified to be over this signature.
+func (s *SignerInfo) Timestamp() (*TSTInfo, *SignedData, error) {
+	v, ok := s.Attribute(OIDTimestampToken)
+	if !ok {
+		return nil, nil, nil
+	}
+
+	token, err := Parse(v.FullBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing timestamp token: %s", err)
+	}
+
+	info, err := ParseTSTInfo(token.Content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h := info.HashAlgorithm.New()
+	h.Write(s.Signature)
+	if !bytes.Equal(h.Sum(nil), info.HashedMessage) {
+		return nil, nil, errors.New("timestamp token is not for this signature")
+	}
+
+	return info, token, nil
+}
+
+// ParseTSTInfo parses the DER-encoded TSTInfo content of a timestamp token.
+func ParseTSTInfo(der []byte) (*TSTInfo, error) {
+	var raw tstInfo
+	if _, err := asn1.Unmarshal(der, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing timestamp info: %s", err)
+	}
+
+	h, err := HashForOID(raw.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TSTInfo{
+		Policy:        raw.Policy,
+		HashAlgorithm: h,
+		HashedMessage: raw.MessageImprint.HashedMessage,
+		SerialNumber:  raw.SerialNumber,
+		GenTime:       raw.GenTime,
+		Nonce:         raw.Nonce,
+	}, nil
+}
+
+// Marshal returns the DER encoding of the TSTInfo. This is the content
+// of the timestamp token signed by a timestamp authority.
+func (t *TSTInfo) Marshal() ([]byte, error) {
+	oid, err := OIDForHash(t.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(tstInfo{
+		Version: 1,
+		Policy:  t.Policy,
+		MessageImprint: MessageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  oid,
+				Parameters: asn1.NullRawValue,
+			},
+			HashedMessage: t.HashedMessage,
+		},
+		SerialNumber: t.SerialNumber,
+		GenTime:      t.GenTime.UTC(),
+		Nonce:        t.Nonce,
+	})
+}
+
+// Verify verifies the signature of every signer. If the SignedData is
+// detached then content must be the signed content, otherwise content
+// is ignored. This doesn't verify the certificate chain.
+func (sd *SignedData) Verify(content []byte) error {
+	if sd.Content != nil {
+		content = sd.Content
+	}
+
+	if len(sd.Signers) == 0 {
+		return errors.New("no signers")
+	}
+
+	for _, s := range sd.Signers {
+		if s.Certificate == nil {
+			return errors.New("signer certificate not found")
+		}
+
+		h := s.DigestAlgorithm.New()
+		h.Write(content)
+		digest := h.Sum(nil)
+
+		signed := content
+		if s.rawSignedAttributes != nil {
+			v, ok := s.Attribute(OIDMessageDigest)
+			if !ok {
+				return errors.New("signed attributes have no message digest")
+			}
+
+			var md []byte
+			if _, err := asn1.Unmarshal(v.FullBytes, &md); err != nil {
+				return fmt.Errorf("error parsing message digest: %s", err)
+			}
+			if !bytes.Equal(md, digest) {
+				return errors.New("message digest does not match content")
+			}
+
+			signed = s.rawSignedAttributes
+		}
+
+		algo, err := signatureAlgorithm(s.Certificate, s.DigestAlgorithm)
+		if err != nil {
+			return err
+		}
+		if err := s.Certificate.CheckSignature(algo, signed, s.Signature); err != nil {
+			return fmt.Errorf("invalid signature: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// signatureAlgorithm returns the x509 signature algorithm for the given
+// certificate key type and digest.
+func signatureAlgorithm(cert *x509.Certificate, h crypto.Hash) (x509.SignatureAlgorithm, error) {
+	switch cert.PublicKeyAlgorithm {
+	case x509.RSA:
+		switch h {
+		case crypto.SHA1:
+			return x509.SHA1WithRSA, nil
+		case crypto.SHA256:
+			return x509.SHA256WithRSA, nil
+		case crypto.SHA384:
+			return x509.SHA384WithRSA, nil
+		case crypto.SHA512:
+			return x509.SHA512WithRSA, nil
+		}
+
+	case x509.ECDSA:
+		switch h {
+		case crypto.SHA1:
+			return x509.ECDSAWithSHA1, nil
+		case crypto.SHA256:
+			return x509.ECDSAWithSHA256, nil
+		case crypto.SHA384:
+			return x509.ECDSAWithSHA384, nil
+		case crypto.SHA512:
+			return x509.ECDSAWithSHA512, nil
+		}
+	}
+
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf(
+		"unsupported signature algorithm: %s with %s", cert.PublicKeyAlgorithm, h)
+}
+
+// HashForOID returns the hash function for the given digest algorithm.
+func HashForOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(OIDDigestSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(OIDDigestSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(OIDDigestSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(OIDDigestSHA512):
+		return crypto.SHA512, nil
+	}
+
+	return 0, fmt.Errorf("unsupported digest algorithm: %s", oid)
+}
+
+// OIDForHash returns the digest algorithm identifier for the given hash.
+func OIDForHash(h crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch h {
+	case crypto.SHA1:
+		return OIDDigestSHA1, nil
+	case crypto.SHA256:
+		return OIDDigestSHA256, nil
+	case crypto.SHA384:
+		return OIDDigestSHA384, nil
+	case crypto.SHA512:
+		return OIDDigestSHA512, nil
+	}
+
+	return nil, fmt.Errorf("unsupported hash: %s", h)
+}
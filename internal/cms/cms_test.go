@@ -0,0 +1,210 @@
+package cms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignParse_detached(t *testing.T) {
+	signer := testSigner(t, false)
+	content := []byte("hello world")
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	der, err := Sign(content, signer, &SignOptions{
+		Detached:    true,
+		SigningTime: now,
+		Attributes: []Attribute{
+			attributeValue(OIDAppleCDHashes, []byte("plist")),
+		},
+	})
+	require.NoError(t, err)
+
+	sd, err := Parse(der)
+	require.NoError(t, err)
+	require.Nil(t, sd.Content)
+	require.Len(t, sd.Certificates, 1)
+	require.Len(t, sd.Signers, 1)
+	require.Equal(t, signer.Certificate, sd.Signers[0].Certificate)
+	require.Equal(t, crypto.SHA256, sd.Signers[0].DigestAlgorithm)
+	require.NoError(t, sd.Verify(content))
+	require.Error(t, sd.Verify([]byte("tampered")))
+
+	st, ok := sd.Signers[0].SigningTime()
+	require.True(t, ok)
+	require.True(t, now.Equal(st))
+
+	_, ok = sd.Signers[0].Attribute(OIDAppleCDHashes)
+	require.True(t, ok)
+
+	info, token, err := sd.Signers[0].Timestamp()
+	require.NoError(t, err)
+	require.Nil(t, info)
+	require.Nil(t, token)
+}
+
+func TestSignParse_attached(t *testing.T) {
+	signer := testSigner(t, true)
+	content := []byte("hello world")
+
+	der, err := Sign(content, signer, nil)
+	require.NoError(t, err)
+
+	sd, err := Parse(der)
+	require.NoError(t, err)
+	require.Equal(t, content, sd.Content)
+	require.NoError(t, sd.Verify(nil))
+}
+
+func TestSignParse_timestamp(t *testing.T) {
+	signer := testSigner(t, false)
+	tsa := testSigner(t, true)
+	genTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	der, err := Sign([]byte("hello"), signer, &SignOptions{
+		Detached:  true,
+		Timestamp: testTimestamp(t, tsa, genTime),
+	})
+	require.NoError(t, err)
+
+	sd, err := Parse(der)
+	require.NoError(t, err)
+
+	info, token, err := sd.Signers[0].Timestamp()
+	require.NoError(t, err)
+	require.NotNil(t, info)
+	require.True(t, genTime.Equal(info.GenTime))
+	require.Equal(t, OIDTSTInfo, token.ContentType)
+	require.NoError(t, token.Verify(nil))
+}
+
+func TestRequestTimestamp(t *testing.T) {
+	tsa := testSigner(t, true)
+	genTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var received TimestampRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, "application/timestamp-query", r.Header.Get("Content-Type"))
+		_, err = asn1.Unmarshal(body, &received)
+		require.NoError(t, err)
+
+		token, err := testTimestamp(t, tsa, genTime)([]byte("signature"))
+		require.NoError(t, err)
+
+		// Set the nonce in the token to match
+		sd, err := Parse(token)
+		require.NoError(t, err)
+		info, err := ParseTSTInfo(sd.Content)
+		require.NoError(t, err)
+		info.Nonce = received.Nonce
+		content, err := info.Marshal()
+		require.NoError(t, err)
+		token, err = Sign(content, tsa, &SignOptions{ContentType: OIDTSTInfo})
+		require.NoError(t, err)
+
+		resp, err := asn1.Marshal(timestampResponse{
+			TimeStampToken: asn1.RawValue{FullBytes: token},
+		})
+		require.NoError(t, err)
+		w.Write(resp)
+	}))
+	defer server.Close()
+
+	token, err := RequestTimestamp(server.URL, []byte("signature"))
+	require.NoError(t, err)
+	require.True(t, received.CertReq)
+
+	sd, err := Parse(token)
+	require.NoError(t, err)
+	require.Equal(t, OIDTSTInfo, sd.ContentType)
+
+	// Data that doesn't match the token is rejected
+	_, err = RequestTimestamp(server.URL, []byte("other"))
+	require.Error(t, err)
+}
+
+func TestRequestTimestamp_rejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := asn1.Marshal(timestampResponse{
+			Status: pkiStatusInfo{Status: 2, StatusString: []string{"bad alg"}},
+		})
+		require.NoError(t, err)
+		w.Write(resp)
+	}))
+	defer server.Close()
+
+	_, err := RequestTimestamp(server.URL, []byte("signature"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "bad alg")
+}
+
+func TestParse_invalid(t *testing.T) {
+	_, err := Parse([]byte("not cms"))
+	require.Error(t, err)
+}
+
+// testSigner creates a signer with a new self-signed certificate.
+func testSigner(t *testing.T, ec bool) *Signer {
+	t.Helper()
+
+	var key crypto.Signer
+	var err error
+	if ec {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject: pkix.Name{
+			CommonName:         "Developer ID Application: Test (TEAMID1234)",
+			OrganizationalUnit: []string{"TEAMID1234"},
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &Signer{Certificate: cert, Key: key}
+}
+
+// testTimestamp returns a timestamp function for SignOptions that creates
+// a timestamp token signed by tsa.
+func testTimestamp(t *testing.T, tsa *Signer, genTime time.Time) func([]byte) ([]byte, error) {
+	return func(sig []byte) ([]byte, error) {
+		h := crypto.SHA256.New()
+		h.Write(sig)
+
+		info := &TSTInfo{
+			Policy:        asn1.ObjectIdentifier{1, 2, 3},
+			HashAlgorithm: crypto.SHA256,
+			HashedMessage: h.Sum(nil),
+			SerialNumber:  big.NewInt(1),
+			GenTime:       genTime,
+		}
+		content, err := info.Marshal()
+		require.NoError(t, err)
+
+		return Sign(content, tsa, &SignOptions{ContentType: OIDTSTInfo})
+	}
+}
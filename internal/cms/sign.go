@@ -0,0 +1,258 @@
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Signer is a certificate and private key used to sign content.
+type Signer struct {
+	// Certificate is the signing certificate.
+	Certificate *x509.Certificate
+
+	// Chain are additional certificates to include, such as the
+	// intermediate certificates of the signing certificate.
+	Chain []*x509.Certificate
+
+	// Key is the private key for Certificate.
+	Key crypto.Signer
+}
+
+// SignOptions are the options for Sign.
+type SignOptions struct {
+	// Hash is the digest algorithm to use. Defaults to SHA-256.
+	Hash crypto.Hash
+
+	// ContentType is the type of the content. Defaults to OIDData.
+	ContentType asn1.ObjectIdentifier
+
+	// Detached, if true, will not include the content in the result.
+	Detached bool
+
+	// SigningTime, if non-zero, is included as a signed attribute.
+	SigningTime time.Time
+
+	// Attributes are additional signed attributes to include.
+	Attributes []Attribute
+
+	// Timestamp, if non-nil, is called with the signature value and must
+	// return a DER-encoded RFC 3161 timestamp token for it. The token is
+	// included as an unsigned attribute.
+	Timestamp func(signature []byte) ([]byte, error)
+}
+
+// Sign creates a DER-encoded ContentInfo structure containing SignedData
+// with a single signer for the given content.
+func Sign(content []byte, signer *Signer, opts *SignOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &SignOptions{}
+	}
+
+	contentType := opts.ContentType
+	if contentType == nil {
+		contentType = OIDData
+	}
+
+	h := opts.Hash
+	if h == 0 {
+		h = crypto.SHA256
+	}
+	digestOID, err := OIDForHash(h)
+	if err != nil {
+		return nil, err
+	}
+	digestAlgo := pkix.AlgorithmIdentifier{Algorithm: digestOID, Parameters: asn1.NullRawValue}
+
+	sigAlgo, err := signatureAlgorithmIdentifier(signer.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := h.New()
+	hasher.Write(content)
+	digest := hasher.Sum(nil)
+
+	// Build our signed attributes
+	attrs := []Attribute{
+		attributeValue(OIDContentType, contentType),
+		attributeValue(OIDMessageDigest, digest),
+	}
+	if !opts.SigningTime.IsZero() {
+		attrs = append(attrs, attributeValue(OIDSigningTime, opts.SigningTime.UTC()))
+	}
+	attrs = append(attrs, opts.Attributes...)
+	signedAttrs, err := marshalAttributes(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign the attributes
+	hasher = h.New()
+	hasher.Write(signedAttrs)
+	signature, err := signer.Key.Sign(rand.Reader, hasher.Sum(nil), h)
+	if err != nil {
+		return nil, err
+	}
+
+	si := signerInfo{
+		Version: 1,
+		SID: asn1.RawValue{FullBytes: mustMarshal(issuerAndSerial{
+			Issuer:       asn1.RawValue{FullBytes: signer.Certificate.RawIssuer},
+			SerialNumber: signer.Certificate.SerialNumber,
+		})},
+		DigestAlgorithm:    digestAlgo,
+		SignedAttrs:        asn1.RawValue{FullBytes: append([]byte{0xa0}, signedAttrs[1:]...)},
+		SignatureAlgorithm: sigAlgo,
+		Signature:          signature,
+	}
+
+	if opts.Timestamp != nil {
+		token, err := opts.Timestamp(signature)
+		if err != nil {
+			return nil, fmt.Errorf("error timestamping signature: %s", err)
+		}
+
+		unsigned, err := marshalAttributes([]Attribute{{
+			Type:   OIDTimestampToken,
+			Values: []asn1.RawValue{{FullBytes: token}},
+		}})
+		if err != nil {
+			return nil, err
+		}
+		si.UnsignedAttrs = asn1.RawValue{FullBytes: append([]byte{0xa1}, unsigned[1:]...)}
+	}
+
+	var certs []byte
+	for _, c := range append([]*x509.Certificate{signer.Certificate}, signer.Chain...) {
+		certs = append(certs, c.Raw...)
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlgo},
+		EncapContentInfo: encapContentInfo{EContentType: contentType},
+		Certificates: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      certs,
+		},
+		SignerInfos: []signerInfo{si},
+	}
+	if !opts.Detached {
+		octets, err := asn1.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		sd.EncapContentInfo.EContent = asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      octets,
+		}
+	}
+
+	return marshalContentInfo(OIDSignedData, sd)
+}
+
+// marshalContentInfo marshals the value as the content of a ContentInfo.
+func marshalContentInfo(oid asn1.ObjectIdentifier, v interface{}) ([]byte, error) {
+	inner, err := asn1.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oid,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      inner,
+		},
+	})
+}
+
+// attributeValue returns an attribute with the single given value. This
+// panics if the value can't be marshaled so it must only be used with
+// known-good values.
+func attributeValue(oid asn1.ObjectIdentifier, v interface{}) Attribute {
+	return Attribute{
+		Type:   oid,
+		Values: []asn1.RawValue{{FullBytes: mustMarshal(v)}},
+	}
+}
+
+// marshalAttributes marshals the attributes as a DER SET OF. The
+// elements of a SET OF must be sorted by their encoding in DER.
+func marshalAttributes(attrs []Attribute) ([]byte, error) {
+	encoded := make([][]byte, len(attrs))
+	for idx, attr := range attrs {
+		var values []byte
+		for _, v := range attr.Values {
+			values = append(values, v.FullBytes...)
+		}
+
+		raw, err := asn1.Marshal(attribute{
+			Type: attr.Type,
+			Values: asn1.RawValue{
+				Class:      asn1.ClassUniversal,
+				Tag:        asn1.TagSet,
+				IsCompound: true,
+				Bytes:      values,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		encoded[idx] = raw
+	}
+
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+
+	return asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSet,
+		IsCompound: true,
+		Bytes:      bytes.Join(encoded, nil),
+	})
+}
+
+// signatureAlgorithmIdentifier returns the signature algorithm to use in
+// the SignerInfo for the given key.
+func signatureAlgorithmIdentifier(key crypto.Signer) (pkix.AlgorithmIdentifier, error) {
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		return pkix.AlgorithmIdentifier{
+			Algorithm:  OIDEncryptionRSA,
+			Parameters: asn1.NullRawValue,
+		}, nil
+
+	case *ecdsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: OIDECDSAWithSHA256}, nil
+	}
+
+	return pkix.AlgorithmIdentifier{}, errors.New("unsupported private key type")
+}
+
+func mustMarshal(v interface{}) []byte {
+	result, err := asn1.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return result
+}
@@ -0,0 +1,118 @@
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TimestampRequest is a RFC 3161 timestamp request.
+type TimestampRequest struct {
+	Version        int
+	MessageImprint MessageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional"`
+}
+
+// timestampResponse is a RFC 3161 timestamp response.
+type timestampResponse struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+// timestampClient is the HTTP client used to request timestamps.
+var timestampClient = &http.Client{Timeout: 1 * time.Minute}
+
+// RequestTimestamp requests a RFC 3161 timestamp token for the given data,
+// usually a signature value, from the timestamp authority at url. The
+// result is the DER-encoded timestamp token, which is suitable for
+// SignOptions.Timestamp. The token is checked to be for the data but
+// the signature of the timestamp authority isn't verified.
+func RequestTimestamp(url string, data []byte) ([]byte, error) {
+	h := crypto.SHA256.New()
+	h.Write(data)
+	digest := h.Sum(nil)
+
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := asn1.Marshal(TimestampRequest{
+		Version: 1,
+		MessageImprint: MessageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  OIDDigestSHA256,
+				Parameters: asn1.NullRawValue,
+			},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := timestampClient.Post(url, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("error requesting timestamp: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading timestamp response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("timestamp server returned status %d: %s",
+			resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var tsResp timestampResponse
+	if _, err := asn1.Unmarshal(body, &tsResp); err != nil {
+		return nil, fmt.Errorf("error parsing timestamp response: %s", err)
+	}
+
+	// 0 is granted and 1 is granted with modifications
+	if s := tsResp.Status; s.Status > 1 {
+		return nil, fmt.Errorf("timestamp request rejected with status %d: %s",
+			s.Status, strings.Join(s.StatusString, "; "))
+	}
+	if len(tsResp.TimeStampToken.FullBytes) == 0 {
+		return nil, errors.New("timestamp response has no token")
+	}
+
+	token, err := Parse(tsResp.TimeStampToken.FullBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing timestamp token: %s", err)
+	}
+	info, err := ParseTSTInfo(token.Content)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(info.HashedMessage, digest) {
+		return nil, errors.New("timestamp token is not for the requested data")
+	}
+	if info.Nonce != nil && info.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("timestamp token nonce does not match the request")
+	}
+
+	return tsResp.TimeStampToken.FullBytes, nil
+}
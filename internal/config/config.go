@@ -1,5 +1,9 @@
 package config
 
+import (
+	"github.com/zclconf/go-cty/cty"
+)
+
 // Config is the configuration structure for gon.
 type Config struct {
 	// Source is the list of binary files to sign.
@@ -14,9 +18,21 @@ type Config struct {
 	// that is ready for notarization as-is
 	Notarize []Notarize `hcl:"notarize,block"`
 
+	// Universal, if present, merges per-architecture binaries in Source
+	// into universal binaries before signing.
+	Universal *Universal `hcl:"universal,block"`
+
+	// App, if present, wraps the binaries in Source in an application
+	// bundle, which is then signed and packaged instead of the binaries.
+	App *App `hcl:"app,block"`
+
 	// Sign are the settings for code-signing the binaries.
 	Sign *Sign `hcl:"sign,block"`
 
+	// Keychain, if present, creates a temporary keychain with the signing
+	// certificate for the duration of the run. This is useful in CI.
+	Keychain *Keychain `hcl:"keychain,block"`
+
 	// AppleId are the credentials to use to talk to Apple.
 	AppleId *AppleId `hcl:"apple_id,block"`
 
@@ -28,6 +44,14 @@ type Config struct {
 	// Dmg, if present, creates a dmg file to package the signed `Source` files
 	// into. Dmg files support stapling so this allows offline usage.
 	Dmg *Dmg `hcl:"dmg,block"`
+
+	// Pkg, if present, creates a signed installer package to install the
+	// signed `Source` files. Installer packages support stapling.
+	Pkg *Pkg `hcl:"pkg,block"`
+
+	// Manifest, if present, writes checksums and metadata of the files
+	// that were notarized once notarization is complete.
+	Manifest *Manifest `hcl:"manifest,block"`
 }
 
 // AppleId are the authentication settings for Apple systems.
@@ -59,8 +83,61 @@ type Notarize struct {
 	// If this isn't specified then the root bundle_id is inherited.
 	BundleId string `hcl:"bundle_id"`
 
-	// Staple, if true will staple the notarization ticket to the file.
-	Staple bool `hcl:"staple,optional"`
+	// Staple, if true will staple the notarization ticket to the file. If
+	// this isn't set, apps, dmgs, and pkgs are stapled, as are zip archives
+	// containing any of these.
+	Staple *bool `hcl:"staple,optional"`
+
+	// Sign, if true, signs the file with the identities of the "sign" block
+	// before it is notarized. Installer packages are signed with the
+	// installer identity and everything else with the application identity.
+	Sign bool `hcl:"sign,optional"`
+}
+
+// Universal are the options for merging binaries into universal binaries.
+type Universal struct {
+	// OutputDir is the directory where the universal binaries are written.
+	OutputDir string `hcl:"output_dir"`
+}
+
+// App are the options for wrapping binaries in an application bundle.
+type App struct {
+	// OutputPath is the path where the bundle is written, such as
+	// "./dist/Terraform.app".
+	OutputPath string `hcl:"output_path"`
+
+	// Name is the name of the application. This defaults to the name of
+	// the bundle.
+	Name string `hcl:"name,optional"`
+
+	// Executable is the name of the source file that is the main
+	// executable. This defaults to the first source file.
+	Executable string `hcl:"executable,optional"`
+
+	// Version and Build are the version and build number of the
+	// application.
+	Version string `hcl:"version,optional"`
+	Build   string `hcl:"build,optional"`
+
+	// Icon is the path to an .icns file or PNG image to use as the
+	// application icon.
+	Icon string `hcl:"icon,optional"`
+
+	// Resources are files or directories to add to Contents/Resources.
+	Resources []string `hcl:"resources,optional"`
+
+	// MinimumSystemVersion is the minimum version of macOS to run on.
+	MinimumSystemVersion string `hcl:"minimum_system_version,optional"`
+
+	// Agent, if true, hides the application from the Dock (LSUIElement).
+	Agent bool `hcl:"agent,optional"`
+
+	// URLSchemes are the URL schemes the application handles.
+	URLSchemes []string `hcl:"url_schemes,optional"`
+
+	// UsageDescriptions are the privacy prompt messages keyed by
+	// Info.plist key, such as "NSCameraUsageDescription".
+	UsageDescriptions map[string]string `hcl:"usage_descriptions,optional"`
 }
 
 // Sign are the options for codesigning the binaries.
@@ -68,8 +145,117 @@ type Sign struct {
 	// ApplicationIdentity is the ID or name of the certificate to
 	// use for signing binaries. This is used for all binaries in "source".
 	ApplicationIdentity string `hcl:"application_identity"`
+
+	// InstallerIdentity is the ID or name of the "Developer ID Installer"
+	// certificate to use for signing installer packages.
+	InstallerIdentity string `hcl:"installer_identity,optional"`
+
 	// Specify a path to an entitlements file in plist format
 	EntitlementsFile string `hcl:"entitlements_file,optional"`
+
+	// Entitlements are inline entitlements as an object, such as
+	// `{ "com.apple.security.cs.allow-jit" = true }`. This can't be set
+	// with EntitlementsFile. Use DecodeEntitlements to read this value.
+	Entitlements cty.Value `hcl:"entitlements,optional"`
+
+	// File are per-file signing options that override the options above
+	// for a single file in "source" or code nested within a bundle in "source".
+	File []SignFile `hcl:"file,block"`
+
+	// Identifier is the identifier to embed in the signature of each file
+	// in "source". This isn't applied to code nested within bundles.
+	Identifier string `hcl:"identifier,optional"`
+
+	// Prefix is the prefix for identifiers derived from file names, such
+	// as "com.example.". This is the codesign `--prefix` argument.
+	Prefix string `hcl:"prefix,optional"`
+
+	// Requirements is the value of the codesign `--requirements` argument
+	// for each file in "source".
+	Requirements string `hcl:"requirements,optional"`
+
+	// RuntimeOptions are additional flags for the codesign `--options`
+	// argument for every file. The hardened runtime is always enabled.
+	RuntimeOptions []string `hcl:"runtime_options,optional"`
+
+	// PreserveMetadata is the list of properties to keep from an existing
+	// signature. This is the codesign `--preserve-metadata` argument.
+	PreserveMetadata []string `hcl:"preserve_metadata,optional"`
+
+	// Timestamp is "none" to sign without a secure timestamp, or the URL
+	// of a timestamp server. This defaults to Apple's timestamp server.
+	Timestamp string `hcl:"timestamp,optional"`
+
+	// Keychain is the path to an existing keychain to search for the
+	// identity. This can't be set with the "keychain" block.
+	Keychain string `hcl:"keychain,optional"`
+
+	// ProvisioningProfile is the path to a provisioning profile to embed
+	// in each app bundle in "source" before signing.
+	ProvisioningProfile string `hcl:"provisioning_profile,optional"`
+
+	// Concurrency is the maximum number of files to sign at the same time.
+	// If this is unset, files are signed with a single codesign process.
+	Concurrency int `hcl:"concurrency,optional"`
+
+	// Native, if true, signs the binaries in pure Go instead of with
+	// codesign, so signing works on any OS. The certificate is loaded from
+	// PKCS12File, or the binaries are signed ad-hoc if ApplicationIdentity
+	// is "-".
+	Native bool `hcl:"native,optional"`
+
+	// PKCS12File is the path to a PKCS#12 (.p12) file with the certificate
+	// and private key for native signing.
+	PKCS12File string `hcl:"pkcs12_file,optional"`
+
+	// PKCS12Password is the password of PKCS12File. This supports the
+	// '@env:<name>' form to read the password from an environment variable.
+	// If this isn't set, the PKCS12_PASSWORD environment variable is used.
+	PKCS12Password string `hcl:"pkcs12_password,optional"`
+}
+
+// SignFile are the signing options for a single file.
+type SignFile struct {
+	// Path is the path to the file these options apply to.
+	Path string `hcl:",label"`
+
+	// EntitlementsFile is the path to an entitlements file in plist
+	// format to use for this file.
+	EntitlementsFile string `hcl:"entitlements_file,optional"`
+
+	// Entitlements are inline entitlements to use for this file. This
+	// can't be set with EntitlementsFile.
+	Entitlements cty.Value `hcl:"entitlements,optional"`
+
+	// Identifier is the identifier to embed in the signature.
+	Identifier string `hcl:"identifier,optional"`
+
+	// Options are additional flags for the codesign `--options` argument.
+	// The hardened runtime ("runtime") is always enabled.
+	Options []string `hcl:"options,optional"`
+
+	// Requirements is the value of the codesign `--requirements` argument.
+	Requirements string `hcl:"requirements,optional"`
+}
+
+// Keychain are the options for a temporary keychain to sign with.
+type Keychain struct {
+	// PKCS12File is the path to the PKCS#12 (.p12) file with the signing
+	// certificate and private key to import.
+	PKCS12File string `hcl:"pkcs12_file"`
+
+	// PKCS12Password is the password of PKCS12File. This supports the
+	// '@env:<name>' form to read the password from an environment variable.
+	// If this isn't set, the PKCS12_PASSWORD environment variable is used.
+	PKCS12Password string `hcl:"pkcs12_password,optional"`
+
+	// Path is the path of the keychain to create. This defaults to a
+	// temporary path.
+	Path string `hcl:"path,optional"`
+
+	// Password is the password of the keychain. This defaults to a
+	// random password.
+	Password string `hcl:"password,optional"`
 }
 
 // Dmg are the options for a dmg file as output.
@@ -80,10 +266,140 @@ type Dmg struct {
 	// Volume name is the name of the volume that shows up in the title
 	// and sidebar after opening it.
 	VolumeName string `hcl:"volume_name"`
+
+	// Root is the path to a directory whose contents are added to the
+	// root of the dmg along with the source files.
+	Root string `hcl:"root,optional"`
+
+	// Files are additional files to add to the dmg at chosen paths.
+	Files []PackageFile `hcl:"files,block"`
+
+	// Background is the path to an image to use as the window background.
+	Background string `hcl:"background,optional"`
+
+	// VolumeIcon is the path to an .icns file or PNG image to use as the
+	// volume icon.
+	VolumeIcon string `hcl:"volume_icon,optional"`
+
+	// WindowPosition is the [x, y] position of the window on the screen.
+	WindowPosition []int `hcl:"window_position,optional"`
+
+	// WindowSize is the [width, height] of the window.
+	WindowSize []int `hcl:"window_size,optional"`
+
+	// IconSize is the size of the icons in the window.
+	IconSize int `hcl:"icon_size,optional"`
+
+	// Icon are the positions of the icons of files in the dmg.
+	Icon []DmgIcon `hcl:"icon,block"`
+
+	// HideExtension are the names of files to hide the extension of.
+	HideExtension []string `hcl:"hide_extension,optional"`
+
+	// ApplicationsLink is the [x, y] position of a link to /Applications.
+	// If this isn't set, there is no link.
+	ApplicationsLink []int `hcl:"applications_link,optional"`
+
+	// EULA is the path to a license agreement to show when opening the dmg.
+	EULA string `hcl:"eula,optional"`
+
+	// Format is the format of the disk image: "UDZO", "UDBZ", "ULFO",
+	// or "ULMO". This defaults to "UDZO".
+	Format string `hcl:"format,optional"`
+
+	// Filesystem is the filesystem of the volume: "HFS+" or "APFS". This
+	// defaults to "HFS+".
+	Filesystem string `hcl:"filesystem,optional"`
+
+	// Size is the size of the volume in megabytes. If this isn't set, the
+	// size is estimated from the size of the files.
+	Size int `hcl:"size,optional"`
+
+	// Mode is how the dmg is created: "finder" or "headless". If this
+	// isn't set, the mode is detected from whether a GUI session is
+	// available.
+	Mode string `hcl:"mode,optional"`
+}
+
+// DmgIcon is the position of the icon of a file in the dmg window.
+type DmgIcon struct {
+	// Name is the name of the file in the root of the dmg.
+	Name string `hcl:",label"`
+
+	X int `hcl:"x"`
+	Y int `hcl:"y"`
+}
+
+// Pkg are the options for an installer package as output.
+type Pkg struct {
+	// OutputPath is the path where the final pkg will be saved.
+	OutputPath string `hcl:"output_path"`
+
+	// Identifier is the identifier of the package. This defaults to the
+	// top-level bundle_id.
+	Identifier string `hcl:"identifier,optional"`
+
+	// Version is the version of the package.
+	Version string `hcl:"version,optional"`
+
+	// InstallLocation is the directory the files are installed into.
+	InstallLocation string `hcl:"install_location,optional"`
+
+	// Scripts is a directory with "preinstall" and "postinstall" scripts.
+	Scripts string `hcl:"scripts,optional"`
+
+	// Product, if true, builds a product archive with a distribution
+	// instead of a component package.
+	Product bool `hcl:"product,optional"`
+
+	// Native, if true, builds and signs the package in pure Go instead of
+	// with pkgbuild and productsign, so packages can be built on any OS.
+	// The installer certificate is loaded from PKCS12File.
+	Native bool `hcl:"native,optional"`
+
+	// PKCS12File is the path to a PKCS#12 (.p12) file with the
+	// "Developer ID Installer" certificate and private key for native
+	// packages.
+	PKCS12File string `hcl:"pkcs12_file,optional"`
+
+	// PKCS12Password is the password of PKCS12File. This supports the
+	// '@env:<name>' form to read the password from an environment variable.
+	// If this isn't set, the PKCS12_PASSWORD environment variable is used.
+	PKCS12Password string `hcl:"pkcs12_password,optional"`
 }
 
 // Zip are the options for a zip file as output.
 type Zip struct {
 	// OutputPath is the path where the final zip file will be saved.
 	OutputPath string `hcl:"output_path"`
+
+	// Files are additional files to add to the zip at chosen paths.
+	Files []PackageFile `hcl:"files,block"`
+}
+
+// PackageFile is an additional file to add to a zip or dmg, such as a
+// README or man page. These are added as-is and aren't signed.
+type PackageFile struct {
+	// Src is the path to the file or directory to add.
+	Src string `hcl:"src"`
+
+	// Dst is the path within the package, such as "share/man/man1/foo.1".
+	Dst string `hcl:"dst"`
+
+	// Mode is the permissions of the file in octal, such as "0644". If
+	// this isn't set, the permissions of Src are kept.
+	Mode string `hcl:"mode,optional"`
+}
+
+// Manifest are the options for writing checksums and metadata of the
+// notarized files. At least one of the paths must be set.
+type Manifest struct {
+	// OutputPath is the path to write a JSON manifest of the files to,
+	// with the path, size, SHA-256 hash, notarization ID, stapling, and
+	// signing identity of each.
+	OutputPath string `hcl:"output_path,optional"`
+
+	// ChecksumsPath is the path to write a SHA256SUMS file to, in the
+	// format of `shasum -a 256`.
+	ChecksumsPath string `hcl:"checksums_path,optional"`
 }
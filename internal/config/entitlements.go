@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// DecodeEntitlements converts the value of an "entitlements" attribute
+// into plain Go values that can be encoded into a plist. The result is nil
+// if the attribute wasn't set.
+func DecodeEntitlements(v cty.Value) (map[string]interface{}, error) {
+	if v == cty.NilVal || v.IsNull() {
+		return nil, nil
+	}
+
+	ty := v.Type()
+	if !ty.IsObjectType() && !ty.IsMapType() {
+		return nil, fmt.Errorf("entitlements must be an object, got %s", ty.FriendlyName())
+	}
+
+	raw, err := ctyToGo(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return raw.(map[string]interface{}), nil
+}
+
+// ctyToGo converts a cty value to the equivalent plist-compatible Go value.
+func ctyToGo(v cty.Value) (interface{}, error) {
+	if v.IsNull() {
+		return nil, fmt.Errorf("null values are not allowed in entitlements")
+	}
+	if !v.IsKnown() {
+		return nil, fmt.Errorf("unknown values are not allowed in entitlements")
+	}
+
+	ty := v.Type()
+	switch {
+	case ty == cty.Bool:
+		return v.True(), nil
+
+	case ty == cty.String:
+		return v.AsString(), nil
+
+	case ty == cty.Number:
+		bf := v.AsBigFloat()
+		if bf.IsInt() {
+			if i, acc := bf.Int64(); acc == big.Exact {
+				return i, nil
+			}
+		}
+
+		f, _ := bf.Float64()
+		return f, nil
+
+	case ty.IsListType(), ty.IsSetType(), ty.IsTupleType():
+		result := make([]interface{}, 0, v.LengthInt())
+		for it := v.ElementIterator(); it.Next(); {
+			_, ev := it.Element()
+			raw, err := ctyToGo(ev)
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, raw)
+		}
+
+		return result, nil
+
+	case ty.IsMapType(), ty.IsObjectType():
+		result := make(map[string]interface{}, v.LengthInt())
+		for it := v.ElementIterator(); it.Next(); {
+			k, ev := it.Element()
+			raw, err := ctyToGo(ev)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", k.AsString(), err)
+			}
+
+			result[k.AsString()] = raw
+		}
+
+		return result, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported value type %s", ty.FriendlyName())
+	}
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestDecodeEntitlements(t *testing.T) {
+	cfg, err := ParseFile(filepath.Join("testdata", "entitle_inline.hcl"))
+	require.NoError(t, err)
+
+	actual, err := DecodeEntitlements(cfg.Sign.File[0].Entitlements)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"com.apple.security.cs.allow-jit":       false,
+		"com.apple.security.application-groups": []interface{}{"TEAM.group"},
+	}, actual)
+}
+
+func TestDecodeEntitlements_unset(t *testing.T) {
+	actual, err := DecodeEntitlements(cty.NilVal)
+	require.NoError(t, err)
+	require.Nil(t, actual)
+}
+
+func TestDecodeEntitlements_notObject(t *testing.T) {
+	_, err := DecodeEntitlements(cty.True)
+	require.Error(t, err)
+}
@@ -0,0 +1,25 @@
+source = ["./dist/terraform", "./dist/terraform-helper"]
+bundle_id = "com.mitchellh.test.terraform"
+
+app {
+  output_path = "./dist/Terraform.app"
+  executable = "terraform"
+  version = "1.2.3"
+  build = "123"
+  icon = "./assets/terraform.icns"
+  resources = ["./assets/LICENSE"]
+  minimum_system_version = "10.13"
+  agent = true
+  url_schemes = ["terraform"]
+  usage_descriptions = {
+    NSCameraUsageDescription = "Terraform uses the camera to scan QR codes."
+  }
+}
+
+sign {
+  application_identity = "Developer ID Application: Mitchell Hashimoto"
+}
+
+zip {
+  output_path = "./terraform.zip"
+}
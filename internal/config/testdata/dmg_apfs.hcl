@@ -0,0 +1,13 @@
+source = ["./terraform"]
+bundle_id = "com.mitchellh.test.terraform"
+
+sign {
+  application_identity = "Developer ID Application: Mitchell Hashimoto"
+}
+
+dmg {
+  output_path = "terraform.dmg"
+  volume_name = "Terraform"
+  format = "ULMO"
+  filesystem = "APFS"
+}
@@ -0,0 +1,27 @@
+source = ["./Terraform.app"]
+bundle_id = "com.mitchellh.test.terraform"
+
+sign {
+  application_identity = "Developer ID Application: Mitchell Hashimoto"
+}
+
+dmg {
+  output_path = "terraform.dmg"
+  volume_name = "Terraform"
+  background = "./background.png"
+  volume_icon = "./volume.icns"
+  window_position = [200, 120]
+  window_size = [600, 400]
+  icon_size = 96
+  hide_extension = ["Terraform.app"]
+  applications_link = [450, 190]
+  eula = "./LICENSE.txt"
+  format = "ULFO"
+  size = 200
+  mode = "finder"
+
+  icon "Terraform.app" {
+    x = 150
+    y = 190
+  }
+}
@@ -0,0 +1,17 @@
+source = ["./terraform", "./terraform-helper"]
+bundle_id = "com.mitchellh.test.terraform"
+
+sign {
+  application_identity = "foo"
+
+  entitlements = {
+    "com.apple.security.cs.allow-jit" = true
+  }
+
+  file "./terraform-helper" {
+    entitlements = {
+      "com.apple.security.cs.allow-jit" = false
+      "com.apple.security.application-groups" = ["TEAM.group"]
+    }
+  }
+}
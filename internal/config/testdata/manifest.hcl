@@ -0,0 +1,15 @@
+source = ["./terraform"]
+bundle_id = "com.mitchellh.test.terraform"
+
+sign {
+  application_identity = "Developer ID Application: Example, Inc. (ABCDE12345)"
+}
+
+zip {
+  output_path = "./dist/terraform.zip"
+}
+
+manifest {
+  output_path    = "./dist/artifacts.json"
+  checksums_path = "./dist/SHA256SUMS"
+}
@@ -0,0 +1,10 @@
+notarize {
+  path = "/path/to/vendor.pkg"
+  bundle_id = "com.example.vendor"
+  sign = true
+}
+
+sign {
+  application_identity = "Developer ID Application: Example"
+  installer_identity = "Developer ID Installer: Example"
+}
@@ -0,0 +1,32 @@
+source = ["./terraform"]
+bundle_id = "com.mitchellh.test.terraform"
+
+sign {
+  application_identity = "Developer ID Application: Mitchell Hashimoto"
+}
+
+zip {
+  output_path = "terraform.zip"
+
+  files {
+    src = "./README.md"
+    dst = "README.md"
+  }
+
+  files {
+    src = "./docs/terraform.1"
+    dst = "share/man/man1/terraform.1"
+    mode = "0644"
+  }
+}
+
+dmg {
+  output_path = "terraform.dmg"
+  volume_name = "Terraform"
+  root = "./dmg"
+
+  files {
+    src = "./completions/terraform.bash"
+    dst = "completions/terraform.bash"
+  }
+}
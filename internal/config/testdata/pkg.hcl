@@ -0,0 +1,15 @@
+source = ["./terraform"]
+bundle_id = "com.mitchellh.test.terraform"
+
+sign {
+  application_identity = "Developer ID Application: Mitchell Hashimoto"
+  installer_identity = "Developer ID Installer: Mitchell Hashimoto"
+}
+
+pkg {
+  output_path = "terraform.pkg"
+  version = "1.0.0"
+  install_location = "/usr/local/bin"
+  scripts = "./scripts"
+  product = true
+}
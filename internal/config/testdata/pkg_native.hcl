@@ -0,0 +1,16 @@
+source = ["./terraform"]
+bundle_id = "com.mitchellh.test.terraform"
+
+sign {
+  application_identity = "Developer ID Application: Mitchell Hashimoto"
+  native = true
+  pkcs12_file = "application.p12"
+}
+
+pkg {
+  output_path = "terraform.pkg"
+  install_location = "/usr/local/bin"
+  native = true
+  pkcs12_file = "installer.p12"
+  pkcs12_password = "@env:INSTALLER_PASSWORD"
+}
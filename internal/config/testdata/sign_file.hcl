@@ -0,0 +1,17 @@
+source = ["./terraform", "./terraform-helper"]
+bundle_id = "com.mitchellh.test.terraform"
+
+sign {
+  application_identity = "foo"
+  concurrency = 4
+
+  file "./terraform" {
+    entitlements_file = "/path/to/jit.entitlements"
+    identifier = "com.mitchellh.test.terraform"
+  }
+
+  file "./terraform-helper" {
+    options = ["library"]
+    requirements = "=designated => identifier \"com.mitchellh.test.helper\""
+  }
+}
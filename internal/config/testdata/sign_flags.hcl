@@ -0,0 +1,14 @@
+source = ["./terraform"]
+bundle_id = "com.mitchellh.test.terraform"
+
+sign {
+  application_identity = "foo"
+  identifier = "com.mitchellh.test.terraform"
+  prefix = "com.mitchellh."
+  requirements = "=designated => identifier \"com.mitchellh.test.terraform\""
+  runtime_options = ["library"]
+  preserve_metadata = ["entitlements"]
+  timestamp = "none"
+  keychain = "/tmp/build.keychain"
+  provisioning_profile = "./foo.provisionprofile"
+}
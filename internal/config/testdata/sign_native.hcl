@@ -0,0 +1,10 @@
+source = ["./terraform"]
+bundle_id = "com.mitchellh.test.terraform"
+
+sign {
+  application_identity = "Developer ID Application: Mitchell Hashimoto"
+  native = true
+  pkcs12_file = "./identity.p12"
+  pkcs12_password = "@env:P12_PASSWORD"
+  timestamp = "http://timestamp.example.com"
+}
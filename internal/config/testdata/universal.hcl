@@ -0,0 +1,10 @@
+source = ["./dist/terraform_darwin_amd64/terraform", "./dist/terraform_darwin_arm64/terraform"]
+bundle_id = "com.mitchellh.test.terraform"
+
+universal {
+  output_dir = "./dist/universal"
+}
+
+sign {
+  application_identity = "Developer ID Application: Mitchell Hashimoto"
+}
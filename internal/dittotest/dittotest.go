@@ -0,0 +1,288 @@
+// Package dittotest is a stand-in for the macOS ditto command so that code
+// which copies and archives files with ditto can be tested on any OS.
+//
+// The fake ditto is the test binary itself: Install puts a link to it named
+// ditto first in PATH, and the TestMain of the package must call Main so
+// that the test binary acts as ditto when it's run that way. Only the
+// options gon uses are supported, with the same semantics as ditto.
+package dittotest
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// envVar is set for the fake ditto so that Main knows to run it.
+const envVar = "GON_DITTOTEST"
+
+// Install puts the fake ditto first in PATH for the duration of the test.
+func Install(t *testing.T) {
+	exe, err := os.Executable()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, os.Symlink(exe, filepath.Join(dir, "ditto")))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv(envVar, "1")
+}
+
+// Main runs the fake ditto and exits if the test binary was run as ditto
+// by Install, and returns otherwise. This must be called by TestMain
+// before m.Run.
+func Main() {
+	if os.Getenv(envVar) == "" {
+		return
+	}
+
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "ditto: %s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Entries returns the sorted names of the entries of a zip archive.
+// Directories end in a slash.
+func Entries(t *testing.T, path string) []string {
+	r, err := zip.OpenReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+
+	var result []string
+	for _, f := range r.File {
+		result = append(result, f.Name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func run(args []string) error {
+	var create, extract, keepParent bool
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-c":
+			create = true
+		case "-x":
+			extract = true
+		case "-k":
+		case "--keepParent":
+			keepParent = true
+		default:
+			return fmt.Errorf("unsupported option %s", args[0])
+		}
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("a source and a destination are required")
+	}
+	srcs, dst := args[:len(args)-1], args[len(args)-1]
+
+	switch {
+	case create && len(srcs) == 1:
+		return createArchive(srcs[0], dst, keepParent)
+	case extract && len(srcs) == 1:
+		return extractArchive(srcs[0], dst)
+	case create, extract:
+		return fmt.Errorf("only one archive source is supported")
+	}
+
+	return copyFiles(srcs, dst)
+}
+
+// copyFiles copies like ditto: a single file is copied to dst, otherwise
+// dst is a directory that files are copied into and the contents of
+// directories are merged into.
+func copyFiles(srcs []string, dst string) error {
+	for _, src := range srcs {
+		fi, err := os.Lstat(src)
+		if err != nil {
+			return err
+		}
+
+		if !fi.IsDir() {
+			target := dst
+			if dfi, err := os.Stat(dst); len(srcs) > 1 || (err == nil && dfi.IsDir()) {
+				target = filepath.Join(dst, filepath.Base(src))
+			}
+			if err := copyFile(src, target, fi); err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+
+			target := filepath.Join(dst, rel)
+			if fi.IsDir() {
+				return os.MkdirAll(target, fi.Mode().Perm())
+			}
+			return copyFile(p, target, fi)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string, fi os.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, dst)
+	}
+
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, data, fi.Mode().Perm())
+}
+
+// createArchive archives the contents of src, or src itself if it's a file
+// or keepParent is set.
+func createArchive(src, dst string, keepParent bool) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	var prefix string
+	if keepParent || !fi.IsDir() {
+		prefix = filepath.Base(src)
+	}
+
+	w := zip.NewWriter(f)
+	err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." && prefix == "" {
+			return nil
+		}
+		name := path.Join(prefix, filepath.ToSlash(rel))
+
+		h, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		h.Name = name
+		if fi.IsDir() {
+			h.Name += "/"
+			_, err = w.CreateHeader(h)
+			return err
+		}
+
+		h.Method = zip.Deflate
+		fw, err := w.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(fw, link)
+			return err
+		}
+
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func extractArchive(src, dst string) error {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for _, f := range r.File {
+		target := filepath.Join(dst, filepath.FromSlash(path.Clean(f.Name)))
+		if !strings.HasPrefix(target, filepath.Clean(dst)+string(filepath.Separator)) {
+			return fmt.Errorf("%s is outside of the destination", f.Name)
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, f.Mode().Perm()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+
+		if f.Mode()&os.ModeSymlink != 0 {
+			err = os.Symlink(string(data), target)
+		} else {
+			err = ioutil.WriteFile(target, data, f.Mode().Perm())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package dsstore
+
+import (
+	"encoding/binary"
+	"path"
+	"strings"
+	"unicode/utf16"
+)
+
+// Alias record tags, from the Carbon Alias Manager.
+const (
+	aliasTagFolderName      = 0
+	aliasTagCarbonPath      = 2
+	aliasTagUnicodeName     = 14
+	aliasTagUnicodeVolume   = 15
+	aliasTagPosixPath       = 18
+	aliasTagPosixMountPoint = 19
+	aliasTagEnd             = 0xffff
+)
+
+// Alias returns a version 2 alias record for the file at path (relative to
+// the root of the volume) on the volume with the given name. This is what
+// Finder uses to refer to the background image of a window.
+//
+// Aliases normally also refer to the file and volume by ID and creation
+// date, but those aren't known until the volume is created. The alias
+// returned here only has the paths, which Finder falls back to when
+// resolving the alias.
+func Alias(volume, p string) []byte {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	name := path.Base(p)
+	dir := path.Dir(p)
+
+	// The fixed size part of the record
+	b := make([]byte, 150)
+	binary.BigEndian.PutUint16(b[6:], 2)          // version
+	pascal(b[10:38], volume)                      // volume name
+	copy(b[42:], "H+")                            // filesystem
+	binary.BigEndian.PutUint16(b[44:], 5)         // ejectable disk
+	pascal(b[50:114], name)                       // file name
+	copy(b[130:], []byte{0xff, 0xff, 0xff, 0xff}) // levels from/to
+
+	tag := func(t uint16, v []byte) {
+		b = append(b, byte(t>>8), byte(t), byte(len(v)>>8), byte(len(v)))
+		b = append(b, v...)
+		if len(v)%2 == 1 {
+			b = append(b, 0)
+		}
+	}
+	unicode := func(t uint16, v string) {
+		chars := utf16.Encode([]rune(v))
+		data := []byte{byte(len(chars) >> 8), byte(len(chars))}
+		for _, c := range chars {
+			data = append(data, byte(c>>8), byte(c))
+		}
+		tag(t, data)
+	}
+
+	if dir != "." {
+		tag(aliasTagFolderName, []byte(carbon(path.Base(dir))))
+	}
+	carbonPath := []string{carbon(volume)}
+	for _, part := range strings.Split(p, "/") {
+		carbonPath = append(carbonPath, carbon(part))
+	}
+	tag(aliasTagCarbonPath, []byte(strings.Join(carbonPath, ":")))
+	unicode(aliasTagUnicodeName, carbon(name))
+	unicode(aliasTagUnicodeVolume, carbon(volume))
+	tag(aliasTagPosixPath, []byte("/"+p))
+	tag(aliasTagPosixMountPoint, []byte("/Volumes/"+volume))
+	b = append(b, aliasTagEnd>>8, aliasTagEnd&0xff, 0, 0)
+
+	binary.BigEndian.PutUint16(b[4:], uint16(len(b)))
+	return b
+}
+
+// pascal writes a length-prefixed string into b, truncating it to fit.
+func pascal(b []byte, v string) {
+	v = carbon(v)
+	if len(v) > len(b)-1 {
+		v = v[:len(b)-1]
+	}
+
+	b[0] = byte(len(v))
+	copy(b[1:], v)
+}
+
+// carbon returns the name as Carbon sees it, which uses ":" as the path
+// separator so any colons in the name are shown as slashes.
+func carbon(v string) string {
+	return strings.Replace(v, ":", "/", -1)
+}
@@ -0,0 +1,441 @@
+// Package dsstore reads and writes the .DS_Store files that Finder uses to
+// store the view settings of a folder, such as the window size, background
+// and the positions of icons.
+//
+// Finder normally writes these files itself, which requires a GUI session.
+// Writing them directly lets the layout of a folder be set headlessly, which
+// is primarily used for the layout of dmg files.
+//
+// A .DS_Store file is a "buddy allocator" file containing a B-tree of
+// records. Each record is a property of a file in the folder, identified
+// by the name of the file and a four character code. The folder itself
+// is named ".".
+package dsstore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// pageSize is the size of each B-tree node.
+	pageSize = 0x1000
+
+	// rootBlockSize is the size reserved for the root block of the
+	// allocator, which fits the offsets of 256 blocks.
+	rootBlockSize = 2048
+)
+
+// headerUnknown are the unknown bytes at the end of the file header, as
+// written by Finder.
+var headerUnknown = []byte{
+	0x00, 0x00, 0x10, 0x0c, 0x00, 0x00, 0x00, 0x87,
+	0x00, 0x00, 0x20, 0x0b, 0x00, 0x00, 0x00, 0x00,
+}
+
+// Record is a single property of a file in the folder.
+type Record struct {
+	// Name is the name of the file in the folder, or "." for the folder.
+	Name string
+
+	// Code is the four character code of the property, such as "Iloc"
+	// for the position of the icon.
+	Code string
+
+	// Type is the four character code of the type of the data, such as
+	// "blob" or "long".
+	Type string
+
+	// Data is the encoded data of the property. For the "blob" and "ustr"
+	// types this includes the length prefix. Use the helpers such as Blob
+	// to create records with the data encoded properly.
+	Data []byte
+}
+
+// Bool returns a record with a boolean value.
+func Bool(name, code string, v bool) Record {
+	data := []byte{0}
+	if v {
+		data[0] = 1
+	}
+
+	return Record{Name: name, Code: code, Type: "bool", Data: data}
+}
+
+// Long returns a record with a 32-bit integer value.
+func Long(name, code string, v uint32) Record {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, v)
+	return Record{Name: name, Code: code, Type: "long", Data: data}
+}
+
+// Type returns a record with a four character code value.
+func Type(name, code, v string) Record {
+	return Record{Name: name, Code: code, Type: "type", Data: []byte(v)}
+}
+
+// Blob returns a record with arbitrary data, such as a binary plist.
+func Blob(name, code string, v []byte) Record {
+	data := make([]byte, 4, 4+len(v))
+	binary.BigEndian.PutUint32(data, uint32(len(v)))
+	return Record{Name: name, Code: code, Type: "blob", Data: append(data, v...)}
+}
+
+// Ustr returns a record with a string value.
+func Ustr(name, code, v string) Record {
+	return Record{Name: name, Code: code, Type: "ustr", Data: encodeString(v)}
+}
+
+// Iloc returns a record with the position of the icon of the named file.
+// The position is the center of the icon, relative to the top left of
+// the window.
+func Iloc(name string, x, y int) Record {
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint32(data[0:], uint32(x))
+	binary.BigEndian.PutUint32(data[4:], uint32(y))
+	binary.BigEndian.PutUint32(data[8:], 0xffffffff)
+	binary.BigEndian.PutUint32(data[12:], 0xffff0000)
+	return Blob(name, "Iloc", data)
+}
+
+// Write encodes the records as a .DS_Store file. The records don't have
+// to be sorted.
+func Write(records []Record) ([]byte, error) {
+	records = append([]Record(nil), records...)
+	for _, r := range records {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].less(&records[j])
+	})
+
+	var a allocator
+	a.free[31] = []uint32{0}
+
+	// The header is the first 32 bytes. The root block and the DSDB block
+	// are always blocks 0 and 1.
+	a.alloc(32)
+	root := a.block(rootBlockSize)
+	dsdb := a.block(20)
+
+	// Write the B-tree bottom up. Each level is the list of nodes with the
+	// records separating them, which are moved up to the next level.
+	nodes := 0
+	levels := 0
+	children, seps, err := a.leaves(records)
+	if err != nil {
+		return nil, err
+	}
+	nodes += len(children)
+	for len(children) > 1 {
+		children, seps, err = a.internal(children, seps)
+		if err != nil {
+			return nil, err
+		}
+		nodes += len(children)
+		levels++
+	}
+
+	// The DSDB block describes the B-tree
+	data := make([]byte, 20)
+	binary.BigEndian.PutUint32(data[0:], children[0])
+	binary.BigEndian.PutUint32(data[4:], uint32(levels))
+	binary.BigEndian.PutUint32(data[8:], uint32(len(records)))
+	binary.BigEndian.PutUint32(data[12:], uint32(nodes))
+	binary.BigEndian.PutUint32(data[16:], pageSize)
+	a.data[dsdb] = data
+
+	// The root block has the offsets of every block, the table of
+	// contents and the free lists. This must be last since it includes
+	// the state of the allocator.
+	if len(a.offsets) > 256 {
+		return nil, fmt.Errorf("too many records for a .DS_Store file")
+	}
+	var rb []byte
+	rb = appendUint32(rb, uint32(len(a.offsets)), 0)
+	for i := 0; i < 256; i++ {
+		var addr uint32
+		if i < len(a.offsets) {
+			addr = a.offsets[i]
+		}
+		rb = appendUint32(rb, addr)
+	}
+	rb = appendUint32(rb, 1)
+	rb = append(append(rb, 4), "DSDB"...)
+	rb = appendUint32(rb, dsdb)
+	for _, f := range a.free {
+		rb = appendUint32(rb, uint32(len(f)))
+		rb = appendUint32(rb, f...)
+	}
+	a.data[root] = rb
+
+	// Lay out the file. Offsets within the file are relative to the
+	// end of the 4-byte file magic.
+	var size uint32
+	for _, addr := range a.offsets {
+		offset, blockSize := blockRange(addr)
+		if end := offset + blockSize; end > size {
+			size = end
+		}
+	}
+	out := make([]byte, 4+size)
+	rootOffset, rootSize := blockRange(a.offsets[root])
+	binary.BigEndian.PutUint32(out[0:], 1)
+	copy(out[4:], "Bud1")
+	binary.BigEndian.PutUint32(out[8:], rootOffset)
+	binary.BigEndian.PutUint32(out[12:], rootSize)
+	binary.BigEndian.PutUint32(out[16:], rootOffset)
+	copy(out[20:], headerUnknown)
+	for id, addr := range a.offsets {
+		offset, _ := blockRange(addr)
+		copy(out[4+offset:], a.data[uint32(id)])
+	}
+
+	return out, nil
+}
+
+// leaves writes the records into leaf nodes. It returns the block numbers
+// of the leaves and the records that separate them.
+func (a *allocator) leaves(records []Record) ([]uint32, []Record, error) {
+	var blocks []uint32
+	var seps []Record
+	for {
+		// Fill the leaf with as many records as fit
+		var sizes []int
+		size := 8
+		for _, r := range records {
+			n := len(r.encode())
+			if size+n > pageSize {
+				break
+			}
+
+			size += n
+			sizes = append(sizes, n)
+		}
+		if len(sizes) == 0 && len(records) > 0 {
+			return nil, nil, fmt.Errorf(
+				"record %q for %q is too large", records[0].Code, records[0].Name)
+		}
+
+		// The record after a full leaf separates it from the next leaf. If
+		// that's the last record, the last record of this leaf separates
+		// them instead so that the next leaf isn't empty.
+		count := len(sizes)
+		if count == len(records)-1 && count > 1 {
+			count--
+		}
+
+		node := appendUint32(nil, 0, uint32(count))
+		for _, r := range records[:count] {
+			node = append(node, r.encode()...)
+		}
+		id := a.block(pageSize)
+		a.data[id] = node
+		blocks = append(blocks, id)
+
+		records = records[count:]
+		if len(records) == 0 {
+			return blocks, seps, nil
+		}
+		seps = append(seps, records[0])
+		records = records[1:]
+	}
+}
+
+// internal writes a level of internal nodes over the given children, which
+// are separated by seps. It returns the block numbers of the nodes and the
+// records that separate them.
+func (a *allocator) internal(children []uint32, seps []Record) ([]uint32, []Record, error) {
+	var blocks []uint32
+	var nextSeps []Record
+	for len(children) > 0 {
+		// Internal nodes list each child followed by the record after it,
+		// with the last child in the header.
+		node := appendUint32(nil, 0, 0)
+		count := 0
+		for len(seps) > 0 {
+			enc := append(appendUint32(nil, children[0]), seps[0].encode()...)
+			if len(node)+len(enc) > pageSize {
+				break
+			}
+
+			node = append(node, enc...)
+			children = children[1:]
+			seps = seps[1:]
+			count++
+		}
+		if count == 0 && len(seps) > 0 {
+			return nil, nil, fmt.Errorf(
+				"record %q for %q is too large", seps[0].Code, seps[0].Name)
+		}
+
+		binary.BigEndian.PutUint32(node[0:], children[0])
+		binary.BigEndian.PutUint32(node[4:], uint32(count))
+		children = children[1:]
+		id := a.block(pageSize)
+		a.data[id] = node
+		blocks = append(blocks, id)
+
+		if len(seps) > 0 {
+			nextSeps = append(nextSeps, seps[0])
+			seps = seps[1:]
+		}
+	}
+
+	return blocks, nextSeps, nil
+}
+
+func (r *Record) validate() error {
+	if len(r.Code) != 4 || len(r.Type) != 4 {
+		return fmt.Errorf("record %q for %q must have a four character code and type",
+			r.Code, r.Name)
+	}
+
+	n, err := dataLen(r.Type, r.Data)
+	if err != nil {
+		return fmt.Errorf("record %q for %q: %s", r.Code, r.Name, err)
+	}
+	if n != len(r.Data) {
+		return fmt.Errorf("record %q for %q has %d bytes of data, expected %d",
+			r.Code, r.Name, len(r.Data), n)
+	}
+
+	return nil
+}
+
+// less sorts records the way Finder does, by the case-insensitive name of
+// the file and then by code.
+func (r *Record) less(other *Record) bool {
+	a, b := strings.ToLower(r.Name), strings.ToLower(other.Name)
+	if a != b {
+		return a < b
+	}
+
+	return r.Code < other.Code
+}
+
+func (r *Record) encode() []byte {
+	result := encodeString(r.Name)
+	result = append(result, r.Code...)
+	result = append(result, r.Type...)
+	return append(result, r.Data...)
+}
+
+// dataLen returns the length of the data of a record of type typ that
+// starts with data.
+func dataLen(typ string, data []byte) (int, error) {
+	switch typ {
+	case "bool":
+		return 1, nil
+	case "long", "shor", "type":
+		return 4, nil
+	case "comp", "dutc":
+		return 8, nil
+	case "blob", "ustr":
+		if len(data) < 4 {
+			return 0, fmt.Errorf("missing length of %q data", typ)
+		}
+
+		n := int(binary.BigEndian.Uint32(data))
+		if typ == "ustr" {
+			n *= 2
+		}
+		return 4 + n, nil
+	default:
+		return 0, fmt.Errorf("unknown type %q", typ)
+	}
+}
+
+// encodeString encodes a string as UTF-16 with its length in characters.
+func encodeString(v string) []byte {
+	chars := utf16.Encode([]rune(v))
+	result := appendUint32(nil, uint32(len(chars)))
+	for _, c := range chars {
+		result = append(result, byte(c>>8), byte(c))
+	}
+
+	return result
+}
+
+func appendUint32(b []byte, vs ...uint32) []byte {
+	for _, v := range vs {
+		b = append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+
+	return b
+}
+
+// allocator is a buddy allocator for the blocks of the file. Every block
+// has a size that is a power of two and is aligned to its size.
+type allocator struct {
+	// free are the offsets of the free blocks of each size, indexed by
+	// the log2 of the size.
+	free [32][]uint32
+
+	// offsets are the addresses of the blocks, indexed by block number.
+	// The address is the offset of the block with the log2 of its size in
+	// the low five bits.
+	offsets []uint32
+
+	// data is the content of each block.
+	data map[uint32][]byte
+}
+
+// block allocates a new block that fits size bytes and returns its
+// block number.
+func (a *allocator) block(size int) uint32 {
+	offset, width := a.alloc(size)
+	if a.data == nil {
+		a.data = map[uint32][]byte{}
+	}
+
+	a.offsets = append(a.offsets, offset|uint32(width))
+	return uint32(len(a.offsets) - 1)
+}
+
+// alloc allocates size bytes and returns the offset and log2 of the size
+// of the allocated space.
+func (a *allocator) alloc(size int) (uint32, int) {
+	width := 5
+	for 1<<uint(width) < size {
+		width++
+	}
+
+	// Find the smallest free block that fits. The file is 2^31 bytes at
+	// most, which is far more than we'll ever need.
+	w := width
+	for len(a.free[w]) == 0 {
+		w++
+	}
+	offset := a.free[w][0]
+	a.free[w] = a.free[w][1:]
+
+	// Split it until it's the right size, freeing the other halves.
+	for w > width {
+		w--
+		a.release(offset+1<<uint(w), w)
+	}
+
+	return offset, width
+}
+
+// release adds a free block to the free list, keeping it sorted.
+func (a *allocator) release(offset uint32, width int) {
+	f := a.free[width]
+	i := sort.Search(len(f), func(i int) bool { return f[i] >= offset })
+	f = append(f, 0)
+	copy(f[i+1:], f[i:])
+	f[i] = offset
+	a.free[width] = f
+}
+
+// blockRange returns the offset and size of the block with the given
+// address.
+func blockRange(addr uint32) (uint32, uint32) {
+	return addr &^ 0x1f, 1 << (addr & 0x1f)
+}
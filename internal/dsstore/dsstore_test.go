@@ -0,0 +1,91 @@
+package dsstore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	records := []Record{
+		Iloc("b.app", 100, 200),
+		Long(".", "vSrn", 1),
+		Type(".", "vstl", "icnv"),
+		Bool("A", "dscl", true),
+		Ustr("a", "cmmt", "héllo"),
+		Blob(".", "bwsp", []byte("plist")),
+	}
+
+	data, err := Write(records)
+	require.NoError(t, err)
+	require.Equal(t, "Bud1", string(data[4:8]))
+
+	actual, err := Read(data)
+	require.NoError(t, err)
+	require.Equal(t, []Record{
+		records[5], records[1], records[2], records[4], records[3], records[0],
+	}, actual)
+}
+
+func TestWrite_large(t *testing.T) {
+	// Enough records to need an internal node in the B-tree
+	var records []Record
+	for i := 0; i < 5000; i++ {
+		records = append(records, Iloc(fmt.Sprintf("file-%05d", i), i, i))
+	}
+
+	data, err := Write(records)
+	require.NoError(t, err)
+
+	actual, err := Read(data)
+	require.NoError(t, err)
+	require.Equal(t, records, actual)
+
+	// The DSDB block should describe a tree with internal nodes
+	actualRecords, levels := dsdb(t, data)
+	require.Equal(t, uint32(len(records)), actualRecords)
+	require.Equal(t, uint32(1), levels)
+}
+
+func TestWrite_invalid(t *testing.T) {
+	cases := []Record{
+		{Name: "a", Code: "Iloc", Type: "blob"},
+		{Name: "a", Code: "Il", Type: "long", Data: make([]byte, 4)},
+		{Name: "a", Code: "Iloc", Type: "long", Data: make([]byte, 2)},
+		{Name: "a", Code: "Iloc", Type: "nope", Data: make([]byte, 4)},
+		Blob("a", "bwsp", make([]byte, pageSize)),
+	}
+
+	for _, r := range cases {
+		_, err := Write([]Record{r})
+		require.Error(t, err)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	alias := Alias("Foo", ".background/bg.png")
+	require.Equal(t, uint16(len(alias)), binary.BigEndian.Uint16(alias[4:]))
+	require.Equal(t, uint16(2), binary.BigEndian.Uint16(alias[6:]))
+	require.Equal(t, "\x03Foo", string(alias[10:14]))
+	require.Equal(t, "\x06bg.png", string(alias[50:57]))
+	require.Contains(t, string(alias), "Foo:.background:bg.png")
+	require.Contains(t, string(alias), "/.background/bg.png")
+	require.Contains(t, string(alias), "/Volumes/Foo")
+}
+
+// dsdb returns the number of records and levels from the DSDB block.
+func dsdb(t *testing.T, data []byte) (uint32, uint32) {
+	t.Helper()
+
+	data = data[4:]
+	root := data[binary.BigEndian.Uint32(data[4:]):]
+	count := binary.BigEndian.Uint32(root)
+	toc := root[8+4*((count+255)&^255):]
+	require.Equal(t, "\x04DSDB", string(toc[4:9]))
+
+	offset, _ := blockRange(binary.BigEndian.Uint32(root[8+4*binary.BigEndian.Uint32(toc[9:]):]))
+	block := data[offset:]
+	return binary.BigEndian.Uint32(block[8:]), binary.BigEndian.Uint32(block[4:])
+}
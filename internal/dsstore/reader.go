@@ -0,0 +1,185 @@
+package dsstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Read decodes the records of a .DS_Store file, in the order they're
+// stored in the file.
+func Read(data []byte) ([]Record, error) {
+	if len(data) < 36 || binary.BigEndian.Uint32(data) != 1 || string(data[4:8]) != "Bud1" {
+		return nil, errors.New("not a .DS_Store file")
+	}
+
+	// Offsets within the file are relative to the end of the file magic
+	data = data[4:]
+	rootOffset := binary.BigEndian.Uint32(data[4:])
+	rootSize := binary.BigEndian.Uint32(data[8:])
+	if binary.BigEndian.Uint32(data[12:]) != rootOffset {
+		return nil, errors.New("inconsistent root block offset")
+	}
+	root, err := slice(data, rootOffset, rootSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the offsets of the blocks. These are padded to a multiple of 256.
+	r := &reader{data: root}
+	count := r.uint32()
+	r.uint32()
+	offsets := make([]uint32, (count+255)&^255)
+	for i := range offsets {
+		offsets[i] = r.uint32()
+	}
+	offsets = offsets[:count]
+
+	// Find the DSDB block in the table of contents
+	dsdb := -1
+	for n := r.uint32(); n > 0 && r.err == nil; n-- {
+		name := string(r.bytes(int(r.byte())))
+		if id := r.uint32(); name == "DSDB" {
+			dsdb = int(id)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if dsdb < 0 {
+		return nil, errors.New("no DSDB block")
+	}
+
+	block := func(id uint32) ([]byte, error) {
+		if int(id) >= len(offsets) {
+			return nil, fmt.Errorf("invalid block %d", id)
+		}
+
+		offset, size := blockRange(offsets[id])
+		return slice(data, offset, size)
+	}
+
+	b, err := block(uint32(dsdb))
+	if err != nil {
+		return nil, err
+	}
+	r = &reader{data: b}
+	rootNode := r.uint32()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// Walk the B-tree in order
+	var result []Record
+	var walk func(id uint32, depth int) error
+	walk = func(id uint32, depth int) error {
+		if depth > 32 {
+			return errors.New("B-tree is too deep")
+		}
+
+		b, err := block(id)
+		if err != nil {
+			return err
+		}
+
+		r := &reader{data: b}
+		next := r.uint32()
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			if next != 0 {
+				if err := walk(r.uint32(), depth+1); err != nil {
+					return err
+				}
+			}
+
+			result = append(result, r.record())
+		}
+		if r.err != nil {
+			return r.err
+		}
+		if next != 0 {
+			return walk(next, depth+1)
+		}
+
+		return nil
+	}
+	if err := walk(rootNode, 0); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func slice(data []byte, offset, size uint32) ([]byte, error) {
+	if uint64(offset)+uint64(size) > uint64(len(data)) {
+		return nil, fmt.Errorf("block at %d is out of range", offset)
+	}
+
+	return data[offset : offset+size], nil
+}
+
+// reader reads big-endian values from data. Once an error occurs, every
+// read returns zero values and err is set.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = errors.New("unexpected end of block")
+		return nil
+	}
+
+	result := r.data[:n]
+	r.data = r.data[n:]
+	return result
+}
+
+func (r *reader) byte() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+
+	return 0
+}
+
+func (r *reader) string() string {
+	n := int(r.uint32())
+	b := r.bytes(2 * n)
+	chars := make([]uint16, 0, n)
+	for i := 0; i+1 < len(b); i += 2 {
+		chars = append(chars, binary.BigEndian.Uint16(b[i:]))
+	}
+
+	return string(utf16.Decode(chars))
+}
+
+func (r *reader) record() Record {
+	var result Record
+	result.Name = r.string()
+	result.Code = string(r.bytes(4))
+	result.Type = string(r.bytes(4))
+	if r.err != nil {
+		return result
+	}
+
+	n, err := dataLen(result.Type, r.data)
+	if err != nil {
+		r.err = err
+		return result
+	}
+	result.Data = r.bytes(n)
+	return result
+}
@@ -0,0 +1,120 @@
+// Package fat reads and writes universal (fat) Mach-O binaries.
+//
+// debug/macho can read universal binaries but can't write them, and
+// signing or merging binaries requires rewriting the container since the
+// size of each architecture changes.
+package fat
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	magicFat   = 0xcafebabe
+	headerSize = 8
+	archSize   = 20
+)
+
+// DefaultAlign is the default alignment of each architecture, as a power
+// of two. This is 16KB which is the page size on arm64.
+const DefaultAlign = 14
+
+// Arch is a single architecture within a universal binary.
+type Arch struct {
+	// CPU and SubCPU are the CPU type and subtype of the architecture.
+	CPU    macho.Cpu
+	SubCPU uint32
+
+	// Align is the alignment of the architecture in the file, as a power
+	// of two.
+	Align uint32
+
+	// Data is the thin Mach-O binary of this architecture.
+	Data []byte
+}
+
+// IsFat returns true if data is a universal binary.
+func IsFat(data []byte) bool {
+	return len(data) >= 4 && binary.BigEndian.Uint32(data) == magicFat
+}
+
+// Read reads the architectures of the universal binary.
+func Read(data []byte) ([]*Arch, error) {
+	f, err := macho.NewFatFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result := make([]*Arch, 0, len(f.Arches))
+	for _, fa := range f.Arches {
+		end := uint64(fa.Offset) + uint64(fa.Size)
+		if end > uint64(len(data)) {
+			return nil, fmt.Errorf("architecture %s is out of bounds", fa.Cpu)
+		}
+
+		result = append(result, &Arch{
+			CPU:    fa.Cpu,
+			SubCPU: fa.SubCpu,
+			Align:  fa.Align,
+			Data:   data[fa.Offset:end],
+		})
+	}
+
+	return result, nil
+}
+
+// Marshal creates a universal binary containing the given architectures.
+// Architectures with an alignment of zero use DefaultAlign.
+func Marshal(arches []*Arch) ([]byte, error) {
+	if len(arches) == 0 {
+		return nil, errors.New("at least one architecture is required")
+	}
+
+	be := binary.BigEndian
+	header := make([]byte, headerSize+archSize*len(arches))
+	be.PutUint32(header[0:], magicFat)
+	be.PutUint32(header[4:], uint32(len(arches)))
+
+	var result bytes.Buffer
+	offset := uint64(len(header))
+	offsets := make([]uint64, len(arches))
+	for i, arch := range arches {
+		align := arch.Align
+		if align == 0 {
+			align = DefaultAlign
+		}
+
+		offset = roundUp(offset, 1<<align)
+		if offset+uint64(len(arch.Data)) > 1<<32-1 {
+			return nil, errors.New("universal binary is too large")
+		}
+		offsets[i] = offset
+
+		h := header[headerSize+archSize*i:]
+		be.PutUint32(h[0:], uint32(arch.CPU))
+		be.PutUint32(h[4:], arch.SubCPU)
+		be.PutUint32(h[8:], uint32(offset))
+		be.PutUint32(h[12:], uint32(len(arch.Data)))
+		be.PutUint32(h[16:], align)
+
+		offset += uint64(len(arch.Data))
+	}
+
+	result.Write(header)
+	for i, arch := range arches {
+		result.Write(make([]byte, offsets[i]-uint64(result.Len())))
+		result.Write(arch.Data)
+	}
+
+	return result.Bytes(), nil
+}
+
+// roundUp rounds v up to a multiple of align, which must be a power of two.
+func roundUp(v, align uint64) uint64 {
+	return (v + align - 1) &^ (align - 1)
+}
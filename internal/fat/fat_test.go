@@ -0,0 +1,59 @@
+package fat
+
+import (
+	"debug/macho"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitchellh/gon/internal/machotest"
+)
+
+func TestMarshalRead(t *testing.T) {
+	amd64 := machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64})
+	arm64 := machotest.Build(t, &machotest.Options{CPU: machotest.CPUARM64})
+
+	data, err := Marshal([]*Arch{
+		{CPU: macho.CpuAmd64, SubCPU: 3, Align: 12, Data: amd64},
+		{CPU: macho.CpuArm64, Data: arm64},
+	})
+	require.NoError(t, err)
+	require.True(t, IsFat(data))
+	require.False(t, IsFat(amd64))
+
+	arches, err := Read(data)
+	require.NoError(t, err)
+	require.Len(t, arches, 2)
+	require.Equal(t, macho.CpuAmd64, arches[0].CPU)
+	require.Equal(t, uint32(3), arches[0].SubCPU)
+	require.Equal(t, uint32(12), arches[0].Align)
+	require.Equal(t, amd64, arches[0].Data)
+	require.Equal(t, macho.CpuArm64, arches[1].CPU)
+	require.Equal(t, uint32(DefaultAlign), arches[1].Align)
+	require.Equal(t, arm64, arches[1].Data)
+}
+
+func TestMarshal_empty(t *testing.T) {
+	_, err := Marshal(nil)
+	require.Error(t, err)
+}
+
+func TestIsMachO(t *testing.T) {
+	amd64 := machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64})
+	universal, err := Marshal([]*Arch{{CPU: macho.CpuAmd64, Data: amd64}})
+	require.NoError(t, err)
+
+	require.True(t, IsMachO(amd64))
+	require.True(t, IsMachO(universal))
+
+	// Java class files share the fat magic, followed by their version
+	require.False(t, IsMachO([]byte{0xca, 0xfe, 0xba, 0xbe, 0x00, 0x00, 0x00, 0x34}))
+	require.False(t, IsMachO([]byte{0xca, 0xfe, 0xba, 0xbe}))
+
+	// Universal binaries with 64-bit offsets can't be read
+	fat64 := append([]byte{}, universal...)
+	fat64[3] = 0xbf
+	require.False(t, IsMachO(fat64))
+	require.False(t, IsFat(fat64))
+	require.False(t, IsMachO([]byte("#!/bin/sh\n")))
+}
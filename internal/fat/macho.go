@@ -0,0 +1,50 @@
+package fat
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// maxArches is the most architectures we expect in a universal binary.
+// Java class files share the fat magic and store their version where the
+// number of architectures would be, which is always much larger.
+const maxArches = 20
+
+// IsMachO returns true if header, the start of a file, is the header of a
+// thin or universal Mach-O binary. At least the first 8 bytes of the file
+// are needed to tell universal binaries apart from Java class files.
+// Universal binaries with 64-bit offsets aren't supported by Read, so they
+// aren't detected either.
+func IsMachO(header []byte) bool {
+	if len(header) < headerSize {
+		return false
+	}
+
+	switch binary.BigEndian.Uint32(header) {
+	case 0xfeedface, 0xfeedfacf, 0xcefaedfe, 0xcffaedfe:
+		return true
+
+	case magicFat:
+		return binary.BigEndian.Uint32(header[4:]) < maxArches
+	}
+
+	return false
+}
+
+// IsMachOFile returns true if the file at path is a thin or universal
+// Mach-O binary. Files that can't be read aren't Mach-O binaries.
+func IsMachOFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+
+	return IsMachO(header)
+}
@@ -0,0 +1,188 @@
+// Package filetype detects the type of the files given to gon for
+// notarization, which determines how they're submitted and if they can
+// be stapled.
+//
+// Files are detected by their contents where possible rather than their
+// extension, so that a misnamed file is caught before it's uploaded and
+// rejected by the notarization service.
+package filetype
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/gon/internal/fat"
+)
+
+// Type is the type of a file.
+type Type string
+
+const (
+	// App is an application bundle (.app directory).
+	App Type = "app"
+
+	// Dmg is a UDIF disk image.
+	Dmg Type = "dmg"
+
+	// Pkg is a flat installer package, which is a xar archive.
+	Pkg Type = "pkg"
+
+	// Zip is a zip archive.
+	Zip Type = "zip"
+
+	// MachO is a bare Mach-O binary, thin or universal.
+	MachO Type = "macho"
+)
+
+// Notarizable returns true if files of this type can be notarized. Apps
+// must be archived into a zip to be submitted.
+func (t Type) Notarizable() bool {
+	switch t {
+	case App, Dmg, Pkg, Zip:
+		return true
+	}
+
+	return false
+}
+
+// Stapleable returns true if a notarization ticket can be stapled to
+// files of this type. Zip archives can't be stapled, but the files within
+// them can be (see ZipStapleable).
+func (t Type) Stapleable() bool {
+	switch t {
+	case App, Dmg, Pkg:
+		return true
+	}
+
+	return false
+}
+
+// Detect returns the type of the file at path. Files are detected by
+// their magic bytes, and directories by their extension. An error is
+// returned if the type isn't one of the types in this package, or if the
+// extension contradicts the contents, such as a ".dmg" that isn't a disk
+// image.
+func Detect(path string) (Type, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if fi.IsDir() {
+		if ext == ".app" {
+			return App, nil
+		}
+
+		return "", fmt.Errorf("%s: directories other than .app bundles are not supported", path)
+	}
+
+	t, err := detectFile(path, fi.Size())
+	if err != nil {
+		return "", err
+	}
+
+	// The extension must agree with the contents for the types that
+	// have a well-known extension.
+	if expected, ok := extensions[ext]; ok && expected != t {
+		if t == "" {
+			return "", fmt.Errorf("%s: file has a %s extension but is not a %s", path, ext, descriptions[expected])
+		}
+
+		return "", fmt.Errorf("%s: file has a %s extension but is a %s", path, ext, descriptions[t])
+	}
+	if t == "" {
+		return "", fmt.Errorf("%s: unknown file type, expected an app, dmg, pkg, or zip", path)
+	}
+
+	return t, nil
+}
+
+// extensions are the types of the well-known file extensions.
+var extensions = map[string]Type{
+	".dmg": Dmg,
+	".pkg": Pkg,
+	".zip": Zip,
+}
+
+// descriptions are the types described for error messages.
+var descriptions = map[Type]string{
+	App:   "app bundle",
+	Dmg:   "UDIF disk image",
+	Pkg:   "flat installer package",
+	Zip:   "zip archive",
+	MachO: "Mach-O binary",
+}
+
+// detectFile returns the type of a regular file by its contents, or an
+// empty type if it isn't known.
+func detectFile(path string, size int64) (Type, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, 8)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	header = header[:n]
+	if len(header) < 4 {
+		return "", nil
+	}
+
+	switch string(header[:4]) {
+	case "xar!":
+		return Pkg, nil
+
+	// A zip starts with a local file header, or with the end of central
+	// directory record if it's empty.
+	case "PK\x03\x04", "PK\x05\x06":
+		return Zip, nil
+	}
+
+	if fat.IsMachO(header) {
+		return MachO, nil
+	}
+
+	// UDIF disk images end with a 512 byte trailer that starts with "koly"
+	if size >= 512 {
+		magic := make([]byte, 4)
+		if _, err := f.ReadAt(magic, size-512); err != nil {
+			return "", err
+		}
+		if string(magic) == "koly" {
+			return Dmg, nil
+		}
+	}
+
+	return "", nil
+}
+
+// ZipStapleable returns true if the zip archive at path contains any app
+// bundles, disk images, or installer packages, which can be stapled once
+// the archive is notarized.
+func ZipStapleable(path string) (bool, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		for _, part := range strings.Split(f.Name, "/") {
+			switch strings.ToLower(filepath.Ext(part)) {
+			case ".app", ".dmg", ".pkg":
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,127 @@
+package filetype
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetect(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon-filetype")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	write := func(name string, data []byte) string {
+		path := filepath.Join(td, name)
+		require.NoError(t, ioutil.WriteFile(path, data, 0644))
+		return path
+	}
+
+	dmg := make([]byte, 2048)
+	copy(dmg[len(dmg)-512:], "koly")
+
+	cases := []struct {
+		Path     string
+		Expected Type
+		Err      bool
+	}{
+		{filepath.Join(td, "Foo.app"), App, false},
+		{filepath.Join(td, "dir"), "", true},
+		{write("foo.zip", zipData(t, "foo")), Zip, false},
+		{write("foo.pkg", []byte("xar!\x00\x1c")), Pkg, false},
+		{write("foo.dmg", dmg), Dmg, false},
+		{write("foo", []byte{0xcf, 0xfa, 0xed, 0xfe, 0x07, 0x00, 0x00, 0x01}), MachO, false},
+		{write("fat", []byte{0xca, 0xfe, 0xba, 0xbe, 0x00, 0x00, 0x00, 0x02}), MachO, false},
+
+		// Types are detected by contents, regardless of extension
+		{write("release", zipData(t, "foo")), Zip, false},
+		{write("release.tar", dmg), Dmg, false},
+
+		// The extension must agree with the contents
+		{write("bad.dmg", []byte("not a disk image")), "", true},
+		{write("bad.pkg", zipData(t, "foo")), "", true},
+		{write("bad.zip", []byte{0xcf, 0xfa, 0xed, 0xfe}), "", true},
+
+		// Java class files share the fat magic, but aren't Mach-O binaries
+		{write("Hello.class", []byte{0xca, 0xfe, 0xba, 0xbe, 0x00, 0x00, 0x00, 0x34, 0x00, 0x05}), "", true},
+
+		// Unknown and missing files
+		{write("empty", nil), "", true},
+		{write("README", []byte("hello")), "", true},
+		{filepath.Join(td, "missing"), "", true},
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(td, "Foo.app"), 0755))
+	require.NoError(t, os.Mkdir(filepath.Join(td, "dir"), 0755))
+
+	for _, tc := range cases {
+		t.Run(filepath.Base(tc.Path), func(t *testing.T) {
+			actual, err := Detect(tc.Path)
+			if tc.Err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.Expected, actual)
+		})
+	}
+}
+
+func TestZipStapleable(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon-filetype")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	cases := map[string]struct {
+		Names    []string
+		Expected bool
+	}{
+		"binary":  {[]string{"foo"}, false},
+		"app":     {[]string{"Foo.app/Contents/MacOS/foo"}, true},
+		"nested":  {[]string{"dist/Foo.App/Contents/Info.plist"}, true},
+		"pkg":     {[]string{"foo.pkg"}, true},
+		"dmg":     {[]string{"release/foo.dmg"}, true},
+		"similar": {[]string{"foo.application", "docs/app"}, false},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(td, name+".zip")
+			require.NoError(t, ioutil.WriteFile(path, zipData(t, tc.Names...), 0644))
+
+			actual, err := ZipStapleable(path)
+			require.NoError(t, err)
+			require.Equal(t, tc.Expected, actual)
+		})
+	}
+}
+
+func TestTypeStapleable(t *testing.T) {
+	require.True(t, App.Stapleable())
+	require.True(t, Dmg.Stapleable())
+	require.True(t, Pkg.Stapleable())
+	require.False(t, Zip.Stapleable())
+	require.False(t, MachO.Stapleable())
+
+	require.True(t, Zip.Notarizable())
+	require.False(t, MachO.Notarizable())
+}
+
+// zipData returns a zip archive with an empty file for each name.
+func zipData(t *testing.T, names ...string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range names {
+		_, err := w.Create(name)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
@@ -0,0 +1,104 @@
+// Package icns encodes Apple icon image (.icns) files.
+//
+// Icon files are normally created from an "iconset" directory with
+// iconutil, which is only available on macOS. This package creates them
+// from a single high-resolution image instead, scaling it to each of the
+// standard sizes, so icons can be created on any OS.
+package icns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Size is an image within an icon file.
+type Size struct {
+	// Type is the four character code of the image in the icon file.
+	Type string
+
+	// Pixels is the width and height of the image in pixels.
+	Pixels int
+}
+
+// Sizes are the images of an icon file, which are the sizes iconutil
+// creates from a complete iconset: 16, 32, 128, 256 and 512 points at
+// 1x and 2x. All of these store PNG data, which requires macOS 10.7.
+var Sizes = []Size{
+	{"icp4", 16},   // 16x16
+	{"ic11", 32},   // 16x16@2x
+	{"icp5", 32},   // 32x32
+	{"ic12", 64},   // 32x32@2x
+	{"ic07", 128},  // 128x128
+	{"ic13", 256},  // 128x128@2x
+	{"ic08", 256},  // 256x256
+	{"ic14", 512},  // 256x256@2x
+	{"ic09", 512},  // 512x512
+	{"ic10", 1024}, // 512x512@2x
+}
+
+// Encode writes an icon file with every size in Sizes to w, scaling img
+// as needed. The image must be square. For the best results, it should
+// be at least 1024x1024 so that it's only ever scaled down.
+func Encode(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() != b.Dy() || b.Dx() == 0 {
+		return fmt.Errorf("icon image must be square, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	// Images that are the same size are only scaled and encoded once
+	src := newSource(img)
+	cache := map[int][]byte{}
+	var body bytes.Buffer
+	for _, s := range Sizes {
+		data, ok := cache[s.Pixels]
+		if !ok {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, src.resize(s.Pixels)); err != nil {
+				return err
+			}
+
+			data = buf.Bytes()
+			cache[s.Pixels] = data
+		}
+
+		body.WriteString(s.Type)
+		binary.Write(&body, binary.BigEndian, uint32(8+len(data)))
+		body.Write(data)
+	}
+
+	header := make([]byte, 8)
+	copy(header, "icns")
+	binary.BigEndian.PutUint32(header[4:], uint32(8+body.Len()))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := body.WriteTo(w)
+	return err
+}
+
+// EncodeFile writes an icon file to dst created from the PNG image at src.
+func EncodeFile(dst, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		return fmt.Errorf("error reading icon image %s: %s", src, err)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, img); err != nil {
+		return fmt.Errorf("error creating icon from %s: %s", src, err)
+	}
+
+	return ioutil.WriteFile(dst, buf.Bytes(), 0644)
+}
@@ -0,0 +1,101 @@
+package icns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	// A solid square with a transparent border, smaller than the largest
+	// size so that it's scaled both ways.
+	img := image.NewNRGBA(image.Rect(0, 0, 300, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 300; x++ {
+			if x >= 50 && x < 250 && y >= 50 && y < 250 {
+				img.SetNRGBA(x, y, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, img))
+
+	entries := parse(t, buf.Bytes())
+	require.Len(t, entries, len(Sizes))
+	for _, s := range Sizes {
+		data, ok := entries[s.Type]
+		require.True(t, ok, s.Type)
+
+		actual, err := png.Decode(bytes.NewReader(data))
+		require.NoError(t, err, s.Type)
+		require.Equal(t, image.Rect(0, 0, s.Pixels, s.Pixels), actual.Bounds(), s.Type)
+
+		// The center has the color of the square and the corners are
+		// transparent, without the color bleeding into the edges.
+		center := color.NRGBAModel.Convert(actual.At(s.Pixels/2, s.Pixels/2)).(color.NRGBA)
+		require.Equal(t, color.NRGBA{R: 200, G: 100, B: 50, A: 255}, center, s.Type)
+		_, _, _, a := actual.At(0, 0).RGBA()
+		require.Zero(t, a, s.Type)
+
+		edge := color.NRGBAModel.Convert(actual.At(s.Pixels/6, s.Pixels/2)).(color.NRGBA)
+		if edge.A > 0 {
+			require.InDelta(t, 200, edge.R, 1, s.Type)
+			require.InDelta(t, 100, edge.G, 1, s.Type)
+		}
+	}
+}
+
+func TestEncode_notSquare(t *testing.T) {
+	var buf bytes.Buffer
+	require.Error(t, Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 100, 50))))
+}
+
+func TestEncodeFile(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon-icns")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	src := filepath.Join(td, "logo.png")
+	f, err := os.Create(src)
+	require.NoError(t, err)
+	require.NoError(t, png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 64, 64))))
+	require.NoError(t, f.Close())
+
+	dst := filepath.Join(td, "logo.icns")
+	require.NoError(t, EncodeFile(dst, src))
+	data, err := ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	require.Len(t, parse(t, data), len(Sizes))
+
+	// Files that aren't PNG images are an error
+	require.Error(t, EncodeFile(dst, dst))
+}
+
+// parse returns the data of the entries of an icon file by type.
+func parse(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+
+	require.Equal(t, "icns", string(data[:4]))
+	require.Equal(t, uint32(len(data)), binary.BigEndian.Uint32(data[4:]))
+
+	result := map[string][]byte{}
+	for rest := data[8:]; len(rest) > 0; {
+		require.True(t, len(rest) >= 8)
+		n := binary.BigEndian.Uint32(rest[4:])
+		require.True(t, n >= 8 && int(n) <= len(rest))
+
+		result[string(rest[:4])] = rest[8:n]
+		rest = rest[n:]
+	}
+
+	return result
+}
@@ -0,0 +1,137 @@
+package icns
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// source is an image to scale, as premultiplied colors so that the color
+// of transparent pixels doesn't bleed into the edges of the icon.
+type source struct {
+	w, h   int
+	pixels [][4]float64
+}
+
+func newSource(img image.Image) *source {
+	b := img.Bounds()
+	s := &source{w: b.Dx(), h: b.Dy()}
+	s.pixels = make([][4]float64, s.w*s.h)
+	for y := 0; y < s.h; y++ {
+		for x := 0; x < s.w; x++ {
+			r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			s.pixels[y*s.w+x] = [4]float64{float64(r), float64(g), float64(bl), float64(a)}
+		}
+	}
+
+	return s
+}
+
+// resize scales the image to a size x size image. This uses a triangle
+// filter, which interpolates linearly when scaling up and averages every
+// pixel that's covered when scaling down, so large images don't alias.
+func (s *source) resize(size int) *image.NRGBA {
+	w, h, src := s.w, s.h, s.pixels
+
+	// Scale horizontally and then vertically
+	xw := weights(w, size)
+	tmp := make([][4]float64, size*h)
+	for y := 0; y < h; y++ {
+		for x, ws := range xw {
+			tmp[y*size+x] = ws.apply(func(i int) [4]float64 { return src[y*w+i] })
+		}
+	}
+
+	yw := weights(h, size)
+	result := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y, ws := range yw {
+		for x := 0; x < size; x++ {
+			v := ws.apply(func(i int) [4]float64 { return tmp[i*size+x] })
+			result.SetNRGBA(x, y, unpremultiply(v))
+		}
+	}
+
+	return result
+}
+
+// kernel is the source pixels and their weights for a destination pixel.
+type kernel struct {
+	start   int
+	weights []float64
+}
+
+func (k kernel) apply(at func(int) [4]float64) [4]float64 {
+	var result [4]float64
+	for i, w := range k.weights {
+		v := at(k.start + i)
+		for c := range result {
+			result[c] += v[c] * w
+		}
+	}
+
+	return result
+}
+
+// weights returns the kernels to scale n pixels to size pixels.
+func weights(n, size int) []kernel {
+	scale := float64(n) / float64(size)
+	support := math.Max(scale, 1)
+
+	result := make([]kernel, size)
+	for i := range result {
+		center := (float64(i)+0.5)*scale - 0.5
+		start := int(math.Ceil(center - support))
+		end := int(math.Floor(center + support))
+
+		// Pixels past the edges are clamped to the edges, so we add their
+		// weight to the edge pixel.
+		lo, hi := clamp(start, n), clamp(end, n)
+		ws := make([]float64, hi-lo+1)
+		var total float64
+		for j := start; j <= end; j++ {
+			wt := 1 - math.Abs(float64(j)-center)/support
+			if wt <= 0 {
+				continue
+			}
+
+			ws[clamp(j, n)-lo] += wt
+			total += wt
+		}
+		for j := range ws {
+			ws[j] /= total
+		}
+
+		result[i] = kernel{start: lo, weights: ws}
+	}
+
+	return result
+}
+
+func clamp(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+
+	return i
+}
+
+// unpremultiply converts a premultiplied 16-bit color to 8-bit NRGBA.
+func unpremultiply(v [4]float64) color.NRGBA {
+	a := v[3]
+	if a <= 0 {
+		return color.NRGBA{}
+	}
+
+	c := func(x float64) uint8 {
+		return uint8(math.Min(255, math.Max(0, math.Round(x/a*255))))
+	}
+	return color.NRGBA{
+		R: c(v[0]),
+		G: c(v[1]),
+		B: c(v[2]),
+		A: uint8(math.Min(255, math.Round(a/0xffff*255))),
+	}
+}
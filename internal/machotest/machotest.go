@@ -0,0 +1,539 @@
+// Package machotest creates minimal Mach-O binaries with embedded code
+// signatures for tests. The binaries aren't runnable but are structurally
+// valid, so they can be parsed and verified like real signed binaries.
+//
+// This also has a stand-in RFC 3161 timestamp authority so signing with
+// secure timestamps can be tested without network access.
+package machotest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitchellh/gon/internal/cms"
+)
+
+// CPU types for Options.CPU.
+const (
+	CPUAMD64 = 0x01000007
+	CPUARM64 = 0x0100000c
+)
+
+// File types for Options.Type.
+const (
+	TypeExec  = 2
+	TypeDylib = 6
+)
+
+const (
+	// CodeLimit is the size of the code of the binaries, which is where
+	// the signature starts. This is two pages.
+	CodeLimit = 0x1800
+
+	// TextOffset is the file offset of the __text section. The space
+	// between the load commands and this offset is empty.
+	TextOffset = 0x400
+
+	// vmBase is the address the binaries are loaded at.
+	vmBase = 0x100000000
+
+	// signatureSize is the space reserved for the signature.
+	signatureSize = 0x4000
+
+	flagAdHoc = 0x2
+)
+
+// Entitlements is an XML entitlements plist with a single entitlement
+// that can be used for Sign.Entitlements.
+const Entitlements = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>com.apple.security.cs.allow-jit</key>
+	<true/>
+</dict>
+</plist>
+`
+
+// Options are the options for creating a binary.
+type Options struct {
+	// CPU is the CPU type, such as CPUAMD64.
+	CPU uint32
+
+	// Type is the file type. Defaults to TypeExec.
+	Type uint32
+
+	// SDK, if non-zero, adds an LC_BUILD_VERSION load command with this
+	// SDK version, encoded as 0xMMMMmmpp.
+	SDK uint32
+
+	// Sign are the signing options. If nil, the binary is unsigned.
+	Sign *Sign
+}
+
+// Sign are the options for signing a binary.
+type Sign struct {
+	Identifier   string
+	TeamID       string
+	Flags        uint32
+	Runtime      uint32
+	Entitlements []byte
+
+	// Alternate, if true, creates a SHA-1 code directory with a SHA-256
+	// alternate like codesign does.
+	Alternate bool
+
+	// Signer is the identity to sign with. If nil, the signature is ad-hoc.
+	Signer *cms.Signer
+
+	// Timestamp, if non-zero, is the time of the secure timestamp and
+	// signing time.
+	Timestamp time.Time
+}
+
+// Build creates a minimal 64-bit Mach-O binary. The binary has a __TEXT
+// segment with a single __text section starting at TextOffset and a
+// __LINKEDIT segment that ends at CodeLimit, followed by the signature.
+func Build(t testing.TB, opts *Options) []byte {
+	t.Helper()
+
+	sigSize := 0
+	if opts.Sign != nil {
+		sigSize = signatureSize
+	}
+
+	le := binary.LittleEndian
+	var cmds []byte
+	ncmds := 2
+
+	// __TEXT with one section
+	text := segment("__TEXT", 0, 0x1000, 5, 1)
+	section := make([]byte, 80)
+	copy(section[0:], "__text")
+	copy(section[16:], "__TEXT")
+	le.PutUint64(section[32:], vmBase+TextOffset)
+	le.PutUint64(section[40:], 0x1000-TextOffset)
+	le.PutUint32(section[48:], TextOffset)
+	le.PutUint32(section[52:], 2)
+	le.PutUint32(section[64:], 0x80000400)
+	cmds = append(cmds, append(text, section...)...)
+
+	// __LINKEDIT which contains everything else, including the signature
+	cmds = append(cmds, segment("__LINKEDIT", 0x1000, uint64(CodeLimit-0x1000+sigSize), 1, 0)...)
+
+	if opts.SDK != 0 {
+		ncmds++
+
+		// LC_BUILD_VERSION for macOS with no tools
+		cmd := make([]byte, 24)
+		le.PutUint32(cmd[0:], 0x32)
+		le.PutUint32(cmd[4:], 24)
+		le.PutUint32(cmd[8:], 1)
+		le.PutUint32(cmd[12:], opts.SDK)
+		le.PutUint32(cmd[16:], opts.SDK)
+		cmds = append(cmds, cmd...)
+	}
+	if opts.Sign != nil {
+		ncmds++
+
+		// LC_CODE_SIGNATURE
+		cmd := make([]byte, 16)
+		le.PutUint32(cmd[0:], 0x1d)
+		le.PutUint32(cmd[4:], 16)
+		le.PutUint32(cmd[8:], CodeLimit)
+		le.PutUint32(cmd[12:], uint32(sigSize))
+		cmds = append(cmds, cmd...)
+	}
+
+	fileType := opts.Type
+	if fileType == 0 {
+		fileType = TypeExec
+	}
+
+	code := make([]byte, CodeLimit)
+	le.PutUint32(code[0:], 0xfeedfacf)
+	le.PutUint32(code[4:], opts.CPU)
+	le.PutUint32(code[12:], fileType)
+	le.PutUint32(code[16:], uint32(ncmds))
+	le.PutUint32(code[20:], uint32(len(cmds)))
+	copy(code[32:], cmds)
+	for i := TextOffset; i < len(code); i++ {
+		code[i] = byte(i ^ int(opts.CPU))
+	}
+
+	if opts.Sign == nil {
+		return code
+	}
+
+	sig := signature(t, code, opts.Sign)
+	require.True(t, len(sig) <= signatureSize)
+
+	result := make([]byte, CodeLimit+signatureSize)
+	copy(result, code)
+	copy(result[CodeLimit:], sig)
+	return result
+}
+
+// segment creates an LC_SEGMENT_64 load command with nsects sections. The
+// sections must be appended by the caller.
+func segment(name string, fileoff, filesize uint64, prot, nsects uint32) []byte {
+	le := binary.LittleEndian
+	cmd := make([]byte, 72)
+	le.PutUint32(cmd[0:], 0x19)
+	le.PutUint32(cmd[4:], 72+80*nsects)
+	copy(cmd[8:], name)
+	le.PutUint64(cmd[24:], vmBase+fileoff)
+	le.PutUint64(cmd[32:], (filesize+0xfff)&^0xfff)
+	le.PutUint64(cmd[40:], fileoff)
+	le.PutUint64(cmd[48:], filesize)
+	le.PutUint32(cmd[56:], prot)
+	le.PutUint32(cmd[60:], prot)
+	le.PutUint32(cmd[64:], nsects)
+	return cmd
+}
+
+// Fat creates a universal binary of the given thin binaries.
+func Fat(arches ...[]byte) []byte {
+	const align = 0x4000
+
+	var result []byte
+	result = appendUint32(result, 0xcafebabe)
+	result = appendUint32(result, uint32(len(arches)))
+
+	offset := align
+	for _, arch := range arches {
+		le := binary.LittleEndian
+		result = appendUint32(result, le.Uint32(arch[4:]))
+		result = appendUint32(result, le.Uint32(arch[8:]))
+		result = appendUint32(result, uint32(offset))
+		result = appendUint32(result, uint32(len(arch)))
+		result = appendUint32(result, 14)
+		offset += (len(arch) + align - 1) / align * align
+	}
+
+	for _, arch := range arches {
+		result = append(result, make([]byte, (len(result)+align-1)/align*align-len(result))...)
+		result = append(result, arch...)
+	}
+
+	return result
+}
+
+// Identity creates a signing identity with a certificate issued by
+// a new certificate authority.
+func Identity(t testing.TB) *cms.Signer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return identity(t, key, "Developer ID Application: Example (TEAMID1234)")
+}
+
+// InstallerIdentity is like Identity but with an RSA key, as used by
+// "Developer ID Installer" certificates for signing installer packages.
+func InstallerIdentity(t testing.TB) *cms.Signer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return identity(t, key, "Developer ID Installer: Example (TEAMID1234)")
+}
+
+// identity creates a signing identity for the key and common name.
+func identity(t testing.TB, key crypto.Signer, name string) *cms.Signer {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Certification Authority"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{
+			CommonName:         name,
+			OrganizationalUnit: []string{"TEAMID1234"},
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &cms.Signer{Certificate: cert, Chain: []*x509.Certificate{ca}, Key: key}
+}
+
+// Slots and magic numbers of the signature blobs.
+const (
+	slotCodeDirectory            = 0
+	slotRequirements             = 2
+	slotEntitlements             = 5
+	slotAlternateCodeDirectories = 0x1000
+	slotSignature                = 0x10000
+
+	magicRequirements  = 0xfade0c01
+	magicCodeDirectory = 0xfade0c02
+	magicEmbeddedSig   = 0xfade0cc0
+	magicEntitlements  = 0xfade7171
+	magicBlobWrapper   = 0xfade0b01
+)
+
+// signature creates the SuperBlob signing the given code.
+func signature(t testing.TB, code []byte, opts *Sign) []byte {
+	blobs := map[uint32][]byte{
+		slotRequirements: blob(magicRequirements, []byte{0, 0, 0, 0}),
+	}
+	if opts.Entitlements != nil {
+		blobs[slotEntitlements] = blob(magicEntitlements, opts.Entitlements)
+	}
+
+	flags := opts.Flags
+	if opts.Signer == nil {
+		flags |= flagAdHoc
+	}
+
+	cd := codeDirectory(crypto.SHA256, code, blobs, opts, flags)
+	if opts.Alternate {
+		blobs[slotAlternateCodeDirectories] = cd
+		cd = codeDirectory(crypto.SHA1, code, blobs, opts, flags)
+	}
+	blobs[slotCodeDirectory] = cd
+
+	var sig []byte
+	if opts.Signer != nil {
+		signOpts := &cms.SignOptions{
+			Detached:    true,
+			SigningTime: opts.Timestamp,
+		}
+		if !opts.Timestamp.IsZero() {
+			signOpts.Timestamp = timestamp(opts.Signer, opts.Timestamp)
+		}
+
+		var err error
+		sig, err = cms.Sign(cd, opts.Signer, signOpts)
+		require.NoError(t, err)
+	}
+	blobs[slotSignature] = blob(magicBlobWrapper, sig)
+
+	slots := []uint32{
+		slotCodeDirectory,
+		slotRequirements,
+		slotEntitlements,
+		slotAlternateCodeDirectories,
+		slotSignature,
+	}
+
+	var index, data []byte
+	count := 0
+	for _, slot := range slots {
+		if b, ok := blobs[slot]; ok {
+			count++
+			index = appendUint32(index, slot)
+			index = appendUint32(index, uint32(len(data)))
+			data = append(data, b...)
+		}
+	}
+
+	be := binary.BigEndian
+	header := 12 + len(index)
+	for i := 0; i < count; i++ {
+		off := be.Uint32(index[i*8+4:])
+		be.PutUint32(index[i*8+4:], off+uint32(header))
+	}
+
+	var result []byte
+	result = appendUint32(result, magicEmbeddedSig)
+	result = appendUint32(result, uint32(header+len(data)))
+	result = appendUint32(result, uint32(count))
+	result = append(result, index...)
+	return append(result, data...)
+}
+
+// TSA starts a RFC 3161 timestamp authority that signs timestamps with
+// signer and the given time. The caller must close the server.
+func TSA(t testing.TB, signer *cms.Signer, genTime time.Time) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil || r.Header.Get("Content-Type") != "application/timestamp-query" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		var req cms.TimestampRequest
+		if _, err := asn1.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		info := &cms.TSTInfo{
+			Policy:        asn1.ObjectIdentifier{1, 2, 3},
+			HashAlgorithm: crypto.SHA256,
+			HashedMessage: req.MessageImprint.HashedMessage,
+			SerialNumber:  big.NewInt(1),
+			GenTime:       genTime,
+			Nonce:         req.Nonce,
+		}
+		content, err := info.Marshal()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		token, err := cms.Sign(content, signer, &cms.SignOptions{ContentType: cms.OIDTSTInfo})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp, err := asn1.Marshal(struct {
+			Status struct{ Status int }
+			Token  asn1.RawValue
+		}{Token: asn1.RawValue{FullBytes: token}})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		w.Write(resp)
+	}))
+}
+
+// timestamp returns a function for cms.SignOptions that creates a
+// timestamp token for the given time signed by signer.
+func timestamp(signer *cms.Signer, t time.Time) func([]byte) ([]byte, error) {
+	return func(sig []byte) ([]byte, error) {
+		h := sha256.Sum256(sig)
+		info := &cms.TSTInfo{
+			Policy:        asn1.ObjectIdentifier{1, 2, 3},
+			HashAlgorithm: crypto.SHA256,
+			HashedMessage: h[:],
+			SerialNumber:  big.NewInt(1),
+			GenTime:       t,
+		}
+		content, err := info.Marshal()
+		if err != nil {
+			return nil, err
+		}
+
+		return cms.Sign(content, signer, &cms.SignOptions{
+			ContentType: cms.OIDTSTInfo,
+		})
+	}
+}
+
+// codeDirectory creates a version 0x20500 code directory.
+func codeDirectory(
+	h crypto.Hash,
+	code []byte,
+	blobs map[uint32][]byte,
+	opts *Sign,
+	flags uint32,
+) []byte {
+	const headerSize = 96
+	const nSpecial = 7
+	const pageSize = 4096
+
+	hash := func(data []byte) []byte {
+		if h == crypto.SHA1 {
+			sum := sha1.Sum(data)
+			return sum[:]
+		}
+
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+
+	strings := append([]byte(opts.Identifier), 0)
+	teamOffset := 0
+	if opts.TeamID != "" {
+		teamOffset = headerSize + len(strings)
+		strings = append(strings, append([]byte(opts.TeamID), 0)...)
+	}
+
+	var hashes []byte
+	for slot := nSpecial; slot > 0; slot-- {
+		if b, ok := blobs[uint32(slot)]; ok {
+			hashes = append(hashes, hash(b)...)
+		} else {
+			hashes = append(hashes, make([]byte, h.Size())...)
+		}
+	}
+	nCode := 0
+	for off := 0; off < len(code); off += pageSize {
+		end := off + pageSize
+		if end > len(code) {
+			end = len(code)
+		}
+		hashes = append(hashes, hash(code[off:end])...)
+		nCode++
+	}
+
+	hashType := byte(2)
+	if h == crypto.SHA1 {
+		hashType = 1
+	}
+
+	be := binary.BigEndian
+	cd := make([]byte, headerSize)
+	be.PutUint32(cd[0:], magicCodeDirectory)
+	be.PutUint32(cd[4:], uint32(headerSize+len(strings)+len(hashes)))
+	be.PutUint32(cd[8:], 0x20500)
+	be.PutUint32(cd[12:], flags)
+	be.PutUint32(cd[16:], uint32(headerSize+len(strings)+nSpecial*h.Size()))
+	be.PutUint32(cd[20:], headerSize)
+	be.PutUint32(cd[24:], nSpecial)
+	be.PutUint32(cd[28:], uint32(nCode))
+	be.PutUint32(cd[32:], uint32(len(code)))
+	cd[36] = byte(h.Size())
+	cd[37] = hashType
+	cd[39] = 12
+	be.PutUint32(cd[48:], uint32(teamOffset))
+	be.PutUint32(cd[88:], opts.Runtime)
+
+	cd = append(cd, strings...)
+	return append(cd, hashes...)
+}
+
+// blob creates a blob with the given magic and payload.
+func blob(magic uint32, payload []byte) []byte {
+	var result []byte
+	result = appendUint32(result, magic)
+	result = appendUint32(result, uint32(8+len(payload)))
+	return append(result, payload...)
+}
+
+// appendUint32 appends the big endian encoding of v.
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
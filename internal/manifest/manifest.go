@@ -0,0 +1,161 @@
+// Package manifest writes checksums and metadata for the files gon
+// produces and notarizes, for use by download pages, package managers,
+// and release tooling.
+//
+// Two formats are supported: a SHA256SUMS file in the format of
+// `shasum -a 256`, which can be checked with `shasum -a 256 -c`, and a
+// JSON manifest with an array of artifacts in the spirit of GoReleaser's
+// artifacts.json.
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Artifact is a file in the manifest.
+type Artifact struct {
+	// Name is the base name of the file.
+	Name string `json:"name"`
+
+	// Path is the path to the file, as given to gon.
+	Path string `json:"path"`
+
+	// Type is the type of the file: "app", "dmg", "pkg", or "zip".
+	Type string `json:"type"`
+
+	// Size is the size of the file in bytes. For directories such as app
+	// bundles, this is the total size of the files within it.
+	Size int64 `json:"size"`
+
+	// SHA256 is the SHA-256 hash of the file as lowercase hex. This is
+	// empty for directories, which aren't hashed.
+	SHA256 string `json:"sha256,omitempty"`
+
+	// NotarizationID is the UUID of the notarization submission.
+	NotarizationID string `json:"notarization_id,omitempty"`
+
+	// Stapled is true if the notarization ticket was stapled to the file,
+	// or to the files within it for zip archives.
+	Stapled bool `json:"stapled"`
+
+	// SigningIdentity and TeamID are the identity the file was signed
+	// with by gon and its Apple team ID. These are empty if the file was
+	// signed outside of gon or they aren't known.
+	SigningIdentity string `json:"signing_identity,omitempty"`
+	TeamID          string `json:"team_id,omitempty"`
+}
+
+// NewArtifact returns the artifact for the file at path with the name,
+// size, and hash set. The other fields are left for the caller to set.
+func NewArtifact(path string) (*Artifact, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Artifact{
+		Name: filepath.Base(path),
+		Path: path,
+	}
+	if fi.IsDir() {
+		result.Size, err = dirSize(path)
+		return result, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Size = n
+	result.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return result, nil
+}
+
+// WriteJSON writes the JSON manifest of the artifacts to path.
+func WriteJSON(path string, artifacts []*Artifact) error {
+	if artifacts == nil {
+		artifacts = []*Artifact{}
+	}
+
+	data, err := json.MarshalIndent(artifacts, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// WriteChecksums writes a SHA256SUMS file of the artifacts to path. The
+// files are listed relative to the directory of path, sorted, so that the
+// checksums can be verified from that directory. Artifacts without a hash,
+// such as directories, aren't listed.
+func WriteChecksums(path string, artifacts []*Artifact) error {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	type entry struct{ name, sum string }
+	var entries []entry
+	for _, a := range artifacts {
+		if a.SHA256 == "" {
+			continue
+		}
+
+		abs, err := filepath.Abs(a.Path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, abs)
+		if err != nil {
+			name = abs
+		}
+
+		entries = append(entries, entry{name: filepath.ToSlash(name), sum: a.SHA256})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintf(&buf, "%s  %s\n", e.sum, e.name)
+	}
+
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// dirSize returns the total size of the regular files within a directory.
+// Symlinks are not followed.
+func dirSize(root string) (int64, error) {
+	var result int64
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			result += info.Size()
+		}
+
+		return nil
+	})
+
+	return result, err
+}
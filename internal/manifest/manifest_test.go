@@ -0,0 +1,109 @@
+package manifest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewArtifact(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon-manifest")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "foo.zip")
+	require.NoError(t, ioutil.WriteFile(path, []byte("hello"), 0644))
+
+	actual, err := NewArtifact(path)
+	require.NoError(t, err)
+	require.Equal(t, &Artifact{
+		Name:   "foo.zip",
+		Path:   path,
+		Size:   5,
+		SHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+	}, actual)
+
+	// Directories are sized but not hashed
+	app := filepath.Join(td, "Foo.app")
+	require.NoError(t, os.MkdirAll(filepath.Join(app, "Contents", "MacOS"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(app, "Contents", "Info.plist"), []byte("abc"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(app, "Contents", "MacOS", "foo"), []byte("abcd"), 0755))
+
+	actual, err = NewArtifact(app)
+	require.NoError(t, err)
+	require.Equal(t, &Artifact{Name: "Foo.app", Path: app, Size: 7}, actual)
+
+	_, err = NewArtifact(filepath.Join(td, "missing"))
+	require.Error(t, err)
+}
+
+func TestWriteChecksums(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon-manifest")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	artifacts := []*Artifact{
+		{Path: filepath.Join(td, "dist", "foo.zip"), SHA256: "bb"},
+		{Path: filepath.Join(td, "dist", "Foo.app")},
+		{Path: filepath.Join(td, "dist", "darwin", "foo.dmg"), SHA256: "aa"},
+		{Path: filepath.Join(td, "other", "foo.pkg"), SHA256: "cc"},
+	}
+
+	path := filepath.Join(td, "dist", "SHA256SUMS")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, WriteChecksums(path, artifacts))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "cc  ../other/foo.pkg\n"+
+		"aa  darwin/foo.dmg\n"+
+		"bb  foo.zip\n", string(data))
+}
+
+func TestWriteJSON(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon-manifest")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "artifacts.json")
+	require.NoError(t, WriteJSON(path, nil))
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "[]\n", string(data))
+
+	expected := []*Artifact{
+		{
+			Name:            "foo.dmg",
+			Path:            "dist/foo.dmg",
+			Type:            "dmg",
+			Size:            10,
+			SHA256:          "aa",
+			NotarizationID:  "2efe2717-52ef-43a5-96dc-0797e4ca1041",
+			Stapled:         true,
+			SigningIdentity: "Developer ID Application: Example, Inc. (ABCDE12345)",
+			TeamID:          "ABCDE12345",
+		},
+		{Name: "Foo.app", Path: "dist/Foo.app", Type: "app", Size: 20},
+	}
+	require.NoError(t, WriteJSON(path, expected))
+
+	data, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var actual []*Artifact
+	require.NoError(t, json.Unmarshal(data, &actual))
+	require.Equal(t, expected, actual)
+
+	var raw []map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &raw))
+	require.Equal(t, map[string]interface{}{
+		"name":    "Foo.app",
+		"path":    "dist/Foo.app",
+		"type":    "app",
+		"size":    float64(20),
+		"stapled": false,
+	}, raw[1])
+}
@@ -0,0 +1,303 @@
+package xar
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/gon/internal/cms"
+)
+
+// Archive is an archive that was read with Read.
+type Archive struct {
+	// Files are the regular files in the archive, in the order of the
+	// table of contents. Directories aren't included.
+	Files []File
+
+	// Certificates are the certificates of the signature, starting with
+	// the signing certificate. This is empty if the archive isn't signed.
+	Certificates []*x509.Certificate
+
+	checksum     []byte
+	rsaSignature []byte
+	cmsSignature []byte
+}
+
+// Read reads an archive. The checksums of the table of contents and of
+// every file are verified. Signatures aren't verified, see Verify.
+func Read(data []byte) (*Archive, error) {
+	var h header
+	if err := binary.Read(bytes.NewReader(data), binary.BigEndian, &h); err != nil {
+		return nil, fmt.Errorf("error reading xar header: %s", err)
+	}
+	if h.Magic != magic {
+		return nil, errors.New("not a xar archive")
+	}
+	if int(h.Size) < headerSize || uint64(len(data)) < uint64(h.Size)+h.TOCLength {
+		return nil, errors.New("xar archive is truncated")
+	}
+
+	compressed := data[h.Size : uint64(h.Size)+h.TOCLength]
+	heap := data[uint64(h.Size)+h.TOCLength:]
+
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("error reading xar table of contents: %s", err)
+	}
+	rawTOC, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("error reading xar table of contents: %s", err)
+	}
+
+	var x xmlXar
+	if err := xml.Unmarshal(rawTOC, &x); err != nil {
+		return nil, fmt.Errorf("error parsing xar table of contents: %s", err)
+	}
+	toc := x.TOC
+
+	var result Archive
+
+	// Verify the checksum of the table of contents
+	if h.ChecksumAlgorithm != checksumNone {
+		newHash, err := hashFor(toc.Checksum.Style)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := heapData(heap, toc.Checksum.Offset, toc.Checksum.Size)
+		if err != nil {
+			return nil, fmt.Errorf("xar checksum: %s", err)
+		}
+
+		hasher := newHash()
+		hasher.Write(compressed)
+		if !bytes.Equal(hasher.Sum(nil), sum) {
+			return nil, errors.New("xar table of contents checksum doesn't match")
+		}
+		result.checksum = sum
+	}
+
+	// Load the signatures
+	for _, sig := range []*xmlSignature{toc.Signature, toc.XSignature} {
+		if sig == nil {
+			continue
+		}
+
+		value, err := heapData(heap, sig.Offset, sig.Size)
+		if err != nil {
+			return nil, fmt.Errorf("xar signature: %s", err)
+		}
+
+		switch sig.Style {
+		case "RSA":
+			result.rsaSignature = value
+		case "CMS":
+			result.cmsSignature = value
+		default:
+			continue
+		}
+
+		if len(result.Certificates) > 0 {
+			continue
+		}
+		for _, raw := range sig.KeyInfo.Certificates {
+			der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(raw), ""))
+			if err != nil {
+				return nil, fmt.Errorf("error decoding xar signature certificate: %s", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing xar signature certificate: %s", err)
+			}
+			result.Certificates = append(result.Certificates, cert)
+		}
+	}
+
+	// Read all the files
+	var walk func(dir string, files []*xmlFile) error
+	walk = func(dir string, files []*xmlFile) error {
+		for _, f := range files {
+			name := path.Join(dir, f.Name)
+			if f.Type == "directory" {
+				if err := walk(name, f.Files); err != nil {
+					return err
+				}
+
+				continue
+			}
+			if f.Type != "file" {
+				continue
+			}
+
+			mode, err := strconv.ParseUint(f.Mode, 8, 32)
+			if err != nil && f.Mode != "" {
+				return fmt.Errorf("%s: invalid mode %q", name, f.Mode)
+			}
+
+			var contents []byte
+			if f.Data != nil {
+				contents, err = fileData(heap, f.Data)
+				if err != nil {
+					return fmt.Errorf("%s: %s", name, err)
+				}
+			}
+
+			result.Files = append(result.Files, File{
+				Name: name,
+				Mode: os.FileMode(mode).Perm(),
+				Data: contents,
+			})
+		}
+
+		return nil
+	}
+	if err := walk("", toc.Files); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// File returns the file with the given name, or nil if it doesn't exist.
+func (a *Archive) File(name string) *File {
+	for idx := range a.Files {
+		if a.Files[idx].Name == name {
+			return &a.Files[idx]
+		}
+	}
+
+	return nil
+}
+
+// Verify verifies the signatures of the archive with the signing
+// certificate. This doesn't verify the certificate chain. An error is
+// returned if the archive isn't signed.
+func (a *Archive) Verify() error {
+	if len(a.Certificates) == 0 || (a.rsaSignature == nil && a.cmsSignature == nil) {
+		return errors.New("xar archive isn't signed")
+	}
+	if a.checksum == nil {
+		return errors.New("xar archive has no checksum to verify the signature of")
+	}
+
+	if a.rsaSignature != nil {
+		pub, ok := a.Certificates[0].PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("xar signature certificate doesn't have an RSA key")
+		}
+
+		sig := a.rsaSignature
+		if len(sig) > pub.Size() {
+			sig = sig[:pub.Size()]
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA1, a.checksum, sig); err != nil {
+			return fmt.Errorf("invalid xar RSA signature: %s", err)
+		}
+	}
+
+	if a.cmsSignature != nil {
+		sd, err := cms.Parse(a.cmsSignature)
+		if err != nil {
+			return fmt.Errorf("error parsing xar CMS signature: %s", err)
+		}
+		if err := sd.Verify(a.checksum); err != nil {
+			return fmt.Errorf("invalid xar CMS signature: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// fileData returns the extracted data of a file, verifying its checksums.
+func fileData(heap []byte, d *xmlData) ([]byte, error) {
+	archived, err := heapData(heap, d.Offset, d.Length)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyChecksum(archived, d.ArchivedChecksum); err != nil {
+		return nil, fmt.Errorf("archived %s", err)
+	}
+
+	extracted := archived
+	switch d.Encoding.Style {
+	case "", encodingNone:
+	case encodingZlib:
+		zr, err := zlib.NewReader(bytes.NewReader(archived))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing: %s", err)
+		}
+		extracted, err = ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", d.Encoding.Style)
+	}
+
+	if err := verifyChecksum(extracted, d.ExtractedChecksum); err != nil {
+		return nil, fmt.Errorf("extracted %s", err)
+	}
+
+	return extracted, nil
+}
+
+// verifyChecksum verifies data against the checksum if it has a style.
+func verifyChecksum(data []byte, c xmlChecksum) error {
+	if c.Style == "" || c.Style == "none" {
+		return nil
+	}
+
+	newHash, err := hashFor(c.Style)
+	if err != nil {
+		return err
+	}
+	hasher := newHash()
+	hasher.Write(data)
+	if hex.EncodeToString(hasher.Sum(nil)) != strings.ToLower(strings.TrimSpace(c.Value)) {
+		return errors.New("checksum doesn't match")
+	}
+
+	return nil
+}
+
+// heapData returns the size bytes at offset in the heap.
+func heapData(heap []byte, offset, size int64) ([]byte, error) {
+	if offset < 0 || size < 0 || offset+size > int64(len(heap)) {
+		return nil, errors.New("data is outside of the archive")
+	}
+
+	return heap[offset : offset+size], nil
+}
+
+// hashFor returns the hash for a checksum style.
+func hashFor(style string) (func() hash.Hash, error) {
+	switch strings.ToLower(style) {
+	case "sha1":
+		return sha1.New, nil
+	case "md5":
+		return md5.New, nil
+	case "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	}
+
+	return nil, fmt.Errorf("unsupported xar checksum %q", style)
+}
@@ -0,0 +1,285 @@
+package xar
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/gon/internal/cms"
+)
+
+// timestampReserve is the space reserved for a timestamp token in the CMS
+// signature. The size of the signature must be known before it's created
+// since the table of contents that is signed includes it.
+const timestampReserve = 8192
+
+// WriteOptions are the options for Write.
+type WriteOptions struct {
+	// Signer, if set, signs the archive. The key must be an RSA key, as
+	// required by the "RSA" signature style. A CMS signature is added
+	// as well, which is what current versions of macOS verify.
+	Signer *cms.Signer
+
+	// Timestamp, if set, is called to timestamp the CMS signature. See
+	// cms.SignOptions.Timestamp.
+	Timestamp func(signature []byte) ([]byte, error)
+
+	// ModTime is the modification time of every file. This defaults to
+	// the current time.
+	ModTime time.Time
+}
+
+// Write writes an archive with the given files to w.
+func Write(w io.Writer, files []File, opts *WriteOptions) error {
+	if opts == nil {
+		opts = &WriteOptions{}
+	}
+
+	modTime := opts.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	now := modTime.UTC().Format(timeFormat)
+
+	// The heap starts with the checksum of the table of contents followed
+	// by the signatures, if any.
+	toc := xmlTOC{
+		Checksum:     xmlHeapRef{Style: "sha1", Offset: 0, Size: sha1.Size},
+		CreationTime: now,
+	}
+	heapSize := int64(sha1.Size)
+
+	if s := opts.Signer; s != nil {
+		pub, ok := s.Certificate.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("signing a xar archive requires an RSA key")
+		}
+
+		var certs []string
+		for _, c := range append([]*x509.Certificate{s.Certificate}, s.Chain...) {
+			certs = append(certs, base64.StdEncoding.EncodeToString(c.Raw))
+		}
+
+		// The CMS signature varies in size so we sign placeholder data
+		// to find out how much space to reserve.
+		placeholder, err := signCMS(make([]byte, sha1.Size), s, nil)
+		if err != nil {
+			return err
+		}
+		cmsSize := int64(len(placeholder))
+		if opts.Timestamp != nil {
+			cmsSize += timestampReserve
+		}
+
+		toc.Signature = &xmlSignature{
+			Style:   "RSA",
+			Offset:  heapSize,
+			Size:    int64(pub.Size()),
+			KeyInfo: xmlKeyInfo{Certificates: certs},
+		}
+		heapSize += toc.Signature.Size
+
+		toc.XSignature = &xmlSignature{
+			Style:   "CMS",
+			Offset:  heapSize,
+			Size:    cmsSize,
+			KeyInfo: xmlKeyInfo{Certificates: certs},
+		}
+		heapSize += toc.XSignature.Size
+	}
+
+	// Build the tree of files, laying out the data of each file in the
+	// heap in the order they were given.
+	var data [][]byte
+	dirs := map[string]*xmlFile{}
+	nextID := 1
+	newFile := func(name, typ string) *xmlFile {
+		f := &xmlFile{
+			ID:    nextID,
+			CTime: now,
+			MTime: now,
+			ATime: now,
+			Name:  path.Base(name),
+			Type:  typ,
+			User:  "root",
+			Group: "wheel",
+		}
+		nextID++
+		return f
+	}
+
+	var dir func(name string) (*[]*xmlFile, error)
+	dir = func(name string) (*[]*xmlFile, error) {
+		if name == "." {
+			return &toc.Files, nil
+		}
+		if f, ok := dirs[name]; ok {
+			return &f.Files, nil
+		}
+
+		parent, err := dir(path.Dir(name))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range *parent {
+			if f.Name == path.Base(name) {
+				return nil, fmt.Errorf("%s: a file can't be used as a directory", name)
+			}
+		}
+
+		f := newFile(name, "directory")
+		f.Mode = "0755"
+		*parent = append(*parent, f)
+		dirs[name] = f
+		return &f.Files, nil
+	}
+
+	for _, file := range files {
+		name := path.Clean(strings.TrimPrefix(file.Name, "/"))
+		if name == "." || strings.HasPrefix(name, "../") || name == ".." {
+			return fmt.Errorf("invalid file name: %q", file.Name)
+		}
+		if _, ok := dirs[name]; ok {
+			return fmt.Errorf("%s: a directory can't be used as a file", name)
+		}
+
+		parent, err := dir(path.Dir(name))
+		if err != nil {
+			return err
+		}
+		for _, f := range *parent {
+			if f.Name == path.Base(name) {
+				return fmt.Errorf("%s: duplicate file", name)
+			}
+		}
+
+		mode := file.Mode.Perm()
+		if mode == 0 {
+			mode = 0644
+		}
+
+		sum := sha1.Sum(file.Data)
+		checksum := xmlChecksum{Style: "sha1", Value: hex.EncodeToString(sum[:])}
+
+		f := newFile(name, "file")
+		f.Mode = fmt.Sprintf("%04o", mode)
+		f.Data = &xmlData{
+			Length:            int64(len(file.Data)),
+			Offset:            heapSize,
+			Size:              int64(len(file.Data)),
+			Encoding:          xmlEncoding{Style: encodingNone},
+			ExtractedChecksum: checksum,
+			ArchivedChecksum:  checksum,
+		}
+		*parent = append(*parent, f)
+
+		data = append(data, file.Data)
+		heapSize += int64(len(file.Data))
+	}
+
+	// Encode and compress the table of contents
+	rawTOC, err := xml.MarshalIndent(&xmlXar{TOC: toc}, "", " ")
+	if err != nil {
+		return err
+	}
+	rawTOC = append([]byte(xml.Header), rawTOC...)
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(rawTOC); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	checksum := sha1.Sum(compressed.Bytes())
+
+	// Write it all out
+	err = binary.Write(w, binary.BigEndian, &header{
+		Magic:             magic,
+		Size:              headerSize,
+		Version:           1,
+		TOCLength:         uint64(compressed.Len()),
+		TOCUncompressed:   uint64(len(rawTOC)),
+		ChecksumAlgorithm: checksumSHA1,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(compressed.Bytes()); err != nil {
+		return err
+	}
+	if _, err := w.Write(checksum[:]); err != nil {
+		return err
+	}
+
+	if s := opts.Signer; s != nil {
+		// The RSA signature is of the checksum as if it were the digest.
+		sig, err := s.Key.Sign(rand.Reader, checksum[:], crypto.SHA1)
+		if err != nil {
+			return fmt.Errorf("error signing archive: %s", err)
+		}
+		if err := writePadded(w, sig, toc.Signature.Size); err != nil {
+			return err
+		}
+
+		sig, err = signCMS(checksum[:], s, opts.Timestamp)
+		if err != nil {
+			return err
+		}
+		if err := writePadded(w, sig, toc.XSignature.Size); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range data {
+		if _, err := w.Write(d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// signCMS creates the detached CMS signature of the checksum of the table
+// of contents.
+func signCMS(checksum []byte, signer *cms.Signer, timestamp func([]byte) ([]byte, error)) ([]byte, error) {
+	sig, err := cms.Sign(checksum, signer, &cms.SignOptions{
+		Detached:    true,
+		SigningTime: time.Now(),
+		Timestamp:   timestamp,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error signing archive: %s", err)
+	}
+
+	return sig, nil
+}
+
+// writePadded writes data padded with zeros to size bytes.
+func writePadded(w io.Writer, data []byte, size int64) error {
+	if int64(len(data)) > size {
+		return fmt.Errorf("signature of %d bytes doesn't fit in the %d bytes reserved",
+			len(data), size)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, size-int64(len(data))))
+	return err
+}
@@ -0,0 +1,127 @@
+// Package xar reads and writes xar archives, the container format of flat
+// installer packages (.pkg).
+//
+// Only the subset of the format used by installer packages is written:
+// file data is stored uncompressed, checksums are SHA-1, and archives may
+// be signed with the "RSA" and "CMS" signature styles that productsign
+// uses. Reading also supports zlib-compressed file data, which is what
+// pkgbuild writes for small files.
+package xar
+
+import (
+	"encoding/xml"
+	"os"
+)
+
+const (
+	// magic is "xar!", the first four bytes of every archive.
+	magic = 0x78617221
+
+	// headerSize is the size of the header we write. Archives with other
+	// checksum algorithms may have larger headers.
+	headerSize = 28
+
+	// Checksum algorithms of the header. Other algorithms are named by
+	// the table of contents.
+	checksumNone = 0
+	checksumSHA1 = 1
+
+	// Encodings of file data.
+	encodingNone = "application/octet-stream"
+	encodingZlib = "application/x-gzip"
+
+	// timeFormat is the format of the times in the table of contents.
+	timeFormat = "2006-01-02T15:04:05Z"
+)
+
+// File is a regular file in an archive.
+type File struct {
+	// Name is the slash-separated path of the file in the archive, such
+	// as "foo.pkg/Payload". Parent directories are created implicitly.
+	Name string
+
+	// Mode are the permission bits of the file. This defaults to 0644.
+	Mode os.FileMode
+
+	// Data is the contents of the file.
+	Data []byte
+}
+
+// header is the fixed-size header at the start of an archive. All
+// values are big-endian.
+type header struct {
+	Magic             uint32
+	Size              uint16
+	Version           uint16
+	TOCLength         uint64
+	TOCUncompressed   uint64
+	ChecksumAlgorithm uint32
+}
+
+// The xml* types are the table of contents. The table of contents is a
+// zlib-compressed XML document that describes the files and where their
+// data is stored in the heap that follows it.
+type xmlXar struct {
+	XMLName xml.Name `xml:"xar"`
+	TOC     xmlTOC   `xml:"toc"`
+}
+
+type xmlTOC struct {
+	Checksum     xmlHeapRef    `xml:"checksum"`
+	CreationTime string        `xml:"creation-time,omitempty"`
+	Signature    *xmlSignature `xml:"signature,omitempty"`
+	XSignature   *xmlSignature `xml:"x-signature,omitempty"`
+	Files        []*xmlFile    `xml:"file"`
+}
+
+type xmlHeapRef struct {
+	Style  string `xml:"style,attr"`
+	Offset int64  `xml:"offset"`
+	Size   int64  `xml:"size"`
+}
+
+type xmlSignature struct {
+	Style   string     `xml:"style,attr"`
+	Offset  int64      `xml:"offset"`
+	Size    int64      `xml:"size"`
+	KeyInfo xmlKeyInfo `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo"`
+}
+
+type xmlKeyInfo struct {
+	Certificates []string `xml:"X509Data>X509Certificate"`
+}
+
+type xmlFile struct {
+	ID    int      `xml:"id,attr"`
+	Data  *xmlData `xml:"data,omitempty"`
+	CTime string   `xml:"ctime,omitempty"`
+	MTime string   `xml:"mtime,omitempty"`
+	ATime string   `xml:"atime,omitempty"`
+	Name  string   `xml:"name"`
+	Type  string   `xml:"type"`
+	Mode  string   `xml:"mode,omitempty"`
+	UID   int      `xml:"uid"`
+	User  string   `xml:"user,omitempty"`
+	GID   int      `xml:"gid"`
+	Group string   `xml:"group,omitempty"`
+
+	Files []*xmlFile `xml:"file"`
+}
+
+type xmlData struct {
+	Length            int64       `xml:"length"`
+	Offset            int64       `xml:"offset"`
+	Size              int64       `xml:"size"`
+	Encoding          xmlEncoding `xml:"encoding"`
+	ExtractedChecksum xmlChecksum `xml:"extracted-checksum"`
+	ArchivedChecksum  xmlChecksum `xml:"archived-checksum"`
+}
+
+type xmlEncoding struct {
+	Style string `xml:"style,attr"`
+}
+
+type xmlChecksum struct {
+	Style string `xml:"style,attr"`
+	Value string `xml:",chardata"`
+}
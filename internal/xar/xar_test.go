@@ -0,0 +1,119 @@
+package xar
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitchellh/gon/internal/cms"
+	"github.com/mitchellh/gon/internal/machotest"
+)
+
+func TestWrite(t *testing.T) {
+	files := []File{
+		{Name: "Distribution", Data: []byte("<installer-gui-script/>")},
+		{Name: "foo.pkg/Payload", Data: []byte("payload")},
+		{Name: "foo.pkg/Scripts", Data: []byte("scripts"), Mode: 0755},
+		{Name: "empty", Data: nil},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, files, nil))
+	require.Equal(t, "xar!", buf.String()[:4])
+
+	archive, err := Read(buf.Bytes())
+	require.NoError(t, err)
+	require.Len(t, archive.Files, len(files))
+	require.Empty(t, archive.Certificates)
+	require.Error(t, archive.Verify())
+
+	for _, f := range files {
+		actual := archive.File(f.Name)
+		require.NotNil(t, actual, f.Name)
+		require.Equal(t, string(f.Data), string(actual.Data))
+
+		mode := f.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		require.Equal(t, mode, actual.Mode)
+	}
+
+	// Corrupting the data of a file is detected
+	data := buf.Bytes()
+	idx := bytes.LastIndex(data, []byte("payload"))
+	data[idx] = 'P'
+	_, err = Read(data)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "foo.pkg/Payload")
+}
+
+func TestWrite_invalid(t *testing.T) {
+	cases := []struct {
+		Name  string
+		Files []File
+	}{
+		{"empty name", []File{{Name: ""}}},
+		{"parent", []File{{Name: "../foo"}}},
+		{"duplicate", []File{{Name: "foo"}, {Name: "foo"}}},
+		{"file as dir", []File{{Name: "foo"}, {Name: "foo/bar"}}},
+		{"dir as file", []File{{Name: "foo/bar"}, {Name: "foo"}}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.Error(t, Write(&buf, tt.Files, nil))
+		})
+	}
+}
+
+func TestWrite_signed(t *testing.T) {
+	signer := machotest.InstallerIdentity(t)
+	tsa := machotest.TSA(t, machotest.Identity(t), time.Now())
+	defer tsa.Close()
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, []File{{Name: "foo", Data: []byte("foo")}}, &WriteOptions{
+		Signer: signer,
+		Timestamp: func(sig []byte) ([]byte, error) {
+			return cms.RequestTimestamp(tsa.URL, sig)
+		},
+	}))
+
+	archive, err := Read(buf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, archive.Verify())
+	require.Len(t, archive.Certificates, 2)
+	require.Equal(t, signer.Certificate.Raw, archive.Certificates[0].Raw)
+
+	// The CMS signature is timestamped
+	sd, err := cms.Parse(archive.cmsSignature)
+	require.NoError(t, err)
+	info, _, err := sd.Signers[0].Timestamp()
+	require.NoError(t, err)
+	require.NotNil(t, info)
+
+	// A signature by another key doesn't verify
+	archive.Certificates[0] = machotest.InstallerIdentity(t).Certificate
+	require.Error(t, archive.Verify())
+}
+
+func TestWrite_signedECDSA(t *testing.T) {
+	var buf bytes.Buffer
+	err := Write(&buf, []File{{Name: "foo"}}, &WriteOptions{
+		Signer: machotest.Identity(t),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "RSA")
+}
+
+func TestRead_invalid(t *testing.T) {
+	_, err := Read([]byte("nope"))
+	require.Error(t, err)
+
+	_, err = Read(append([]byte("xar!"), make([]byte, 24)...))
+	require.Error(t, err)
+}
@@ -0,0 +1,296 @@
+package keychain
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Identity is a code signing identity: a certificate with a private key.
+type Identity struct {
+	// SHA1 is the SHA-1 hash of the certificate as uppercase hex. This
+	// uniquely identifies the identity and is a valid value for codesign.
+	SHA1 string
+
+	// Name is the common name of the certificate, such as
+	// "Developer ID Application: Example, Inc. (ABCDE12345)".
+	Name string
+
+	// TeamID is the Apple team ID of the certificate, if known.
+	TeamID string
+
+	// NotBefore and NotAfter are the validity period of the certificate.
+	// These are zero if the certificate couldn't be read.
+	NotBefore time.Time
+	NotAfter  time.Time
+
+	// Invalid is the reason the identity can't be used to sign, as
+	// reported by security, such as "CSSMERR_TP_CERT_EXPIRED". This is
+	// empty if the identity is valid.
+	Invalid string
+}
+
+// Valid returns true if the identity can be used to sign.
+func (i *Identity) Valid() bool {
+	return i.Invalid == ""
+}
+
+// Warnings returns warnings about using this identity to sign software
+// for notarization. Only "Developer ID Application" certificates can sign
+// software to notarize, which is also checked by the preflight package.
+func (i *Identity) Warnings() []string {
+	if strings.HasPrefix(i.Name, "Developer ID Application:") {
+		return nil
+	}
+
+	for _, prefix := range []string{"Apple Development:", "Mac Developer:"} {
+		if strings.HasPrefix(i.Name, prefix) {
+			return []string{fmt.Sprintf(
+				"%q is a development certificate. Software signed with it "+
+					"can't be notarized. Use a \"Developer ID Application\" "+
+					"certificate instead.", i.Name)}
+		}
+	}
+
+	return []string{fmt.Sprintf(
+		"%q isn't a \"Developer ID Application\" certificate. Software "+
+			"signed with it can't be notarized.", i.Name)}
+}
+
+// String returns the hash and name of the identity as shown by security.
+func (i *Identity) String() string {
+	return fmt.Sprintf("%s %q", i.SHA1, i.Name)
+}
+
+// IdentityOptions are the options for FindIdentities.
+type IdentityOptions struct {
+	// Keychain is the path to the keychain to search. If this is empty,
+	// the keychain search list is used.
+	Keychain string
+
+	// Logger is the logger to use. If this is nil then no logging will be done.
+	Logger hclog.Logger
+
+	// BaseCmd is the base command for executing the security binary. This
+	// is used for tests to overwrite where the security binary is.
+	BaseCmd *exec.Cmd
+}
+
+// FindIdentities returns the code signing identities, including those
+// that are invalid, such as expired identities.
+func FindIdentities(ctx context.Context, opts *IdentityOptions) ([]*Identity, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+
+	// We don't use "-v" so that invalid identities are included and we
+	// can tell the user why their identity can't be used.
+	args := []string{"find-identity", "-p", "codesigning"}
+	if opts.Keychain != "" {
+		args = append(args, opts.Keychain)
+	}
+	out, err := security(ctx, opts.BaseCmd, logger, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error finding identities:\n\n%s", err)
+	}
+	result := parseIdentities(out)
+
+	// The validity period and team ID come from the certificates
+	args = []string{"find-certificate", "-a", "-p"}
+	if opts.Keychain != "" {
+		args = append(args, opts.Keychain)
+	}
+	out, err = security(ctx, opts.BaseCmd, logger, args...)
+	if err != nil {
+		logger.Warn("error reading certificates, validity is unknown", "err", err)
+		return result, nil
+	}
+
+	certs := parseCertificates(out)
+	for _, identity := range result {
+		cert, ok := certs[identity.SHA1]
+		if !ok {
+			continue
+		}
+
+		identity.NotBefore = cert.NotBefore
+		identity.NotAfter = cert.NotAfter
+		if ous := cert.Subject.OrganizationalUnit; len(ous) > 0 {
+			identity.TeamID = ous[0]
+		}
+	}
+
+	return result, nil
+}
+
+// ResolveIdentity finds the identity that codesign uses for the given
+// value of its `-s` flag. This is either the SHA-1 hash of the certificate
+// or a full or partial common name. A full match of the common name is
+// preferred over partial matches.
+//
+// An error is returned if no valid identity matches, or if more than one
+// valid identity matches.
+func ResolveIdentity(identities []*Identity, name string) (*Identity, error) {
+	var matches []*Identity
+	if isSHA1(name) {
+		for _, identity := range identities {
+			if strings.EqualFold(identity.SHA1, name) {
+				matches = append(matches, identity)
+			}
+		}
+	} else {
+		for _, identity := range identities {
+			if identity.Name == name {
+				matches = append(matches, identity)
+			}
+		}
+		if len(matches) == 0 {
+			for _, identity := range identities {
+				if strings.Contains(identity.Name, name) {
+					matches = append(matches, identity)
+				}
+			}
+		}
+	}
+
+	var valid, invalid []*Identity
+	for _, identity := range matches {
+		if identity.Valid() {
+			valid = append(valid, identity)
+		} else {
+			invalid = append(invalid, identity)
+		}
+	}
+
+	switch {
+	case len(valid) == 1:
+		return valid[0], nil
+
+	case len(valid) > 1:
+		var list []string
+		for _, identity := range valid {
+			list = append(list, "  "+identity.String())
+		}
+		return nil, fmt.Errorf(
+			"identity %q is ambiguous, it matches:\n\n%s\n\n"+
+				"Use the full name or the SHA-1 hash of the identity.",
+			name, strings.Join(list, "\n"))
+
+	case len(invalid) > 0:
+		identity := invalid[0]
+		reason := identity.Invalid
+		if identity.Invalid == "CSSMERR_TP_CERT_EXPIRED" && !identity.NotAfter.IsZero() {
+			reason = fmt.Sprintf("the certificate expired on %s",
+				identity.NotAfter.Format("2006-01-02"))
+		}
+		return nil, fmt.Errorf("identity %s can't be used to sign: %s", identity, reason)
+
+	default:
+		var list []string
+		for _, identity := range identities {
+			if identity.Valid() {
+				list = append(list, "  "+identity.String())
+			}
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf(
+				"no identity found matching %q, and there are no valid "+
+					"code signing identities", name)
+		}
+
+		return nil, fmt.Errorf(
+			"no identity found matching %q. The valid code signing identities are:\n\n%s",
+			name, strings.Join(list, "\n"))
+	}
+}
+
+// identityRe matches a line of the output of `security find-identity`,
+// such as `1) 0123...4567 "Name" (CSSMERR_TP_CERT_EXPIRED)`. The reason in
+// parentheses is only present for invalid identities.
+var identityRe = regexp.MustCompile(`^\s*\d+\)\s+([0-9A-Fa-f]{40})\s+"(.*)"(?:\s+\((.+)\))?\s*$`)
+
+// teamIDRe matches the team ID at the end of a certificate name.
+var teamIDRe = regexp.MustCompile(`\(([A-Z0-9]{10})\)$`)
+
+// TeamID returns the Apple team ID at the end of a certificate name, such
+// as "ABCDE12345" for "Developer ID Application: Example, Inc. (ABCDE12345)".
+// The result is empty if the name doesn't end in a team ID.
+func TeamID(name string) string {
+	if m := teamIDRe.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+
+	return ""
+}
+
+// parseIdentities parses the output of `security find-identity`. Identities
+// listed more than once, such as in the "Valid identities only" section,
+// are only returned once.
+func parseIdentities(out string) []*Identity {
+	var result []*Identity
+	seen := map[string]struct{}{}
+	for _, line := range strings.Split(out, "\n") {
+		m := identityRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		hash := strings.ToUpper(m[1])
+		if _, ok := seen[hash]; ok {
+			continue
+		}
+		seen[hash] = struct{}{}
+
+		identity := &Identity{SHA1: hash, Name: m[2], Invalid: m[3]}
+		identity.TeamID = TeamID(identity.Name)
+		result = append(result, identity)
+	}
+
+	return result
+}
+
+// parseCertificates parses the PEM output of `security find-certificate -p`,
+// keyed by the uppercase SHA-1 hash. Certificates that can't be parsed are
+// skipped.
+func parseCertificates(out string) map[string]*x509.Certificate {
+	result := map[string]*x509.Certificate{}
+	rest := []byte(out)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return result
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+
+		sum := sha1.Sum(block.Bytes)
+		result[strings.ToUpper(hex.EncodeToString(sum[:]))] = cert
+	}
+}
+
+// isSHA1 returns true if v is a hex SHA-1 hash.
+func isSHA1(v string) bool {
+	if len(v) != 40 {
+		return false
+	}
+
+	_, err := hex.DecodeString(v)
+	return err == nil
+}
@@ -0,0 +1,190 @@
+package keychain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+const testIdentities = `Policy: Code Signing
+  Matching identities
+  1) 1111111111111111111111111111111111111111 "Developer ID Application: Example, Inc. (ABCDE12345)"
+  2) 2222222222222222222222222222222222222222 "Developer ID Application: Example, Inc. (ABCDE12345)" (CSSMERR_TP_CERT_EXPIRED)
+  3) 3333333333333333333333333333333333333333 "Apple Development: dev@example.com (FGHIJ67890)"
+  4) 4444444444444444444444444444444444444444 "Developer ID Application: Other (KLMNO12345)"
+  5) 5555555555555555555555555555555555555555 "Developer ID Application: Old (PQRST12345)" (CSSMERR_TP_CERT_EXPIRED)
+     5 identities found
+
+  Valid identities only
+  1) 1111111111111111111111111111111111111111 "Developer ID Application: Example, Inc. (ABCDE12345)"
+  2) 3333333333333333333333333333333333333333 "Apple Development: dev@example.com (FGHIJ67890)"
+  3) 4444444444444444444444444444444444444444 "Developer ID Application: Other (KLMNO12345)"
+     3 valid identities found
+`
+
+func TestParseIdentities(t *testing.T) {
+	identities := parseIdentities(testIdentities)
+	require.Len(t, identities, 5)
+	require.Equal(t, &Identity{
+		SHA1:   "1111111111111111111111111111111111111111",
+		Name:   "Developer ID Application: Example, Inc. (ABCDE12345)",
+		TeamID: "ABCDE12345",
+	}, identities[0])
+	require.Equal(t, "CSSMERR_TP_CERT_EXPIRED", identities[1].Invalid)
+	require.False(t, identities[1].Valid())
+	require.Equal(t, "FGHIJ67890", identities[2].TeamID)
+}
+
+func TestResolveIdentity(t *testing.T) {
+	identities := parseIdentities(testIdentities)
+
+	cases := []struct {
+		Name  string
+		SHA1  string
+		Error string
+	}{
+		// Valid identities are preferred over expired ones with the same name
+		{"Developer ID Application: Example, Inc. (ABCDE12345)", "1111111111111111111111111111111111111111", ""},
+		{"Example, Inc.", "1111111111111111111111111111111111111111", ""},
+		{"4444444444444444444444444444444444444444", "4444444444444444444444444444444444444444", ""},
+		{"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "", "no identity found"},
+		{"dev@example.com", "3333333333333333333333333333333333333333", ""},
+		{"Developer ID Application", "", "is ambiguous"},
+		{"Old", "", "CSSMERR_TP_CERT_EXPIRED"},
+		{"2222222222222222222222222222222222222222", "", "can't be used to sign"},
+		{"Nope", "", "no identity found"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			identity, err := ResolveIdentity(identities, tt.Name)
+			if tt.Error != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.Error)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.SHA1, identity.SHA1)
+		})
+	}
+}
+
+func TestIdentityWarnings(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Warning string
+	}{
+		{"Developer ID Application: Example, Inc. (ABCDE12345)", ""},
+		{"Apple Development: dev@example.com (FGHIJ67890)", "is a development certificate"},
+		{"Mac Developer: dev@example.com (FGHIJ67890)", "is a development certificate"},
+		{"Apple Distribution: Example, Inc. (ABCDE12345)", "isn't a \"Developer ID Application\""},
+		{"3rd Party Mac Developer Application: Example, Inc. (ABCDE12345)", "isn't a \"Developer ID Application\""},
+		{"Developer ID Installer: Example, Inc. (ABCDE12345)", "isn't a \"Developer ID Application\""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			warnings := (&Identity{Name: tt.Name}).Warnings()
+			if tt.Warning == "" {
+				require.Empty(t, warnings)
+				return
+			}
+
+			require.Len(t, warnings, 1)
+			require.Contains(t, warnings[0], tt.Warning)
+			require.Contains(t, warnings[0], "can't be notarized")
+		})
+	}
+}
+
+func TestTeamID(t *testing.T) {
+	require.Equal(t, "ABCDE12345", TeamID("Developer ID Installer: Example, Inc. (ABCDE12345)"))
+	require.Equal(t, "", TeamID("Developer ID Installer"))
+	require.Equal(t, "", TeamID("Example (ABCDE12345) Inc."))
+}
+
+func TestFindIdentities(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	// A certificate for one of the identities
+	notAfter := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	der := testCertificate(t, notAfter)
+	sum := sha1.Sum(der)
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	identitiesPath := filepath.Join(td, "identities")
+	require.NoError(t, ioutil.WriteFile(identitiesPath, []byte(
+		`  1) `+hash+` "Developer ID Application: Example (TEAMID1234)" (CSSMERR_TP_CERT_EXPIRED)`+"\n"+
+			`     1 identities found`+"\n"), 0644))
+	certsPath := filepath.Join(td, "certificates")
+	require.NoError(t, ioutil.WriteFile(certsPath,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+
+	record := filepath.Join(td, "record")
+	cmd := childCmd(t, "security")
+	cmd.Env = append(cmd.Env,
+		childRecordEnv+"="+record,
+		childIdentitiesEnv+"="+identitiesPath,
+		childCertificatesEnv+"="+certsPath,
+	)
+
+	identities, err := FindIdentities(context.Background(), &IdentityOptions{
+		Keychain: "/tmp/gon.keychain",
+		Logger:   hclog.L(),
+		BaseCmd:  cmd,
+	})
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	require.Equal(t, hash, identities[0].SHA1)
+	require.Equal(t, "TEAMID1234", identities[0].TeamID)
+	require.True(t, notAfter.Equal(identities[0].NotAfter))
+
+	require.Equal(t, []string{
+		"find-identity -p codesigning /tmp/gon.keychain",
+		"find-certificate -a -p /tmp/gon.keychain",
+	}, testRecord(t, record))
+
+	_, err = ResolveIdentity(identities, "Example")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "expired on 2020-01-02")
+}
+
+// testCertificate creates a self-signed code signing certificate.
+func testCertificate(t *testing.T, notAfter time.Time) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName:         "Developer ID Application: Example (TEAMID1234)",
+			OrganizationalUnit: []string{"TEAMID1234"},
+		},
+		NotBefore: notAfter.AddDate(-5, 0, 0),
+		NotAfter:  notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return der
+}
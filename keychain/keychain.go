@@ -0,0 +1,283 @@
+// Package keychain manages temporary keychains for signing in CI.
+//
+// Signing with codesign requires the certificate to be in a keychain, and
+// using a certificate from a keychain normally prompts for approval. Create
+// creates a new keychain, imports a PKCS#12 certificate into it, and allows
+// codesign to use the key without prompting. Delete removes it again.
+//
+// FindIdentities and ResolveIdentity find the signing identity that
+// codesign will use so that problems with it can be reported clearly.
+package keychain
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Options are the options for Create.
+type Options struct {
+	// PKCS12File is the path to the PKCS#12 (.p12) file with the
+	// certificate and private key to import. This is required.
+	PKCS12File string
+
+	// PKCS12Password is the password of PKCS12File.
+	PKCS12Password string
+
+	// Path is the path of the keychain to create. If this is empty, the
+	// keychain is created in a new temporary directory. The keychain must
+	// not already exist.
+	Path string
+
+	// Password is the password of the new keychain. If this is empty, a
+	// random password is used.
+	Password string
+
+	// Logger is the logger to use. If this is nil then no logging will be done.
+	Logger hclog.Logger
+
+	// BaseCmd is the base command for executing the security binary. This
+	// is used for tests to overwrite where the security binary is.
+	BaseCmd *exec.Cmd
+}
+
+// Keychain is a keychain created by Create.
+type Keychain struct {
+	// Path is the path to the keychain. This is the value for the
+	// Keychain field of sign.Options.
+	Path string
+
+	// searchList is the keychain search list before we modified it.
+	searchList []string
+
+	// tempDir is the temporary directory containing the keychain, if any.
+	tempDir string
+
+	opts   *Options
+	logger hclog.Logger
+}
+
+// Create creates a new keychain, imports the certificate, and adds the
+// keychain to the user's keychain search list so that codesign can find
+// the identity. Delete must be called to remove the keychain, even if
+// signing fails.
+//
+// If Create returns an error, anything it created is already removed.
+func Create(ctx context.Context, opts *Options) (result *Keychain, err error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+
+	if opts.PKCS12File == "" {
+		return nil, fmt.Errorf("a PKCS#12 file is required to create a keychain")
+	}
+
+	k := &Keychain{
+		Path:   opts.Path,
+		opts:   opts,
+		logger: logger,
+	}
+	if k.Path == "" {
+		k.tempDir, err = ioutil.TempDir("", "gon-keychain")
+		if err != nil {
+			return nil, err
+		}
+		k.Path = filepath.Join(k.tempDir, "gon.keychain")
+	}
+
+	password := opts.Password
+	if password == "" {
+		var buf [16]byte
+		if _, err := rand.Read(buf[:]); err != nil {
+			return nil, err
+		}
+		password = hex.EncodeToString(buf[:])
+	}
+
+	// If anything fails, remove whatever we created so far
+	created := false
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		if created {
+			if derr := k.Delete(ctx); derr != nil {
+				logger.Warn("error deleting keychain", "err", derr)
+			}
+		} else if k.tempDir != "" {
+			os.RemoveAll(k.tempDir)
+		}
+	}()
+
+	// Record the search list so that we can restore it
+	out, err := k.security(ctx, "list-keychains", "-d", "user")
+	if err != nil {
+		return nil, fmt.Errorf("error listing keychains:\n\n%s", err)
+	}
+	k.searchList = parseList(out)
+
+	if _, err := k.security(ctx, "create-keychain", "-p", password, k.Path); err != nil {
+		return nil, fmt.Errorf("error creating keychain:\n\n%s", err)
+	}
+	created = true
+
+	steps := []struct {
+		Desc string
+		Args []string
+	}{
+		// No timeout and don't lock on sleep so the keychain stays
+		// unlocked for long builds.
+		{"configuring keychain", []string{"set-keychain-settings", k.Path}},
+
+		{"unlocking keychain", []string{"unlock-keychain", "-p", password, k.Path}},
+
+		{"importing certificate", []string{
+			"import", opts.PKCS12File,
+			"-k", k.Path,
+			"-f", "pkcs12",
+			"-P", opts.PKCS12Password,
+			"-T", "/usr/bin/codesign",
+			"-T", "/usr/bin/productsign",
+		}},
+
+		// This allows Apple's tools to use the key without prompting
+		{"setting key partition list", []string{
+			"set-key-partition-list",
+			"-S", "apple-tool:,apple:,codesign:",
+			"-s",
+			"-k", password,
+			k.Path,
+		}},
+
+		// The keychain must be in the search list for codesign to find
+		// the certificate chain.
+		{"adding keychain to search list", append(
+			[]string{"list-keychains", "-d", "user", "-s", k.Path}, k.searchList...)},
+	}
+	for _, step := range steps {
+		if _, err := k.security(ctx, step.Args...); err != nil {
+			return nil, fmt.Errorf("error %s:\n\n%s", step.Desc, err)
+		}
+	}
+
+	return k, nil
+}
+
+// Delete deletes the keychain and restores the keychain search list.
+func (k *Keychain) Delete(ctx context.Context) error {
+	if k.tempDir != "" {
+		defer os.RemoveAll(k.tempDir)
+	}
+
+	var errs []string
+	if _, err := k.security(ctx, "delete-keychain", k.Path); err != nil {
+		errs = append(errs, fmt.Sprintf("error deleting keychain:\n\n%s", err))
+	}
+
+	args := append([]string{"list-keychains", "-d", "user", "-s"}, k.searchList...)
+	if _, err := k.security(ctx, args...); err != nil {
+		errs = append(errs, fmt.Sprintf("error restoring keychain search list:\n\n%s", err))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n\n"))
+	}
+
+	return nil
+}
+
+// security executes the security binary with the given arguments.
+func (k *Keychain) security(ctx context.Context, args ...string) (string, error) {
+	return security(ctx, k.opts.BaseCmd, k.logger, args...)
+}
+
+// security executes the security binary with the given arguments and
+// returns the output. If it fails, the error contains the output.
+func security(ctx context.Context, base *exec.Cmd, logger hclog.Logger, args ...string) (string, error) {
+	// Build our command
+	var cmd exec.Cmd
+	if base != nil {
+		cmd = *base
+	}
+
+	// We only set the path if it isn't set. This lets the options set the
+	// path to the security binary that we use.
+	if cmd.Path == "" {
+		path, err := exec.LookPath("security")
+		if err != nil {
+			return "", err
+		}
+		cmd.Path = path
+	}
+
+	cmd.Args = append([]string{"security"}, args...)
+
+	// We store all output in out for logging and in case there is an error.
+	// Only stdout is returned since some commands print warnings to stderr.
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	// Log what we're going to execute, without the passwords
+	logger.Info("executing security",
+		"command_path", cmd.Path,
+		"command_args", redact(cmd.Args),
+	)
+
+	// Execute
+	if err := cmd.Run(); err != nil {
+		output := out.String() + stderr.String()
+		logger.Error("error executing security", "err", err, "output", output)
+		return "", fmt.Errorf("%s\n\n%s", err, output)
+	}
+
+	return out.String(), nil
+}
+
+// redact returns a copy of args with the values of password flags removed.
+func redact(args []string) []string {
+	result := make([]string, len(args))
+	copy(result, args)
+	for i := 1; i < len(result); i++ {
+		switch result[i-1] {
+		case "-p", "-P", "-k":
+			// -k is the keychain for import but the password for
+			// set-key-partition-list.
+			if result[i-1] == "-k" && result[1] == "import" {
+				continue
+			}
+
+			result[i] = "<redacted>"
+		}
+	}
+
+	return result
+}
+
+// parseList parses the output of `security list-keychains`, which is
+// one quoted path per line.
+func parseList(out string) []string {
+	var result []string
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		line = strings.Trim(line, `"`)
+		if line != "" {
+			result = append(result, line)
+		}
+	}
+
+	return result
+}
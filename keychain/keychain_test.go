@@ -0,0 +1,211 @@
+package keychain
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	// Set our default logger
+	logger := hclog.L()
+	logger.SetLevel(hclog.Trace)
+	hclog.SetDefault(logger)
+
+	// If we got a subcommand, run that
+	if v := os.Getenv(childEnv); v != "" && childCommands[v] != nil {
+		os.Exit(childCommands[v]())
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestCreateDelete(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	record := filepath.Join(td, "record")
+	cmd := childCmd(t, "security")
+	cmd.Env = append(cmd.Env, childRecordEnv+"="+record)
+
+	k, err := Create(context.Background(), &Options{
+		PKCS12File:     "cert.p12",
+		PKCS12Password: "p12pass",
+		Path:           "/tmp/gon.keychain",
+		Password:       "secret",
+		Logger:         hclog.L(),
+		BaseCmd:        cmd,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "/tmp/gon.keychain", k.Path)
+	require.NoError(t, k.Delete(context.Background()))
+
+	require.Equal(t, []string{
+		"list-keychains -d user",
+		"create-keychain -p secret /tmp/gon.keychain",
+		"set-keychain-settings /tmp/gon.keychain",
+		"unlock-keychain -p secret /tmp/gon.keychain",
+		"import cert.p12 -k /tmp/gon.keychain -f pkcs12 -P p12pass " +
+			"-T /usr/bin/codesign -T /usr/bin/productsign",
+		"set-key-partition-list -S apple-tool:,apple:,codesign: -s -k secret /tmp/gon.keychain",
+		"list-keychains -d user -s /tmp/gon.keychain /Users/foo/login.keychain-db /Users/foo/other keychain",
+		"delete-keychain /tmp/gon.keychain",
+		"list-keychains -d user -s /Users/foo/login.keychain-db /Users/foo/other keychain",
+	}, testRecord(t, record))
+}
+
+func TestCreate_tempPath(t *testing.T) {
+	k, err := Create(context.Background(), &Options{
+		PKCS12File: "cert.p12",
+		BaseCmd:    childCmd(t, "security"),
+	})
+	require.NoError(t, err)
+
+	dir := filepath.Dir(k.Path)
+	_, err = os.Stat(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, k.Delete(context.Background()))
+	_, err = os.Stat(dir)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestCreate_failure(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	record := filepath.Join(td, "record")
+	cmd := childCmd(t, "security")
+	cmd.Env = append(cmd.Env,
+		childRecordEnv+"="+record,
+		childFailEnv+"=import",
+	)
+
+	_, err = Create(context.Background(), &Options{
+		PKCS12File: "cert.p12",
+		Path:       "/tmp/gon.keychain",
+		BaseCmd:    cmd,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error importing certificate")
+
+	// The keychain is deleted and the search list restored
+	calls := testRecord(t, record)
+	require.Equal(t, []string{
+		"delete-keychain /tmp/gon.keychain",
+		"list-keychains -d user -s /Users/foo/login.keychain-db /Users/foo/other keychain",
+	}, calls[len(calls)-2:])
+}
+
+func TestRedact(t *testing.T) {
+	require.Equal(t,
+		[]string{"security", "import", "a.p12", "-k", "a.keychain", "-P", "<redacted>"},
+		redact([]string{"security", "import", "a.p12", "-k", "a.keychain", "-P", "pass"}))
+	require.Equal(t,
+		[]string{"security", "set-key-partition-list", "-k", "<redacted>", "a.keychain"},
+		redact([]string{"security", "set-key-partition-list", "-k", "pass", "a.keychain"}))
+}
+
+// testRecord returns the recorded arguments of each security call.
+func testRecord(t *testing.T, path string) []string {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// childEnv is the env var that must be set to trigger a child command.
+const childEnv = "GON_TEST_CHILD"
+
+// childRecordEnv is the env var with the path to the file where the
+// "security" child command appends its arguments.
+const childRecordEnv = "GON_TEST_RECORD"
+
+// childFailEnv is the env var with a security subcommand that fails.
+const childFailEnv = "GON_TEST_FAIL"
+
+// childIdentitiesEnv and childCertificatesEnv are the env vars with the
+// paths to the output of the find-identity and find-certificate commands.
+const (
+	childIdentitiesEnv   = "GON_TEST_IDENTITIES"
+	childCertificatesEnv = "GON_TEST_CERTIFICATES"
+)
+
+// childCommands is the list of commands we support
+var childCommands = map[string]func() int{
+	"security": childSecurity,
+}
+
+// childCmd is used to create a command that executes a command in the
+// childCommands map in a new process.
+func childCmd(t *testing.T, name string, args ...string) *exec.Cmd {
+	t.Helper()
+
+	// Get the path to our executable
+	selfPath, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		t.Fatalf("error creating child command: %s", err)
+		return nil
+	}
+
+	cmd := exec.Command(selfPath, args...)
+	cmd.Env = os.Environ()
+	cmd.Env = append(cmd.Env, childEnv+"="+name)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd
+}
+
+// childSecurity fakes security, recording its arguments.
+func childSecurity() int {
+	args := os.Args[1:]
+	if p := os.Getenv(childRecordEnv); p != "" {
+		f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error opening record file: %s", err)
+			return 1
+		}
+		defer f.Close()
+
+		fmt.Fprintln(f, strings.Join(args, " "))
+	}
+
+	if len(args) > 0 && args[0] == os.Getenv(childFailEnv) {
+		fmt.Println("security: failed")
+		return 1
+	}
+
+	// Output the contents of a file for the find commands
+	for env, cmd := range map[string]string{
+		childIdentitiesEnv:   "find-identity",
+		childCertificatesEnv: "find-certificate",
+	} {
+		if len(args) > 0 && args[0] == cmd {
+			data, err := ioutil.ReadFile(os.Getenv(env))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error reading output: %s", err)
+				return 1
+			}
+			os.Stdout.Write(data)
+		}
+	}
+
+	if len(args) == 3 && args[0] == "list-keychains" {
+		fmt.Println(`    "/Users/foo/login.keychain-db"`)
+		fmt.Println(`    "/Users/foo/other keychain"`)
+	}
+
+	return 0
+}
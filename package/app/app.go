@@ -0,0 +1,314 @@
+// Package app creates application bundles (.app) for command-line binaries.
+//
+// macOS requires an application bundle for features such as custom URL
+// schemes, login items and privacy prompts, which read their settings from
+// the Info.plist of the bundle. This package wraps already built binaries
+// in a minimal bundle so that they can use these features. The bundle is
+// created in pure Go, so this works on any OS.
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"howett.net/plist"
+
+	"github.com/mitchellh/gon/internal/icns"
+)
+
+// Options are the options for creating the application bundle.
+type Options struct {
+	// Files are the binaries to put in Contents/MacOS of the bundle. The
+	// first file is the main executable of the bundle unless Executable
+	// is set. At least one file is required.
+	Files []string
+
+	// OutputPath is the path where the bundle will be written, such as
+	// "dist/Foo.app". The directory containing this path must already
+	// exist. If the bundle already exists it will be replaced.
+	OutputPath string
+
+	// BundleID is the bundle identifier, such as "com.example.foo". This
+	// is required.
+	BundleID string
+
+	// Name is the name of the application. This defaults to the name of
+	// OutputPath without the ".app" extension.
+	Name string
+
+	// Executable is the name of the file in Files to use as the main
+	// executable of the bundle. This defaults to the first file.
+	Executable string
+
+	// Version is the version of the application, such as "1.2.3". This
+	// defaults to "1.0".
+	Version string
+
+	// Build is the build number of the application. This defaults to
+	// Version.
+	Build string
+
+	// Icon is the (optional) path to an .icns file to use as the icon of
+	// the application. This can also be a square PNG image, ideally
+	// 1024x1024, which is converted to an .icns file.
+	Icon string
+
+	// Resources are additional files or directories to put in
+	// Contents/Resources of the bundle.
+	Resources []string
+
+	// MinimumSystemVersion is the (optional) minimum version of macOS
+	// the application runs on, such as "10.13".
+	MinimumSystemVersion string
+
+	// Agent, if true, sets LSUIElement so that the application doesn't
+	// show in the Dock or have a menu bar. This should be set for
+	// command-line binaries and background agents.
+	Agent bool
+
+	// URLSchemes are the URL schemes the application handles, such as
+	// "foo" for "foo://" URLs.
+	URLSchemes []string
+
+	// UsageDescriptions are the messages shown in privacy prompts, keyed
+	// by Info.plist key such as "NSCameraUsageDescription".
+	UsageDescriptions map[string]string
+
+	// Logger is the logger to use. If this is nil then no logging will be done.
+	Logger hclog.Logger
+}
+
+// Create creates the application bundle using the options given.
+func Create(ctx context.Context, opts *Options) error {
+	logger := opts.Logger
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	info := opts.infoPlist()
+	data, err := plist.MarshalIndent(info, plist.XMLFormat, "\t")
+	if err != nil {
+		return err
+	}
+
+	// Build the bundle next to the output path and move it into place
+	// at the end so that we never leave a partial bundle behind.
+	td, err := ioutil.TempDir(filepath.Dir(opts.OutputPath), ".gon-app")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(td)
+
+	bundle := filepath.Join(td, filepath.Base(opts.OutputPath))
+	contents := filepath.Join(bundle, "Contents")
+	for _, dir := range []string{"MacOS", "Resources"} {
+		if err := os.MkdirAll(filepath.Join(contents, dir), 0755); err != nil {
+			return err
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(contents, "Info.plist"), data, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(contents, "PkgInfo"), []byte("APPL????"), 0644); err != nil {
+		return err
+	}
+
+	for _, f := range opts.Files {
+		logger.Info("copying executable", "path", f)
+		if err := copyTree(f, filepath.Join(contents, "MacOS", filepath.Base(f))); err != nil {
+			return err
+		}
+	}
+	if opts.Icon != "" {
+		dst := filepath.Join(contents, "Resources", info["CFBundleIconFile"].(string))
+		if isPNG(opts.Icon) {
+			err = icns.EncodeFile(dst, opts.Icon)
+		} else {
+			err = copyTree(opts.Icon, dst)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, f := range opts.Resources {
+		logger.Info("copying resource", "path", f)
+		if err := copyTree(f, filepath.Join(contents, "Resources", filepath.Base(f))); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Lstat(opts.OutputPath); err == nil {
+		logger.Info("output path exists, removing", "path", opts.OutputPath)
+		if err := os.RemoveAll(opts.OutputPath); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(bundle, opts.OutputPath); err != nil {
+		return err
+	}
+
+	logger.Info("app bundle creation complete", "output_path", opts.OutputPath)
+	return nil
+}
+
+func (o *Options) validate() error {
+	if o.BundleID == "" {
+		return fmt.Errorf("a bundle ID is required to create an app bundle")
+	}
+	if filepath.Ext(o.OutputPath) != ".app" {
+		return fmt.Errorf("app bundle output path must end in .app, got %q", o.OutputPath)
+	}
+	if len(o.Files) == 0 {
+		return fmt.Errorf("an app bundle requires at least one executable")
+	}
+
+	names := map[string]bool{}
+	for _, f := range o.Files {
+		name := filepath.Base(f)
+		if names[name] {
+			return fmt.Errorf("app bundle executable %q is set more than once", name)
+		}
+		names[name] = true
+	}
+	if o.Executable != "" && !names[o.Executable] {
+		return fmt.Errorf("app bundle executable %q is not one of the files", o.Executable)
+	}
+
+	for k := range o.UsageDescriptions {
+		if !strings.HasSuffix(k, "UsageDescription") {
+			return fmt.Errorf("usage description key %q must end in UsageDescription", k)
+		}
+	}
+
+	return nil
+}
+
+// infoPlist returns the contents of the Info.plist of the bundle.
+func (o *Options) infoPlist() map[string]interface{} {
+	name := o.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(o.OutputPath), ".app")
+	}
+	executable := o.Executable
+	if executable == "" {
+		executable = filepath.Base(o.Files[0])
+	}
+	version := o.Version
+	if version == "" {
+		version = "1.0"
+	}
+	build := o.Build
+	if build == "" {
+		build = version
+	}
+
+	result := map[string]interface{}{
+		"CFBundleDevelopmentRegion":     "en",
+		"CFBundleExecutable":            executable,
+		"CFBundleIdentifier":            o.BundleID,
+		"CFBundleInfoDictionaryVersion": "6.0",
+		"CFBundleName":                  name,
+		"CFBundlePackageType":           "APPL",
+		"CFBundleShortVersionString":    version,
+		"CFBundleVersion":               build,
+		"NSHighResolutionCapable":       true,
+	}
+	if o.Icon != "" {
+		name := filepath.Base(o.Icon)
+		if isPNG(name) {
+			name = strings.TrimSuffix(name, filepath.Ext(name)) + ".icns"
+		}
+		result["CFBundleIconFile"] = name
+	}
+	if o.MinimumSystemVersion != "" {
+		result["LSMinimumSystemVersion"] = o.MinimumSystemVersion
+	}
+	if o.Agent {
+		result["LSUIElement"] = true
+	}
+	if len(o.URLSchemes) > 0 {
+		result["CFBundleURLTypes"] = []map[string]interface{}{
+			{
+				"CFBundleURLName":    o.BundleID,
+				"CFBundleURLSchemes": o.URLSchemes,
+			},
+		}
+	}
+	for k, v := range o.UsageDescriptions {
+		result[k] = v
+	}
+
+	return result
+}
+
+// isPNG returns true if the path is a PNG image, by extension.
+func isPNG(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".png")
+}
+
+// copyTree copies the file or directory src to dst, keeping the
+// permissions and any symlinks within directories.
+func copyTree(src, dst string) error {
+	// Resolve src itself if it's a symlink, since Walk doesn't follow it
+	src, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+
+		default:
+			return copyFile(path, target, info.Mode().Perm())
+		}
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"howett.net/plist"
+)
+
+func TestCreate(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon-app")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	// Create our inputs
+	write := func(name string, mode os.FileMode) string {
+		path := filepath.Join(td, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(name), mode))
+		return path
+	}
+	main := write("dist/foo", 0755)
+	helper := write("dist/foo-helper", 0755)
+	icon := write("assets/foo.icns", 0644)
+	write("assets/docs/README", 0644)
+
+	output := filepath.Join(td, "Foo.app")
+	require.NoError(t, os.MkdirAll(filepath.Join(output, "stale"), 0755))
+
+	require.NoError(t, Create(context.Background(), &Options{
+		Files:             []string{helper, main},
+		OutputPath:        output,
+		BundleID:          "com.example.foo",
+		Executable:        "foo",
+		Version:           "1.2.3",
+		Icon:              icon,
+		Resources:         []string{filepath.Join(td, "assets", "docs")},
+		Agent:             true,
+		URLSchemes:        []string{"foo"},
+		UsageDescriptions: map[string]string{"NSCameraUsageDescription": "Scan codes"},
+	}))
+
+	// The bundle replaces the existing output
+	_, err = os.Stat(filepath.Join(output, "stale"))
+	require.True(t, os.IsNotExist(err))
+
+	contents := filepath.Join(output, "Contents")
+	for path, mode := range map[string]os.FileMode{
+		"MacOS/foo":             0755,
+		"MacOS/foo-helper":      0755,
+		"Resources/foo.icns":    0644,
+		"Resources/docs/README": 0644,
+		"PkgInfo":               0644,
+	} {
+		fi, err := os.Stat(filepath.Join(contents, path))
+		require.NoError(t, err, path)
+		require.Equal(t, mode, fi.Mode().Perm(), path)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(contents, "Info.plist"))
+	require.NoError(t, err)
+	var info map[string]interface{}
+	_, err = plist.Unmarshal(data, &info)
+	require.NoError(t, err)
+	require.Equal(t, "foo", info["CFBundleExecutable"])
+	require.Equal(t, "com.example.foo", info["CFBundleIdentifier"])
+	require.Equal(t, "Foo", info["CFBundleName"])
+	require.Equal(t, "APPL", info["CFBundlePackageType"])
+	require.Equal(t, "1.2.3", info["CFBundleShortVersionString"])
+	require.Equal(t, "1.2.3", info["CFBundleVersion"])
+	require.Equal(t, "foo.icns", info["CFBundleIconFile"])
+	require.Equal(t, true, info["LSUIElement"])
+	require.Equal(t, "Scan codes", info["NSCameraUsageDescription"])
+	require.Equal(t, []interface{}{
+		map[string]interface{}{
+			"CFBundleURLName":    "com.example.foo",
+			"CFBundleURLSchemes": []interface{}{"foo"},
+		},
+	}, info["CFBundleURLTypes"])
+}
+
+func TestCreate_pngIcon(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon-app")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	exec := filepath.Join(td, "foo")
+	require.NoError(t, ioutil.WriteFile(exec, []byte("foo"), 0755))
+
+	icon := filepath.Join(td, "logo.png")
+	f, err := os.Create(icon)
+	require.NoError(t, err)
+	require.NoError(t, png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 64, 64))))
+	require.NoError(t, f.Close())
+
+	output := filepath.Join(td, "Foo.app")
+	require.NoError(t, Create(context.Background(), &Options{
+		Files:      []string{exec},
+		OutputPath: output,
+		BundleID:   "com.example.foo",
+		Icon:       icon,
+	}))
+
+	data, err := ioutil.ReadFile(filepath.Join(output, "Contents", "Resources", "logo.icns"))
+	require.NoError(t, err)
+	require.Equal(t, "icns", string(data[:4]))
+
+	data, err = ioutil.ReadFile(filepath.Join(output, "Contents", "Info.plist"))
+	require.NoError(t, err)
+	var info map[string]interface{}
+	_, err = plist.Unmarshal(data, &info)
+	require.NoError(t, err)
+	require.Equal(t, "logo.icns", info["CFBundleIconFile"])
+}
+
+func TestOptionsValidate(t *testing.T) {
+	valid := func() *Options {
+		return &Options{
+			Files:      []string{"dist/foo"},
+			OutputPath: "Foo.app",
+			BundleID:   "com.example.foo",
+		}
+	}
+	require.NoError(t, valid().validate())
+
+	cases := map[string]func(*Options){
+		"no bundle id":    func(o *Options) { o.BundleID = "" },
+		"no files":        func(o *Options) { o.Files = nil },
+		"not an app":      func(o *Options) { o.OutputPath = "Foo" },
+		"duplicate files": func(o *Options) { o.Files = []string{"a/foo", "b/foo"} },
+		"unknown exec":    func(o *Options) { o.Executable = "bar" },
+		"usage key":       func(o *Options) { o.UsageDescriptions = map[string]string{"NSCamera": "x"} },
+	}
+	for name, f := range cases {
+		t.Run(name, func(t *testing.T) {
+			opts := valid()
+			f(opts)
+			require.Error(t, opts.validate())
+		})
+	}
+}
@@ -7,6 +7,10 @@
 // NOT a pure Go implementation of dmg creation. Please understand the risks
 // associated with this before choosing to use this package.
 //
+// create-dmg lays out the window by scripting Finder, which doesn't work
+// without a GUI session. In that case, the dmg is instead created with
+// hdiutil alone and the layout is written directly. See Mode.
+//
 // [1]: https://github.com/andreyvit/create-dmg
 package dmg
 
@@ -17,11 +21,15 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/hashicorp/go-hclog"
 
 	"github.com/mitchellh/gon/internal/createdmg"
+	"github.com/mitchellh/gon/internal/icns"
 )
 
 // Options are the options for creating the dmg archive.
@@ -41,6 +49,11 @@ type Options struct {
 	// in Files.
 	Root string
 
+	// ExtraFiles are additional files to put at chosen paths within the
+	// dmg, such as a README or man pages. These are added to a copy of
+	// Root, so Root isn't modified.
+	ExtraFiles []File
+
 	// OutputPath is the path where the dmg file will be written. The directory
 	// containing this path must already exist. If a file already exist here
 	// it will be overwritten.
@@ -49,14 +62,142 @@ type Options struct {
 	// VolumeName is the name of the dmg volume when mounted.
 	VolumeName string
 
+	// Background is the (optional) path to an image to use as the
+	// background of the Finder window when the dmg is opened.
+	Background string
+
+	// VolumeIcon is the (optional) path to an .icns file to use as the
+	// icon of the mounted volume. This can also be a square PNG image,
+	// ideally 1024x1024, which is converted to an .icns file.
+	VolumeIcon string
+
+	// WindowPosition is the position of the top-left corner of the Finder
+	// window on the screen. If this is nil, create-dmg's default is used.
+	WindowPosition *Point
+
+	// WindowSize is the width (X) and height (Y) of the Finder window. If
+	// this is nil, create-dmg's default is used.
+	WindowSize *Point
+
+	// IconSize is the size of the icons in the Finder window, from 16 to
+	// 512. If this is zero, create-dmg's default of 128 is used.
+	IconSize int
+
+	// Icons are the positions of the icons of files in the root of the dmg.
+	// Files without a position are placed at 0,0.
+	Icons []Icon
+
+	// HideExtension are the names of files in the root of the dmg to hide
+	// the extension of, such as "Foo.app".
+	HideExtension []string
+
+	// ApplicationsLink, if set, adds a link to the /Applications folder at
+	// this position so that apps can be installed by dragging them onto it.
+	ApplicationsLink *Point
+
+	// EULA is the (optional) path to a license agreement to show when
+	// the dmg is opened.
+	EULA string
+
+	// Format is the format of the disk image, such as FormatUDZO. This
+	// defaults to FormatUDZO, which is readable by every version of macOS.
+	// FormatULFO and FormatULMO compress large bundles much better but
+	// require macOS 10.11 and 10.15 respectively to open the dmg.
+	Format string
+
+	// Filesystem is the filesystem of the volume, FilesystemHFSPlus or
+	// FilesystemAPFS. This defaults to FilesystemHFSPlus. APFS volumes
+	// require macOS 10.13 to open the dmg and are only supported in
+	// ModeHeadless.
+	Filesystem string
+
+	// Size is the (optional) size of the volume in megabytes. If this is
+	// zero, the size is estimated from the size of the files. This is
+	// the size before compression.
+	Size int
+
+	// Mode is how the dmg is created. This defaults to ModeAuto, which
+	// creates the dmg headlessly if there is no window server available.
+	Mode Mode
+
 	// Logger is the logger to use. If this is nil then no logging will be done.
 	Logger hclog.Logger
 
-	// BaseCmd is the base command for executing the codesign binary. This is
-	// used for tests to overwrite where the codesign binary is.
+	// BaseCmd is the base command for executing create-dmg, or hdiutil and
+	// xattr in headless mode. This is used for tests to overwrite where the
+	// binaries are.
 	BaseCmd *exec.Cmd
 }
 
+// File is a file or directory to add to the dmg at a chosen path.
+type File struct {
+	// Src is the path to the file or directory to add.
+	Src string
+
+	// Dst is the slash-separated path within the dmg, such as
+	// "share/man/man1/foo.1". Parent directories are created as needed.
+	Dst string
+
+	// Mode, if non-zero, sets the permissions of Dst.
+	Mode os.FileMode
+}
+
+// Point is a position or size in the Finder window, in points.
+type Point struct {
+	X, Y int
+}
+
+// Icon is the position of the icon of a file in the root of the dmg.
+type Icon struct {
+	// Name is the name of the file in the root of the dmg.
+	Name string
+
+	Point
+}
+
+// The formats of disk images that can be used for Format. These are all
+// compressed and read-only.
+const (
+	FormatUDZO = "UDZO" // zlib
+	FormatUDBZ = "UDBZ" // bzip2
+	FormatULFO = "ULFO" // LZFSE, macOS 10.11+
+	FormatULMO = "ULMO" // LZMA, macOS 10.15+
+)
+
+// The filesystems that can be used for Filesystem.
+const (
+	FilesystemHFSPlus = "HFS+"
+	FilesystemAPFS    = "APFS" // macOS 10.13+
+)
+
+// imageKeys are the hdiutil image keys to use for each format. These set
+// the highest compression level, which create-dmg does as well.
+var imageKeys = map[string]string{
+	FormatUDZO: "zlib-level=9",
+	FormatUDBZ: "bzip2-level=9",
+	FormatULFO: "",
+	FormatULMO: "",
+}
+
+// Mode is how the dmg is created.
+type Mode string
+
+const (
+	// ModeAuto uses ModeFinder if there is a window server available and
+	// ModeHeadless otherwise. See DetectMode.
+	ModeAuto Mode = ""
+
+	// ModeFinder creates the dmg with create-dmg, which lays out the
+	// window by scripting Finder with AppleScript. This requires a GUI
+	// session and opens Finder windows while the dmg is created.
+	ModeFinder Mode = "finder"
+
+	// ModeHeadless creates the dmg with hdiutil alone. The window layout
+	// is set by writing the .DS_Store file directly rather than with
+	// Finder. EULAs aren't supported in this mode.
+	ModeHeadless Mode = "headless"
+)
+
 // Dmg creates a dmg archive for notarization using the options given.
 func Dmg(ctx context.Context, opts *Options) error {
 	logger := opts.Logger
@@ -64,6 +205,50 @@ func Dmg(ctx context.Context, opts *Options) error {
 		logger = hclog.NewNullLogger()
 	}
 
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	// A PNG volume icon is converted to an icon file first. We copy the
+	// options so that the caller's options aren't modified.
+	if strings.EqualFold(filepath.Ext(opts.VolumeIcon), ".png") {
+		td, err := ioutil.TempDir("", "gon-dmg-icon")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(td)
+
+		path := filepath.Join(td, "VolumeIcon.icns")
+		logger.Info("converting volume icon", "src", opts.VolumeIcon, "dst", path)
+		if err := icns.EncodeFile(path, opts.VolumeIcon); err != nil {
+			return err
+		}
+
+		optsCopy := *opts
+		optsCopy.VolumeIcon = path
+		opts = &optsCopy
+	}
+
+	mode := opts.ResolveMode()
+	logger.Info("dmg creation mode", "mode", mode)
+
+	// If our output path exists prior to running, we have to delete that
+	if _, err := os.Stat(opts.OutputPath); err == nil {
+		logger.Info("output path exists, removing", "path", opts.OutputPath)
+		if err := os.Remove(opts.OutputPath); err != nil {
+			return err
+		}
+	}
+
+	if mode == ModeHeadless {
+		if err := headless(ctx, opts, logger); err != nil {
+			return err
+		}
+
+		logger.Info("dmg creation complete", "output_path", opts.OutputPath)
+		return nil
+	}
+
 	// Build our command
 	var cmd *exec.Cmd
 	if opts.BaseCmd != nil {
@@ -81,41 +266,33 @@ func Dmg(ctx context.Context, opts *Options) error {
 		defer createdmg.Close(cmd)
 	}
 
-	// Set our basic settings
-	args := []string{
-		filepath.Base(cmd.Path), // argv[0]
-		"--volname", opts.VolumeName,
-	}
-
-	// Inject our files
-	for _, f := range opts.Files {
-		args = append(args, "--add-file", filepath.Base(f), f, "0", "0")
-	}
-
 	// Set our root directory. If one wasn't specified, we create an empty
 	// temporary directory to act as our root and we just use the flags to
 	// inject our files.
 	root := opts.Root
-	if root == "" {
+	if root == "" || len(opts.ExtraFiles) > 0 {
 		td, err := ioutil.TempDir("", "gon")
 		if err != nil {
 			return err
 		}
 		defer os.RemoveAll(td)
 		root = td
-	}
-
-	// Add the final arguments and set it on cmd
-	cmd.Args = append(args, opts.OutputPath, root)
 
-	// If our output path exists prior to running, we have to delete that
-	if _, err := os.Stat(opts.OutputPath); err == nil {
-		logger.Info("output path exists, removing", "path", opts.OutputPath)
-		if err := os.Remove(opts.OutputPath); err != nil {
+		// create-dmg can only add files to the root of the dmg, so we
+		// copy the extra files into our root.
+		if opts.Root != "" {
+			if err := run(ctx, logger, nil, "ditto", opts.Root, root); err != nil {
+				return fmt.Errorf("error copying %s to the dmg root:\n\n%s", opts.Root, err)
+			}
+		}
+		if err := addFiles(ctx, logger, root, opts.ExtraFiles); err != nil {
 			return err
 		}
 	}
 
+	// Set the arguments on cmd, with argv[0] first
+	cmd.Args = append([]string{filepath.Base(cmd.Path)}, args(opts, root)...)
+
 	// We store all output in out for logging and in case there is an error
 	var out bytes.Buffer
 	cmd.Stdout = &out
@@ -137,3 +314,170 @@ func Dmg(ctx context.Context, opts *Options) error {
 	logger.Info("dmg creation complete", "output", out.String())
 	return nil
 }
+
+// ResolveMode returns the mode the dmg is created with. If Mode is
+// ModeAuto, this is ModeHeadless if the options require it and otherwise
+// the mode from DetectMode.
+func (o *Options) ResolveMode() Mode {
+	if o.Mode != ModeAuto {
+		return o.Mode
+	}
+	if o.Filesystem == FilesystemAPFS {
+		return ModeHeadless
+	}
+
+	return DetectMode()
+}
+
+// validate checks the options for values create-dmg would accept but
+// that would result in a broken window layout or disk image.
+func (o *Options) validate() error {
+	switch o.Mode {
+	case ModeAuto, ModeFinder, ModeHeadless:
+	default:
+		return fmt.Errorf("unknown dmg mode %q", o.Mode)
+	}
+
+	if _, ok := imageKeys[o.Format]; o.Format != "" && !ok {
+		return fmt.Errorf("unknown dmg format %q, must be one of UDZO, UDBZ, ULFO, or ULMO", o.Format)
+	}
+	switch o.Filesystem {
+	case "", FilesystemHFSPlus:
+	case FilesystemAPFS:
+		if o.Mode == ModeFinder {
+			return fmt.Errorf("an APFS dmg can only be created in headless mode")
+		}
+	default:
+		return fmt.Errorf("unknown dmg filesystem %q, must be HFS+ or APFS", o.Filesystem)
+	}
+	if o.Size < 0 {
+		return fmt.Errorf("dmg size must be positive, got %d", o.Size)
+	}
+
+	if o.IconSize != 0 && (o.IconSize < 16 || o.IconSize > 512) {
+		return fmt.Errorf("icon size must be between 16 and 512, got %d", o.IconSize)
+	}
+	if s := o.WindowSize; s != nil && (s.X <= 0 || s.Y <= 0) {
+		return fmt.Errorf("window size must be positive, got %dx%d", s.X, s.Y)
+	}
+
+	// Extra files can't overlap each other or the files in Files, since
+	// they would be merged into each other.
+	paths := map[string]bool{}
+	for _, f := range o.Files {
+		paths[filepath.Base(f)] = true
+	}
+	for _, f := range o.ExtraFiles {
+		dst := path.Clean(f.Dst)
+		if f.Src == "" || f.Dst == "" {
+			return fmt.Errorf("extra files require a source and a destination")
+		}
+		if path.IsAbs(dst) || dst == "." || dst == ".." || strings.HasPrefix(dst, "../") {
+			return fmt.Errorf("extra file destination %q must be a path within the dmg", f.Dst)
+		}
+		if paths[dst] || paths[strings.SplitN(dst, "/", 2)[0]] {
+			return fmt.Errorf("extra file destination %q overlaps another file", f.Dst)
+		}
+		paths[dst] = true
+	}
+
+	seen := map[string]bool{}
+	for _, icon := range o.Icons {
+		if icon.Name == "" {
+			return fmt.Errorf("icon positions require the name of a file")
+		}
+		if seen[icon.Name] {
+			return fmt.Errorf("icon position for %q is set more than once", icon.Name)
+		}
+		seen[icon.Name] = true
+	}
+
+	return nil
+}
+
+// addFiles copies the files into the directory root at their destinations.
+func addFiles(ctx context.Context, logger hclog.Logger, root string, files []File) error {
+	for _, f := range files {
+		dst := filepath.Join(root, filepath.FromSlash(path.Clean(f.Dst)))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+
+		// ditto copies directories recursively and preserves metadata
+		if err := run(ctx, logger, nil, "ditto", f.Src, dst); err != nil {
+			return fmt.Errorf("error copying %s to the dmg root:\n\n%s", f.Src, err)
+		}
+		if f.Mode != 0 {
+			if err := os.Chmod(dst, f.Mode); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// args returns the arguments to create-dmg, excluding argv[0], to create
+// the dmg from root.
+func args(opts *Options, root string) []string {
+	point := func(p Point) []string {
+		return []string{strconv.Itoa(p.X), strconv.Itoa(p.Y)}
+	}
+
+	// Set our basic settings. The format must be first, since create-dmg
+	// sets the default image key for UDZO after parsing each flag and
+	// wouldn't unset it for other formats.
+	var result []string
+	if v := opts.Format; v != "" {
+		result = append(result, "--format", v)
+	}
+	result = append(result, "--volname", opts.VolumeName)
+	if v := opts.Size; v != 0 {
+		result = append(result, "--disk-image-size", strconv.Itoa(v))
+	}
+	if v := opts.Background; v != "" {
+		result = append(result, "--background", v)
+	}
+	if v := opts.VolumeIcon; v != "" {
+		result = append(result, "--volicon", v)
+	}
+	if v := opts.WindowPosition; v != nil {
+		result = append(append(result, "--window-pos"), point(*v)...)
+	}
+	if v := opts.WindowSize; v != nil {
+		result = append(append(result, "--window-size"), point(*v)...)
+	}
+	if v := opts.IconSize; v != 0 {
+		result = append(result, "--icon-size", strconv.Itoa(v))
+	}
+
+	// Inject our files at their icon positions
+	icons := map[string]Point{}
+	for _, icon := range opts.Icons {
+		icons[icon.Name] = icon.Point
+	}
+	for _, f := range opts.Files {
+		name := filepath.Base(f)
+		result = append(append(result, "--add-file", name, f), point(icons[name])...)
+		delete(icons, name)
+	}
+
+	// The remaining icons are for files in the root directory
+	for _, icon := range opts.Icons {
+		if _, ok := icons[icon.Name]; ok {
+			result = append(append(result, "--icon", icon.Name), point(icon.Point)...)
+		}
+	}
+
+	for _, name := range opts.HideExtension {
+		result = append(result, "--hide-extension", name)
+	}
+	if v := opts.ApplicationsLink; v != nil {
+		result = append(append(result, "--app-drop-link"), point(*v)...)
+	}
+	if v := opts.EULA; v != "" {
+		result = append(result, "--eula", v)
+	}
+
+	return append(result, opts.OutputPath, root)
+}
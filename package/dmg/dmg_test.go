@@ -0,0 +1,177 @@
+package dmg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sebdah/goldie"
+	"github.com/stretchr/testify/require"
+	"howett.net/plist"
+
+	"github.com/mitchellh/gon/internal/dsstore"
+)
+
+func init() {
+	goldie.FixtureDir = "testdata"
+}
+
+func TestArgs(t *testing.T) {
+	cases := []struct {
+		Name string
+		Opts *Options
+	}{
+		{
+			"basic",
+			&Options{
+				Files:      []string{"/tmp/build/terraform"},
+				OutputPath: "terraform.dmg",
+				VolumeName: "Terraform",
+			},
+		},
+
+		{
+			"layout",
+			&Options{
+				Files:          []string{"/tmp/build/Foo.app", "/tmp/build/README"},
+				OutputPath:     "foo.dmg",
+				VolumeName:     "Foo",
+				Background:     "./background.png",
+				VolumeIcon:     "./volume.icns",
+				WindowPosition: &Point{200, 120},
+				WindowSize:     &Point{600, 400},
+				IconSize:       96,
+				Icons: []Icon{
+					{Name: "Foo.app", Point: Point{150, 190}},
+					{Name: "LICENSE", Point: Point{300, 300}},
+				},
+				HideExtension:    []string{"Foo.app"},
+				ApplicationsLink: &Point{450, 190},
+				EULA:             "./LICENSE.txt",
+			},
+		},
+
+		{
+			"format",
+			&Options{
+				Files:      []string{"/tmp/build/terraform"},
+				OutputPath: "terraform.dmg",
+				VolumeName: "Terraform",
+				Format:     FormatULFO,
+				Filesystem: FilesystemHFSPlus,
+				Size:       200,
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			require.NoError(t, tt.Opts.validate())
+
+			actual := args(tt.Opts, "/tmp/root")
+			goldie.Assert(t, tt.Name, []byte(strings.Join(actual, "\n")+"\n"))
+		})
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	cases := []struct {
+		Name string
+		Opts *Options
+	}{
+		{"icon size too small", &Options{IconSize: 8}},
+		{"icon size too large", &Options{IconSize: 1024}},
+		{"window size", &Options{WindowSize: &Point{0, 400}}},
+		{"icon without name", &Options{Icons: []Icon{{Point: Point{1, 2}}}}},
+		{"duplicate icon", &Options{Icons: []Icon{{Name: "a"}, {Name: "a"}}}},
+		{"unknown mode", &Options{Mode: "nope"}},
+		{"unknown format", &Options{Format: "UDRW"}},
+		{"unknown filesystem", &Options{Filesystem: "FAT32"}},
+		{"apfs with finder", &Options{Filesystem: FilesystemAPFS, Mode: ModeFinder}},
+		{"negative size", &Options{Size: -1}},
+		{"extra file without source", &Options{ExtraFiles: []File{{Dst: "README"}}}},
+		{"extra file outside", &Options{ExtraFiles: []File{{Src: "a", Dst: "../README"}}}},
+		{"extra file absolute", &Options{ExtraFiles: []File{{Src: "a", Dst: "/README"}}}},
+		{"extra file root", &Options{ExtraFiles: []File{{Src: "a", Dst: "./"}}}},
+		{"extra file duplicate", &Options{ExtraFiles: []File{
+			{Src: "a", Dst: "README"}, {Src: "b", Dst: "./README"},
+		}}},
+		{"extra file in source", &Options{
+			Files:      []string{"/tmp/build/Foo.app"},
+			ExtraFiles: []File{{Src: "a", Dst: "Foo.app/README"}},
+		}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			require.Error(t, tt.Opts.validate())
+		})
+	}
+}
+
+func TestOptionsValidate_extraFiles(t *testing.T) {
+	opts := &Options{
+		Files: []string{"/tmp/build/foo"},
+		ExtraFiles: []File{
+			{Src: "./README.md", Dst: "README.md"},
+			{Src: "./foo.1", Dst: "share/man/man1/foo.1", Mode: 0644},
+			{Src: "./foo.bash", Dst: "share/bash-completion/completions/foo"},
+		},
+	}
+	require.NoError(t, opts.validate())
+}
+
+func TestOptionsResolveMode(t *testing.T) {
+	require.Equal(t, ModeFinder, (&Options{Mode: ModeFinder}).ResolveMode())
+	require.Equal(t, ModeHeadless, (&Options{Mode: ModeHeadless}).ResolveMode())
+	require.Equal(t, ModeHeadless, (&Options{Filesystem: FilesystemAPFS}).ResolveMode())
+}
+
+func TestLayout(t *testing.T) {
+	opts := &Options{
+		Files:          []string{"/tmp/build/Foo.app", "/tmp/build/README"},
+		VolumeName:     "Foo",
+		Background:     "./background.png",
+		WindowPosition: &Point{200, 120},
+		WindowSize:     &Point{600, 400},
+		IconSize:       96,
+		Icons: []Icon{
+			{Name: "Foo.app", Point: Point{150, 190}},
+			{Name: "LICENSE", Point: Point{300, 300}},
+		},
+		ApplicationsLink: &Point{450, 190},
+	}
+
+	data, err := layout(opts)
+	require.NoError(t, err)
+	records, err := dsstore.Read(data)
+	require.NoError(t, err)
+
+	byName := map[string]dsstore.Record{}
+	for _, r := range records {
+		byName[r.Name+"/"+r.Code] = r
+	}
+
+	// Icon positions
+	for name, p := range map[string]Point{
+		"Foo.app":      {150, 190},
+		"README":       {0, 0},
+		"LICENSE":      {300, 300},
+		"Applications": {450, 190},
+		".background":  {900, 100},
+	} {
+		require.Equal(t, dsstore.Iloc(name, p.X, p.Y), byName[name+"/Iloc"], name)
+	}
+
+	// Window settings
+	var bwsp map[string]interface{}
+	_, err = plist.Unmarshal(byName["./bwsp"].Data[4:], &bwsp)
+	require.NoError(t, err)
+	require.Equal(t, "{{200, 120}, {600, 400}}", bwsp["WindowBounds"])
+
+	var icvp map[string]interface{}
+	_, err = plist.Unmarshal(byName["./icvp"].Data[4:], &icvp)
+	require.NoError(t, err)
+	require.Equal(t, 96.0, icvp["iconSize"])
+	require.Equal(t, uint64(2), icvp["backgroundType"])
+	require.Equal(t, dsstore.Alias("Foo", ".background/background.png"), icvp["backgroundImageAlias"])
+}
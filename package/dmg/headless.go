@@ -0,0 +1,356 @@
+package dmg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"howett.net/plist"
+
+	"github.com/mitchellh/gon/internal/dsstore"
+)
+
+// The defaults for the window layout, which match create-dmg.
+var (
+	defaultWindowPosition = Point{10, 60}
+	defaultWindowSize     = Point{500, 350}
+)
+
+const (
+	defaultIconSize = 128
+	defaultTextSize = 16
+)
+
+// Finder flags in the com.apple.FinderInfo extended attribute.
+const (
+	finderFlagExtensionHidden = 0x0010
+	finderFlagHasCustomIcon   = 0x0400
+)
+
+// DetectMode returns ModeFinder if there is a window server that Finder
+// can use and ModeHeadless otherwise, such as when running over SSH or on
+// a CI machine without a logged in user.
+func DetectMode() Mode {
+	if runtime.GOOS != "darwin" {
+		return ModeHeadless
+	}
+
+	// The launchd session of a logged in GUI user is "Aqua". Other
+	// sessions such as "Background" and "StandardIO" can't use Finder.
+	out, err := exec.Command("launchctl", "managername").Output()
+	if err != nil || strings.TrimSpace(string(out)) != "Aqua" {
+		return ModeHeadless
+	}
+
+	return ModeFinder
+}
+
+// headless creates the dmg with hdiutil, without Finder.
+func headless(ctx context.Context, opts *Options, logger hclog.Logger) error {
+	if opts.EULA != "" {
+		return fmt.Errorf("a EULA can't be added to a dmg in headless mode")
+	}
+
+	td, err := ioutil.TempDir("", "gon-dmg")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(td)
+
+	root := filepath.Join(td, "root")
+	if err := stage(ctx, logger, opts, root); err != nil {
+		return err
+	}
+
+	format := opts.Format
+	if format == "" {
+		format = FormatUDZO
+	}
+	filesystem := opts.Filesystem
+	if filesystem == "" {
+		filesystem = FilesystemHFSPlus
+	}
+
+	create := []string{
+		"create",
+		"-srcfolder", root,
+		"-volname", opts.VolumeName,
+		"-fs", filesystem,
+	}
+	if opts.Size != 0 {
+		create = append(create, "-size", fmt.Sprintf("%dm", opts.Size))
+	}
+	compress := []string{"-format", format}
+	if key := imageKeys[format]; key != "" {
+		compress = append(compress, "-imagekey", key)
+	}
+
+	// Without a volume icon we can create the compressed image directly.
+	// Otherwise we create a writable image so that we can mark the root
+	// of the volume as having a custom icon, which can't be done on the
+	// source folder.
+	if opts.VolumeIcon == "" {
+		args := append(append(create, compress...), opts.OutputPath)
+		if err := run(ctx, logger, opts.BaseCmd, "hdiutil", args...); err != nil {
+			return fmt.Errorf("error creating dmg:\n\n%s", err)
+		}
+
+		return nil
+	}
+
+	rw := filepath.Join(td, "rw.dmg")
+	args := append(create, "-format", "UDRW", rw)
+	if err := run(ctx, logger, opts.BaseCmd, "hdiutil", args...); err != nil {
+		return fmt.Errorf("error creating dmg:\n\n%s", err)
+	}
+
+	// -nobrowse keeps the volume from showing up in Finder while we
+	// modify it.
+	mount := filepath.Join(td, "mount")
+	err = run(ctx, logger, opts.BaseCmd, "hdiutil", "attach",
+		"-readwrite", "-noverify", "-noautoopen", "-nobrowse",
+		"-mountpoint", mount, rw)
+	if err != nil {
+		return fmt.Errorf("error mounting dmg:\n\n%s", err)
+	}
+	err = setFinderFlags(ctx, logger, opts.BaseCmd, mount, finderFlagHasCustomIcon)
+	if derr := run(ctx, logger, opts.BaseCmd, "hdiutil", "detach", mount); derr != nil && err == nil {
+		err = fmt.Errorf("error unmounting dmg:\n\n%s", derr)
+	}
+	if err != nil {
+		return err
+	}
+
+	args = append([]string{"convert", rw}, compress...)
+	args = append(args, "-o", opts.OutputPath)
+	if err := run(ctx, logger, opts.BaseCmd, "hdiutil", args...); err != nil {
+		return fmt.Errorf("error compressing dmg:\n\n%s", err)
+	}
+
+	return nil
+}
+
+// stage populates the directory root with the contents of the dmg,
+// including the hidden files for the window layout.
+func stage(ctx context.Context, logger hclog.Logger, opts *Options, root string) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+
+	// ditto copies the contents of a directory into the destination
+	if opts.Root != "" {
+		if err := run(ctx, logger, nil, "ditto", opts.Root, root); err != nil {
+			return fmt.Errorf("error copying %s to the dmg root:\n\n%s", opts.Root, err)
+		}
+	}
+	for _, f := range opts.Files {
+		dst := filepath.Join(root, filepath.Base(f))
+		if err := run(ctx, logger, nil, "ditto", f, dst); err != nil {
+			return fmt.Errorf("error copying %s to the dmg root:\n\n%s", f, err)
+		}
+	}
+	if err := addFiles(ctx, logger, root, opts.ExtraFiles); err != nil {
+		return err
+	}
+
+	if opts.Background != "" {
+		dst := filepath.Join(root, ".background", filepath.Base(opts.Background))
+		if err := copyFile(opts.Background, dst); err != nil {
+			return err
+		}
+	}
+	if opts.VolumeIcon != "" {
+		if err := copyFile(opts.VolumeIcon, filepath.Join(root, ".VolumeIcon.icns")); err != nil {
+			return err
+		}
+	}
+	if opts.ApplicationsLink != nil {
+		if err := os.Symlink("/Applications", filepath.Join(root, "Applications")); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range opts.HideExtension {
+		err := setFinderFlags(ctx, logger, opts.BaseCmd,
+			filepath.Join(root, name), finderFlagExtensionHidden)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := layout(opts)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(root, ".DS_Store"), data, 0644)
+}
+
+// layout returns the .DS_Store file for the window layout. The settings
+// match what create-dmg configures with Finder.
+func layout(opts *Options) ([]byte, error) {
+	pos := defaultWindowPosition
+	if opts.WindowPosition != nil {
+		pos = *opts.WindowPosition
+	}
+	size := defaultWindowSize
+	if opts.WindowSize != nil {
+		size = *opts.WindowSize
+	}
+	iconSize := opts.IconSize
+	if iconSize == 0 {
+		iconSize = defaultIconSize
+	}
+
+	bwsp, err := plist.Marshal(map[string]interface{}{
+		"WindowBounds": fmt.Sprintf("{{%d, %d}, {%d, %d}}",
+			pos.X, pos.Y, size.X, size.Y),
+		"ContainerShowSidebar":  false,
+		"PreviewPaneVisibility": false,
+		"ShowPathbar":           false,
+		"ShowSidebar":           false,
+		"ShowStatusBar":         false,
+		"ShowTabView":           false,
+		"ShowToolbar":           false,
+		"SidebarWidth":          180,
+	}, plist.BinaryFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	view := map[string]interface{}{
+		"viewOptionsVersion": 1,
+		"arrangeBy":          "none",
+		"backgroundType":     0,
+		"gridOffsetX":        0.0,
+		"gridOffsetY":        0.0,
+		"gridSpacing":        100.0,
+		"iconSize":           float64(iconSize),
+		"labelOnBottom":      true,
+		"showIconPreview":    true,
+		"showItemInfo":       false,
+		"textSize":           float64(defaultTextSize),
+	}
+	if opts.Background != "" {
+		view["backgroundType"] = 2
+		view["backgroundImageAlias"] = dsstore.Alias(opts.VolumeName,
+			".background/"+filepath.Base(opts.Background))
+	}
+	icvp, err := plist.Marshal(view, plist.BinaryFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []dsstore.Record{
+		dsstore.Blob(".", "bwsp", bwsp),
+		dsstore.Blob(".", "icvp", icvp),
+		dsstore.Type(".", "vstl", "icnv"),
+		dsstore.Long(".", "vSrn", 1),
+	}
+
+	// Like create-dmg, files added with Files are placed at 0,0 if they
+	// don't have a position. Files in the root are placed by Finder.
+	icons := map[string]Point{}
+	for _, f := range opts.Files {
+		icons[filepath.Base(f)] = Point{}
+	}
+	for _, icon := range opts.Icons {
+		icons[icon.Name] = icon.Point
+	}
+	if v := opts.ApplicationsLink; v != nil {
+		icons["Applications"] = *v
+	}
+
+	// With a background, create-dmg moves the hidden files out of the
+	// window in case the user has Finder showing hidden files.
+	if opts.Background != "" {
+		hidden := Point{pos.X + size.X + 100, 100}
+		icons[".background"] = hidden
+		icons[".DS_Store"] = hidden
+		if opts.VolumeIcon != "" {
+			icons[".VolumeIcon.icns"] = hidden
+		}
+	}
+
+	for name, p := range icons {
+		records = append(records, dsstore.Iloc(name, p.X, p.Y))
+	}
+
+	return dsstore.Write(records)
+}
+
+// setFinderFlags sets the Finder flags of the file at path. This replaces
+// any existing Finder info of the file.
+func setFinderFlags(ctx context.Context, logger hclog.Logger, base *exec.Cmd, path string, flags uint16) error {
+	info := make([]byte, 32)
+	info[8] = byte(flags >> 8)
+	info[9] = byte(flags)
+
+	err := run(ctx, logger, base, "xattr",
+		"-wx", "com.apple.FinderInfo", fmt.Sprintf("%x", info), path)
+	if err != nil {
+		return fmt.Errorf("error setting Finder info of %s:\n\n%s", path, err)
+	}
+
+	return nil
+}
+
+// copyFile copies the file src to dst, creating the directory of dst.
+func copyFile(src, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(dst, data, 0644)
+}
+
+// run executes the named binary with the given arguments. If it fails,
+// the error contains the output.
+func run(ctx context.Context, logger hclog.Logger, base *exec.Cmd, name string, args ...string) error {
+	// Build our command
+	var cmd exec.Cmd
+	if base != nil {
+		cmd = *base
+	}
+
+	// We only set the path if it isn't set. This lets the options set the
+	// path to the binary that we use.
+	if cmd.Path == "" {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return err
+		}
+		cmd.Path = path
+	}
+
+	cmd.Args = append([]string{name}, args...)
+
+	// We store all output in out for logging and in case there is an error
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = cmd.Stdout
+
+	// Log what we're going to execute
+	logger.Info("executing "+name,
+		"command_path", cmd.Path,
+		"command_args", cmd.Args,
+	)
+
+	// Execute
+	if err := cmd.Run(); err != nil {
+		logger.Error("error executing "+name, "err", err, "output", out.String())
+		return fmt.Errorf("%s\n\n%s", err, out.String())
+	}
+
+	return nil
+}
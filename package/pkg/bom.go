@@ -0,0 +1,317 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path"
+)
+
+// The Bom ("bill of materials") lists every path of the payload with its
+// mode, owner, size, and checksum. Installer uses it to write the receipt
+// of the package. The format is a block store: a header, the blocks, a
+// table of named variables pointing at blocks, and an index of the blocks.
+// The paths are stored in a B+ tree of which we only write a single level
+// of leaves. All values are big-endian.
+const (
+	// bomHeaderSize is the size of the header, which is padded.
+	bomHeaderSize = 512
+
+	// bomLeafSize is the number of paths in each leaf of the paths tree.
+	bomLeafSize = 256
+
+	// Types of the paths.
+	bomTypeFile    = 1
+	bomTypeDir     = 2
+	bomTypeSymlink = 3
+)
+
+type bomHeader struct {
+	Magic          [8]byte
+	Version        uint32
+	NumberOfBlocks uint32
+	IndexOffset    uint32
+	IndexLength    uint32
+	VarsOffset     uint32
+	VarsLength     uint32
+}
+
+type bomPointer struct {
+	Address uint32
+	Length  uint32
+}
+
+type bomInfo struct {
+	Version             uint32
+	NumberOfPaths       uint32
+	NumberOfInfoEntries uint32
+	Entry               [4]uint32
+}
+
+type bomTree struct {
+	Magic     [4]byte
+	Version   uint32
+	Child     uint32
+	BlockSize uint32
+	PathCount uint32
+	Unknown   uint8
+}
+
+type bomVIndex struct {
+	Unknown0 uint32
+	Tree     uint32
+	Unknown2 uint32
+	Unknown3 uint8
+}
+
+type bomPathsHeader struct {
+	IsLeaf   uint16
+	Count    uint16
+	Forward  uint32
+	Backward uint32
+}
+
+type bomPathIndices struct {
+	// Index0 is the block of the bomPathInfo1 in a leaf, or the block
+	// of the child in a branch.
+	Index0 uint32
+
+	// Index1 is the block of the name of the path.
+	Index1 uint32
+}
+
+type bomPathInfo1 struct {
+	ID    uint32
+	Index uint32
+}
+
+type bomPathInfo2 struct {
+	Type         uint8
+	Unknown0     uint8
+	Architecture uint16
+	Mode         uint16
+	User         uint32
+	Group        uint32
+	ModTime      uint32
+	Size         uint32
+	Unknown1     uint8
+	Checksum     uint32
+	LinkLength   uint32
+}
+
+// bomWriter collects the blocks of a Bom.
+type bomWriter struct {
+	blocks [][]byte
+}
+
+type bomVar struct {
+	Name  string
+	Index uint32
+}
+
+// add adds a block and returns its index. Index 0 is always unused.
+func (w *bomWriter) add(vs ...interface{}) uint32 {
+	var buf bytes.Buffer
+	for _, v := range vs {
+		if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+			// We only write fixed-size values so this can't happen
+			panic(err)
+		}
+	}
+
+	w.blocks = append(w.blocks, buf.Bytes())
+	return uint32(len(w.blocks))
+}
+
+// set replaces the block at the given index.
+func (w *bomWriter) set(index uint32, vs ...interface{}) {
+	w.add(vs...)
+	w.blocks[index-1] = w.blocks[len(w.blocks)-1]
+	w.blocks = w.blocks[:len(w.blocks)-1]
+}
+
+// tree adds a tree of paths. The paths are added to leaves of bomLeafSize
+// paths, with a branch pointing at the leaves if there is more than one.
+func (w *bomWriter) tree(blockSize uint32, indices []bomPathIndices) uint32 {
+	// Reserve the leaves so that they can be linked to each other
+	var leaves []uint32
+	for start := 0; start == 0 || start < len(indices); start += bomLeafSize {
+		leaves = append(leaves, w.add())
+	}
+
+	var branch []bomPathIndices
+	for idx, leaf := range leaves {
+		chunk := indices[idx*bomLeafSize:]
+		if len(chunk) > bomLeafSize {
+			chunk = chunk[:bomLeafSize]
+		}
+
+		var forward, backward uint32
+		if idx > 0 {
+			backward = leaves[idx-1]
+		}
+		if idx < len(leaves)-1 {
+			forward = leaves[idx+1]
+		}
+
+		w.set(leaf, bomPathsHeader{
+			IsLeaf:   1,
+			Count:    uint16(len(chunk)),
+			Forward:  forward,
+			Backward: backward,
+		}, chunk)
+
+		if len(chunk) > 0 {
+			branch = append(branch, bomPathIndices{
+				Index0: leaf,
+				Index1: chunk[len(chunk)-1].Index1,
+			})
+		}
+	}
+
+	child := leaves[0]
+	if len(leaves) > 1 {
+		child = w.add(bomPathsHeader{Count: uint16(len(branch))}, branch)
+	}
+
+	return w.add(bomTree{
+		Magic:     [4]byte{'t', 'r', 'e', 'e'},
+		Version:   1,
+		Child:     child,
+		BlockSize: blockSize,
+		PathCount: uint32(len(indices)),
+	})
+}
+
+// writeBom returns the Bom of the payload entries.
+func writeBom(entries []*entry) []byte {
+	var w bomWriter
+
+	ids := map[string]uint32{}
+	var indices []bomPathIndices
+	for idx, e := range entries {
+		id := uint32(idx + 1)
+		ids[e.Path] = id
+
+		info := bomPathInfo2{
+			Type:         bomTypeFile,
+			Unknown0:     1,
+			Architecture: 3,
+			Mode:         uint16(e.unixMode()),
+			ModTime:      uint32(e.ModTime.Unix()),
+			Size:         uint32(len(e.Data)),
+			Unknown1:     1,
+		}
+		var link []byte
+		switch {
+		case e.Mode.IsDir():
+			info.Type = bomTypeDir
+		case e.Mode&os.ModeSymlink != 0:
+			info.Type = bomTypeSymlink
+			info.Checksum = cksum(e.Data)
+			link = append(append([]byte{}, e.Data...), 0)
+			info.LinkLength = uint32(len(link))
+		default:
+			info.Checksum = cksum(e.Data)
+		}
+		info2 := w.add(info, link)
+		info1 := w.add(bomPathInfo1{ID: id, Index: info2})
+
+		var parent uint32
+		name := e.Path
+		if e.Path != "." {
+			parent = ids[path.Dir(e.Path)]
+			name = path.Base(e.Path)
+		}
+		file := w.add(parent, append([]byte(name), 0))
+
+		indices = append(indices, bomPathIndices{Index0: info1, Index1: file})
+	}
+
+	vars := []bomVar{
+		{"BomInfo", w.add(bomInfo{
+			Version:             1,
+			NumberOfPaths:       uint32(len(entries)),
+			NumberOfInfoEntries: 1,
+		})},
+		{"Paths", w.tree(4096, indices)},
+		{"HLIndex", w.tree(4096, nil)},
+		{"VIndex", w.add(bomVIndex{Unknown0: 1, Tree: w.tree(128, nil)})},
+		{"Size64", w.tree(128, nil)},
+	}
+
+	// Lay out the blocks after the header
+	var buf bytes.Buffer
+	buf.Write(make([]byte, bomHeaderSize))
+	pointers := []bomPointer{{}}
+	for _, b := range w.blocks {
+		pointers = append(pointers, bomPointer{
+			Address: uint32(buf.Len()),
+			Length:  uint32(len(b)),
+		})
+		buf.Write(b)
+	}
+
+	varsOffset := buf.Len()
+	binary.Write(&buf, binary.BigEndian, uint32(len(vars)))
+	for _, v := range vars {
+		binary.Write(&buf, binary.BigEndian, v.Index)
+		buf.WriteByte(uint8(len(v.Name)))
+		buf.WriteString(v.Name)
+	}
+
+	// The index is the block table followed by an empty free list
+	indexOffset := buf.Len()
+	binary.Write(&buf, binary.BigEndian, uint32(len(pointers)))
+	binary.Write(&buf, binary.BigEndian, pointers)
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	binary.Write(&buf, binary.BigEndian, make([]bomPointer, 2))
+
+	result := buf.Bytes()
+	var header bytes.Buffer
+	binary.Write(&header, binary.BigEndian, bomHeader{
+		Magic:          [8]byte{'B', 'O', 'M', 'S', 't', 'o', 'r', 'e'},
+		Version:        1,
+		NumberOfBlocks: uint32(len(w.blocks)),
+		IndexOffset:    uint32(indexOffset),
+		IndexLength:    uint32(len(result) - indexOffset),
+		VarsOffset:     uint32(varsOffset),
+		VarsLength:     uint32(indexOffset - varsOffset),
+	})
+	copy(result, header.Bytes())
+
+	return result
+}
+
+// cksumTable is the CRC table of cksum, which uses the CRC-32 polynomial
+// but isn't reflected like the IEEE checksum in hash/crc32.
+var cksumTable = func() [256]uint32 {
+	var table [256]uint32
+	for idx := range table {
+		c := uint32(idx) << 24
+		for bit := 0; bit < 8; bit++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		table[idx] = c
+	}
+
+	return table
+}()
+
+// cksum returns the POSIX cksum checksum of data, which is the checksum
+// stored in a Bom.
+func cksum(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = crc<<8 ^ cksumTable[byte(crc>>24)^b]
+	}
+	for n := len(data); n > 0; n >>= 8 {
+		crc = crc<<8 ^ cksumTable[byte(crc>>24)^byte(n)]
+	}
+
+	return ^crc
+}
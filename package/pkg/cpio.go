@@ -0,0 +1,187 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Unix file types of st_mode, which is what cpio and the Bom store.
+const (
+	modeDir     = 0040000
+	modeRegular = 0100000
+	modeSymlink = 0120000
+)
+
+// entry is a file, directory, or symlink in the payload of a package.
+type entry struct {
+	// Path is the slash-separated path relative to the package root.
+	// The root itself is ".".
+	Path string
+
+	Mode    os.FileMode
+	ModTime time.Time
+
+	// Data is the contents of a file or the target of a symlink.
+	Data []byte
+}
+
+// unixMode returns the st_mode of the entry.
+func (e *entry) unixMode() uint32 {
+	mode := uint32(e.Mode.Perm())
+	switch {
+	case e.Mode.IsDir():
+		mode |= modeDir
+	case e.Mode&os.ModeSymlink != 0:
+		mode |= modeSymlink
+	default:
+		mode |= modeRegular
+	}
+
+	return mode
+}
+
+// collectEntries returns the entries of a payload from the root directory
+// and the files, which are added to the root. Files replace entries of the
+// same path in root. Parents are always ordered before their children.
+func collectEntries(root string, files []string) ([]*entry, error) {
+	entries := map[string]*entry{
+		".": {Path: ".", Mode: os.ModeDir | 0755, ModTime: time.Now()},
+	}
+
+	if root != "" {
+		if err := walkEntries(entries, root, "."); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range files {
+		if err := walkEntries(entries, f, filepath.Base(f)); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]*entry, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return lessPath(result[i].Path, result[j].Path)
+	})
+
+	return result, nil
+}
+
+// walkEntries adds the file or directory tree at src to entries as dst.
+func walkEntries(entries map[string]*entry, src, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		name := path.Join(dst, filepath.ToSlash(rel))
+		if name == "." {
+			// The root is the install location, which always keeps the
+			// default permissions.
+			if !info.IsDir() {
+				return fmt.Errorf("%s: the package root must be a directory", p)
+			}
+
+			return nil
+		}
+
+		e := &entry{
+			Path:    name,
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+		}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			e.Data = []byte(target)
+
+		case info.Mode().IsRegular():
+			e.Data, err = ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+
+		case !info.IsDir():
+			return fmt.Errorf("%s: only files, directories, and symlinks can be packaged", p)
+		}
+
+		entries[name] = e
+		return nil
+	})
+}
+
+// lessPath orders paths by their components so that a directory is
+// always followed by its children.
+func lessPath(a, b string) bool {
+	if a == "." || b == "." {
+		return a == "." && b != "."
+	}
+
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for idx := 0; idx < len(as) && idx < len(bs); idx++ {
+		if as[idx] != bs[idx] {
+			return as[idx] < bs[idx]
+		}
+	}
+
+	return len(as) < len(bs)
+}
+
+// writeCPIO writes the entries as a gzip-compressed cpio archive in the
+// portable ("odc") format, which is the format of the Payload and Scripts
+// of a package.
+func writeCPIO(w io.Writer, entries []*entry) error {
+	zw := gzip.NewWriter(w)
+
+	for idx, e := range entries {
+		name := "."
+		if e.Path != "." {
+			name = "./" + e.Path
+		}
+
+		nlink := 1
+		if e.Mode.IsDir() {
+			nlink = 2
+		}
+
+		err := writeCPIOHeader(zw, idx+1, e.unixMode(), nlink, e.ModTime.Unix(), name, len(e.Data))
+		if err != nil {
+			return err
+		}
+		if _, err := zw.Write(e.Data); err != nil {
+			return err
+		}
+	}
+
+	if err := writeCPIOHeader(zw, 0, 0, 1, 0, "TRAILER!!!", 0); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// writeCPIOHeader writes the header and name of a cpio entry. Files are
+// always owned by root.
+func writeCPIOHeader(w io.Writer, ino int, mode uint32, nlink int, mtime int64, name string, size int) error {
+	_, err := fmt.Fprintf(w, "070707%06o%06o%06o%06o%06o%06o%06o%011o%06o%011o%s\x00",
+		0, ino, mode, 0, 0, nlink, 0, mtime, len(name)+1, size, name)
+	return err
+}
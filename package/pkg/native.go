@@ -0,0 +1,244 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/mitchellh/gon/internal/cms"
+	"github.com/mitchellh/gon/internal/xar"
+)
+
+// pkgInfo is the PackageInfo of a component package.
+type pkgInfo struct {
+	XMLName          xml.Name        `xml:"pkg-info"`
+	FormatVersion    int             `xml:"format-version,attr"`
+	Identifier       string          `xml:"identifier,attr"`
+	Version          string          `xml:"version,attr"`
+	InstallLocation  string          `xml:"install-location,attr"`
+	Auth             string          `xml:"auth,attr"`
+	GeneratorVersion string          `xml:"generator-version,attr"`
+	Payload          pkgInfoPayload  `xml:"payload"`
+	Scripts          *pkgInfoScripts `xml:"scripts,omitempty"`
+}
+
+type pkgInfoPayload struct {
+	NumberOfFiles int   `xml:"numberOfFiles,attr"`
+	InstallKBytes int64 `xml:"installKBytes,attr"`
+}
+
+type pkgInfoScripts struct {
+	Preinstall  *pkgInfoScript `xml:"preinstall,omitempty"`
+	Postinstall *pkgInfoScript `xml:"postinstall,omitempty"`
+}
+
+type pkgInfoScript struct {
+	File string `xml:"file,attr"`
+}
+
+// distribution is the Distribution of a product archive with a single
+// component package that is always installed.
+type distribution struct {
+	XMLName        xml.Name             `xml:"installer-gui-script"`
+	MinSpecVersion int                  `xml:"minSpecVersion,attr"`
+	Options        distributionOptions  `xml:"options"`
+	Outline        distributionLine     `xml:"choices-outline>line"`
+	Choices        []distributionChoice `xml:"choice"`
+	PkgRefs        []distributionPkgRef `xml:"pkg-ref"`
+}
+
+type distributionOptions struct {
+	Customize         string `xml:"customize,attr"`
+	RequireScripts    bool   `xml:"require-scripts,attr"`
+	HostArchitectures string `xml:"hostArchitectures,attr"`
+}
+
+type distributionLine struct {
+	Choice string             `xml:"choice,attr"`
+	Lines  []distributionLine `xml:"line"`
+}
+
+type distributionChoice struct {
+	ID      string               `xml:"id,attr"`
+	Visible *bool                `xml:"visible,attr"`
+	PkgRefs []distributionPkgRef `xml:"pkg-ref"`
+}
+
+type distributionPkgRef struct {
+	ID            string `xml:"id,attr"`
+	Version       string `xml:"version,attr,omitempty"`
+	InstallKBytes int64  `xml:"installKBytes,attr,omitempty"`
+	OnConclusion  string `xml:"onConclusion,attr,omitempty"`
+	URL           string `xml:",chardata"`
+}
+
+// native creates the package in pure Go instead of with pkgbuild and
+// productbuild, signing it with opts.Certificate if set.
+func native(opts *Options, logger hclog.Logger) error {
+	logger.Info("building package natively", "output_path", opts.OutputPath)
+
+	entries, err := collectEntries(opts.Root, opts.Files)
+	if err != nil {
+		return err
+	}
+
+	version := opts.Version
+	if version == "" {
+		version = "0"
+	}
+	installLocation := opts.InstallLocation
+	if installLocation == "" {
+		installLocation = "/"
+	}
+
+	var kbytes int64
+	for _, e := range entries {
+		if e.Mode.IsRegular() {
+			kbytes += (int64(len(e.Data)) + 1023) / 1024
+		}
+	}
+
+	info := pkgInfo{
+		FormatVersion:    2,
+		Identifier:       opts.Identifier,
+		Version:          version,
+		InstallLocation:  installLocation,
+		Auth:             "root",
+		GeneratorVersion: "gon",
+		Payload: pkgInfoPayload{
+			NumberOfFiles: len(entries),
+			InstallKBytes: kbytes,
+		},
+	}
+
+	var payload bytes.Buffer
+	if err := writeCPIO(&payload, entries); err != nil {
+		return err
+	}
+	component := []xar.File{
+		{Name: "Bom", Data: writeBom(entries)},
+		{Name: "Payload", Data: payload.Bytes()},
+	}
+
+	if opts.Scripts != "" {
+		scripts, err := collectEntries(opts.Scripts, nil)
+		if err != nil {
+			return err
+		}
+
+		info.Scripts = &pkgInfoScripts{}
+		for _, e := range scripts {
+			switch e.Path {
+			case "preinstall":
+				info.Scripts.Preinstall = &pkgInfoScript{File: "./preinstall"}
+			case "postinstall":
+				info.Scripts.Postinstall = &pkgInfoScript{File: "./postinstall"}
+			default:
+				continue
+			}
+
+			if e.Mode&0111 == 0 {
+				return fmt.Errorf("%s: script must be executable",
+					filepath.Join(opts.Scripts, e.Path))
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := writeCPIO(&buf, scripts); err != nil {
+			return err
+		}
+		component = append(component, xar.File{Name: "Scripts", Data: buf.Bytes()})
+	}
+
+	data, err := marshalXML(info)
+	if err != nil {
+		return err
+	}
+	component = append(component, xar.File{Name: "PackageInfo", Data: data})
+
+	// A product archive has the component package as a directory next
+	// to the distribution.
+	files := component
+	if opts.Product {
+		name := filepath.Base(opts.OutputPath)
+		if !strings.HasSuffix(name, ".pkg") {
+			name += ".pkg"
+		}
+
+		visible := false
+		ref := distributionPkgRef{ID: opts.Identifier}
+		data, err := marshalXML(distribution{
+			MinSpecVersion: 2,
+			Options: distributionOptions{
+				Customize:         "never",
+				HostArchitectures: "x86_64,arm64",
+			},
+			Outline: distributionLine{
+				Choice: "default",
+				Lines:  []distributionLine{{Choice: opts.Identifier}},
+			},
+			Choices: []distributionChoice{
+				{ID: "default"},
+				{ID: opts.Identifier, Visible: &visible, PkgRefs: []distributionPkgRef{ref}},
+			},
+			PkgRefs: []distributionPkgRef{
+				ref,
+				{
+					ID:            opts.Identifier,
+					Version:       version,
+					InstallKBytes: kbytes,
+					OnConclusion:  "none",
+					URL:           "#" + url.PathEscape(name),
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		files = []xar.File{{Name: "Distribution", Data: data}}
+		for _, f := range component {
+			f.Name = name + "/" + f.Name
+			files = append(files, f)
+		}
+	}
+
+	var writeOpts xar.WriteOptions
+	if c := opts.Certificate; c != nil {
+		writeOpts.Signer = &cms.Signer{
+			Certificate: c.Certificate,
+			Chain:       c.Chain,
+			Key:         c.Key,
+		}
+		logger.Info("signing package", "subject", c.Certificate.Subject.CommonName)
+
+		if u := opts.TimestampURL; u != "" {
+			writeOpts.Timestamp = func(sig []byte) ([]byte, error) {
+				return cms.RequestTimestamp(u, sig)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	if err := xar.Write(&out, files, &writeOpts); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(opts.OutputPath, out.Bytes(), 0644)
+}
+
+// marshalXML returns the XML document of v.
+func marshalXML(v interface{}) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
@@ -0,0 +1,316 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitchellh/gon/internal/machotest"
+	"github.com/mitchellh/gon/internal/xar"
+	"github.com/mitchellh/gon/sign"
+)
+
+func TestPkg_native(t *testing.T) {
+	td := testTree(t)
+	defer os.RemoveAll(td)
+
+	output := filepath.Join(td, "foo.pkg")
+	require.NoError(t, Pkg(context.Background(), &Options{
+		Root:            filepath.Join(td, "root"),
+		Files:           []string{filepath.Join(td, "extra")},
+		OutputPath:      output,
+		Identifier:      "com.example.foo",
+		Version:         "1.2.3",
+		InstallLocation: "/usr/local",
+		Scripts:         filepath.Join(td, "scripts"),
+		Native:          true,
+		Logger:          hclog.L(),
+	}))
+
+	archive := readPkg(t, output)
+	require.Nil(t, archive.File("Distribution"))
+	require.Error(t, archive.Verify())
+
+	// The payload has every file with its contents
+	payload := readCPIO(t, archive.File("Payload").Data)
+	require.Equal(t, []string{
+		".",
+		"./bin",
+		"./bin/foo",
+		"./bin/link",
+		"./extra",
+		"./share",
+		"./share/readme",
+	}, payload.Names)
+	require.Equal(t, "foo", payload.Data["./bin/foo"])
+	require.Equal(t, "foo", payload.Data["./bin/link"])
+	require.Equal(t, "extra", payload.Data["./extra"])
+	require.Equal(t, uint32(0100755), payload.Modes["./bin/foo"])
+	require.Equal(t, uint32(modeSymlink), payload.Modes["./bin/link"]&0170000)
+	require.Equal(t, uint32(modeDir|0755), payload.Modes["./share"])
+
+	// The Bom lists the same paths
+	require.Equal(t, payload.Names, readBomPaths(t, archive.File("Bom").Data))
+
+	scripts := readCPIO(t, archive.File("Scripts").Data)
+	require.Equal(t, []string{".", "./postinstall"}, scripts.Names)
+
+	var info pkgInfo
+	require.NoError(t, xml.Unmarshal(archive.File("PackageInfo").Data, &info))
+	require.Equal(t, "com.example.foo", info.Identifier)
+	require.Equal(t, "1.2.3", info.Version)
+	require.Equal(t, "/usr/local", info.InstallLocation)
+	require.Equal(t, len(payload.Names), info.Payload.NumberOfFiles)
+	require.Nil(t, info.Scripts.Preinstall)
+	require.Equal(t, "./postinstall", info.Scripts.Postinstall.File)
+}
+
+func TestPkg_nativeProduct(t *testing.T) {
+	td := testTree(t)
+	defer os.RemoveAll(td)
+
+	identity := machotest.InstallerIdentity(t)
+	output := filepath.Join(td, "foo bar.pkg")
+	require.NoError(t, Pkg(context.Background(), &Options{
+		Files:      []string{filepath.Join(td, "extra")},
+		OutputPath: output,
+		Identifier: "com.example.foo",
+		Product:    true,
+		Native:     true,
+		Certificate: &sign.Certificate{
+			Certificate: identity.Certificate,
+			Chain:       identity.Chain,
+			Key:         identity.Key,
+		},
+		Logger: hclog.L(),
+	}))
+
+	archive := readPkg(t, output)
+	require.NoError(t, archive.Verify())
+	require.Equal(t, identity.Certificate.Raw, archive.Certificates[0].Raw)
+
+	var dist distribution
+	require.NoError(t, xml.Unmarshal(archive.File("Distribution").Data, &dist))
+	require.Equal(t, "#foo%20bar.pkg", dist.PkgRefs[1].URL)
+	require.Equal(t, "0", dist.PkgRefs[1].Version)
+	require.Equal(t, "com.example.foo", dist.Outline.Lines[0].Choice)
+
+	payload := readCPIO(t, archive.File("foo bar.pkg/Payload").Data)
+	require.Equal(t, []string{".", "./extra"}, payload.Names)
+	require.NotNil(t, archive.File("foo bar.pkg/Bom"))
+	require.NotNil(t, archive.File("foo bar.pkg/PackageInfo"))
+	require.Nil(t, archive.File("foo bar.pkg/Scripts"))
+}
+
+func TestPkg_nativeErrors(t *testing.T) {
+	td := testTree(t)
+	defer os.RemoveAll(td)
+
+	// Scripts must be executable
+	scripts := filepath.Join(td, "scripts")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(scripts, "preinstall"), nil, 0644))
+	err := Pkg(context.Background(), &Options{
+		Files:      []string{filepath.Join(td, "extra")},
+		OutputPath: filepath.Join(td, "foo.pkg"),
+		Identifier: "com.example.foo",
+		Scripts:    scripts,
+		Native:     true,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "executable")
+
+	// Only RSA keys can sign packages
+	identity := machotest.Identity(t)
+	err = Pkg(context.Background(), &Options{
+		Files:      []string{filepath.Join(td, "extra")},
+		OutputPath: filepath.Join(td, "foo.pkg"),
+		Identifier: "com.example.foo",
+		Native:     true,
+		Certificate: &sign.Certificate{
+			Certificate: identity.Certificate,
+			Key:         identity.Key,
+		},
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "RSA")
+}
+
+func TestWriteBom_leaves(t *testing.T) {
+	// Enough paths for multiple leaves in the paths tree
+	entries := []*entry{{Path: ".", Mode: os.ModeDir | 0755}}
+	expected := []string{"."}
+	for idx := 0; idx < bomLeafSize*2+10; idx++ {
+		name := "file" + strconv.Itoa(idx)
+		entries = append(entries, &entry{Path: name, Mode: 0644, Data: []byte(name)})
+		expected = append(expected, "./"+name)
+	}
+
+	require.Equal(t, expected, readBomPaths(t, writeBom(entries)))
+}
+
+func TestCksum(t *testing.T) {
+	require.Equal(t, uint32(4294967295), cksum(nil))
+	require.Equal(t, uint32(930766865), cksum([]byte("123456789")))
+}
+
+// testTree creates a directory with a package root, an extra file,
+// and a scripts directory. The caller must remove the directory.
+func testTree(t *testing.T) string {
+	t.Helper()
+
+	td, err := ioutil.TempDir("", "gon-pkg")
+	require.NoError(t, err)
+
+	files := map[string]string{
+		"root/bin/foo":        "foo",
+		"root/share/readme":   "readme",
+		"extra":               "extra",
+		"scripts/postinstall": "#!/bin/sh\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(td, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0755))
+	}
+	require.NoError(t, os.Chmod(filepath.Join(td, "root", "share", "readme"), 0644))
+	require.NoError(t, os.Symlink("foo", filepath.Join(td, "root", "bin", "link")))
+
+	return td
+}
+
+// readPkg reads the package at path.
+func readPkg(t *testing.T, path string) *xar.Archive {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	archive, err := xar.Read(data)
+	require.NoError(t, err)
+	return archive
+}
+
+// cpioArchive are the contents of a cpio archive.
+type cpioArchive struct {
+	Names []string
+	Modes map[string]uint32
+
+	// Data are the contents of files. Symlinks are resolved within
+	// the archive.
+	Data map[string]string
+}
+
+// readCPIO reads a gzip-compressed cpio archive in the "odc" format.
+func readCPIO(t *testing.T, data []byte) *cpioArchive {
+	t.Helper()
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	raw, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+
+	result := &cpioArchive{Modes: map[string]uint32{}, Data: map[string]string{}}
+	links := map[string]string{}
+	field := func(start, size int) int {
+		v, err := strconv.ParseUint(string(raw[start:start+size]), 8, 64)
+		require.NoError(t, err)
+		return int(v)
+	}
+	for {
+		require.True(t, len(raw) >= 76)
+		require.Equal(t, "070707", string(raw[:6]))
+		mode := field(18, 6)
+		nameSize := field(59, 6)
+		size := field(65, 11)
+		name := string(raw[76 : 76+nameSize-1])
+		contents := string(raw[76+nameSize : 76+nameSize+size])
+		raw = raw[76+nameSize+size:]
+		if name == "TRAILER!!!" {
+			break
+		}
+
+		result.Names = append(result.Names, name)
+		result.Modes[name] = uint32(mode)
+		switch mode & 0170000 {
+		case modeRegular:
+			result.Data[name] = contents
+		case modeSymlink:
+			links[name] = contents
+		}
+	}
+	for name, target := range links {
+		dir := name[:strings.LastIndex(name, "/")+1]
+		result.Data[name] = result.Data[dir+target]
+	}
+
+	return result
+}
+
+// readBomPaths returns the paths listed in a Bom in the order of the
+// paths tree, in the same form as the names in the payload.
+func readBomPaths(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	require.Equal(t, "BOMStore", string(data[:8]))
+	u32 := func(b []byte) uint32 { return binary.BigEndian.Uint32(b) }
+
+	index := data[u32(data[16:]):]
+	block := func(idx uint32) []byte {
+		p := index[4+8*idx:]
+		return data[u32(p) : u32(p)+u32(p[4:])]
+	}
+
+	vars := map[string]uint32{}
+	v := data[u32(data[24:]):]
+	for count := u32(v); count > 0; count-- {
+		n := int(v[8])
+		vars[string(v[9:9+n])] = u32(v[4:])
+		v = v[5+n:]
+	}
+
+	tree := block(vars["Paths"])
+	require.Equal(t, "tree", string(tree[:4]))
+	paths := block(u32(tree[8:]))
+	for binary.BigEndian.Uint16(paths) == 0 {
+		paths = block(u32(paths[12:]))
+	}
+
+	names := map[uint32]string{}
+	var result []string
+	for {
+		count := int(binary.BigEndian.Uint16(paths[2:]))
+		for idx := 0; idx < count; idx++ {
+			entry := paths[12+8*idx:]
+			id := u32(block(u32(entry)))
+			file := block(u32(entry[4:]))
+			parent := u32(file)
+			name := strings.TrimRight(string(file[4:]), "\x00")
+			if parent != 0 {
+				name = names[parent] + "/" + name
+			} else {
+				name = "."
+			}
+
+			names[id] = name
+			result = append(result, name)
+		}
+
+		forward := u32(paths[4:])
+		if forward == 0 {
+			break
+		}
+		paths = block(forward)
+	}
+
+	return result
+}
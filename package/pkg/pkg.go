@@ -0,0 +1,227 @@
+// Package pkg creates flat installer packages (.pkg) for notarization.
+//
+// This works by subprocessing to "pkgbuild" to build a component package
+// and optionally "productbuild" to wrap it in a product archive with a
+// distribution. Both are only available on macOS. The resulting package
+// is unsigned; sign it with sign.SignInstaller.
+//
+// With Options.Native, the package is instead written in pure Go, so
+// packages can be built on any OS. Native packages are signed while they
+// are written if Options.Certificate is set.
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/mitchellh/gon/sign"
+)
+
+// Options are the options for creating the installer package.
+type Options struct {
+	// Files is a list of files to install. These are copied into the root
+	// of the package, so they're installed directly in InstallLocation.
+	//
+	// If both Files and Root are set, the files are added to a copy of Root.
+	Files []string
+
+	// Root is the directory to use as the root of the package. This can
+	// be set to install a whole directory tree.
+	Root string
+
+	// OutputPath is the path where the package will be written. The directory
+	// containing this path must already exist. If a file already exist here
+	// it will be overwritten.
+	OutputPath string
+
+	// Identifier is the unique identifier of the package, such as
+	// "com.example.terraform". This is required.
+	Identifier string
+
+	// Version is the version of the package. If this is empty, pkgbuild
+	// uses a version of 0.
+	Version string
+
+	// InstallLocation is the directory the package installs into, such as
+	// "/usr/local/bin". If this is empty, the package installs into "/".
+	InstallLocation string
+
+	// Scripts is an (optional) directory with "preinstall" and
+	// "postinstall" scripts to run during installation.
+	Scripts string
+
+	// Product, if true, wraps the component package in a product archive
+	// with a distribution created by productbuild. Product archives are
+	// required for some deployment methods, such as the Mac App Store and
+	// some MDM solutions.
+	Product bool
+
+	// Native, if true, builds the package in pure Go instead of with
+	// pkgbuild and productbuild. This works on any OS.
+	Native bool
+
+	// Certificate, if set, is the "Developer ID Installer" certificate to
+	// sign the package with when building natively. This must have an
+	// RSA key.
+	Certificate *sign.Certificate
+
+	// TimestampURL is the URL of the RFC 3161 timestamp server used to
+	// timestamp the signature of a native package, such as
+	// sign.AppleTimestampURL. If this is empty the signature has no secure
+	// timestamp.
+	TimestampURL string
+
+	// Logger is the logger to use. If this is nil then no logging will be done.
+	Logger hclog.Logger
+
+	// BaseCmd is the base command for executing pkgbuild and productbuild.
+	// This is used for tests to overwrite where the binaries are.
+	BaseCmd *exec.Cmd
+}
+
+// Pkg creates an installer package for notarization using the options given.
+func Pkg(ctx context.Context, opts *Options) error {
+	logger := opts.Logger
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+
+	if opts.Identifier == "" {
+		return fmt.Errorf("an identifier is required to create a package")
+	}
+
+	if opts.Native {
+		if err := native(opts, logger); err != nil {
+			return err
+		}
+
+		logger.Info("pkg creation complete", "output_path", opts.OutputPath)
+		return nil
+	}
+	if opts.Certificate != nil {
+		return fmt.Errorf("a certificate can only be used to build a package natively")
+	}
+
+	td, err := ioutil.TempDir("", "gon-pkg")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(td)
+
+	// Setup our root directory with the given files.
+	root := opts.Root
+	if root == "" || len(opts.Files) > 0 {
+		root = filepath.Join(td, "root")
+		if err := createRoot(ctx, logger, opts, root); err != nil {
+			return err
+		}
+	}
+
+	// Build the component package. If we're building a product archive,
+	// the component package is only an intermediate file.
+	component := opts.OutputPath
+	if opts.Product {
+		component = filepath.Join(td, filepath.Base(opts.OutputPath))
+	}
+
+	args := []string{
+		"--root", root,
+		"--identifier", opts.Identifier,
+	}
+	if opts.Version != "" {
+		args = append(args, "--version", opts.Version)
+	}
+	if opts.InstallLocation != "" {
+		args = append(args, "--install-location", opts.InstallLocation)
+	}
+	if opts.Scripts != "" {
+		args = append(args, "--scripts", opts.Scripts)
+	}
+	args = append(args, component)
+	if err := run(ctx, logger, opts.BaseCmd, "pkgbuild", args...); err != nil {
+		return fmt.Errorf("error creating component package:\n\n%s", err)
+	}
+
+	if opts.Product {
+		err := run(ctx, logger, opts.BaseCmd, "productbuild",
+			"--package", component, opts.OutputPath)
+		if err != nil {
+			return fmt.Errorf("error creating product archive:\n\n%s", err)
+		}
+	}
+
+	logger.Info("pkg creation complete", "output_path", opts.OutputPath)
+	return nil
+}
+
+// createRoot populates the directory root with the contents of Root and
+// the files in Files.
+func createRoot(ctx context.Context, logger hclog.Logger, opts *Options, root string) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+
+	// ditto copies the contents of a directory into the destination
+	if opts.Root != "" {
+		if err := run(ctx, logger, nil, "ditto", opts.Root, root); err != nil {
+			return fmt.Errorf("error copying %s to the package root:\n\n%s", opts.Root, err)
+		}
+	}
+
+	for _, f := range opts.Files {
+		dst := filepath.Join(root, filepath.Base(f))
+		if err := run(ctx, logger, nil, "ditto", f, dst); err != nil {
+			return fmt.Errorf("error copying %s to the package root:\n\n%s", f, err)
+		}
+	}
+
+	return nil
+}
+
+// run executes the named binary with the given arguments. If it fails,
+// the error contains the output.
+func run(ctx context.Context, logger hclog.Logger, base *exec.Cmd, name string, args ...string) error {
+	// Build our command
+	var cmd exec.Cmd
+	if base != nil {
+		cmd = *base
+	}
+
+	// We only set the path if it isn't set. This lets the options set the
+	// path to the binary that we use.
+	if cmd.Path == "" {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return err
+		}
+		cmd.Path = path
+	}
+
+	cmd.Args = append([]string{name}, args...)
+
+	// We store all output in out for logging and in case there is an error
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = cmd.Stdout
+
+	// Log what we're going to execute
+	logger.Info("executing "+name,
+		"command_path", cmd.Path,
+		"command_args", cmd.Args,
+	)
+
+	// Execute
+	if err := cmd.Run(); err != nil {
+		logger.Error("error executing "+name, "err", err, "output", out.String())
+		return fmt.Errorf("%s\n\n%s", err, out.String())
+	}
+
+	return nil
+}
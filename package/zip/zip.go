@@ -4,10 +4,13 @@ package zip
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/hashicorp/go-hclog"
 )
@@ -17,6 +20,10 @@ type Options struct {
 	// Files to add to the zip package.
 	Files []string
 
+	// ExtraFiles are additional files to put at chosen paths within the
+	// zip, such as a README or man pages.
+	ExtraFiles []File
+
 	// OutputPath is the path where the zip file will be written. The directory
 	// containing this path must already exist. If a file already exist here
 	// it will be overwritten.
@@ -30,6 +37,19 @@ type Options struct {
 	BaseCmd *exec.Cmd
 }
 
+// File is a file or directory to add to the zip at a chosen path.
+type File struct {
+	// Src is the path to the file or directory to add.
+	Src string
+
+	// Dst is the slash-separated path within the zip, such as
+	// "share/man/man1/foo.1". Parent directories are created as needed.
+	Dst string
+
+	// Mode, if non-zero, sets the permissions of Dst.
+	Mode os.FileMode
+}
+
 // Zip creates a zip archive for notarization using the options given.
 //
 // For now this works by subprocessing to "ditto" which is the recommended
@@ -42,6 +62,10 @@ func Zip(ctx context.Context, opts *Options) error {
 		logger = hclog.NewNullLogger()
 	}
 
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
 	// Setup our root directory with the given files.
 	root, err := createRoot(ctx, logger, opts)
 	if err != nil {
@@ -115,29 +139,139 @@ func dittoCmd(ctx context.Context, base *exec.Cmd) (*exec.Cmd, error) {
 // createRoot creates a root directory we can use `ditto` that contains all
 // the given Files as input. This lets us support multiple files.
 //
+// Each file is copied into the root under its base name. ditto merges the
+// contents of a directory into the destination, so copying the directories
+// into the root directly would drop the bundle directory of an app.
+//
 // If the returned directory value is non-empty, you must defer to remove
 // the directory since it is meant to be a temporary directory.
 //
 // The directory is guaranteed to be empty if error is non-nil.
 func createRoot(ctx context.Context, logger hclog.Logger, opts *Options) (string, error) {
-	// Build our copy command
-	cmd, err := dittoCmd(ctx, opts.BaseCmd)
+	// Create our root directory
+	root, err := ioutil.TempDir("", "gon-createzip")
 	if err != nil {
 		return "", err
 	}
 
-	// Create our root directory
-	root, err := ioutil.TempDir("", "gon-createzip")
+	// Copy the files and the extra files to their destinations
+	files := make([]File, 0, len(opts.Files)+len(opts.ExtraFiles))
+	for _, f := range opts.Files {
+		files = append(files, File{Src: f, Dst: filepath.Base(f)})
+	}
+	files = append(files, opts.ExtraFiles...)
+	for _, f := range files {
+		if err := addFile(ctx, logger, opts.BaseCmd, root, f); err != nil {
+			os.RemoveAll(root)
+			return "", err
+		}
+	}
+
+	return root, nil
+}
+
+// addFile copies the file f into the directory root at its destination.
+func addFile(ctx context.Context, logger hclog.Logger, base *exec.Cmd, root string, f File) error {
+	dst := filepath.Join(root, filepath.FromSlash(path.Clean(f.Dst)))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	cmd, err := dittoCmd(ctx, base)
 	if err != nil {
-		return "", err
+		return err
+	}
+	cmd.Args = []string{filepath.Base(cmd.Path), f.Src, dst}
+
+	// We store all output in out for logging and in case there is an error
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = cmd.Stdout
+
+	logger.Info("executing ditto to copy file for archiving",
+		"src", f.Src,
+		"dst", f.Dst,
+		"command_path", cmd.Path,
+		"command_args", cmd.Args,
+	)
+	if err := cmd.Run(); err != nil {
+		logger.Error("error copying file", "err", err, "output", out.String())
+		return fmt.Errorf("error copying %s to the zip archive:\n\n%s", f.Src, out.String())
+	}
+
+	if f.Mode != 0 {
+		return os.Chmod(dst, f.Mode)
+	}
+
+	return nil
+}
+
+// validate checks that the files have different names and that the extra
+// files are within the zip and don't overlap each other or the files in
+// Files, which would merge them.
+func (o *Options) validate() error {
+	paths := map[string]bool{}
+	for _, f := range o.Files {
+		name := filepath.Base(f)
+		if paths[name] {
+			return fmt.Errorf("more than one file named %q to add to the zip", name)
+		}
+		paths[name] = true
+	}
+	for _, f := range o.ExtraFiles {
+		dst := path.Clean(f.Dst)
+		if f.Src == "" || f.Dst == "" {
+			return fmt.Errorf("extra files require a source and a destination")
+		}
+		if path.IsAbs(dst) || dst == "." || dst == ".." || strings.HasPrefix(dst, "../") {
+			return fmt.Errorf("extra file destination %q must be a path within the zip", f.Dst)
+		}
+		if paths[dst] || paths[strings.SplitN(dst, "/", 2)[0]] {
+			return fmt.Errorf("extra file destination %q overlaps another file", f.Dst)
+		}
+		paths[dst] = true
+	}
+
+	return nil
+}
+
+// ExtractOptions are the options for extracting a zip archive.
+type ExtractOptions struct {
+	// Path is the path to the zip archive to extract.
+	Path string
+
+	// OutputDir is the directory to extract the archive into. This is
+	// created if it doesn't exist.
+	OutputDir string
+
+	// Logger is the logger to use. If this is nil then no logging will be done.
+	Logger hclog.Logger
+
+	// BaseCmd is the base command for executing the ditto binary. This is
+	// used for tests to overwrite where the ditto binary is.
+	BaseCmd *exec.Cmd
+}
+
+// Extract extracts a zip archive with "ditto" so that extended attributes
+// and symlinks within bundles are restored the same way they're archived.
+func Extract(ctx context.Context, opts *ExtractOptions) error {
+	logger := opts.Logger
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+
+	cmd, err := dittoCmd(ctx, opts.BaseCmd)
+	if err != nil {
+		return err
 	}
 
-	// Setup our args to copy our files into the root
 	cmd.Args = []string{
 		filepath.Base(cmd.Path),
+		"-x", // extract an archive
+		"-k", // the archive is a PKZip archive, not CPIO
+		opts.Path,
+		opts.OutputDir,
 	}
-	cmd.Args = append(cmd.Args, opts.Files...)
-	cmd.Args = append(cmd.Args, root)
 
 	// We store all output in out for logging and in case there is an error
 	var out bytes.Buffer
@@ -145,23 +279,19 @@ func createRoot(ctx context.Context, logger hclog.Logger, opts *Options) (string
 	cmd.Stderr = cmd.Stdout
 
 	// Log what we're going to execute
-	logger.Info("executing ditto to copy files for archiving",
-		"output_path", opts.OutputPath,
+	logger.Info("executing ditto for zip archive extraction",
+		"path", opts.Path,
+		"output_dir", opts.OutputDir,
 		"command_path", cmd.Path,
 		"command_args", cmd.Args,
 	)
 
-	// Execute copy
+	// Execute
 	if err = cmd.Run(); err != nil {
-		os.RemoveAll(root)
-
-		logger.Error(
-			"error copying source files to create zip archive",
-			"err", err,
-			"output", out.String(),
-		)
-		return "", err
+		logger.Error("error extracting zip archive", "err", err, "output", out.String())
+		return fmt.Errorf("error extracting %s:\n\n%s", opts.Path, out.String())
 	}
 
-	return root, nil
+	logger.Info("zip archive extraction complete", "output", out.String())
+	return nil
 }
@@ -0,0 +1,99 @@
+package zip
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitchellh/gon/internal/dittotest"
+)
+
+func TestMain(m *testing.M) {
+	dittotest.Main()
+	os.Exit(m.Run())
+}
+
+func TestZip_layout(t *testing.T) {
+	dittotest.Install(t)
+
+	td := t.TempDir()
+	for path, mode := range map[string]os.FileMode{
+		"Foo.app/Contents/Info.plist": 0644,
+		"Foo.app/Contents/MacOS/foo":  0755,
+		"bar":                         0755,
+		"foo.1":                       0644,
+	} {
+		path = filepath.Join(td, filepath.FromSlash(path))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte("hello"), mode))
+	}
+
+	output := filepath.Join(td, "foo.zip")
+	require.NoError(t, Zip(context.Background(), &Options{
+		Files: []string{
+			filepath.Join(td, "Foo.app"),
+			filepath.Join(td, "bar"),
+		},
+		ExtraFiles: []File{
+			{Src: filepath.Join(td, "foo.1"), Dst: "share/man/man1/foo.1"},
+		},
+		OutputPath: output,
+	}))
+
+	require.Equal(t, []string{
+		"Foo.app/",
+		"Foo.app/Contents/",
+		"Foo.app/Contents/Info.plist",
+		"Foo.app/Contents/MacOS/",
+		"Foo.app/Contents/MacOS/foo",
+		"bar",
+		"share/",
+		"share/man/",
+		"share/man/man1/",
+		"share/man/man1/foo.1",
+	}, dittotest.Entries(t, output))
+}
+
+func TestOptionsValidate(t *testing.T) {
+	cases := []struct {
+		Name string
+		Opts *Options
+		Err  string
+	}{
+		{
+			"same name",
+			&Options{Files: []string{"./a/foo", "./b/foo"}},
+			"more than one file named",
+		},
+
+		{
+			"extra file outside",
+			&Options{
+				Files:      []string{"./foo"},
+				ExtraFiles: []File{{Src: "./README", Dst: "../README"}},
+			},
+			"must be a path within the zip",
+		},
+
+		{
+			"extra file overlaps",
+			&Options{
+				Files:      []string{"./Foo.app"},
+				ExtraFiles: []File{{Src: "./README", Dst: "Foo.app/README"}},
+			},
+			"overlaps another file",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := tt.Opts.validate()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.Err)
+		})
+	}
+}
@@ -0,0 +1,362 @@
+// Package preflight checks files for common problems that cause Apple to
+// reject them during notarization, so that they can be caught before the
+// files are uploaded.
+//
+// The Mach-O binaries are inspected in pure Go using the codesig package.
+// Binaries inside zip files and app bundles are checked too. Binaries
+// inside disk images are only checked if `hdiutil` is available to
+// mount the image, which requires macOS.
+package preflight
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"debug/macho"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/mitchellh/gon/codesig"
+	"github.com/mitchellh/gon/internal/fat"
+	"github.com/mitchellh/gon/notarize"
+)
+
+// The severities of the issues found. These match the severities used
+// in the notarization log.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// minSDK is the oldest SDK that notarization accepts, 10.9.
+const minSDK = 0x000a0900
+
+// Options are the options for Check.
+type Options struct {
+	// Path is the path to the file to check. This may be a binary, an
+	// app bundle, a zip, or a dmg. Any other files aren't checked.
+	Path string
+
+	// Logger is the logger to use. If this is nil then no logging will be done.
+	Logger hclog.Logger
+
+	// BaseCmd is the base command for executing hdiutil to mount disk
+	// images. This is used for tests to overwrite where the binary is.
+	BaseCmd *exec.Cmd
+}
+
+// Check checks the file given in the options for problems that will cause
+// notarization to fail. The issues found are returned in the same format
+// as the notarization log. The paths of the issues are relative to the
+// directory containing the file, and paths within archives are joined to
+// the path of the archive, for example "foo.zip/foo".
+//
+// An error is only returned if the checks could not be performed. Use
+// Failed to determine if any of the issues will cause notarization to fail.
+func Check(ctx context.Context, opts *Options) ([]notarize.LogIssue, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+
+	fi, err := os.Stat(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(opts.Path)
+	switch ext := strings.ToLower(filepath.Ext(opts.Path)); {
+	case fi.IsDir():
+		return checkDir(opts.Path, name, logger)
+
+	case ext == ".zip":
+		return checkZip(opts.Path, name, logger)
+
+	case ext == ".dmg":
+		return checkDmg(ctx, opts, name, logger)
+
+	case ext == ".pkg":
+		logger.Info("skipping preflight checks for installer package", "path", opts.Path)
+		return nil, nil
+
+	default:
+		return checkFile(opts.Path, name, logger)
+	}
+}
+
+// Failed returns true if any of the issues is an error.
+func Failed(issues []notarize.LogIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkDir checks all the files within a directory, such as an app bundle.
+// Symlinks are not followed.
+func checkDir(root, name string, logger hclog.Logger) ([]notarize.LogIssue, error) {
+	var result []notarize.LogIssue
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		issues, err := checkFile(p, path.Join(name, filepath.ToSlash(rel)), logger)
+		if err != nil {
+			return err
+		}
+		result = append(result, issues...)
+		return nil
+	})
+
+	return result, err
+}
+
+// checkZip checks all the files within a zip archive.
+func checkZip(p, name string, logger hclog.Logger) ([]notarize.LogIssue, error) {
+	r, err := zip.OpenReader(p)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var result []notarize.LogIssue
+	for _, f := range r.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		data, err := readMachO(f.Open)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s in %s: %s", f.Name, p, err)
+		}
+		if data == nil {
+			continue
+		}
+
+		result = append(result, checkBinary(path.Join(name, f.Name), data, logger)...)
+	}
+
+	return result, nil
+}
+
+// checkDmg mounts the disk image and checks all the files within it.
+func checkDmg(ctx context.Context, opts *Options, name string, logger hclog.Logger) ([]notarize.LogIssue, error) {
+	cmd, err := command(opts.BaseCmd, "hdiutil")
+	if err != nil {
+		logger.Warn("hdiutil not found, can't check disk image contents", "path", opts.Path)
+		return []notarize.LogIssue{{
+			Severity: SeverityWarning,
+			Path:     name,
+			Message: "The contents of the disk image were not checked because " +
+				"hdiutil is not available.",
+		}}, nil
+	}
+
+	mountpoint, err := ioutil.TempDir("", "gon-preflight")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(mountpoint)
+
+	attach := *cmd
+	attach.Args = []string{
+		"hdiutil", "attach",
+		"-nobrowse",
+		"-readonly",
+		"-noautoopen",
+		"-mountpoint", mountpoint,
+		opts.Path,
+	}
+	if err := run(&attach, logger); err != nil {
+		return nil, fmt.Errorf("error mounting disk image: %s", err)
+	}
+	defer func() {
+		detach := *cmd
+		detach.Args = []string{"hdiutil", "detach", mountpoint, "-force"}
+		if err := run(&detach, logger); err != nil {
+			logger.Warn("error unmounting disk image", "err", err)
+		}
+	}()
+
+	return checkDir(mountpoint, name, logger)
+}
+
+// checkFile checks a single file. Files that aren't Mach-O binaries
+// are ignored.
+func checkFile(p, name string, logger hclog.Logger) ([]notarize.LogIssue, error) {
+	data, err := readMachO(func() (io.ReadCloser, error) { return os.Open(p) })
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	return checkBinary(name, data, logger), nil
+}
+
+// checkBinary checks a single Mach-O binary.
+func checkBinary(name string, data []byte, logger hclog.Logger) []notarize.LogIssue {
+	logger.Debug("checking binary", "path", name)
+
+	f, err := codesig.NewFile(data)
+	if err == codesig.ErrNotMachO {
+		return nil
+	}
+	if err != nil {
+		return []notarize.LogIssue{{
+			Severity: SeverityError,
+			Path:     name,
+			Message:  fmt.Sprintf("The signature of the binary is invalid: %s.", err),
+		}}
+	}
+
+	// Each architecture is checked, but most issues apply to all
+	// architectures so we only report each message once.
+	var result []notarize.LogIssue
+	seen := map[string]struct{}{}
+	for _, arch := range f.Arches {
+		for _, msg := range checkArch(arch) {
+			if _, ok := seen[msg]; ok {
+				continue
+			}
+			seen[msg] = struct{}{}
+
+			logger.Info("preflight issue", "path", name, "arch", arch.CPU, "message", msg)
+			result = append(result, notarize.LogIssue{
+				Severity: SeverityError,
+				Path:     name,
+				Message:  msg,
+			})
+		}
+	}
+
+	return result
+}
+
+// checkArch returns the messages for all the problems with a single
+// architecture. The messages match those used by the notary service.
+func checkArch(arch *codesig.Arch) []string {
+	sig := arch.Signature
+	if sig == nil {
+		return []string{"The binary is not signed."}
+	}
+
+	var result []string
+	if err := arch.Verify(); err != nil {
+		result = append(result, fmt.Sprintf("The signature of the binary is invalid: %s.", err))
+	}
+
+	if sig.AdHoc() || !strings.HasPrefix(sig.Identity(), "Developer ID Application:") {
+		result = append(result,
+			"The binary is not signed with a valid Developer ID certificate.")
+	}
+
+	if !sig.AdHoc() && sig.Timestamp.IsZero() {
+		result = append(result, "The signature does not include a secure timestamp.")
+	}
+
+	if arch.Type == macho.TypeExec && !sig.Runtime() {
+		result = append(result, "The executable does not have the hardened runtime enabled.")
+	}
+
+	if ents, err := sig.ParsedEntitlements(); err != nil {
+		result = append(result, fmt.Sprintf("The entitlements are invalid: %s.", err))
+	} else if v, ok := ents["com.apple.security.get-task-allow"].(bool); ok && v {
+		result = append(result,
+			"The executable requests the com.apple.security.get-task-allow entitlement.")
+	}
+
+	if arch.SDK != 0 && arch.SDK < minSDK {
+		result = append(result, "The binary uses an SDK older than the 10.9 SDK.")
+	}
+
+	return result
+}
+
+// readMachO reads the file opened by open if it is a Mach-O binary. The
+// result is nil if the file isn't a Mach-O binary. Only the first bytes
+// are read to determine this so large non-binary files are cheap to skip.
+func readMachO(open func() (io.ReadCloser, error)) ([]byte, error) {
+	r, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	if !fat.IsMachO(header) {
+		return nil, nil
+	}
+
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(header, rest...), nil
+}
+
+// run executes the command, returning an error with the output if it fails.
+func run(cmd *exec.Cmd, logger hclog.Logger) error {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = cmd.Stdout
+
+	logger.Info("executing hdiutil",
+		"command_path", cmd.Path,
+		"command_args", cmd.Args,
+	)
+
+	if err := cmd.Run(); err != nil {
+		logger.Error("error executing hdiutil", "err", err, "output", out.String())
+		return fmt.Errorf("%s\n\n%s", err, out.String())
+	}
+
+	return nil
+}
+
+// command returns the command to execute the given tool based on the
+// given base command. The arguments must still be set by the caller.
+func command(base *exec.Cmd, name string) (*exec.Cmd, error) {
+	var cmd exec.Cmd
+	if base != nil {
+		cmd = *base
+	}
+
+	// We only set the path if it isn't set. This lets the options set the
+	// path to the binary that we use.
+	if cmd.Path == "" {
+		p, err := exec.LookPath(name)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Path = p
+	}
+
+	return &cmd, nil
+}
@@ -0,0 +1,351 @@
+package preflight
+
+import (
+	"archive/zip"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitchellh/gon/internal/machotest"
+	"github.com/mitchellh/gon/notarize"
+)
+
+func TestMain(m *testing.M) {
+	// Set our default logger
+	logger := hclog.L()
+	logger.SetLevel(hclog.Trace)
+	hclog.SetDefault(logger)
+
+	// If we got a subcommand, run that
+	if v := os.Getenv(childEnv); v != "" && childCommands[v] != nil {
+		os.Exit(childCommands[v]())
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestCheck_binary(t *testing.T) {
+	identity := machotest.Identity(t)
+	timestamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// valid returns signing options that pass all the checks
+	valid := func() *machotest.Sign {
+		return &machotest.Sign{
+			Identifier: "com.example.foo",
+			TeamID:     "TEAMID1234",
+			Flags:      0x10000,
+			Signer:     identity,
+			Timestamp:  timestamp,
+		}
+	}
+
+	cases := []struct {
+		Name     string
+		Options  func() *machotest.Options
+		Messages []string
+	}{
+		{
+			"valid",
+			func() *machotest.Options {
+				return &machotest.Options{CPU: machotest.CPUAMD64, SDK: 0x000a0f00, Sign: valid()}
+			},
+			nil,
+		},
+
+		{
+			"unsigned",
+			func() *machotest.Options {
+				return &machotest.Options{CPU: machotest.CPUAMD64}
+			},
+			[]string{"The binary is not signed."},
+		},
+
+		{
+			"ad-hoc",
+			func() *machotest.Options {
+				sign := valid()
+				sign.Signer = nil
+				return &machotest.Options{CPU: machotest.CPUARM64, Sign: sign}
+			},
+			[]string{"The binary is not signed with a valid Developer ID certificate."},
+		},
+
+		{
+			"no runtime or timestamp",
+			func() *machotest.Options {
+				sign := valid()
+				sign.Flags = 0
+				sign.Timestamp = time.Time{}
+				return &machotest.Options{CPU: machotest.CPUAMD64, Sign: sign}
+			},
+			[]string{
+				"The signature does not include a secure timestamp.",
+				"The executable does not have the hardened runtime enabled.",
+			},
+		},
+
+		{
+			"dylib without runtime",
+			func() *machotest.Options {
+				sign := valid()
+				sign.Flags = 0
+				return &machotest.Options{CPU: machotest.CPUAMD64, Type: machotest.TypeDylib, Sign: sign}
+			},
+			nil,
+		},
+
+		{
+			"get-task-allow",
+			func() *machotest.Options {
+				sign := valid()
+				sign.Entitlements = []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>com.apple.security.get-task-allow</key>
+	<true/>
+</dict>
+</plist>`)
+				return &machotest.Options{CPU: machotest.CPUAMD64, Sign: sign}
+			},
+			[]string{"The executable requests the com.apple.security.get-task-allow entitlement."},
+		},
+
+		{
+			"old SDK",
+			func() *machotest.Options {
+				return &machotest.Options{CPU: machotest.CPUAMD64, SDK: 0x000a0800, Sign: valid()}
+			},
+			[]string{"The binary uses an SDK older than the 10.9 SDK."},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			td, err := ioutil.TempDir("", "gon")
+			require.NoError(t, err)
+			defer os.RemoveAll(td)
+
+			path := filepath.Join(td, "foo")
+			require.NoError(t, ioutil.WriteFile(path, machotest.Build(t, tt.Options()), 0755))
+
+			issues, err := Check(context.Background(), &Options{Path: path})
+			require.NoError(t, err)
+
+			var messages []string
+			for _, issue := range issues {
+				require.Equal(t, SeverityError, issue.Severity)
+				require.Equal(t, "foo", issue.Path)
+				messages = append(messages, issue.Message)
+			}
+			require.Equal(t, tt.Messages, messages)
+			require.Equal(t, len(tt.Messages) > 0, Failed(issues))
+		})
+	}
+}
+
+func TestCheck_universal(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	// Both architectures are unsigned but the issue is only reported once
+	path := filepath.Join(td, "foo")
+	require.NoError(t, ioutil.WriteFile(path, machotest.Fat(
+		machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64}),
+		machotest.Build(t, &machotest.Options{CPU: machotest.CPUARM64}),
+	), 0755))
+
+	issues, err := Check(context.Background(), &Options{Path: path})
+	require.NoError(t, err)
+	require.Equal(t, []notarize.LogIssue{{
+		Severity: SeverityError,
+		Path:     "foo",
+		Message:  "The binary is not signed.",
+	}}, issues)
+}
+
+func TestCheck_notBinary(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "foo.txt")
+	require.NoError(t, ioutil.WriteFile(path, []byte("hello"), 0644))
+
+	issues, err := Check(context.Background(), &Options{Path: path})
+	require.NoError(t, err)
+	require.Empty(t, issues)
+}
+
+func TestCheck_javaClass(t *testing.T) {
+	// Java class files start with the same magic as universal binaries
+	class, err := ioutil.ReadFile(filepath.Join("testdata", "Hello.class"))
+	require.NoError(t, err)
+
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "Hello.class")
+	require.NoError(t, ioutil.WriteFile(path, class, 0644))
+	issues, err := Check(context.Background(), &Options{Path: path})
+	require.NoError(t, err)
+	require.Empty(t, issues)
+
+	path = filepath.Join(td, "foo.zip")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	w := zip.NewWriter(f)
+	testZipFile(t, w, "Foo.app/Contents/Java/Hello.class", class)
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+
+	issues, err = Check(context.Background(), &Options{Path: path})
+	require.NoError(t, err)
+	require.Empty(t, issues)
+}
+
+func TestCheck_zip(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "foo.zip")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	w := zip.NewWriter(f)
+	testZipFile(t, w, "Foo.app/Contents/Info.plist", []byte("<plist/>"))
+	testZipFile(t, w, "Foo.app/Contents/MacOS/Foo",
+		machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64}))
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+
+	issues, err := Check(context.Background(), &Options{Path: path})
+	require.NoError(t, err)
+	require.Equal(t, []notarize.LogIssue{{
+		Severity: SeverityError,
+		Path:     "foo.zip/Foo.app/Contents/MacOS/Foo",
+		Message:  "The binary is not signed.",
+	}}, issues)
+}
+
+func TestCheck_dir(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	app := filepath.Join(td, "Foo.app")
+	require.NoError(t, os.MkdirAll(filepath.Join(app, "Contents", "MacOS"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(app, "Contents", "Info.plist"), []byte("<plist/>"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(app, "Contents", "MacOS", "Foo"),
+		machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64}), 0755))
+
+	issues, err := Check(context.Background(), &Options{Path: app})
+	require.NoError(t, err)
+	require.Equal(t, []notarize.LogIssue{{
+		Severity: SeverityError,
+		Path:     "Foo.app/Contents/MacOS/Foo",
+		Message:  "The binary is not signed.",
+	}}, issues)
+}
+
+func TestCheck_dmg(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	binary := filepath.Join(td, "binary")
+	require.NoError(t, ioutil.WriteFile(binary,
+		machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64}), 0755))
+
+	path := filepath.Join(td, "foo.dmg")
+	require.NoError(t, ioutil.WriteFile(path, []byte("dmg"), 0644))
+
+	cmd := childCmd(t, "hdiutil")
+	cmd.Env = append(cmd.Env, childBinaryEnv+"="+binary)
+	issues, err := Check(context.Background(), &Options{Path: path, BaseCmd: cmd})
+	require.NoError(t, err)
+	require.Equal(t, []notarize.LogIssue{{
+		Severity: SeverityError,
+		Path:     "foo.dmg/foo",
+		Message:  "The binary is not signed.",
+	}}, issues)
+}
+
+func testZipFile(t *testing.T, w *zip.Writer, name string, data []byte) {
+	t.Helper()
+
+	fw, err := w.Create(name)
+	require.NoError(t, err)
+	_, err = fw.Write(data)
+	require.NoError(t, err)
+}
+
+// childEnv is the env var that must be set to trigger a child command.
+const childEnv = "GON_TEST_CHILD"
+
+// childBinaryEnv is the env var with the path to the binary that the
+// hdiutil child command places in the mounted disk image.
+const childBinaryEnv = "GON_TEST_BINARY"
+
+// childCommands is the list of commands we support
+var childCommands = map[string]func() int{
+	"hdiutil": testCmdHdiutil,
+}
+
+// childCmd is used to create a command that executes a command in the
+// childCommands map in a new process.
+func childCmd(t *testing.T, name string, args ...string) *exec.Cmd {
+	t.Helper()
+
+	// Get the path to our executable
+	selfPath, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		t.Fatalf("error creating child command: %s", err)
+		return nil
+	}
+
+	cmd := exec.Command(selfPath, args...)
+	cmd.Env = os.Environ()
+	cmd.Env = append(cmd.Env, childEnv+"="+name)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd
+}
+
+// testCmdHdiutil fakes hdiutil. Attaching copies the binary from the
+// environment into the mountpoint.
+func testCmdHdiutil() int {
+	args := os.Args[1:]
+	if len(args) == 0 || args[0] != "attach" {
+		return 0
+	}
+
+	var mountpoint string
+	for i, arg := range args {
+		if arg == "-mountpoint" && i+1 < len(args) {
+			mountpoint = args[i+1]
+		}
+	}
+	if mountpoint == "" {
+		return 1
+	}
+
+	data, err := ioutil.ReadFile(os.Getenv(childBinaryEnv))
+	if err != nil {
+		return 1
+	}
+	if err := ioutil.WriteFile(filepath.Join(mountpoint, "foo"), data, 0755); err != nil {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,165 @@
+package sign
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"howett.net/plist"
+)
+
+// Entitlements is a set of entitlements to sign with. The keys are the
+// entitlement names and the values may be any value that can be encoded
+// to a plist: bools, strings, numbers, slices and maps of these.
+type Entitlements map[string]interface{}
+
+// knownEntitlements are the entitlements that are commonly used with
+// the hardened runtime and Developer ID signing. All of these must be
+// boolean values.
+var knownEntitlements = map[string]struct{}{
+	// Hardened runtime
+	"com.apple.security.cs.allow-jit":                          {},
+	"com.apple.security.cs.allow-unsigned-executable-memory":   {},
+	"com.apple.security.cs.allow-dyld-environment-variables":   {},
+	"com.apple.security.cs.disable-library-validation":         {},
+	"com.apple.security.cs.disable-executable-page-protection": {},
+	"com.apple.security.cs.debugger":                           {},
+	"com.apple.security.device.audio-input":                    {},
+	"com.apple.security.device.camera":                         {},
+	"com.apple.security.personal-information.location":         {},
+	"com.apple.security.personal-information.addressbook":      {},
+	"com.apple.security.personal-information.calendars":        {},
+	"com.apple.security.personal-information.photos-library":   {},
+	"com.apple.security.automation.apple-events":               {},
+	"com.apple.security.get-task-allow":                        {},
+
+	// App sandbox
+	"com.apple.security.app-sandbox":                         {},
+	"com.apple.security.inherit":                             {},
+	"com.apple.security.network.client":                      {},
+	"com.apple.security.network.server":                      {},
+	"com.apple.security.device.bluetooth":                    {},
+	"com.apple.security.device.usb":                          {},
+	"com.apple.security.print":                               {},
+	"com.apple.security.files.user-selected.read-only":       {},
+	"com.apple.security.files.user-selected.read-write":      {},
+	"com.apple.security.files.downloads.read-only":           {},
+	"com.apple.security.files.downloads.read-write":          {},
+	"com.apple.security.assets.movies.read-only":             {},
+	"com.apple.security.assets.movies.read-write":            {},
+	"com.apple.security.assets.music.read-only":              {},
+	"com.apple.security.assets.music.read-write":             {},
+	"com.apple.security.assets.pictures.read-only":           {},
+	"com.apple.security.assets.pictures.read-write":          {},
+	"com.apple.security.files.bookmarks.app-scope":           {},
+	"com.apple.security.files.bookmarks.document-scope":      {},
+	"com.apple.security.files.all":                           {},
+	"com.apple.security.temporary-exception.audio-unit-host": {},
+}
+
+// knownValueEntitlements are known entitlements that take non-boolean
+// values, usually a list of strings.
+var knownValueEntitlements = map[string]struct{}{
+	"com.apple.security.application-groups":                                      {},
+	"com.apple.security.temporary-exception.apple-events":                        {},
+	"com.apple.security.temporary-exception.files.absolute-path.read-only":       {},
+	"com.apple.security.temporary-exception.files.absolute-path.read-write":      {},
+	"com.apple.security.temporary-exception.files.home-relative-path.read-only":  {},
+	"com.apple.security.temporary-exception.files.home-relative-path.read-write": {},
+	"com.apple.security.temporary-exception.mach-lookup.global-name":             {},
+}
+
+// Validate checks the entitlements for errors. An error is returned if
+// a known entitlement has a value of the wrong type. The returned warnings
+// are human-friendly messages for entitlements that are unknown or that are
+// likely to cause notarization to fail.
+func (e Entitlements) Validate() ([]string, error) {
+	var warnings []string
+	for _, k := range e.keys() {
+		v := e[k]
+		if _, ok := knownEntitlements[k]; ok {
+			b, ok := v.(bool)
+			if !ok {
+				return warnings, fmt.Errorf(
+					"entitlement %q must be a boolean, got %T", k, v)
+			}
+
+			if k == "com.apple.security.get-task-allow" && b {
+				warnings = append(warnings, fmt.Sprintf(
+					"entitlement %q is set. Notarization rejects binaries with "+
+						"this entitlement since it allows other processes to "+
+						"attach a debugger.", k))
+			}
+
+			continue
+		}
+
+		if _, ok := knownValueEntitlements[k]; ok {
+			continue
+		}
+
+		switch {
+		case restrictedEntitlement(k):
+			warnings = append(warnings, fmt.Sprintf(
+				"entitlement %q is restricted and requires an embedded "+
+					"provisioning profile that allows it, otherwise the "+
+					"signed code will fail to launch.", k))
+
+		default:
+			warnings = append(warnings, fmt.Sprintf(
+				"entitlement %q is not a known hardened runtime or sandbox "+
+					"entitlement. Please verify the name is correct.", k))
+		}
+	}
+
+	return warnings, nil
+}
+
+// restrictedEntitlement returns true if the entitlement must be allowed
+// by an embedded provisioning profile.
+func restrictedEntitlement(k string) bool {
+	return strings.HasPrefix(k, "com.apple.developer.") ||
+		k == "com.apple.application-identifier" ||
+		k == "keychain-access-groups"
+}
+
+// keys returns the sorted names of the entitlements.
+func (e Entitlements) keys() []string {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Plist returns the entitlements encoded as an XML plist.
+func (e Entitlements) Plist() ([]byte, error) {
+	return plist.MarshalIndent(map[string]interface{}(e), plist.XMLFormat, "\t")
+}
+
+// TempFile writes the entitlements to a new temporary file in XML plist
+// format and returns the path to the file. The file can be used with
+// Options.Entitlements. The caller is responsible for removing the file.
+func (e Entitlements) TempFile() (string, error) {
+	data, err := e.Plist()
+	if err != nil {
+		return "", err
+	}
+
+	f, err := ioutil.TempFile("", "gon-*.entitlements")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
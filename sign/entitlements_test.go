@@ -0,0 +1,90 @@
+package sign
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"howett.net/plist"
+)
+
+func TestEntitlementsValidate(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Input    Entitlements
+		Warnings int
+		Err      bool
+	}{
+		{
+			"valid",
+			Entitlements{
+				"com.apple.security.cs.allow-jit":       true,
+				"com.apple.security.network.client":     false,
+				"com.apple.security.application-groups": []interface{}{"TEAM.group"},
+			},
+			0,
+			false,
+		},
+
+		{
+			"wrong type",
+			Entitlements{"com.apple.security.cs.allow-jit": "yes"},
+			0,
+			true,
+		},
+
+		{
+			"get-task-allow",
+			Entitlements{"com.apple.security.get-task-allow": true},
+			1,
+			false,
+		},
+
+		{
+			"get-task-allow false",
+			Entitlements{"com.apple.security.get-task-allow": false},
+			0,
+			false,
+		},
+
+		{
+			"unknown and restricted",
+			Entitlements{
+				"com.example.unknown":                             true,
+				"com.apple.developer.networking.networkextension": []interface{}{"packet-tunnel-provider"},
+			},
+			2,
+			false,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			warnings, err := tt.Input.Validate()
+			require.Equal(t, tt.Err, err != nil)
+			require.Len(t, warnings, tt.Warnings)
+		})
+	}
+}
+
+func TestEntitlementsTempFile(t *testing.T) {
+	input := Entitlements{
+		"com.apple.security.cs.allow-jit":       true,
+		"com.apple.security.app-sandbox":        false,
+		"com.apple.security.application-groups": []interface{}{"TEAM.group"},
+	}
+
+	path, err := input.TempFile()
+	require.NoError(t, err)
+	defer os.Remove(path)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	var actual map[string]interface{}
+	format, err := plist.Unmarshal(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, plist.XMLFormat, format)
+	require.Equal(t, map[string]interface{}(input), actual)
+}
@@ -0,0 +1,138 @@
+package sign
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// InstallerOptions are the options for SignInstaller.
+type InstallerOptions struct {
+	// Path is the path to the installer package (.pkg) to sign. This is
+	// required. The package is signed _in-place_ and any existing signature
+	// is replaced.
+	Path string
+
+	// Identity is the "Developer ID Installer" identity to sign with. This
+	// is required. This is any valid value for the `--sign` flag of
+	// productsign: the SHA-1 hash or the full or partial common name.
+	Identity string
+
+	// Keychain is an (optional) path to the keychain to search for Identity.
+	Keychain string
+
+	// Timestamp controls the secure timestamp of the signature. See
+	// Options.Timestamp. productsign only supports the default server, so
+	// a URL is an error.
+	Timestamp string
+
+	// Output is an io.Writer where the output of the command will be written.
+	// If this is nil then the output will only be sent to the log (if set)
+	// or in the error result value if signing failed.
+	Output io.Writer
+
+	// Logger is the logger to use. If this is nil then no logging will be done.
+	Logger hclog.Logger
+
+	// BaseCmd is the base command for executing the productsign binary. This
+	// is used for tests to overwrite where the productsign binary is.
+	BaseCmd *exec.Cmd
+}
+
+// SignInstaller signs an installer package with productsign.
+//
+// productsign can't sign in place, so the package is signed to a temporary
+// file next to it which then replaces the original.
+func SignInstaller(ctx context.Context, opts *InstallerOptions) error {
+	logger := opts.Logger
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+
+	if opts.Identity == "" {
+		return fmt.Errorf("an installer identity is required to sign %s", opts.Path)
+	}
+
+	// Build our command
+	var cmd exec.Cmd
+	if opts.BaseCmd != nil {
+		cmd = *opts.BaseCmd
+	}
+
+	// We only set the path if it isn't set. This lets the options set the
+	// path to the productsign binary that we use.
+	if cmd.Path == "" {
+		path, err := exec.LookPath("productsign")
+		if err != nil {
+			return err
+		}
+		cmd.Path = path
+	}
+
+	cmd.Args = []string{
+		"productsign",
+		"--sign", opts.Identity,
+	}
+
+	switch opts.Timestamp {
+	case "":
+		cmd.Args = append(cmd.Args, "--timestamp")
+	case TimestampNone:
+		cmd.Args = append(cmd.Args, "--timestamp=none")
+	default:
+		return fmt.Errorf("productsign doesn't support the timestamp server %q", opts.Timestamp)
+	}
+
+	if v := opts.Keychain; len(v) > 0 {
+		cmd.Args = append(cmd.Args, "--keychain", v)
+	}
+
+	// Sign to a temporary path in the same directory so that we can
+	// rename it over the original.
+	td, err := ioutil.TempDir(filepath.Dir(opts.Path), ".gon-productsign")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(td)
+	output := filepath.Join(td, filepath.Base(opts.Path))
+	cmd.Args = append(cmd.Args, opts.Path, output)
+
+	// We store all output in out for logging and in case there is an error
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	// If we have an output set, we write to both
+	if opts.Output != nil {
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, opts.Output)
+	}
+
+	// We send stderr to the same place as stdout
+	cmd.Stderr = cmd.Stdout
+
+	// Log what we're going to execute
+	logger.Info("executing productsign",
+		"path", opts.Path,
+		"command_path", cmd.Path,
+		"command_args", cmd.Args,
+	)
+
+	// Execute
+	if err := cmd.Run(); err != nil {
+		logger.Error("error signing installer", "err", err, "output", out.String())
+		return fmt.Errorf("error signing installer:\n\n%s", out.String())
+	}
+
+	if err := os.Rename(output, opts.Path); err != nil {
+		return err
+	}
+
+	logger.Info("installer signing complete", "output", out.String())
+	return nil
+}
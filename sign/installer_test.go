@@ -0,0 +1,77 @@
+package sign
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignInstaller(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon-sign")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	record := filepath.Join(td, "record")
+	cmd := childCmd(t, "copy")
+	cmd.Env = append(cmd.Env, childRecordEnv+"="+record)
+
+	pkg := filepath.Join(td, "foo.pkg")
+	require.NoError(t, ioutil.WriteFile(pkg, []byte("xar!"), 0644))
+
+	require.NoError(t, SignInstaller(context.Background(), &InstallerOptions{
+		Path:     pkg,
+		Identity: "Developer ID Installer: Example",
+		Keychain: "/tmp/gon.keychain",
+		Logger:   hclog.L(),
+		BaseCmd:  cmd,
+	}))
+
+	// The signed package replaces the original
+	contents, err := ioutil.ReadFile(pkg)
+	require.NoError(t, err)
+	require.Equal(t, "xar!signed", string(contents))
+
+	contents, err = ioutil.ReadFile(record)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(contents),
+		"--sign Developer ID Installer: Example --timestamp --keychain /tmp/gon.keychain "+pkg+" "))
+
+	// Only the temporary directory was removed
+	fis, err := ioutil.ReadDir(td)
+	require.NoError(t, err)
+	require.Len(t, fis, 2)
+}
+
+func TestSignInstaller_errors(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon-sign")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	pkg := filepath.Join(td, "foo.pkg")
+	require.NoError(t, ioutil.WriteFile(pkg, []byte("xar!"), 0644))
+
+	err = SignInstaller(context.Background(), &InstallerOptions{
+		Path:    pkg,
+		BaseCmd: childCmd(t, "success"),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "identity is required")
+
+	err = SignInstaller(context.Background(), &InstallerOptions{
+		Path:     pkg,
+		Identity: "foo",
+		BaseCmd:  childCmd(t, "fail"),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed")
+
+	contents, err := ioutil.ReadFile(pkg)
+	require.NoError(t, err)
+	require.Equal(t, "xar!", string(contents))
+}
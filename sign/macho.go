@@ -0,0 +1,615 @@
+package sign
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"howett.net/plist"
+
+	"github.com/mitchellh/gon/internal/cms"
+	"github.com/mitchellh/gon/internal/fat"
+)
+
+// Mach-O header and load command values used by the native signer.
+const (
+	machoMagic32 = 0xfeedface
+	machoMagic64 = 0xfeedfacf
+
+	machoTypeExecute = 2
+
+	loadCmdSegment         = 0x1
+	loadCmdSegment64       = 0x19
+	loadCmdCodeSignature   = 0x1d
+	loadCmdVersionMinMacOS = 0x24
+	loadCmdBuildVersion    = 0x32
+
+	cpuARM64 = 0x0100000c
+)
+
+// Code signature blob magic numbers, slots and flags.
+const (
+	magicCodeDirectory = 0xfade0c02
+	magicEmbeddedSig   = 0xfade0cc0
+	magicEntitlements  = 0xfade7171
+	magicBlobWrapper   = 0xfade0b01
+
+	slotCodeDirectory = 0
+	slotRequirements  = 2
+	slotEntitlements  = 5
+	slotSignature     = 0x10000
+
+	flagAdHoc   = 0x00000002
+	flagRuntime = 0x00010000
+
+	// execSegMainBinary is the exec segment flag for main executables.
+	execSegMainBinary = 0x1
+
+	codeDirectoryVersion = 0x20500
+	codeDirectorySize    = 96
+	pageSizeBits         = 12
+	pageSize             = 1 << pageSizeBits
+)
+
+// runtimeFlags are the CodeDirectory flags for the values of
+// FileOptions.RuntimeOptions.
+var runtimeFlags = map[string]uint32{
+	"host":     0x00000001,
+	"hard":     0x00000100,
+	"kill":     0x00000200,
+	"expires":  0x00000400,
+	"restrict": 0x00000800,
+	"library":  0x00002000,
+	"runtime":  flagRuntime,
+}
+
+// errNotMachO is returned when signing a file that isn't a Mach-O binary.
+var errNotMachO = errors.New("not a Mach-O binary")
+
+// machoSigner signs Mach-O binaries in pure Go.
+type machoSigner struct {
+	// Identifier is the signing identifier.
+	Identifier string
+
+	// Flags are the CodeDirectory flags. flagAdHoc is added automatically
+	// if Certificate is nil.
+	Flags uint32
+
+	// Entitlements are the XML entitlements to embed, if any.
+	Entitlements []byte
+
+	// Certificate is the signing identity. If nil, the signature is ad-hoc.
+	Certificate *Certificate
+
+	// TimestampURL is the URL of a RFC 3161 timestamp server. If empty,
+	// the signature has no secure timestamp.
+	TimestampURL string
+}
+
+// Sign signs the thin or universal binary data and returns the signed
+// binary. Any existing signature is replaced.
+func (s *machoSigner) Sign(data []byte) ([]byte, error) {
+	if !fat.IsFat(data) {
+		return s.signThin(data)
+	}
+
+	arches, err := fat.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, arch := range arches {
+		arch.Data, err = s.signThin(arch.Data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", arch.CPU, err)
+		}
+	}
+
+	return fat.Marshal(arches)
+}
+
+// machoFile is the information the signer needs from a thin binary.
+type machoFile struct {
+	bo       binary.ByteOrder
+	is64     bool
+	cpu      uint32
+	fileType uint32
+	sdk      uint32
+
+	// ncmds and sizeofcmds are the values from the header. cmdsEnd is the
+	// offset of the end of the load commands.
+	ncmds, sizeofcmds uint32
+	cmdsEnd           int
+
+	// dataStart is the offset of the first section or segment data
+	// after the load commands. This limits how many load commands can
+	// be added.
+	dataStart int
+
+	// codeSignature, linkedit and text are the offsets of the load
+	// commands, or -1 if not present.
+	codeSignature int
+	linkedit      int
+	text          int
+}
+
+// parseMachO parses the header and load commands of a thin binary.
+func parseMachO(data []byte) (*machoFile, error) {
+	if len(data) < 28 {
+		return nil, errNotMachO
+	}
+
+	f := &machoFile{codeSignature: -1, linkedit: -1, text: -1}
+	switch {
+	case binary.LittleEndian.Uint32(data) == machoMagic32:
+		f.bo = binary.LittleEndian
+	case binary.LittleEndian.Uint32(data) == machoMagic64:
+		f.bo, f.is64 = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(data) == machoMagic32:
+		f.bo = binary.BigEndian
+	case binary.BigEndian.Uint32(data) == machoMagic64:
+		f.bo, f.is64 = binary.BigEndian, true
+	default:
+		return nil, errNotMachO
+	}
+
+	bo := f.bo
+	f.cpu = bo.Uint32(data[4:])
+	f.fileType = bo.Uint32(data[12:])
+	f.ncmds = bo.Uint32(data[16:])
+	f.sizeofcmds = bo.Uint32(data[20:])
+
+	off := 28
+	if f.is64 {
+		off = 32
+	}
+	f.cmdsEnd = off + int(f.sizeofcmds)
+	f.dataStart = len(data)
+	if f.cmdsEnd > len(data) {
+		return nil, errors.New("load commands are out of bounds")
+	}
+
+	for i := uint32(0); i < f.ncmds; i++ {
+		if off+8 > f.cmdsEnd {
+			return nil, errors.New("load commands are out of bounds")
+		}
+		cmd := bo.Uint32(data[off:])
+		size := int(bo.Uint32(data[off+4:]))
+		if size < 8 || off+size > f.cmdsEnd {
+			return nil, fmt.Errorf("load command %d has an invalid size", i)
+		}
+		raw := data[off : off+size]
+
+		switch cmd {
+		case loadCmdSegment, loadCmdSegment64:
+			if err := f.parseSegment(off, raw); err != nil {
+				return nil, err
+			}
+
+		case loadCmdCodeSignature:
+			if size < 16 {
+				return nil, errors.New("invalid LC_CODE_SIGNATURE load command")
+			}
+			f.codeSignature = off
+
+		case loadCmdVersionMinMacOS:
+			if size >= 16 {
+				f.sdk = bo.Uint32(raw[12:])
+			}
+
+		case loadCmdBuildVersion:
+			if size >= 20 {
+				f.sdk = bo.Uint32(raw[16:])
+			}
+		}
+
+		off += size
+	}
+
+	return f, nil
+}
+
+// parseSegment records the segment load command at off.
+func (f *machoFile) parseSegment(off int, raw []byte) error {
+	headerSize, sectionSize, offsetField := 56, 68, 40
+	if f.is64 {
+		headerSize, sectionSize, offsetField = 72, 80, 48
+	}
+	if len(raw) < headerSize {
+		return errors.New("invalid segment load command")
+	}
+
+	switch name := cString(raw[8:24]); name {
+	case "__TEXT":
+		f.text = off
+	case "__LINKEDIT":
+		f.linkedit = off
+	}
+
+	fileoff, filesize := f.segmentFile(raw)
+	if fileoff > 0 && filesize > 0 && fileoff < uint64(f.dataStart) {
+		f.dataStart = int(fileoff)
+	}
+
+	nsects := int(f.bo.Uint32(raw[headerSize-8:]))
+	if headerSize+nsects*sectionSize > len(raw) {
+		return errors.New("segment sections are out of bounds")
+	}
+	for i := 0; i < nsects; i++ {
+		sect := raw[headerSize+i*sectionSize:]
+		if v := int(f.bo.Uint32(sect[offsetField:])); v > 0 && v < f.dataStart {
+			f.dataStart = v
+		}
+	}
+
+	return nil
+}
+
+// segmentFile returns the file offset and size of the segment command.
+func (f *machoFile) segmentFile(raw []byte) (uint64, uint64) {
+	if f.is64 {
+		return f.bo.Uint64(raw[40:]), f.bo.Uint64(raw[48:])
+	}
+
+	return uint64(f.bo.Uint32(raw[32:])), uint64(f.bo.Uint32(raw[36:]))
+}
+
+// setSegmentSize sets the file and VM size of the segment command.
+func (f *machoFile) setSegmentSize(raw []byte, filesize uint64) {
+	align := uint64(0x1000)
+	if f.cpu == cpuARM64 {
+		align = 0x4000
+	}
+	vmsize := roundUp(filesize, align)
+
+	if f.is64 {
+		f.bo.PutUint64(raw[32:], vmsize)
+		f.bo.PutUint64(raw[48:], filesize)
+		return
+	}
+
+	f.bo.PutUint32(raw[28:], uint32(vmsize))
+	f.bo.PutUint32(raw[36:], uint32(filesize))
+}
+
+// signThin signs a thin binary.
+func (s *machoSigner) signThin(data []byte) ([]byte, error) {
+	f, err := parseMachO(data)
+	if err != nil {
+		return nil, err
+	}
+	if f.linkedit < 0 {
+		return nil, errors.New("binary has no __LINKEDIT segment")
+	}
+
+	// The signature must be at the end of the __LINKEDIT segment, which
+	// must be at the end of the file.
+	fileoff, filesize := f.segmentFile(data[f.linkedit:])
+	if fileoff+filesize != uint64(len(data)) {
+		return nil, errors.New("__LINKEDIT must be the last segment of the binary")
+	}
+
+	// The code ends where the existing signature starts, if any
+	codeEnd := len(data)
+	if f.codeSignature >= 0 {
+		codeEnd = int(f.bo.Uint32(data[f.codeSignature+8:]))
+		if codeEnd < int(fileoff) || codeEnd > len(data) {
+			return nil, errors.New("existing code signature is out of bounds")
+		}
+	} else if f.cmdsEnd+16 > f.dataStart {
+		return nil, errors.New(
+			"not enough space in the header for the code signature load " +
+				"command; link the binary with -headerpad")
+	}
+
+	// Build everything that doesn't depend on the code so we know the
+	// size to reserve for the signature.
+	sigOffset := int(roundUp(uint64(codeEnd), 16))
+	nCode := (sigOffset + pageSize - 1) / pageSize
+
+	teamID := ""
+	if s.Certificate != nil {
+		teamID = teamIDForSigner(s.Certificate)
+	}
+
+	blobs := map[uint32][]byte{
+		slotRequirements: requirements(s.Identifier, s.Certificate),
+	}
+	if s.Entitlements != nil {
+		blobs[slotEntitlements] = blob(magicEntitlements, s.Entitlements)
+	}
+
+	reserved := 12 + 8*4 + codeDirectorySize + len(s.Identifier) + len(teamID) + 2 +
+		(slotEntitlements+nCode)*sha256.Size + len(blobs[slotRequirements]) +
+		len(blobs[slotEntitlements]) + 8
+	if s.Certificate != nil {
+		reserved += 4096 + len(s.Certificate.Certificate.Raw)
+		for _, cert := range s.Certificate.Chain {
+			reserved += len(cert.Raw)
+		}
+		if s.TimestampURL != "" {
+			reserved += 16384
+		}
+	}
+	reserved = int(roundUp(uint64(reserved), 16))
+
+	// Update the header for the new signature. We copy the code so that
+	// data is never modified.
+	result := make([]byte, sigOffset+reserved)
+	copy(result, data[:codeEnd])
+
+	bo := f.bo
+	if f.codeSignature < 0 {
+		f.codeSignature = f.cmdsEnd
+		cmd := result[f.codeSignature:]
+		bo.PutUint32(cmd[0:], loadCmdCodeSignature)
+		bo.PutUint32(cmd[4:], 16)
+		bo.PutUint32(result[16:], f.ncmds+1)
+		bo.PutUint32(result[20:], f.sizeofcmds+16)
+	}
+	bo.PutUint32(result[f.codeSignature+8:], uint32(sigOffset))
+	bo.PutUint32(result[f.codeSignature+12:], uint32(reserved))
+	f.setSegmentSize(result[f.linkedit:], uint64(sigOffset+reserved)-fileoff)
+
+	// Sign the code
+	flags := s.Flags
+	if s.Certificate == nil {
+		flags |= flagAdHoc
+	}
+
+	var execSegBase, execSegLimit uint64
+	if f.text >= 0 {
+		execSegBase, execSegLimit = f.segmentFile(result[f.text:])
+	}
+	var execSegFlags uint64
+	if f.fileType == machoTypeExecute {
+		execSegFlags = execSegMainBinary
+	}
+
+	cd := codeDirectory(&codeDirectoryOptions{
+		Identifier:   s.Identifier,
+		TeamID:       teamID,
+		Flags:        flags,
+		Code:         result[:sigOffset],
+		Blobs:        blobs,
+		ExecSegBase:  execSegBase,
+		ExecSegLimit: execSegLimit,
+		ExecSegFlags: execSegFlags,
+		Runtime:      f.sdk,
+	})
+	blobs[slotCodeDirectory] = cd
+
+	sig, err := s.cms(cd)
+	if err != nil {
+		return nil, err
+	}
+	blobs[slotSignature] = blob(magicBlobWrapper, sig)
+
+	sb := superBlob(blobs)
+	if len(sb) > reserved {
+		return nil, fmt.Errorf(
+			"signature is larger than the reserved space (%d > %d bytes)", len(sb), reserved)
+	}
+	copy(result[sigOffset:], sb)
+
+	return result, nil
+}
+
+// cms creates the CMS signature of the CodeDirectory. Ad-hoc signatures
+// have an empty CMS signature.
+func (s *machoSigner) cms(cd []byte) ([]byte, error) {
+	if s.Certificate == nil {
+		return nil, nil
+	}
+
+	// The signature includes the hashes of the CodeDirectories in two
+	// forms: a plist of the truncated hashes and the full hashes.
+	sum := sha256.Sum256(cd)
+	hashes, err := plist.Marshal(map[string]interface{}{
+		"cdhashes": [][]byte{sum[:20]},
+	}, plist.XMLFormat)
+	if err != nil {
+		return nil, err
+	}
+	hashesValue, err := asn1.Marshal(hashes)
+	if err != nil {
+		return nil, err
+	}
+	digestValue, err := asn1.Marshal(struct {
+		Algorithm asn1.ObjectIdentifier
+		Digest    []byte
+	}{cms.OIDDigestSHA256, sum[:]})
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &cms.SignOptions{
+		Hash:        crypto.SHA256,
+		Detached:    true,
+		SigningTime: time.Now(),
+		Attributes: []cms.Attribute{
+			{
+				Type:   cms.OIDAppleCDHashes,
+				Values: []asn1.RawValue{{FullBytes: hashesValue}},
+			},
+			{
+				Type:   cms.OIDAppleCDHashes2,
+				Values: []asn1.RawValue{{FullBytes: digestValue}},
+			},
+		},
+	}
+	if url := s.TimestampURL; url != "" {
+		opts.Timestamp = func(sig []byte) ([]byte, error) {
+			token, err := cms.RequestTimestamp(url, sig)
+			if err != nil {
+				return nil, fmt.Errorf("error timestamping signature: %s", err)
+			}
+
+			return token, nil
+		}
+	}
+
+	return cms.Sign(cd, &cms.Signer{
+		Certificate: s.Certificate.Certificate,
+		Chain:       s.Certificate.Chain,
+		Key:         s.Certificate.Key,
+	}, opts)
+}
+
+// codeDirectoryOptions are the options for codeDirectory.
+type codeDirectoryOptions struct {
+	Identifier string
+	TeamID     string
+	Flags      uint32
+
+	// Code is the code to hash, which is everything before the signature.
+	Code []byte
+
+	// Blobs are the other blobs of the signature, keyed by slot. The
+	// hashes of these are stored in the special slots.
+	Blobs map[uint32][]byte
+
+	ExecSegBase  uint64
+	ExecSegLimit uint64
+	ExecSegFlags uint64
+
+	// Runtime is the SDK version the hardened runtime applies to.
+	Runtime uint32
+}
+
+// codeDirectory creates a SHA-256 CodeDirectory blob.
+func codeDirectory(opts *codeDirectoryOptions) []byte {
+	nSpecial := slotRequirements
+	if _, ok := opts.Blobs[slotEntitlements]; ok {
+		nSpecial = slotEntitlements
+	}
+
+	strs := append([]byte(opts.Identifier), 0)
+	teamOffset := 0
+	if opts.TeamID != "" {
+		teamOffset = codeDirectorySize + len(strs)
+		strs = append(strs, append([]byte(opts.TeamID), 0)...)
+	}
+
+	// Special slots are stored in reverse order before the code slots.
+	// Missing blobs have a hash of all zeros.
+	var hashes []byte
+	for slot := nSpecial; slot > 0; slot-- {
+		if b, ok := opts.Blobs[uint32(slot)]; ok {
+			sum := sha256.Sum256(b)
+			hashes = append(hashes, sum[:]...)
+		} else {
+			hashes = append(hashes, make([]byte, sha256.Size)...)
+		}
+	}
+	nCode := 0
+	for off := 0; off < len(opts.Code); off += pageSize {
+		end := off + pageSize
+		if end > len(opts.Code) {
+			end = len(opts.Code)
+		}
+		sum := sha256.Sum256(opts.Code[off:end])
+		hashes = append(hashes, sum[:]...)
+		nCode++
+	}
+
+	be := binary.BigEndian
+	cd := make([]byte, codeDirectorySize)
+	be.PutUint32(cd[0:], magicCodeDirectory)
+	be.PutUint32(cd[4:], uint32(codeDirectorySize+len(strs)+len(hashes)))
+	be.PutUint32(cd[8:], codeDirectoryVersion)
+	be.PutUint32(cd[12:], opts.Flags)
+	be.PutUint32(cd[16:], uint32(codeDirectorySize+len(strs)+nSpecial*sha256.Size))
+	be.PutUint32(cd[20:], codeDirectorySize)
+	be.PutUint32(cd[24:], uint32(nSpecial))
+	be.PutUint32(cd[28:], uint32(nCode))
+	be.PutUint32(cd[32:], uint32(len(opts.Code)))
+	cd[36] = sha256.Size
+	cd[37] = 2 // SHA-256
+	cd[39] = pageSizeBits
+	be.PutUint32(cd[48:], uint32(teamOffset))
+	be.PutUint64(cd[64:], opts.ExecSegBase)
+	be.PutUint64(cd[72:], opts.ExecSegLimit)
+	be.PutUint64(cd[80:], opts.ExecSegFlags)
+	be.PutUint32(cd[88:], opts.Runtime)
+
+	cd = append(cd, strs...)
+	return append(cd, hashes...)
+}
+
+// superBlob creates the embedded signature SuperBlob of the blobs,
+// keyed by slot. The blobs are ordered by slot.
+func superBlob(blobs map[uint32][]byte) []byte {
+	slots := []uint32{slotCodeDirectory, slotRequirements, slotEntitlements, slotSignature}
+
+	var count int
+	for _, slot := range slots {
+		if _, ok := blobs[slot]; ok {
+			count++
+		}
+	}
+
+	var index, data []byte
+	offset := 12 + 8*count
+	for _, slot := range slots {
+		b, ok := blobs[slot]
+		if !ok {
+			continue
+		}
+
+		index = appendUint32(index, slot)
+		index = appendUint32(index, uint32(offset+len(data)))
+		data = append(data, b...)
+	}
+
+	var result []byte
+	result = appendUint32(result, magicEmbeddedSig)
+	result = appendUint32(result, uint32(offset+len(data)))
+	result = appendUint32(result, uint32(count))
+	result = append(result, index...)
+	return append(result, data...)
+}
+
+// teamIDForSigner returns the team ID to embed for the certificate.
+// Only Developer ID certificates have a team ID.
+func teamIDForSigner(cert *Certificate) string {
+	if !hasExtension(cert.Certificate, oidDeveloperIDLeaf) {
+		return ""
+	}
+
+	return teamID(cert.Certificate)
+}
+
+// blob creates a blob with the given magic and payload.
+func blob(magic uint32, payload []byte) []byte {
+	var result []byte
+	result = appendUint32(result, magic)
+	result = appendUint32(result, uint32(8+len(payload)))
+	return append(result, payload...)
+}
+
+// cString returns the NUL-terminated string at the start of data.
+func cString(data []byte) string {
+	for i, b := range data {
+		if b == 0 {
+			return string(data[:i])
+		}
+	}
+
+	return string(data)
+}
+
+// roundUp rounds v up to a multiple of align, which must be a power of two.
+func roundUp(v, align uint64) uint64 {
+	return (v + align - 1) &^ (align - 1)
+}
+
+// appendUint32 appends the big endian encoding of v.
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,179 @@
+package sign
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/mitchellh/gon/codesig"
+	"github.com/mitchellh/gon/internal/fat"
+)
+
+// errNoCertificate is returned when native signing isn't ad-hoc and
+// has no certificate.
+var errNoCertificate = errors.New(
+	"native signing requires a certificate, or the identity \"-\" for ad-hoc signing")
+
+// signNative signs every component of the plan in pure Go. Only Mach-O
+// binaries can be signed natively.
+func signNative(opts *Options, logger hclog.Logger, plan *Plan) error {
+	if opts.Certificate == nil && opts.Identity != "-" {
+		return errNoCertificate
+	}
+
+	// Check everything up front so that we don't sign some files and
+	// then fail on an unsupported one.
+	for _, c := range plan.Components {
+		switch {
+		case c.Kind == KindBundle:
+			return fmt.Errorf(
+				"%s: native signing doesn't support bundles, use codesign instead", c.Path)
+
+		case c.Kind == KindFile && !fat.IsMachOFile(c.Path):
+			return fmt.Errorf(
+				"%s: native signing only supports Mach-O binaries, use codesign instead", c.Path)
+
+		case c.Requirements != "":
+			return fmt.Errorf(
+				"%s: custom requirements aren't supported by native signing", c.Path)
+		}
+	}
+
+	if opts.Concurrency <= 1 {
+		for _, c := range plan.Components {
+			if err := signNativeFile(opts, logger, c); err != nil {
+				return fmt.Errorf("error signing %s: %s", c.Path, err)
+			}
+		}
+
+		return nil
+	}
+
+	// Without bundles all the components are independent, so they can
+	// all be signed at the same time.
+	concurrentOpts := *opts
+	if opts.Output != nil {
+		concurrentOpts.Output = &lockedWriter{w: opts.Output}
+	}
+	return forEach(plan.Components, opts.Concurrency, func(c *Component) error {
+		if err := signNativeFile(&concurrentOpts, logger, c); err != nil {
+			return fmt.Errorf("error signing %s: %s", c.Path, err)
+		}
+
+		return nil
+	})
+}
+
+// signNativeFile signs a single Mach-O binary in place.
+func signNativeFile(opts *Options, logger hclog.Logger, c *Component) error {
+	s := &machoSigner{
+		Identifier:  c.Identifier,
+		Certificate: opts.Certificate,
+	}
+
+	// Ad-hoc signatures have no CMS signature to timestamp.
+	if s.Certificate != nil {
+		switch opts.Timestamp {
+		case "":
+			s.TimestampURL = AppleTimestampURL
+		case TimestampNone:
+		default:
+			s.TimestampURL = opts.Timestamp
+		}
+	}
+
+	// Like codesign, the default identifier is the file name without
+	// its extension, with the prefix if it has no dots.
+	if s.Identifier == "" {
+		base := filepath.Base(c.Path)
+		s.Identifier = strings.TrimSuffix(base, filepath.Ext(base))
+		if !strings.Contains(s.Identifier, ".") {
+			s.Identifier = opts.Prefix + s.Identifier
+		}
+	}
+
+	for _, v := range strings.Split(c.runtimeOptions(), ",") {
+		flag, ok := runtimeFlags[v]
+		if !ok {
+			return fmt.Errorf("unknown runtime option %q", v)
+		}
+		s.Flags |= flag
+	}
+
+	if c.Entitlements != "" {
+		data, err := ioutil.ReadFile(c.Entitlements)
+		if err != nil {
+			return fmt.Errorf("error reading entitlements: %s", err)
+		}
+		s.Entitlements = data
+	}
+
+	fi, err := os.Stat(c.Path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(c.Path)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("signing natively",
+		"file", c.Path,
+		"identifier", s.Identifier,
+		"adhoc", s.Certificate == nil,
+		"timestamp_url", s.TimestampURL,
+	)
+
+	signed, err := s.Sign(data)
+	if err != nil {
+		return err
+	}
+
+	// Verify the result with the independent signature parser so that
+	// we never write a broken signature.
+	f, err := codesig.NewFile(signed)
+	if err == nil {
+		err = f.Verify()
+	}
+	if err != nil {
+		return fmt.Errorf("error verifying the new signature: %s", err)
+	}
+
+	// Write to a temporary file and rename it over the original so that
+	// the binary is never left partially written.
+	tmp, err := ioutil.TempFile(filepath.Dir(c.Path), "."+filepath.Base(c.Path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(signed); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), fi.Mode()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.Path); err != nil {
+		return err
+	}
+
+	if opts.Output != nil {
+		kind := "thin"
+		if len(f.Arches) > 1 || f.Universal {
+			kind = "universal"
+		}
+		fmt.Fprintf(opts.Output, "%s: signed Mach-O %s [%s]\n", c.Path, kind, s.Identifier)
+	}
+
+	logger.Info("native signing complete", "file", c.Path, "cdhash", f.Arches[0].Signature.CDHash())
+	return nil
+}
@@ -0,0 +1,254 @@
+package sign
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitchellh/gon/codesig"
+	"github.com/mitchellh/gon/internal/cms"
+	"github.com/mitchellh/gon/internal/machotest"
+)
+
+func TestLoadPKCS12(t *testing.T) {
+	cert, err := LoadPKCS12(filepath.Join("testdata", "identity.p12"), "password")
+	require.NoError(t, err)
+	require.Equal(t, "Developer ID Application: Example (TEAMID1234)", cert.Certificate.Subject.CommonName)
+	require.Len(t, cert.Chain, 1)
+	require.Equal(t, "Test Developer ID Certification Authority", cert.Chain[0].Subject.CommonName)
+	require.NotNil(t, cert.Key)
+
+	_, err = LoadPKCS12(filepath.Join("testdata", "identity.p12"), "wrong")
+	require.Error(t, err)
+}
+
+func TestSignNative_adhoc(t *testing.T) {
+	path := testBinary(t, machotest.Build(t, &machotest.Options{CPU: machotest.CPUARM64, SDK: 0x000b0000}))
+	defer os.RemoveAll(filepath.Dir(path))
+
+	require.NoError(t, Sign(context.Background(), &Options{
+		Files:    []string{path},
+		Identity: "-",
+		Native:   true,
+		Logger:   hclog.L(),
+	}))
+
+	f := testVerify(t, path)
+	sig := f.Arches[0].Signature
+	require.True(t, sig.AdHoc())
+	require.True(t, sig.Runtime())
+	require.Equal(t, "foo", sig.Identifier())
+	require.Equal(t, "", sig.TeamID())
+	require.Equal(t, "11.0.0", sig.CodeDirectory.RuntimeVersionString())
+	require.Empty(t, sig.CMS)
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), fi.Mode())
+}
+
+func TestSignNative_prefix(t *testing.T) {
+	path := testBinary(t, machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64}))
+	defer os.RemoveAll(filepath.Dir(path))
+
+	require.NoError(t, Sign(context.Background(), &Options{
+		Files:     []string{path},
+		Identity:  "-",
+		Prefix:    "com.example.",
+		Timestamp: TimestampNone,
+		Native:    true,
+	}))
+
+	f := testVerify(t, path)
+	require.Equal(t, "com.example.foo", f.Arches[0].Signature.Identifier())
+}
+
+func TestSignNative_certificate(t *testing.T) {
+	cert, err := LoadPKCS12(filepath.Join("testdata", "identity.p12"), "password")
+	require.NoError(t, err)
+
+	genTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tsa := machotest.TSA(t, machotest.Identity(t), genTime)
+	defer tsa.Close()
+
+	path := testBinary(t, machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64}))
+	defer os.RemoveAll(filepath.Dir(path))
+
+	ents := filepath.Join(filepath.Dir(path), "ents.plist")
+	require.NoError(t, ioutil.WriteFile(ents, []byte(machotest.Entitlements), 0644))
+
+	var out bytes.Buffer
+	require.NoError(t, Sign(context.Background(), &Options{
+		Files:        []string{path},
+		Entitlements: ents,
+		FileOptions: map[string]*FileOptions{
+			path: {
+				Identifier:     "com.example.foo",
+				RuntimeOptions: []string{"library"},
+			},
+		},
+		Native:      true,
+		Certificate: cert,
+		Timestamp:   tsa.URL,
+		Output:      &out,
+		Logger:      hclog.L(),
+	}))
+	require.Contains(t, out.String(), "signed Mach-O thin [com.example.foo]")
+
+	f := testVerify(t, path)
+	sig := f.Arches[0].Signature
+	require.False(t, sig.AdHoc())
+	require.Equal(t, []string{"library-validation", "runtime"}, sig.CodeDirectory.FlagNames())
+	require.Equal(t, "com.example.foo", sig.Identifier())
+	require.Equal(t, "TEAMID1234", sig.TeamID())
+	require.Equal(t, "Developer ID Application: Example (TEAMID1234)", sig.Identity())
+	require.Len(t, sig.Certificates, 2)
+	require.True(t, genTime.Equal(sig.Timestamp))
+	require.Equal(t, machotest.Entitlements, string(sig.Entitlements))
+
+	// The designated requirement pins the team
+	require.True(t, bytes.Contains(sig.Requirements, []byte("subject.OU")))
+	require.True(t, bytes.Contains(sig.Requirements, []byte("TEAMID1234")))
+
+	// The CDHashes attributes match the code directory
+	hashes, ok := sig.SignedData.Signers[0].Attribute(cms.OIDAppleCDHashes)
+	require.True(t, ok)
+	require.True(t, bytes.Contains(hashes.FullBytes, []byte("<key>cdhashes</key>")))
+}
+
+func TestSignNative_resign(t *testing.T) {
+	path := testBinary(t, machotest.Build(t, &machotest.Options{
+		CPU: machotest.CPUAMD64,
+		Sign: &machotest.Sign{
+			Identifier:   "old",
+			Entitlements: []byte(machotest.Entitlements),
+			Signer:       machotest.Identity(t),
+		},
+	}))
+	defer os.RemoveAll(filepath.Dir(path))
+
+	opts := &Options{
+		Files:    []string{path},
+		Identity: "-",
+		Native:   true,
+		Logger:   hclog.L(),
+	}
+	require.NoError(t, Sign(context.Background(), opts))
+
+	f := testVerify(t, path)
+	sig := f.Arches[0].Signature
+	require.True(t, sig.AdHoc())
+	require.Equal(t, "foo", sig.Identifier())
+	require.Empty(t, sig.Entitlements)
+
+	// Signing again gives the same result
+	before, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, Sign(context.Background(), opts))
+	after, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+}
+
+func TestSignNative_universal(t *testing.T) {
+	path := testBinary(t, machotest.Fat(
+		machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64}),
+		machotest.Build(t, &machotest.Options{CPU: machotest.CPUARM64}),
+	))
+	defer os.RemoveAll(filepath.Dir(path))
+
+	require.NoError(t, Sign(context.Background(), &Options{
+		Files:    []string{path},
+		Identity: "-",
+		Native:   true,
+		Logger:   hclog.L(),
+	}))
+
+	f := testVerify(t, path)
+	require.True(t, f.Universal)
+	require.Len(t, f.Arches, 2)
+	for _, arch := range f.Arches {
+		require.NotNil(t, arch.Signature, arch.CPU)
+		require.Equal(t, "foo", arch.Signature.Identifier())
+	}
+}
+
+func TestSignNative_noHeaderSpace(t *testing.T) {
+	data := machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64})
+
+	// Move the __text section to directly after the load commands
+	le := binary.LittleEndian
+	le.PutUint32(data[32+72+48:], 32+le.Uint32(data[20:]))
+
+	path := testBinary(t, data)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	err := Sign(context.Background(), &Options{
+		Files:    []string{path},
+		Identity: "-",
+		Native:   true,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "-headerpad")
+
+	// The file is untouched
+	after, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, data, after)
+}
+
+func TestSignNative_unsupported(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "foo.txt")
+	require.NoError(t, ioutil.WriteFile(path, []byte("hello"), 0644))
+
+	err = Sign(context.Background(), &Options{
+		Files:    []string{path},
+		Identity: "-",
+		Native:   true,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "only supports Mach-O binaries")
+
+	// Without a certificate the identity must be ad-hoc
+	err = Sign(context.Background(), &Options{
+		Files:    []string{path},
+		Identity: "Developer ID Application: Example",
+		Native:   true,
+	})
+	require.Equal(t, errNoCertificate, err)
+}
+
+// testBinary writes data to an executable file named "foo" in a new
+// temporary directory. The caller must remove the directory.
+func testBinary(t *testing.T, data []byte) string {
+	t.Helper()
+
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+
+	path := filepath.Join(td, "foo")
+	require.NoError(t, ioutil.WriteFile(path, data, 0755))
+	return path
+}
+
+// testVerify opens and verifies the signature of the binary at path.
+func testVerify(t *testing.T, path string) *codesig.File {
+	t.Helper()
+
+	f, err := codesig.Open(path)
+	require.NoError(t, err)
+	require.NoError(t, f.Verify())
+	return f
+}
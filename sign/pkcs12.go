@@ -0,0 +1,110 @@
+package sign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// Certificate is a signing certificate and its private key, used for
+// native signing.
+type Certificate struct {
+	// Certificate is the signing certificate, such as a
+	// "Developer ID Application" certificate.
+	Certificate *x509.Certificate
+
+	// Chain are the other certificates of the chain, such as the
+	// Developer ID intermediate certificate. These are embedded in
+	// the signature.
+	Chain []*x509.Certificate
+
+	// Key is the private key of Certificate.
+	Key crypto.Signer
+}
+
+// LoadPKCS12 loads a certificate and private key from a PKCS#12 (.p12)
+// file, such as one exported from Keychain Access. Any other certificates
+// in the file are loaded as the chain.
+//
+// Only files using the legacy encryption algorithms are supported. This
+// is what Keychain Access exports. With OpenSSL 3, use the `-legacy` flag
+// of `openssl pkcs12 -export`.
+func LoadPKCS12(path, password string) (*Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err)
+	}
+
+	var result Certificate
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("error reading certificate in %s: %s", path, err)
+			}
+			certs = append(certs, cert)
+
+		case "PRIVATE KEY":
+			if result.Key != nil {
+				return nil, fmt.Errorf("%s has more than one private key", path)
+			}
+
+			// The key is not PKCS#8 despite the block type, it is
+			// PKCS#1 for RSA keys or SEC 1 for EC keys.
+			if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+				result.Key = key
+			} else if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+				result.Key = key
+			} else {
+				return nil, fmt.Errorf("unsupported private key type in %s", path)
+			}
+		}
+	}
+	if result.Key == nil {
+		return nil, fmt.Errorf("%s has no private key", path)
+	}
+
+	// The signing certificate is the one matching the key
+	for _, cert := range certs {
+		if result.Certificate == nil && publicKeyEqual(cert.PublicKey, result.Key.Public()) {
+			result.Certificate = cert
+			continue
+		}
+
+		result.Chain = append(result.Chain, cert)
+	}
+	if result.Certificate == nil {
+		return nil, fmt.Errorf("%s has no certificate for its private key", path)
+	}
+
+	return &result, nil
+}
+
+// publicKeyEqual returns true if the two public keys are equal.
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	switch a := a.(type) {
+	case *rsa.PublicKey:
+		b, ok := b.(*rsa.PublicKey)
+		return ok && a.N.Cmp(b.N) == 0 && a.E == b.E
+
+	case *ecdsa.PublicKey:
+		b, ok := b.(*ecdsa.PublicKey)
+		return ok && a.Curve == b.Curve && a.X.Cmp(b.X) == 0 && a.Y.Cmp(b.Y) == 0
+
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
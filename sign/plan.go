@@ -0,0 +1,305 @@
+package sign
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"howett.net/plist"
+
+	"github.com/mitchellh/gon/internal/fat"
+)
+
+// Kind is the type of a single component that is signed.
+type Kind int
+
+const (
+	// KindFile is a file given directly to Sign that isn't a bundle. This
+	// may be a Mach-O binary, but also a dmg, pkg, etc.
+	KindFile Kind = iota
+
+	// KindMachO is a Mach-O file (executable, dylib, etc.) that is nested
+	// within a bundle and isn't the main executable of its bundle.
+	KindMachO
+
+	// KindBundle is a bundle directory such as an app, framework, or
+	// XPC service. Signing a bundle signs its main executable and seals
+	// its resources, so all nested code must be signed first.
+	KindBundle
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindFile:
+		return "file"
+	case KindMachO:
+		return "macho"
+	case KindBundle:
+		return "bundle"
+	default:
+		return "unknown"
+	}
+}
+
+// Component is a single file or bundle directory that is passed to codesign.
+type Component struct {
+	// Path is the path to the file or bundle directory to sign.
+	Path string
+
+	// Kind is the type of this component.
+	Kind Kind
+
+	// Depth is the nesting depth of this component. Files given directly
+	// to Sign have a depth of zero, code nested directly within them has
+	// a depth of one, and so on.
+	Depth int
+
+	// FileOptions are the options to sign this component with. By default,
+	// only top-level components receive the entitlements, identifier, and
+	// requirements from Options, and nested code is signed without them.
+	// The runtime options of Options apply to all components. Any matching
+	// entry in Options.FileOptions overrides these.
+	FileOptions
+}
+
+// Plan is the ordered list of components that must be signed to sign
+// the files given in Options. Components are sorted deepest-first so that
+// nested code is always signed before the bundle that contains it.
+type Plan struct {
+	Components []*Component
+}
+
+// NewPlan creates the signing plan for the given options. Any entry in
+// Files that is a bundle directory is walked to find all nested Mach-O
+// files and bundles. All other files are signed as-is.
+func NewPlan(opts *Options) (*Plan, error) {
+	var result []*Component
+	for _, f := range opts.Files {
+		root := &Component{
+			Path: f,
+			Kind: KindFile,
+			FileOptions: FileOptions{
+				Entitlements: opts.Entitlements,
+				Identifier:   opts.Identifier,
+				Requirements: opts.Requirements,
+			},
+		}
+
+		if IsBundle(f) {
+			root.Kind = KindBundle
+
+			nested, err := walkBundle(f, 1)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, nested...)
+		}
+
+		result = append(result, root)
+	}
+
+	// The runtime options apply to everything, including nested code
+	for _, c := range result {
+		c.RuntimeOptions = opts.RuntimeOptions
+	}
+
+	// Apply any per-file overrides. Paths are compared cleaned so that
+	// "./foo" and "foo" match. Every override must match a component
+	// since otherwise it is almost certainly a typo in the path.
+	overrides := map[string]*FileOptions{}
+	paths := map[string]string{}
+	for path, fo := range opts.FileOptions {
+		key := filepath.Clean(path)
+		if other, ok := paths[key]; ok {
+			return nil, fmt.Errorf(
+				"file options specified for both %q and %q, which are the same path", other, path)
+		}
+		paths[key] = path
+		overrides[key] = fo
+	}
+	used := map[string]bool{}
+	for _, c := range result {
+		key := filepath.Clean(c.Path)
+		if fo, ok := overrides[key]; ok && fo != nil {
+			c.FileOptions = c.FileOptions.merge(fo)
+			used[key] = true
+		}
+	}
+	for key, path := range paths {
+		if !used[key] {
+			return nil, fmt.Errorf(
+				"file options specified for %q, but that path is not a file "+
+					"to sign or code nested within a bundle to sign", path)
+		}
+	}
+
+	// Sort deepest-first. We use a stable sort so that components at the
+	// same depth retain the order that we found them in.
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Depth > result[j].Depth
+	})
+
+	return &Plan{Components: result}, nil
+}
+
+// Stages returns the components of the plan grouped into stages. The
+// stages must be signed in order, but all the components within a single
+// stage are independent of each other and can be signed in any order.
+func (p *Plan) Stages() [][]*Component {
+	var result [][]*Component
+	for idx, c := range p.Components {
+		if idx == 0 || p.Components[idx-1].Depth != c.Depth {
+			result = append(result, nil)
+		}
+
+		result[len(result)-1] = append(result[len(result)-1], c)
+	}
+
+	return result
+}
+
+// groupComponents groups the components of a single stage by the options
+// they're signed with so that each group can be signed with a single
+// invocation of codesign. The order of the groups is the order in which
+// the first component of each group appears.
+func groupComponents(stage []*Component) [][]*Component {
+	var result [][]*Component
+	index := map[string]int{}
+	for _, c := range stage {
+		key := c.FileOptions.key()
+		idx, ok := index[key]
+		if !ok {
+			idx = len(result)
+			index[key] = idx
+			result = append(result, nil)
+		}
+
+		result[idx] = append(result[idx], c)
+	}
+
+	return result
+}
+
+// bundleExts are the directory extensions that we treat as bundles.
+var bundleExts = map[string]struct{}{
+	".app":             {},
+	".appex":           {},
+	".bundle":          {},
+	".framework":       {},
+	".kext":            {},
+	".mdimporter":      {},
+	".plugin":          {},
+	".qlgenerator":     {},
+	".systemextension": {},
+	".xpc":             {},
+}
+
+// IsBundle returns true if the path is a bundle directory, such as an app,
+// framework, or XPC service.
+func IsBundle(path string) bool {
+	fi, err := os.Lstat(path)
+	if err != nil || !fi.IsDir() {
+		return false
+	}
+
+	_, ok := bundleExts[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// walkBundle walks the bundle at the given path and returns all the
+// nested code within it that must be signed. The bundle itself is not
+// included in the result. depth is the depth of the code directly nested
+// within this bundle.
+func walkBundle(bundle string, depth int) ([]*Component, error) {
+	main := bundleExecutable(bundle)
+
+	var result []*Component
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range fis {
+			path := filepath.Join(dir, fi.Name())
+
+			switch {
+			case fi.Mode()&os.ModeSymlink != 0:
+				// Symlinks are never signed. Frameworks use these to
+				// point to the current version which we sign directly.
+				continue
+
+			case fi.IsDir() && IsBundle(path):
+				nested, err := walkBundle(path, depth+1)
+				if err != nil {
+					return err
+				}
+
+				result = append(result, nested...)
+				result = append(result, &Component{
+					Path:  path,
+					Kind:  KindBundle,
+					Depth: depth,
+				})
+
+			case fi.IsDir():
+				if err := walk(path); err != nil {
+					return err
+				}
+
+			case fi.Mode().IsRegular():
+				if path == main || !fat.IsMachOFile(path) {
+					continue
+				}
+
+				result = append(result, &Component{
+					Path:  path,
+					Kind:  KindMachO,
+					Depth: depth,
+				})
+			}
+		}
+
+		return nil
+	}
+
+	return result, walk(bundle)
+}
+
+// bundleExecutable returns the path to the main executable of a bundle.
+// The main executable is signed as part of signing the bundle itself so
+// it must not be signed separately.
+func bundleExecutable(bundle string) string {
+	name := strings.TrimSuffix(filepath.Base(bundle), filepath.Ext(bundle))
+
+	// Frameworks are versioned and place the executable at the root
+	// of the version directory.
+	if strings.EqualFold(filepath.Ext(bundle), ".framework") {
+		current, err := filepath.EvalSymlinks(filepath.Join(bundle, "Versions", "Current"))
+		if err != nil {
+			// Shallow framework
+			return filepath.Join(bundle, name)
+		}
+
+		return filepath.Join(bundle, "Versions", filepath.Base(current), name)
+	}
+
+	// All other bundles use the standard Contents layout. The name of the
+	// executable is in the Info.plist but defaults to the bundle name.
+	if f, err := os.Open(filepath.Join(bundle, "Contents", "Info.plist")); err == nil {
+		defer f.Close()
+
+		var info struct {
+			Executable string `plist:"CFBundleExecutable"`
+		}
+		if err := plist.NewDecoder(f).Decode(&info); err == nil && info.Executable != "" {
+			name = info.Executable
+		}
+	}
+
+	return filepath.Join(bundle, "Contents", "MacOS", name)
+}
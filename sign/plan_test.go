@@ -0,0 +1,259 @@
+package sign
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPlan_flat(t *testing.T) {
+	plan, err := NewPlan(&Options{
+		Files:        []string{"foo", "bar"},
+		Entitlements: "ent.plist",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []*Component{
+		{Path: "foo", Kind: KindFile, FileOptions: FileOptions{Entitlements: "ent.plist"}},
+		{Path: "bar", Kind: KindFile, FileOptions: FileOptions{Entitlements: "ent.plist"}},
+	}, plan.Components)
+	require.Len(t, plan.Stages(), 1)
+}
+
+func TestNewPlan_fileOptions(t *testing.T) {
+	plan, err := NewPlan(&Options{
+		Files:        []string{"foo", "./bar", "baz"},
+		Entitlements: "ent.plist",
+		FileOptions: map[string]*FileOptions{
+			"bar": {
+				Entitlements:   "jit.plist",
+				Identifier:     "com.example.bar",
+				RuntimeOptions: []string{"library"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, FileOptions{
+		Entitlements:   "jit.plist",
+		Identifier:     "com.example.bar",
+		RuntimeOptions: []string{"library"},
+	}, plan.Components[1].FileOptions)
+	require.Equal(t, "runtime,library", plan.Components[1].runtimeOptions())
+
+	groups := groupComponents(plan.Stages()[0])
+	require.Len(t, groups, 2)
+	require.Len(t, groups[0], 2)
+	require.Len(t, groups[1], 1)
+}
+
+func TestNewPlan_fileOptionsUncleanPath(t *testing.T) {
+	plan, err := NewPlan(&Options{
+		Files: []string{"./foo", "bar"},
+		FileOptions: map[string]*FileOptions{
+			"./foo":  {Identifier: "com.example.foo"},
+			"./bar/": {Identifier: "com.example.bar"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "com.example.foo", plan.Components[0].Identifier)
+	require.Equal(t, "com.example.bar", plan.Components[1].Identifier)
+
+	// The same path given twice is ambiguous
+	_, err = NewPlan(&Options{
+		Files: []string{"foo"},
+		FileOptions: map[string]*FileOptions{
+			"foo":   {Identifier: "com.example.foo"},
+			"./foo": {Identifier: "com.example.other"},
+		},
+	})
+	require.Error(t, err)
+}
+
+func TestNewPlan_fileOptionsUnknown(t *testing.T) {
+	_, err := NewPlan(&Options{
+		Files: []string{"foo"},
+		FileOptions: map[string]*FileOptions{
+			"bar": {Identifier: "com.example.bar"},
+		},
+	})
+	require.Error(t, err)
+}
+
+func TestNewPlan_bundle(t *testing.T) {
+	td := testBundle(t)
+	defer os.RemoveAll(td)
+
+	app := filepath.Join(td, "Foo.app")
+	plan, err := NewPlan(&Options{
+		Files:        []string{app},
+		Entitlements: "ent.plist",
+	})
+	require.NoError(t, err)
+
+	var actual []string
+	for _, c := range plan.Components {
+		rel, err := filepath.Rel(td, c.Path)
+		require.NoError(t, err)
+		actual = append(actual, rel)
+	}
+
+	require.Equal(t, []string{
+		"Foo.app/Contents/Frameworks/Bar.framework/Versions/A/Libraries/libqux.dylib",
+		"Foo.app/Contents/Frameworks/Bar.framework",
+		"Foo.app/Contents/Frameworks/libbaz.dylib",
+		"Foo.app/Contents/MacOS/helper",
+		"Foo.app/Contents/XPCServices/Svc.xpc",
+		"Foo.app",
+	}, actual)
+
+	stages := plan.Stages()
+	require.Len(t, stages, 3)
+	require.Len(t, stages[0], 1)
+	require.Len(t, stages[1], 4)
+	require.Len(t, stages[2], 1)
+
+	// Only the root should get the entitlements
+	for _, c := range plan.Components[:len(plan.Components)-1] {
+		require.Empty(t, c.Entitlements)
+	}
+	root := plan.Components[len(plan.Components)-1]
+	require.Equal(t, "ent.plist", root.Entitlements)
+	require.Equal(t, KindBundle, root.Kind)
+}
+
+func TestNewPlan_bundleFileOptions(t *testing.T) {
+	td := testBundle(t)
+	defer os.RemoveAll(td)
+
+	app := filepath.Join(td, "Foo.app")
+	helper := filepath.Join(app, "Contents", "MacOS", "helper")
+	plan, err := NewPlan(&Options{
+		Files:        []string{app},
+		Entitlements: "ent.plist",
+		FileOptions: map[string]*FileOptions{
+			helper: {Entitlements: "helper.plist"},
+		},
+	})
+	require.NoError(t, err)
+
+	for _, c := range plan.Components {
+		if c.Path == helper {
+			require.Equal(t, "helper.plist", c.Entitlements)
+			return
+		}
+	}
+
+	t.Fatal("helper not found")
+}
+
+func TestSign_bundle(t *testing.T) {
+	td := testBundle(t)
+	defer os.RemoveAll(td)
+
+	record := filepath.Join(td, "record")
+	cmd := childCmd(t, "record")
+	cmd.Env = append(cmd.Env, childRecordEnv+"="+record)
+
+	app := filepath.Join(td, "Foo.app")
+	require.NoError(t, Sign(context.Background(), &Options{
+		Files:        []string{app},
+		Identity:     "bar",
+		Entitlements: "ent.plist",
+		Logger:       hclog.L(),
+		BaseCmd:      cmd,
+	}))
+
+	contents, err := ioutil.ReadFile(record)
+	require.NoError(t, err)
+
+	calls := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	require.Len(t, calls, 3)
+	require.True(t, strings.HasSuffix(calls[0], "libqux.dylib"))
+	require.NotContains(t, calls[1], "--entitlements")
+	require.Contains(t, calls[1], "Bar.framework")
+	require.Contains(t, calls[1], "Svc.xpc")
+	require.Contains(t, calls[2], "--entitlements ent.plist")
+	require.True(t, strings.HasSuffix(calls[2], "Foo.app"))
+}
+
+func TestSign_bundleConcurrency(t *testing.T) {
+	td := testBundle(t)
+	defer os.RemoveAll(td)
+
+	record := filepath.Join(td, "record")
+	cmd := childCmd(t, "record")
+	cmd.Env = append(cmd.Env, childRecordEnv+"="+record)
+
+	app := filepath.Join(td, "Foo.app")
+	require.NoError(t, Sign(context.Background(), &Options{
+		Files:       []string{app},
+		Identity:    "bar",
+		Concurrency: 4,
+		Logger:      hclog.L(),
+		BaseCmd:     cmd,
+	}))
+
+	contents, err := ioutil.ReadFile(record)
+	require.NoError(t, err)
+
+	// Every file is signed separately, inside-out
+	calls := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	require.Len(t, calls, 6)
+	require.True(t, strings.HasSuffix(calls[0], "libqux.dylib"))
+	middle := strings.Join(calls[1:5], "\n")
+	for _, name := range []string{"Bar.framework", "Svc.xpc", "libbaz.dylib", "helper"} {
+		require.Contains(t, middle, name)
+	}
+	require.True(t, strings.HasSuffix(calls[5], "Foo.app"))
+}
+
+// testBundle creates a fixture app bundle in a temporary directory and
+// returns the path to the directory. The caller must remove it.
+func testBundle(t *testing.T) string {
+	t.Helper()
+
+	td, err := ioutil.TempDir("", "gon-sign")
+	require.NoError(t, err)
+
+	macho := []byte{0xcf, 0xfa, 0xed, 0xfe, 0x07, 0x00, 0x00, 0x01}
+	app := filepath.Join(td, "Foo.app", "Contents")
+	framework := filepath.Join(app, "Frameworks", "Bar.framework")
+	files := map[string][]byte{
+		filepath.Join(app, "Info.plist"):                                         testInfoPlist("Foo"),
+		filepath.Join(app, "MacOS", "Foo"):                                       macho,
+		filepath.Join(app, "MacOS", "helper"):                                    macho,
+		filepath.Join(app, "Resources", "readme.txt"):                            []byte("hello"),
+		filepath.Join(app, "Frameworks", "libbaz.dylib"):                         macho,
+		filepath.Join(framework, "Versions", "A", "Bar"):                         macho,
+		filepath.Join(framework, "Versions", "A", "Libraries", "libqux.dylib"):   macho,
+		filepath.Join(app, "XPCServices", "Svc.xpc", "Contents", "Info.plist"):   testInfoPlist("Svc"),
+		filepath.Join(app, "XPCServices", "Svc.xpc", "Contents", "MacOS", "Svc"): macho,
+	}
+	for path, data := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, data, 0755))
+	}
+
+	require.NoError(t, os.Symlink("A", filepath.Join(framework, "Versions", "Current")))
+	require.NoError(t, os.Symlink(
+		filepath.Join("Versions", "Current", "Bar"), filepath.Join(framework, "Bar")))
+
+	return td
+}
+
+func testInfoPlist(executable string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CFBundleExecutable</key>
+	<string>` + executable + `</string>
+</dict>
+</plist>
+`)
+}
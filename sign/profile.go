@@ -0,0 +1,278 @@
+package sign
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
+	"howett.net/plist"
+
+	"github.com/mitchellh/gon/internal/cms"
+)
+
+// entitlementAppID is the entitlement of a provisioning profile with the
+// application identifier that the profile is for.
+const entitlementAppID = "com.apple.application-identifier"
+
+// ProvisioningProfile is a parsed provisioning profile. Provisioning
+// profiles are CMS signed plists that grant restricted entitlements, such
+// as Network Extensions or associated domains, to an app of a single team.
+type ProvisioningProfile struct {
+	// Name is the name of the profile.
+	Name string `plist:"Name"`
+
+	// UUID is the unique identifier of the profile.
+	UUID string `plist:"UUID"`
+
+	// TeamIdentifier are the team IDs that the profile is for.
+	TeamIdentifier []string `plist:"TeamIdentifier"`
+
+	// ExpirationDate is when the profile expires.
+	ExpirationDate time.Time `plist:"ExpirationDate"`
+
+	// Entitlements are the entitlements the profile allows. String values
+	// may end with "*" to allow any value with that prefix.
+	Entitlements Entitlements `plist:"Entitlements"`
+}
+
+// ParseProvisioningProfile parses the contents of a provisioning profile.
+// This decodes the plist payload of the CMS structure but doesn't verify
+// Apple's signature of it.
+func ParseProvisioningProfile(data []byte) (*ProvisioningProfile, error) {
+	sd, err := cms.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(sd.Content) == 0 {
+		return nil, fmt.Errorf("provisioning profile has no content")
+	}
+
+	var result ProvisioningProfile
+	if _, err := plist.Unmarshal(sd.Content, &result); err != nil {
+		return nil, fmt.Errorf("error decoding provisioning profile plist: %s", err)
+	}
+
+	return &result, nil
+}
+
+// ApplicationIdentifier returns the application identifier the profile
+// is for, such as "ABCDE12345.com.example.app". This may end with "*".
+func (p *ProvisioningProfile) ApplicationIdentifier() string {
+	v, _ := p.Entitlements[entitlementAppID].(string)
+	if v == "" {
+		// Older profiles don't use the "com.apple." prefix
+		v, _ = p.Entitlements["application-identifier"].(string)
+	}
+
+	return v
+}
+
+// Check checks that the profile can be used to sign the bundle with the
+// given bundle ID and entitlements with a certificate of the given team.
+// Every restricted entitlement must be allowed by the profile. If teamID
+// is empty, the team isn't checked. All the problems found are
+// returned as a *multierror.Error.
+func (p *ProvisioningProfile) Check(teamID, bundleID string, ents Entitlements) error {
+	var result error
+	if !p.ExpirationDate.IsZero() && p.ExpirationDate.Before(time.Now()) {
+		result = multierror.Append(result, fmt.Errorf(
+			"the provisioning profile expired on %s", p.ExpirationDate.Format("2006-01-02")))
+	}
+
+	if teamID != "" && !containsString(p.TeamIdentifier, teamID) {
+		result = multierror.Append(result, fmt.Errorf(
+			"the provisioning profile is for team %s, but the signing team is %s",
+			strings.Join(p.TeamIdentifier, ", "), teamID))
+	}
+
+	if appID := p.ApplicationIdentifier(); appID != "" {
+		idx := strings.Index(appID, ".")
+		if bundleID == "" || idx < 0 || !matchWildcard(appID[idx+1:], bundleID) {
+			result = multierror.Append(result, fmt.Errorf(
+				"the provisioning profile is for application identifier %q, "+
+					"but the bundle ID is %q", appID, bundleID))
+		}
+	}
+
+	// Only restricted entitlements need to be allowed by the profile.
+	// Hardened runtime and sandbox entitlements are never in profiles.
+	for _, key := range ents.keys() {
+		if !restrictedEntitlement(key) {
+			continue
+		}
+
+		allowed, ok := p.Entitlements[key]
+		if !ok {
+			result = multierror.Append(result, fmt.Errorf(
+				"entitlement %q isn't allowed by the provisioning profile", key))
+			continue
+		}
+
+		if !entitlementAllowed(allowed, ents[key]) {
+			result = multierror.Append(result, fmt.Errorf(
+				"entitlement %q has the value %v, but the provisioning profile "+
+					"only allows %v", key, ents[key], allowed))
+		}
+	}
+
+	return result
+}
+
+// embedProvisioningProfiles embeds the provisioning profile of the options
+// in every top-level bundle of the plan.
+func embedProvisioningProfiles(opts *Options, logger hclog.Logger, plan *Plan) error {
+	data, err := ioutil.ReadFile(opts.ProvisioningProfile)
+	if err != nil {
+		return err
+	}
+	profile, err := ParseProvisioningProfile(data)
+	if err != nil {
+		return fmt.Errorf("error parsing provisioning profile %s: %s",
+			opts.ProvisioningProfile, err)
+	}
+
+	found := false
+	for _, c := range plan.Components {
+		if c.Depth != 0 || c.Kind != KindBundle {
+			continue
+		}
+		found = true
+
+		logger.Info("embedding provisioning profile",
+			"bundle", c.Path,
+			"profile", opts.ProvisioningProfile,
+			"profile_name", profile.Name,
+			"profile_uuid", profile.UUID,
+		)
+		if err := embedProvisioningProfile(opts, profile, data, c); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("a provisioning profile can only be embedded in a " +
+			"bundle, but no bundles are being signed")
+	}
+
+	return nil
+}
+
+// embedProvisioningProfile copies the provisioning profile into the bundle
+// after checking that it matches the bundle.
+func embedProvisioningProfile(opts *Options, profile *ProvisioningProfile, data []byte, c *Component) error {
+	bundleID, err := bundleIdentifier(c.Path)
+	if err != nil {
+		return err
+	}
+
+	var ents Entitlements
+	if c.Entitlements != "" {
+		raw, err := ioutil.ReadFile(c.Entitlements)
+		if err != nil {
+			return fmt.Errorf("error reading entitlements: %s", err)
+		}
+		if _, err := plist.Unmarshal(raw, &ents); err != nil {
+			return fmt.Errorf("error decoding entitlements %s: %s", c.Entitlements, err)
+		}
+	}
+
+	// The team isn't checked if it isn't known, such as when codesign
+	// signs with an identity whose team ID couldn't be resolved.
+	teamID := opts.TeamID
+	if teamID == "" && opts.Certificate != nil {
+		teamID = teamIDForSigner(opts.Certificate)
+	}
+
+	if err := profile.Check(teamID, bundleID, ents); err != nil {
+		return fmt.Errorf("provisioning profile %q doesn't match %s:\n\n%s",
+			profile.Name, c.Path, err)
+	}
+
+	return ioutil.WriteFile(
+		filepath.Join(c.Path, "Contents", "embedded.provisionprofile"), data, 0644)
+}
+
+// bundleIdentifier returns the CFBundleIdentifier of a bundle with the
+// standard Contents layout.
+func bundleIdentifier(bundle string) (string, error) {
+	f, err := os.Open(filepath.Join(bundle, "Contents", "Info.plist"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var info struct {
+		Identifier string `plist:"CFBundleIdentifier"`
+	}
+	if err := plist.NewDecoder(f).Decode(&info); err != nil {
+		return "", fmt.Errorf("error decoding Info.plist of %s: %s", bundle, err)
+	}
+
+	return info.Identifier, nil
+}
+
+// entitlementAllowed returns true if the value of an entitlement is
+// allowed by the value of the same entitlement in a provisioning profile.
+func entitlementAllowed(allowed, v interface{}) bool {
+	switch allowed := allowed.(type) {
+	case bool:
+		// A true value allows the entitlement to be enabled or disabled
+		b, ok := v.(bool)
+		return ok && (allowed || !b)
+
+	case string:
+		s, ok := v.(string)
+		return ok && matchWildcard(allowed, s)
+
+	case []interface{}:
+		values, ok := v.([]interface{})
+		if !ok {
+			values = []interface{}{v}
+		}
+
+		// Every value must be allowed by some value of the profile
+		for _, value := range values {
+			found := false
+			for _, a := range allowed {
+				if entitlementAllowed(a, value) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+
+		return true
+
+	default:
+		return reflect.DeepEqual(allowed, v)
+	}
+}
+
+// matchWildcard returns true if v matches pattern, which may end in "*"
+// to match any suffix.
+func matchWildcard(pattern, v string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(v, strings.TrimSuffix(pattern, "*"))
+	}
+
+	return pattern == v
+}
+
+// containsString returns true if v is in list.
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
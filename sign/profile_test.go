@@ -0,0 +1,185 @@
+package sign
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	"howett.net/plist"
+
+	"github.com/mitchellh/gon/internal/cms"
+	"github.com/mitchellh/gon/internal/machotest"
+)
+
+func TestParseProvisioningProfile(t *testing.T) {
+	profile, err := ParseProvisioningProfile(testProfile(t, time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	require.Equal(t, "Foo Developer ID", profile.Name)
+	require.Equal(t, []string{"TEAMID1234"}, profile.TeamIdentifier)
+	require.Equal(t, "TEAMID1234.com.example.foo", profile.ApplicationIdentifier())
+
+	_, err = ParseProvisioningProfile([]byte("nope"))
+	require.Error(t, err)
+}
+
+func TestProvisioningProfileCheck(t *testing.T) {
+	profile, err := ParseProvisioningProfile(testProfile(t, time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+
+	cases := []struct {
+		Name     string
+		TeamID   string
+		BundleID string
+		Ents     Entitlements
+		Errors   []string
+	}{
+		{
+			"match",
+			"TEAMID1234",
+			"com.example.foo",
+			Entitlements{
+				"com.apple.application-identifier":                "TEAMID1234.com.example.foo",
+				"com.apple.developer.networking.networkextension": []interface{}{"packet-tunnel-provider"},
+				"com.apple.security.cs.allow-jit":                 true,
+			},
+			nil,
+		},
+		{
+			"no team",
+			"",
+			"com.example.foo",
+			nil,
+			nil,
+		},
+		{
+			"wrong team and bundle",
+			"OTHERTEAM1",
+			"com.example.bar",
+			nil,
+			[]string{"signing team is OTHERTEAM1", `bundle ID is "com.example.bar"`},
+		},
+		{
+			"entitlement not allowed",
+			"TEAMID1234",
+			"com.example.foo",
+			Entitlements{"com.apple.developer.associated-domains": []interface{}{"applinks:example.com"}},
+			[]string{`"com.apple.developer.associated-domains" isn't allowed`},
+		},
+		{
+			"entitlement value not allowed",
+			"TEAMID1234",
+			"com.example.foo",
+			Entitlements{"com.apple.developer.networking.networkextension": []interface{}{"dns-proxy"}},
+			[]string{"only allows"},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := profile.Check(tt.TeamID, tt.BundleID, tt.Ents)
+			if len(tt.Errors) == 0 {
+				require.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			for _, e := range tt.Errors {
+				require.Contains(t, err.Error(), e)
+			}
+		})
+	}
+
+	// Expired profiles can't be used
+	profile, err = ParseProvisioningProfile(testProfile(t, time.Now().Add(-time.Hour)))
+	require.NoError(t, err)
+	err = profile.Check("TEAMID1234", "com.example.foo", nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "expired")
+}
+
+func TestSign_provisioningProfile(t *testing.T) {
+	td := testBundle(t)
+	defer os.RemoveAll(td)
+
+	app := filepath.Join(td, "Foo.app")
+	info, err := plist.Marshal(map[string]string{
+		"CFBundleExecutable": "Foo",
+		"CFBundleIdentifier": "com.example.foo",
+	}, plist.XMLFormat)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(app, "Contents", "Info.plist"), info, 0644))
+
+	profile := filepath.Join(td, "foo.provisionprofile")
+	data := testProfile(t, time.Now().Add(time.Hour))
+	require.NoError(t, ioutil.WriteFile(profile, data, 0644))
+
+	// A profile for another team is rejected before anything is signed
+	err = Sign(context.Background(), &Options{
+		Files:               []string{app},
+		Identity:            "bar",
+		ProvisioningProfile: profile,
+		TeamID:              "OTHERTEAM1",
+		Logger:              hclog.L(),
+		BaseCmd:             childCmd(t, "fail"),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "signing team is OTHERTEAM1")
+
+	embedded := filepath.Join(app, "Contents", "embedded.provisionprofile")
+	_, err = os.Stat(embedded)
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, Sign(context.Background(), &Options{
+		Files:               []string{app},
+		Identity:            "bar",
+		ProvisioningProfile: profile,
+		TeamID:              "TEAMID1234",
+		Logger:              hclog.L(),
+		BaseCmd:             childCmd(t, "success"),
+	}))
+
+	contents, err := ioutil.ReadFile(embedded)
+	require.NoError(t, err)
+	require.Equal(t, data, contents)
+
+	// Without a team ID or certificate the team can't be checked, but the
+	// rest of the profile still is.
+	require.NoError(t, os.Remove(embedded))
+	require.NoError(t, Sign(context.Background(), &Options{
+		Files:               []string{app},
+		Identity:            "bar",
+		ProvisioningProfile: profile,
+		Logger:              hclog.L(),
+		BaseCmd:             childCmd(t, "success"),
+	}))
+	_, err = os.Stat(embedded)
+	require.NoError(t, err)
+}
+
+// testProfile returns a provisioning profile for the app
+// "com.example.foo" of the team "TEAMID1234".
+func testProfile(t *testing.T, expiration time.Time) []byte {
+	t.Helper()
+
+	content, err := plist.Marshal(map[string]interface{}{
+		"Name":           "Foo Developer ID",
+		"UUID":           "8B7C6D5E-0000-4000-8000-000000000000",
+		"TeamIdentifier": []string{"TEAMID1234"},
+		"ExpirationDate": expiration,
+		"Entitlements": map[string]interface{}{
+			"com.apple.application-identifier":                "TEAMID1234.com.example.foo",
+			"com.apple.developer.team-identifier":             "TEAMID1234",
+			"com.apple.developer.networking.networkextension": []string{"packet-tunnel-provider*", "app-proxy-provider"},
+		},
+	}, plist.XMLFormat)
+	require.NoError(t, err)
+
+	data, err := cms.Sign(content, machotest.Identity(t), nil)
+	require.NoError(t, err)
+	return data
+}
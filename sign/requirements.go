@@ -0,0 +1,169 @@
+package sign
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+)
+
+// Magic numbers of the requirement blobs.
+const (
+	magicRequirement  = 0xfade0c00
+	magicRequirements = 0xfade0c01
+)
+
+// Requirement expression opcodes and match types. See the Security
+// framework's requirement.h for the full list.
+const (
+	opIdent              = 2
+	opAnchorHash         = 4
+	opAnd                = 6
+	opCertField          = 11
+	opCertGeneric        = 14
+	opAppleGenericAnchor = 15
+
+	matchExists = 0
+	matchEqual  = 1
+
+	// certificate slots for the leaf and the root (-1) of the chain
+	certLeaf = 0
+	certRoot = 0xffffffff
+
+	// requirementDesignated is the requirement type of the designated
+	// requirement within a requirement set.
+	requirementDesignated = 3
+
+	// requirementKindExpr is the kind of a requirement blob holding
+	// an expression.
+	requirementKindExpr = 1
+)
+
+// OIDs of the extensions marking Apple's Developer ID certificates.
+var (
+	oidDeveloperIDLeaf         = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 1, 13}
+	oidDeveloperIDIntermediate = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 6}
+)
+
+// requirements returns the requirement set blob with the designated
+// requirement for code with the given identifier signed by cert. This is
+// the same designated requirement that codesign creates. Ad-hoc signatures
+// (a nil cert) have an empty requirement set.
+func requirements(identifier string, cert *Certificate) []byte {
+	var set []byte
+	set = appendUint32(set, magicRequirements)
+	if cert == nil {
+		set = appendUint32(set, 12)
+		return appendUint32(set, 0)
+	}
+
+	req := designatedRequirement(identifier, cert)
+	set = appendUint32(set, uint32(20+len(req)))
+	set = appendUint32(set, 1)
+	set = appendUint32(set, requirementDesignated)
+	set = appendUint32(set, 20)
+	return append(set, req...)
+}
+
+// designatedRequirement returns the requirement blob for the designated
+// requirement. For Developer ID certificates this is:
+//
+//	identifier "ID" and anchor apple generic and
+//	certificate 1[field.1.2.840.113635.100.6.2.6] exists and
+//	certificate leaf[field.1.2.840.113635.100.6.1.13] exists and
+//	certificate leaf[subject.OU] = "TEAMID"
+//
+// Any other certificate is pinned by the hash of its root certificate:
+//
+//	identifier "ID" and certificate root = H"HASH"
+func designatedRequirement(identifier string, cert *Certificate) []byte {
+	var terms [][]byte
+	terms = append(terms, appendData(appendUint32(nil, opIdent), []byte(identifier)))
+
+	if hasExtension(cert.Certificate, oidDeveloperIDLeaf) {
+		terms = append(terms,
+			appendUint32(nil, opAppleGenericAnchor),
+			certGeneric(1, oidDeveloperIDIntermediate),
+			certGeneric(certLeaf, oidDeveloperIDLeaf),
+		)
+
+		var term []byte
+		term = appendUint32(term, opCertField)
+		term = appendUint32(term, certLeaf)
+		term = appendData(term, []byte("subject.OU"))
+		term = appendUint32(term, matchEqual)
+		term = appendData(term, []byte(teamID(cert.Certificate)))
+		terms = append(terms, term)
+	} else {
+		root := cert.Certificate
+		if len(cert.Chain) > 0 {
+			root = cert.Chain[len(cert.Chain)-1]
+		}
+		hash := sha1.Sum(root.Raw)
+
+		var term []byte
+		term = appendUint32(term, opAnchorHash)
+		term = appendUint32(term, certRoot)
+		term = appendData(term, hash[:])
+		terms = append(terms, term)
+	}
+
+	// "a and b and c" is encoded in prefix form as "and(and(a, b), c)"
+	var expr []byte
+	for i := 1; i < len(terms); i++ {
+		expr = appendUint32(expr, opAnd)
+	}
+	for _, term := range terms {
+		expr = append(expr, term...)
+	}
+
+	var result []byte
+	result = appendUint32(result, magicRequirement)
+	result = appendUint32(result, uint32(12+len(expr)))
+	result = appendUint32(result, requirementKindExpr)
+	return append(result, expr...)
+}
+
+// certGeneric returns the expression that the certificate in the given
+// slot has the extension oid.
+func certGeneric(slot uint32, oid asn1.ObjectIdentifier) []byte {
+	// The data is the DER encoding of the OID without the tag and length
+	der, err := asn1.Marshal(oid)
+	if err != nil {
+		panic(err)
+	}
+
+	var result []byte
+	result = appendUint32(result, opCertGeneric)
+	result = appendUint32(result, slot)
+	result = appendData(result, der[2:])
+	return appendUint32(result, matchExists)
+}
+
+// hasExtension returns true if the certificate has the extension oid.
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// teamID returns the team ID of the certificate, which is the subject's
+// organizational unit.
+func teamID(cert *x509.Certificate) string {
+	if ous := cert.Subject.OrganizationalUnit; len(ous) > 0 {
+		return ous[0]
+	}
+
+	return ""
+}
+
+// appendData appends length-prefixed data padded to a multiple of four
+// bytes as used by requirement expressions.
+func appendData(b, data []byte) []byte {
+	b = appendUint32(b, uint32(len(data)))
+	b = append(b, data...)
+	return append(b, make([]byte, (4-len(data)%4)%4)...)
+}
@@ -7,26 +7,127 @@ import (
 	"fmt"
 	"io"
 	"os/exec"
+	"strings"
+	"sync"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
 )
 
+// AppleTimestampURL is the URL of Apple's timestamp server, which is what
+// codesign uses for secure timestamps.
+const AppleTimestampURL = "http://timestamp.apple.com/ts01"
+
 // Options are the options for Sign.
 type Options struct {
 	// Files are the list of files to sign. This is required. The files
 	// will be signed _in-place_ so you must take care to copy the files
 	// to a new location if you do not want these files modified.
+	//
+	// Files may also be bundle directories such as ".app" bundles. In
+	// this case, all the nested code within the bundle is signed as well.
 	Files []string
 
 	// Identity is the identity to use for the signing operation. This is required.
 	// This value must be a valid value for the `-s` flag for the `codesign`
 	// binary. See the man pages for that for more help since the value can
 	// be in a variety of forms.
+	//
+	// With Native, this is only used to request ad-hoc signing with "-".
 	Identity string
 
-	// Entitlements is an (optional) path to a plist format .entitlements file
+	// Native, if true, signs the files in pure Go instead of executing
+	// codesign, so signing works on any OS. Only Mach-O binaries (thin or
+	// universal) can be signed natively; bundles, disk images, and other
+	// files can't.
+	//
+	// Native signing uses Certificate to sign. If Certificate is nil, then
+	// Identity must be "-" and the files are signed ad-hoc.
+	Native bool
+
+	// Certificate is the certificate and private key for native signing,
+	// usually loaded with LoadPKCS12.
+	Certificate *Certificate
+
+	// Timestamp controls the secure timestamp of the signature. If this is
+	// empty, the timestamp is requested from Apple's timestamp server. If
+	// this is TimestampNone, the signature has no secure timestamp, which is
+	// faster for local development builds but can't be notarized. Any other
+	// value is the URL of the timestamp server to use. This is the
+	// `--timestamp` flag of codesign.
+	//
+	// Native signing honors this the same way, requesting the timestamp
+	// from AppleTimestampURL by default. Notarization requires a secure
+	// timestamp.
+	Timestamp string
+
+	// Keychain is an (optional) path to the keychain to search for Identity,
+	// such as one created by the keychain package. This is the `--keychain`
+	// flag of codesign. If this is empty, the keychain search list is used.
+	Keychain string
+
+	// Entitlements is an (optional) path to a plist format .entitlements file.
+	// For bundles, the entitlements are only applied to the bundle itself
+	// and not to any nested code.
 	Entitlements string
 
+	// Identifier is the (optional) unique identifier to embed in the
+	// signature of each file in Files. Like Entitlements, this isn't applied
+	// to code nested within bundles.
+	Identifier string
+
+	// Prefix is an (optional) prefix for identifiers that codesign derives
+	// from file names, such as "com.example.". It is only used for files
+	// without an explicit identifier whose derived identifier has no dots.
+	// This is the `--prefix` flag of codesign.
+	Prefix string
+
+	// Requirements is the (optional) internal requirements to embed in the
+	// signature of each file in Files. See FileOptions.Requirements. Like
+	// Entitlements, this isn't applied to code nested within bundles.
+	Requirements string
+
+	// RuntimeOptions are additional flags for the `--options` argument of
+	// codesign for every file, including nested code. See
+	// FileOptions.RuntimeOptions.
+	RuntimeOptions []string
+
+	// PreserveMetadata is the list of properties of an existing signature
+	// to keep when re-signing, such as "entitlements" or "requirements".
+	// This is the `--preserve-metadata` flag of codesign. This isn't
+	// supported with Native.
+	PreserveMetadata []string
+
+	// ProvisioningProfile is an (optional) path to a provisioning profile
+	// to embed as "Contents/embedded.provisionprofile" in each bundle in
+	// Files before signing. This is required for restricted entitlements
+	// such as Network Extensions or associated domains. Signing fails if the
+	// profile doesn't match the team, bundle ID, or entitlements of a bundle.
+	ProvisioningProfile string
+
+	// TeamID is the (optional) team ID of Identity, used to check that
+	// ProvisioningProfile is for the same team. With Native, the team ID
+	// of Certificate is used if this is empty. If the team ID isn't known,
+	// the team of the profile isn't checked.
+	TeamID string
+
+	// FileOptions are per-file overrides of the signing options, keyed by
+	// path. The path may be any entry in Files or the path to any code nested
+	// within a bundle in Files. Files are grouped by their options and each
+	// group is signed separately.
+	FileOptions map[string]*FileOptions
+
+	// Concurrency is the maximum number of files to sign at the same time.
+	// If this is greater than one, every file is signed with its own codesign
+	// process and up to this many processes run at once. Files are still
+	// signed inside-out, so only independent files are signed concurrently.
+	// Every file is attempted and the errors are collected as a
+	// *multierror.Error with one error per failing file.
+	//
+	// If this is zero or one, files with the same options are signed
+	// together with a single codesign process.
+	Concurrency int
+
 	// Output is an io.Writer where the output of the command will be written.
 	// If this is nil then the output will only be sent to the log (if set)
 	// or in the error result value if signing failed.
@@ -40,13 +141,243 @@ type Options struct {
 	BaseCmd *exec.Cmd
 }
 
+// TimestampNone is the value for Options.Timestamp to sign without a
+// secure timestamp.
+const TimestampNone = "none"
+
+// preserveMetadata are the valid values for Options.PreserveMetadata.
+var preserveMetadata = map[string]struct{}{
+	"identifier":         {},
+	"entitlements":       {},
+	"requirements":       {},
+	"flags":              {},
+	"runtime":            {},
+	"launch-constraints": {},
+}
+
+// validate checks that the options are valid and consistent with
+// each other.
+func (o *Options) validate() error {
+	switch {
+	case o.Timestamp == "", o.Timestamp == TimestampNone:
+	case strings.HasPrefix(o.Timestamp, "http://"), strings.HasPrefix(o.Timestamp, "https://"):
+	default:
+		return fmt.Errorf(
+			"invalid timestamp %q, must be %q or the URL of a timestamp server",
+			o.Timestamp, TimestampNone)
+	}
+
+	options := append([]string{}, o.RuntimeOptions...)
+	for _, fo := range o.FileOptions {
+		if fo != nil {
+			options = append(options, fo.RuntimeOptions...)
+		}
+	}
+	for _, v := range options {
+		if _, ok := runtimeFlags[v]; !ok {
+			return fmt.Errorf("unknown runtime option %q", v)
+		}
+	}
+
+	for _, v := range o.PreserveMetadata {
+		if _, ok := preserveMetadata[v]; !ok {
+			return fmt.Errorf("unknown metadata to preserve %q", v)
+		}
+	}
+
+	if o.Native && len(o.PreserveMetadata) > 0 {
+		return fmt.Errorf("preserving metadata isn't supported by native signing")
+	}
+
+	if o.Native && o.ProvisioningProfile != "" {
+		return fmt.Errorf("provisioning profiles aren't supported by native signing")
+	}
+
+	return nil
+}
+
+// FileOptions are the signing options that can be set for a single file.
+type FileOptions struct {
+	// Entitlements is the path to a plist format .entitlements file.
+	Entitlements string
+
+	// Identifier is the unique identifier to embed in the signature. If
+	// this is empty, codesign derives one from the file name or Info.plist.
+	Identifier string
+
+	// RuntimeOptions are additional flags for the `--options` argument
+	// of codesign, such as "library". The "runtime" flag which enables the
+	// hardened runtime is always set since notarization requires it.
+	RuntimeOptions []string
+
+	// Requirements is the internal requirements to embed in the signature.
+	// This is any valid value for the `--requirements` flag of codesign: either
+	// a path to a requirements file or a requirement expression prefixed
+	// with "=".
+	Requirements string
+}
+
+// merge returns a copy of these options with any non-empty values
+// from other set.
+func (o FileOptions) merge(other *FileOptions) FileOptions {
+	if other.Entitlements != "" {
+		o.Entitlements = other.Entitlements
+	}
+	if other.Identifier != "" {
+		o.Identifier = other.Identifier
+	}
+	if len(other.RuntimeOptions) > 0 {
+		o.RuntimeOptions = other.RuntimeOptions
+	}
+	if other.Requirements != "" {
+		o.Requirements = other.Requirements
+	}
+
+	return o
+}
+
+// runtimeOptions returns the value for the `--options` flag.
+func (o FileOptions) runtimeOptions() string {
+	result := []string{"runtime"}
+	for _, v := range o.RuntimeOptions {
+		if v != "runtime" {
+			result = append(result, v)
+		}
+	}
+
+	return strings.Join(result, ",")
+}
+
+// key returns a string that is equal for two FileOptions values if they
+// result in the same codesign arguments.
+func (o FileOptions) key() string {
+	return strings.Join([]string{
+		o.Entitlements,
+		o.Identifier,
+		o.runtimeOptions(),
+		o.Requirements,
+	}, "\x00")
+}
+
 // Sign signs one or more files returning an error if any.
+//
+// Any bundle directories (such as ".app" or ".framework") in Files are
+// signed inside-out: all nested code is found and signed deepest-first
+// before the bundle itself is signed. See NewPlan for details.
 func Sign(ctx context.Context, opts *Options) error {
 	logger := opts.Logger
 	if logger == nil {
 		logger = hclog.NewNullLogger()
 	}
 
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	plan, err := NewPlan(opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Native {
+		return signNative(opts, logger, plan)
+	}
+
+	if opts.ProvisioningProfile != "" {
+		if err := embedProvisioningProfiles(opts, logger, plan); err != nil {
+			return err
+		}
+	}
+
+	// Sign each stage in order. Within each stage we sign every group of
+	// components sharing the same options with a single codesign call.
+	if opts.Concurrency <= 1 {
+		for _, stage := range plan.Stages() {
+			for _, group := range groupComponents(stage) {
+				if err := codesign(ctx, opts, logger, group); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	// Sign each file of each stage separately with a pool of workers. We
+	// stop after any stage with errors since the next stages contain the
+	// bundles of the failed files.
+	concurrentOpts := *opts
+	if opts.Output != nil {
+		concurrentOpts.Output = &lockedWriter{w: opts.Output}
+	}
+	for _, stage := range plan.Stages() {
+		err := forEach(stage, opts.Concurrency, func(c *Component) error {
+			if err := codesign(ctx, &concurrentOpts, logger, []*Component{c}); err != nil {
+				return fmt.Errorf("%s: %s", c.Path, err)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forEach calls f for every component with at most n calls running at
+// the same time. All the components are processed even if some fail, and
+// the errors are returned as a *multierror.Error in the order of the
+// components.
+func forEach(components []*Component, n int, f func(*Component) error) error {
+	if n < 1 {
+		n = 1
+	}
+
+	errs := make([]error, len(components))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < n && i < len(components); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range work {
+				errs[idx] = f(components[idx])
+			}
+		}()
+	}
+	for idx := range components {
+		work <- idx
+	}
+	close(work)
+	wg.Wait()
+
+	var result *multierror.Error
+	for _, err := range errs {
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// lockedWriter is an io.Writer that can be written to concurrently.
+type lockedWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	return w.w.Write(p)
+}
+
+// codesign executes codesign for the given group of components. All the
+// components must share the same signing options.
+func codesign(ctx context.Context, opts *Options, logger hclog.Logger, group []*Component) error {
 	// Build our command
 	var cmd exec.Cmd
 	if opts.BaseCmd != nil {
@@ -68,16 +399,42 @@ func Sign(ctx context.Context, opts *Options) error {
 		"-s", opts.Identity,
 		"-f",
 		"-v",
-		"--timestamp",
-		"--options", "runtime",
 	}
 
-	if len(opts.Entitlements) > 0 {
-		cmd.Args = append(cmd.Args, "--entitlements", opts.Entitlements)
+	switch opts.Timestamp {
+	case "":
+		cmd.Args = append(cmd.Args, "--timestamp")
+	default:
+		cmd.Args = append(cmd.Args, "--timestamp="+opts.Timestamp)
+	}
+
+	cmd.Args = append(cmd.Args, "--options", group[0].runtimeOptions())
+
+	if v := opts.Keychain; len(v) > 0 {
+		cmd.Args = append(cmd.Args, "--keychain", v)
+	}
+	if v := opts.Prefix; len(v) > 0 {
+		cmd.Args = append(cmd.Args, "--prefix", v)
+	}
+	if v := opts.PreserveMetadata; len(v) > 0 {
+		cmd.Args = append(cmd.Args, "--preserve-metadata="+strings.Join(v, ","))
+	}
+	if v := group[0].Entitlements; len(v) > 0 {
+		cmd.Args = append(cmd.Args, "--entitlements", v)
+	}
+	if v := group[0].Identifier; len(v) > 0 {
+		cmd.Args = append(cmd.Args, "--identifier", v)
+	}
+	if v := group[0].Requirements; len(v) > 0 {
+		cmd.Args = append(cmd.Args, "--requirements", v)
 	}
 
 	// Append the files that we want to sign
-	cmd.Args = append(cmd.Args, opts.Files...)
+	files := make([]string, len(group))
+	for idx, c := range group {
+		files[idx] = c.Path
+	}
+	cmd.Args = append(cmd.Args, files...)
 
 	// We store all output in out for logging and in case there is an error
 	var out bytes.Buffer
@@ -93,7 +450,7 @@ func Sign(ctx context.Context, opts *Options) error {
 
 	// Log what we're going to execute
 	logger.Info("executing codesigning",
-		"files", opts.Files,
+		"files", files,
 		"command_path", cmd.Path,
 		"command_args", cmd.Args,
 	)
@@ -1,9 +1,12 @@
 package sign
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -13,8 +16,15 @@ const childEnv = "GON_TEST_CHILD"
 // childCommands is the list of commands we support
 var childCommands = map[string]func() int{
 	"success": childSuccess,
+	"record":  childRecord,
+	"fail":    childFail,
+	"copy":    childCopy,
 }
 
+// childRecordEnv is the env var with the path to the file where the
+// "record" child command appends its arguments.
+const childRecordEnv = "GON_TEST_RECORD"
+
 // childCmd is used to create a command that executes a command in the
 // childCommands map in a new process.
 func childCmd(t *testing.T, name string, args ...string) *exec.Cmd {
@@ -40,3 +50,40 @@ func childSuccess() int {
 	println("success")
 	return 0
 }
+
+func childRecord() int {
+	f, err := os.OpenFile(os.Getenv(childRecordEnv), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening record file: %s", err)
+		return 1
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, strings.Join(os.Args[1:], " "))
+	return 0
+}
+
+func childFail() int {
+	fmt.Fprintf(os.Stderr, "%s: failed\n", os.Args[len(os.Args)-1])
+	return 1
+}
+
+// childCopy records its arguments like childRecord and then copies the
+// second to last argument to the last, like productsign.
+func childCopy() int {
+	if code := childRecord(); code != 0 {
+		return code
+	}
+
+	data, err := ioutil.ReadFile(os.Args[len(os.Args)-2])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading input: %s", err)
+		return 1
+	}
+	if err := ioutil.WriteFile(os.Args[len(os.Args)-1], append(data, "signed"...), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing output: %s", err)
+		return 1
+	}
+
+	return 0
+}
@@ -2,10 +2,14 @@ package sign
 
 import (
 	"context"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
 	"github.com/stretchr/testify/require"
 )
 
@@ -31,3 +35,125 @@ func TestSign_success(t *testing.T) {
 		BaseCmd:  childCmd(t, "success"),
 	}))
 }
+
+func TestSign_flags(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon-sign")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	record := filepath.Join(td, "record")
+	cmd := childCmd(t, "record")
+	cmd.Env = append(cmd.Env, childRecordEnv+"="+record)
+
+	require.NoError(t, Sign(context.Background(), &Options{
+		Files:            []string{"foo"},
+		Identity:         "bar",
+		Timestamp:        TimestampNone,
+		Identifier:       "com.example.foo",
+		Prefix:           "com.example.",
+		Requirements:     "=designated => identifier foo",
+		RuntimeOptions:   []string{"library"},
+		PreserveMetadata: []string{"entitlements", "flags"},
+		Logger:           hclog.L(),
+		BaseCmd:          cmd,
+	}))
+
+	contents, err := ioutil.ReadFile(record)
+	require.NoError(t, err)
+	require.Equal(t,
+		"-s bar -f -v --timestamp=none --options runtime,library "+
+			"--prefix com.example. --preserve-metadata=entitlements,flags "+
+			"--identifier com.example.foo --requirements =designated => identifier foo foo",
+		strings.TrimSpace(string(contents)))
+}
+
+func TestSign_invalid(t *testing.T) {
+	cases := []struct {
+		Name  string
+		Opts  Options
+		Error string
+	}{
+		{
+			"timestamp",
+			Options{Timestamp: "apple"},
+			"invalid timestamp",
+		},
+		{
+			"runtime options",
+			Options{RuntimeOptions: []string{"libary"}},
+			`unknown runtime option "libary"`,
+		},
+		{
+			"file runtime options",
+			Options{FileOptions: map[string]*FileOptions{
+				"foo": {RuntimeOptions: []string{"nope"}},
+			}},
+			`unknown runtime option "nope"`,
+		},
+		{
+			"preserve metadata",
+			Options{PreserveMetadata: []string{"everything"}},
+			"unknown metadata",
+		},
+		{
+			"native preserve metadata",
+			Options{Native: true, PreserveMetadata: []string{"entitlements"}},
+			"native signing",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			opts := tt.Opts
+			opts.Files = []string{"foo"}
+			opts.Identity = "-"
+			opts.BaseCmd = childCmd(t, "success")
+
+			err := Sign(context.Background(), &opts)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.Error)
+		})
+	}
+}
+
+func TestSign_concurrencyErrors(t *testing.T) {
+	err := Sign(context.Background(), &Options{
+		Files:       []string{"foo", "bar", "baz"},
+		Identity:    "bar",
+		Concurrency: 2,
+		Logger:      hclog.L(),
+		BaseCmd:     childCmd(t, "fail"),
+	})
+	require.Error(t, err)
+
+	// Every failing file is reported separately, in order
+	merr, ok := err.(*multierror.Error)
+	require.True(t, ok)
+	require.Len(t, merr.Errors, 3)
+	for i, name := range []string{"foo", "bar", "baz"} {
+		require.Contains(t, merr.Errors[i].Error(), name+": ")
+		require.Contains(t, merr.Errors[i].Error(), name+": failed")
+	}
+}
+
+func TestSign_keychain(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon-sign")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	record := filepath.Join(td, "record")
+	cmd := childCmd(t, "record")
+	cmd.Env = append(cmd.Env, childRecordEnv+"="+record)
+
+	require.NoError(t, Sign(context.Background(), &Options{
+		Files:    []string{"foo"},
+		Identity: "bar",
+		Keychain: "/tmp/gon.keychain",
+		Logger:   hclog.L(),
+		BaseCmd:  cmd,
+	}))
+
+	contents, err := ioutil.ReadFile(record)
+	require.NoError(t, err)
+	require.Contains(t, string(contents), "--keychain /tmp/gon.keychain")
+}
@@ -0,0 +1,226 @@
+// Package universal merges per-architecture Mach-O binaries into
+// universal (fat) binaries.
+//
+// This is the same as `lipo -create` but implemented in pure Go so that
+// it works on any OS.
+package universal
+
+import (
+	"bytes"
+	"context"
+	"debug/macho"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/mitchellh/gon/internal/fat"
+)
+
+// Options are the options for Create.
+type Options struct {
+	// Files are the files to merge. Mach-O binaries with the same name
+	// (ignoring an architecture suffix, see Key) are merged into a single
+	// universal binary. All other files are returned unmodified.
+	Files []string
+
+	// OutputDir is the directory where the universal binaries are written.
+	// This is required. The directory is created if it doesn't exist.
+	OutputDir string
+
+	// Logger is the logger to use. If this is nil then no logging will be done.
+	Logger hclog.Logger
+}
+
+// archSuffixes are the architecture suffixes that are removed from file
+// names to find the binaries to merge.
+var archSuffixes = []string{
+	"amd64", "x86_64", "x64", "arm64", "aarch64",
+}
+
+// Key returns the name of the universal binary that the file at path is
+// merged into. This is the file name with any architecture suffix such as
+// "_amd64" or "-arm64" removed. For example, both "dist/foo_darwin_amd64/foo"
+// and "dist/foo_darwin_arm64/foo" have the key "foo", as do "foo-amd64"
+// and "foo-arm64".
+func Key(path string) string {
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for _, suffix := range archSuffixes {
+		for _, sep := range []string{"_", "-"} {
+			if trimmed := strings.TrimSuffix(base, sep+suffix); trimmed != base && trimmed != "" {
+				return trimmed + ext
+			}
+		}
+	}
+
+	return name
+}
+
+// Create merges the Mach-O binaries in Files with the same key into
+// universal binaries in OutputDir. The result is Files with each group of
+// merged binaries replaced by the path of its universal binary, in the
+// position of the first binary of the group.
+//
+// Binaries with no other binary to merge with and files that aren't
+// Mach-O binaries are returned unmodified. It is an error for a group
+// to contain the same architecture twice.
+func Create(ctx context.Context, opts *Options) ([]string, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+
+	if opts.OutputDir == "" {
+		return nil, fmt.Errorf("an output directory is required")
+	}
+
+	// Group the binaries by key, keeping the order of the files
+	var keys []string
+	groups := map[string][]string{}
+	for _, f := range opts.Files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.Mode().IsRegular() || !fat.IsMachOFile(f) {
+			continue
+		}
+
+		key := Key(f)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], f)
+	}
+
+	if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Merge each group with more than one binary
+	replace := map[string]string{}
+	for _, key := range keys {
+		files := groups[key]
+		if len(files) < 2 {
+			continue
+		}
+
+		output := filepath.Join(opts.OutputDir, key)
+		logger.Info("creating universal binary", "files", files, "output", output)
+		if err := merge(files, output); err != nil {
+			return nil, fmt.Errorf("error creating universal binary %s: %s", output, err)
+		}
+
+		for _, f := range files {
+			replace[f] = output
+		}
+	}
+
+	// Build the result, replacing each group with its output once
+	var result []string
+	seen := map[string]struct{}{}
+	for _, f := range opts.Files {
+		output, ok := replace[f]
+		if !ok {
+			result = append(result, f)
+			continue
+		}
+
+		if _, ok := seen[output]; !ok {
+			seen[output] = struct{}{}
+			result = append(result, output)
+		}
+	}
+
+	return result, nil
+}
+
+// merge writes a universal binary with all the architectures of files
+// to output. The files may be thin or universal binaries.
+func merge(files []string, output string) error {
+	var arches []*fat.Arch
+	cpus := map[string]string{}
+	var mode os.FileMode
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		mode |= fi.Mode().Perm()
+
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+
+		fileArches, err := readArches(data)
+		if err != nil {
+			return fmt.Errorf("%s: %s", f, err)
+		}
+		for _, arch := range fileArches {
+			name := cpuName(arch)
+			if other, ok := cpus[name]; ok {
+				return fmt.Errorf(
+					"%s and %s both contain the %s architecture", other, f, name)
+			}
+			cpus[name] = f
+
+			arches = append(arches, arch)
+		}
+	}
+
+	// Sort like lipo does so that the result is deterministic
+	sort.SliceStable(arches, func(i, j int) bool {
+		if arches[i].CPU != arches[j].CPU {
+			return arches[i].CPU < arches[j].CPU
+		}
+
+		return arches[i].SubCPU < arches[j].SubCPU
+	})
+
+	data, err := fat.Marshal(arches)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(output, data, mode)
+}
+
+// readArches returns the architectures of a thin or universal binary.
+func readArches(data []byte) ([]*fat.Arch, error) {
+	if fat.IsFat(data) {
+		return fat.Read(data)
+	}
+
+	f, err := macho.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return []*fat.Arch{{
+		CPU:    f.Cpu,
+		SubCPU: f.SubCpu,
+		Data:   data,
+	}}, nil
+}
+
+// cpuName returns the name of the architecture for error messages.
+func cpuName(arch *fat.Arch) string {
+	switch {
+	case arch.CPU == macho.CpuAmd64:
+		return "x86_64"
+	case arch.CPU == macho.CpuArm64 && arch.SubCPU&0xffffff == 2:
+		return "arm64e"
+	case arch.CPU == macho.CpuArm64:
+		return "arm64"
+	default:
+		return arch.CPU.String()
+	}
+}
@@ -0,0 +1,101 @@
+package universal
+
+import (
+	"context"
+	"debug/macho"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitchellh/gon/internal/fat"
+	"github.com/mitchellh/gon/internal/machotest"
+)
+
+func TestKey(t *testing.T) {
+	cases := map[string]string{
+		"dist/foo_darwin_amd64/foo": "foo",
+		"foo-amd64":                 "foo",
+		"foo_arm64":                 "foo",
+		"foo-x86_64":                "foo",
+		"libfoo-arm64.dylib":        "libfoo.dylib",
+		"arm64":                     "arm64",
+		"foo":                       "foo",
+	}
+
+	for input, expected := range cases {
+		require.Equal(t, expected, Key(input), input)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	amd64 := machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64})
+	arm64 := machotest.Build(t, &machotest.Options{CPU: machotest.CPUARM64})
+	files := map[string][]byte{
+		"foo_darwin_arm64/foo": arm64,
+		"foo_darwin_amd64/foo": amd64,
+		"bar-amd64":            amd64,
+		"README.md":            []byte("hello"),
+	}
+	for name, data := range files {
+		path := filepath.Join(td, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, data, 0755))
+	}
+
+	output := filepath.Join(td, "universal")
+	result, err := Create(context.Background(), &Options{
+		Files: []string{
+			filepath.Join(td, "README.md"),
+			filepath.Join(td, "foo_darwin_arm64/foo"),
+			filepath.Join(td, "bar-amd64"),
+			filepath.Join(td, "foo_darwin_amd64/foo"),
+		},
+		OutputDir: output,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(td, "README.md"),
+		filepath.Join(output, "foo"),
+		filepath.Join(td, "bar-amd64"),
+	}, result)
+
+	data, err := ioutil.ReadFile(filepath.Join(output, "foo"))
+	require.NoError(t, err)
+	arches, err := fat.Read(data)
+	require.NoError(t, err)
+	require.Len(t, arches, 2)
+	require.Equal(t, macho.CpuAmd64, arches[0].CPU)
+	require.Equal(t, amd64, arches[0].Data)
+	require.Equal(t, macho.CpuArm64, arches[1].CPU)
+	require.Equal(t, arm64, arches[1].Data)
+
+	fi, err := os.Stat(filepath.Join(output, "foo"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), fi.Mode())
+}
+
+func TestCreate_duplicateArch(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	amd64 := machotest.Build(t, &machotest.Options{CPU: machotest.CPUAMD64})
+	a := filepath.Join(td, "foo-amd64")
+	b := filepath.Join(td, "foo-x86_64")
+	require.NoError(t, ioutil.WriteFile(a, amd64, 0755))
+	require.NoError(t, ioutil.WriteFile(b, amd64, 0755))
+
+	_, err = Create(context.Background(), &Options{
+		Files:     []string{a, b},
+		OutputDir: filepath.Join(td, "universal"),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "both contain the x86_64 architecture")
+}
@@ -0,0 +1,241 @@
+package verify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Info is the signing information for a file as reported by
+// `codesign -dvvv`. All fields should be checked against their zero value
+// since not all fields are reported for every type of signature.
+type Info struct {
+	// Executable is the path to the signed executable.
+	Executable string
+
+	// Identifier is the signing identifier, such as "com.example.app".
+	Identifier string
+
+	// Format is a description of the file format, such as
+	// "Mach-O thin (x86_64)" or "app bundle with Mach-O universal".
+	Format string
+
+	// CodeDirectoryVersion is the version of the CodeDirectory, such as "20500".
+	CodeDirectoryVersion string
+
+	// Flags is the raw value of the CodeDirectory flags and FlagNames
+	// are the human-friendly names of the set flags, such as "runtime".
+	Flags     uint32
+	FlagNames []string
+
+	// CDHash is the hex-encoded code directory hash.
+	CDHash string
+
+	// Authorities is the certificate chain of the signature, starting
+	// with the signing certificate. This is empty for ad-hoc signatures.
+	Authorities []string
+
+	// TeamID is the team identifier of the signing certificate.
+	TeamID string
+
+	// Timestamp is the secure timestamp of the signature. This is zero
+	// if the signature has no secure timestamp.
+	Timestamp time.Time
+
+	// SignedTime is the (insecure) signing time reported by the signer.
+	// This is only set if there is no secure timestamp.
+	SignedTime time.Time
+
+	// RuntimeVersion is the hardened runtime version, such as "10.15.0".
+	RuntimeVersion string
+
+	// AdHoc is true if this is an ad-hoc signature.
+	AdHoc bool
+}
+
+// Identity returns the name of the signing certificate or an empty
+// string if there is none.
+func (i *Info) Identity() string {
+	if len(i.Authorities) == 0 {
+		return ""
+	}
+
+	return i.Authorities[0]
+}
+
+// Runtime returns true if the hardened runtime is enabled.
+func (i *Info) Runtime() bool {
+	return i.Flags&0x10000 != 0
+}
+
+// flagsRe matches the flags value of the CodeDirectory line, for example
+// "flags=0x10000(runtime)".
+var flagsRe = regexp.MustCompile(`flags=0x([0-9a-fA-F]+)(?:\(([^)]*)\))?`)
+
+// ParseInfo parses the output of `codesign -dvvv`.
+func ParseInfo(output string) (*Info, error) {
+	var result Info
+	var found bool
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		idx := strings.Index(line, "=")
+		if idx == -1 {
+			continue
+		}
+
+		key, value := line[:idx], line[idx+1:]
+		switch key {
+		case "Executable":
+			result.Executable = value
+			found = true
+
+		case "Identifier":
+			result.Identifier = value
+			found = true
+
+		case "Format":
+			result.Format = value
+
+		case "CodeDirectory v":
+			if idx := strings.Index(value, " "); idx >= 0 {
+				result.CodeDirectoryVersion = value[:idx]
+			}
+
+			if m := flagsRe.FindStringSubmatch(value); m != nil {
+				flags, err := strconv.ParseUint(m[1], 16, 32)
+				if err != nil {
+					return nil, fmt.Errorf("error parsing flags %q: %s", m[1], err)
+				}
+				result.Flags = uint32(flags)
+
+				if m[2] != "" && m[2] != "none" {
+					result.FlagNames = strings.Split(m[2], ",")
+				}
+			}
+
+		case "CDHash":
+			result.CDHash = value
+
+		case "Authority":
+			result.Authorities = append(result.Authorities, value)
+
+		case "Signature":
+			result.AdHoc = value == "adhoc"
+
+		case "TeamIdentifier":
+			if value != "not set" {
+				result.TeamID = value
+			}
+
+		case "Timestamp":
+			t, err := parseTime(value)
+			if err != nil {
+				return nil, err
+			}
+			result.Timestamp = t
+
+		case "Signed Time":
+			t, err := parseTime(value)
+			if err != nil {
+				return nil, err
+			}
+			result.SignedTime = t
+
+		case "Runtime Version":
+			result.RuntimeVersion = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no signing information found in codesign output")
+	}
+
+	return &result, nil
+}
+
+// parseTime parses the time format used by codesign, such as
+// "Nov 2, 2019 at 10:20:30 AM".
+func parseTime(v string) (time.Time, error) {
+	// Newer versions of macOS use a narrow no-break space before AM/PM.
+	v = strings.Replace(v, "\u202f", " ", -1)
+
+	t, err := time.ParseInLocation("Jan 2, 2006 at 3:04:05 PM", v, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing time %q: %s", v, err)
+	}
+
+	return t, nil
+}
+
+// info executes `codesign -dvvv` for the given path and parses the result.
+func info(ctx context.Context, opts *Options) (*Info, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+
+	cmd, err := command(opts.BaseCmd, "codesign")
+	if err != nil {
+		return nil, err
+	}
+	cmd.Args = []string{
+		"codesign",
+		"-d",
+		"-vvv",
+		opts.Path,
+	}
+
+	// We store all output in out for logging and in case there is an error.
+	// codesign writes the information to stderr.
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = cmd.Stdout
+
+	// Log what we're going to execute
+	logger.Info("executing codesign to read signing information",
+		"path", opts.Path,
+		"command_path", cmd.Path,
+		"command_args", cmd.Args,
+	)
+
+	// Execute
+	if err := cmd.Run(); err != nil {
+		logger.Error("error reading signing information", "err", err, "output", out.String())
+		return nil, fmt.Errorf("error reading signing information:\n\n%s", out.String())
+	}
+
+	return ParseInfo(out.String())
+}
+
+// command returns the command to execute the given tool based on the
+// given base command. The arguments must still be set by the caller.
+func command(base *exec.Cmd, name string) (*exec.Cmd, error) {
+	var cmd exec.Cmd
+	if base != nil {
+		cmd = *base
+	}
+
+	// We only set the path if it isn't set. This lets the options set the
+	// path to the binary that we use.
+	if cmd.Path == "" {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Path = path
+	}
+
+	return &cmd, nil
+}
@@ -0,0 +1,124 @@
+package verify
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/mitchellh/gon/codesig"
+)
+
+// Offline verifies the code signature of the Mach-O binary given in the
+// options without using any macOS tools, so this works on any platform.
+// Options.BaseCmd is ignored.
+//
+// Only bare binaries (thin or universal) are supported. The code signature
+// of every architecture is verified but the certificate chain isn't
+// checked against the system trust settings, and Gatekeeper and the
+// stapled ticket checks are always skipped since they require macOS.
+func Offline(opts *Options) (*Result, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+
+	fi, err := os.Stat(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("%s: only Mach-O binaries can be verified offline", opts.Path)
+	}
+
+	logger.Info("reading code signature", "path", opts.Path)
+	f, err := codesig.Open(opts.Path)
+	if err == codesig.ErrNotMachO {
+		return nil, fmt.Errorf("%s: only Mach-O binaries can be verified offline", opts.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Path: opts.Path, CDHashes: map[string]string{}}
+	for _, arch := range f.Arches {
+		check := &Check{Name: fmt.Sprintf("signature (%s)", arch.CPU)}
+		if err := arch.Verify(); err != nil {
+			check.Output = err.Error()
+		} else {
+			check.Passed = true
+		}
+
+		logger.Info("verification check complete",
+			"check", check.Name,
+			"passed", check.Passed,
+			"output", check.Output,
+		)
+		result.Checks = append(result.Checks, check)
+
+		sig := arch.Signature
+		if sig == nil {
+			continue
+		}
+
+		result.CDHashes[arch.CPU] = sig.CDHash()
+		if result.Info == nil {
+			result.Info = offlineInfo(opts.Path, f, sig)
+		}
+	}
+
+	result.Checks = append(result.Checks, &Check{
+		Name:    "gatekeeper",
+		Skipped: true,
+		Output:  "Gatekeeper can't be assessed offline",
+	}, &Check{
+		Name:    "stapler",
+		Skipped: true,
+		Output:  "notarization tickets can't be stapled to bare binaries",
+	})
+
+	return result, nil
+}
+
+// offlineInfo converts a code signature to the Info that codesign would
+// report for it.
+func offlineInfo(path string, f *codesig.File, sig *codesig.Signature) *Info {
+	cd := sig.CodeDirectory
+	result := &Info{
+		Executable:           path,
+		Identifier:           sig.Identifier(),
+		CodeDirectoryVersion: strconv.FormatUint(uint64(cd.Version), 16),
+		Flags:                cd.Flags,
+		FlagNames:            cd.FlagNames(),
+		CDHash:               hex.EncodeToString(cd.CDHash()),
+		TeamID:               sig.TeamID(),
+		Timestamp:            sig.Timestamp,
+		RuntimeVersion:       cd.RuntimeVersionString(),
+		AdHoc:                sig.AdHoc(),
+	}
+
+	if sig.Timestamp.IsZero() {
+		result.SignedTime = sig.SigningTime
+	}
+
+	for _, c := range sig.Certificates {
+		result.Authorities = append(result.Authorities, c.Subject.CommonName)
+	}
+
+	if f.Universal {
+		result.Format = "Mach-O universal ("
+		for i, arch := range f.Arches {
+			if i > 0 {
+				result.Format += " "
+			}
+			result.Format += arch.CPU
+		}
+		result.Format += ")"
+	} else {
+		result.Format = fmt.Sprintf("Mach-O thin (%s)", f.Arches[0].CPU)
+	}
+
+	return result
+}
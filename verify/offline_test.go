@@ -0,0 +1,51 @@
+package verify
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffline_unsigned(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	// A minimal x86_64 executable with no load commands
+	header := make([]byte, 32)
+	binary.LittleEndian.PutUint32(header[0:], 0xfeedfacf)
+	binary.LittleEndian.PutUint32(header[4:], 0x01000007)
+	binary.LittleEndian.PutUint32(header[12:], 2)
+	path := filepath.Join(td, "foo")
+	require.NoError(t, ioutil.WriteFile(path, header, 0755))
+
+	result, err := Offline(&Options{Path: path})
+	require.NoError(t, err)
+	require.False(t, result.Passed())
+	require.Nil(t, result.Info)
+	require.Empty(t, result.CDHashes)
+	require.Len(t, result.Checks, 3)
+	require.Equal(t, "signature (x86_64)", result.Checks[0].Name)
+	require.Equal(t, "code is not signed", result.Checks[0].Output)
+	require.True(t, result.Checks[1].Skipped)
+	require.True(t, result.Checks[2].Skipped)
+}
+
+func TestOffline_unsupported(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "foo.sh")
+	require.NoError(t, ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0755))
+
+	_, err = Offline(&Options{Path: path})
+	require.Error(t, err)
+
+	_, err = Offline(&Options{Path: td})
+	require.Error(t, err)
+}
@@ -0,0 +1,209 @@
+// Package verify verifies that files are properly signed, accepted by
+// Gatekeeper, and have a valid notarization ticket stapled.
+//
+// This works by subprocessing to `codesign`, `spctl`, `pkgutil`, and
+// `stapler`, so this only works on macOS.
+package verify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Options are the options for Verify.
+type Options struct {
+	// Path is the path to the file to verify. This may be a signed binary,
+	// an app bundle, a dmg, or a pkg.
+	Path string
+
+	// Logger is the logger to use. If this is nil then no logging will be done.
+	Logger hclog.Logger
+
+	// BaseCmd is the base command for executing the verification tools. This
+	// is used for tests to overwrite where the binaries are. The first
+	// argument is always set to the name of the tool being executed
+	// ("codesign", "spctl", "pkgutil", or "xcrun").
+	BaseCmd *exec.Cmd
+}
+
+// Result is the result of verifying a single file.
+type Result struct {
+	// Path is the path to the file that was verified.
+	Path string
+
+	// Checks are the checks that were performed, in order.
+	Checks []*Check
+
+	// Info is the signing information of the file. This is nil for
+	// pkg files since they aren't signed with codesign.
+	Info *Info
+
+	// CDHashes are the code directory hashes of each architecture of a
+	// Mach-O binary, keyed by the CPU name such as "x86_64". This is only
+	// set by Offline.
+	CDHashes map[string]string
+}
+
+// Passed returns true if all the checks that weren't skipped passed.
+func (r *Result) Passed() bool {
+	for _, c := range r.Checks {
+		if !c.Skipped && !c.Passed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Check is the result of a single verification check.
+type Check struct {
+	// Name is the name of the check, such as "codesign" or "gatekeeper".
+	Name string
+
+	// Passed is true if the check passed.
+	Passed bool
+
+	// Skipped is true if the check doesn't apply to this type of file.
+	// Output contains the reason the check was skipped.
+	Skipped bool
+
+	// Output is the output of the command that performed the check.
+	Output string
+}
+
+// fileType is the type of file being verified.
+type fileType int
+
+const (
+	typeBinary fileType = iota
+	typeApp
+	typeDmg
+	typePkg
+)
+
+// Verify verifies the file given in the options. An error is only returned
+// if the verification could not be performed. Failed checks are reported
+// in the Result and should be checked with Result.Passed.
+func Verify(ctx context.Context, opts *Options) (*Result, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+
+	fi, err := os.Stat(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ft fileType
+	switch ext := strings.ToLower(filepath.Ext(opts.Path)); {
+	case fi.IsDir() && ext == ".app":
+		ft = typeApp
+	case fi.IsDir():
+		return nil, fmt.Errorf("%s: directories other than .app bundles can't be verified", opts.Path)
+	case ext == ".dmg":
+		ft = typeDmg
+	case ext == ".pkg":
+		ft = typePkg
+	case ext == ".zip":
+		return nil, fmt.Errorf("%s: zip files must be extracted to verify their contents", opts.Path)
+	default:
+		ft = typeBinary
+	}
+
+	result := &Result{Path: opts.Path}
+
+	// Verify the signature itself
+	if ft == typePkg {
+		result.Checks = append(result.Checks, run(ctx, opts, logger, "signature",
+			"pkgutil", "--check-signature", opts.Path))
+	} else {
+		result.Checks = append(result.Checks, run(ctx, opts, logger, "codesign",
+			"codesign", "--verify", "--strict", "--deep", "--verbose=2", opts.Path))
+
+		result.Info, err = info(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Gatekeeper assessment. Gatekeeper only assesses apps for execution
+	// so bare binaries are always rejected as "not an app".
+	switch ft {
+	case typeApp:
+		result.Checks = append(result.Checks, run(ctx, opts, logger, "gatekeeper",
+			"spctl", "--assess", "--type", "execute", "-vv", opts.Path))
+	case typeDmg:
+		result.Checks = append(result.Checks, run(ctx, opts, logger, "gatekeeper",
+			"spctl", "--assess", "--type", "open",
+			"--context", "context:primary-signature", "-vv", opts.Path))
+	case typePkg:
+		result.Checks = append(result.Checks, run(ctx, opts, logger, "gatekeeper",
+			"spctl", "--assess", "--type", "install", "-vv", opts.Path))
+	default:
+		result.Checks = append(result.Checks, &Check{
+			Name:    "gatekeeper",
+			Skipped: true,
+			Output:  "Gatekeeper only assesses apps, disk images, and installers",
+		})
+	}
+
+	// Stapled ticket
+	if ft == typeBinary {
+		result.Checks = append(result.Checks, &Check{
+			Name:    "stapler",
+			Skipped: true,
+			Output:  "notarization tickets can't be stapled to bare binaries",
+		})
+	} else {
+		result.Checks = append(result.Checks, run(ctx, opts, logger, "stapler",
+			"xcrun", "stapler", "validate", opts.Path))
+	}
+
+	return result, nil
+}
+
+// run executes a single check with the given command name and arguments.
+// The check passes if the command exits successfully.
+func run(ctx context.Context, opts *Options, logger hclog.Logger, check string, args ...string) *Check {
+	result := &Check{Name: check}
+
+	cmd, err := command(opts.BaseCmd, args[0])
+	if err != nil {
+		result.Output = err.Error()
+		return result
+	}
+	cmd.Args = args
+
+	// We store all output in out for logging and for the result
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = cmd.Stdout
+
+	// Log what we're going to execute
+	logger.Info("executing verification check",
+		"check", check,
+		"path", opts.Path,
+		"command_path", cmd.Path,
+		"command_args", cmd.Args,
+	)
+
+	err = cmd.Run()
+	result.Passed = err == nil
+	result.Output = strings.TrimSpace(out.String())
+
+	logger.Info("verification check complete",
+		"check", check,
+		"passed", result.Passed,
+		"output", result.Output,
+	)
+
+	return result
+}
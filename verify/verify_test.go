@@ -0,0 +1,177 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	// Set our default logger
+	logger := hclog.L()
+	logger.SetLevel(hclog.Trace)
+	hclog.SetDefault(logger)
+
+	// If we got a subcommand, run that
+	if v := os.Getenv(childEnv); v != "" && childCommands[v] != nil {
+		os.Exit(childCommands[v]())
+	}
+
+	os.Exit(m.Run())
+}
+
+// childEnv is the env var that must be set to trigger a child command.
+const childEnv = "GON_TEST_CHILD"
+
+// childCommands is the list of commands we support
+var childCommands = map[string]func() int{
+	"success":         testCmdSuccess,
+	"gatekeeper-fail": testCmdGatekeeperFail,
+}
+
+// childCmd is used to create a command that executes a command in the
+// childCommands map in a new process.
+func childCmd(t *testing.T, name string, args ...string) *exec.Cmd {
+	t.Helper()
+
+	// Get the path to our executable
+	selfPath, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		t.Fatalf("error creating child command: %s", err)
+		return nil
+	}
+
+	cmd := exec.Command(selfPath, args...)
+	cmd.Env = os.Environ()
+	cmd.Env = append(cmd.Env, childEnv+"="+name)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd
+}
+
+func TestParseInfo(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "info_developer_id.txt"))
+	require.NoError(t, err)
+
+	info, err := ParseInfo(string(data))
+	require.NoError(t, err)
+	require.Equal(t, "gon", info.Identifier)
+	require.Equal(t, "20500", info.CodeDirectoryVersion)
+	require.Equal(t, uint32(0x10000), info.Flags)
+	require.Equal(t, []string{"runtime"}, info.FlagNames)
+	require.True(t, info.Runtime())
+	require.Equal(t, "Developer ID Application: Mitchell Hashimoto (GK79KXBF4F)", info.Identity())
+	require.Len(t, info.Authorities, 3)
+	require.Equal(t, "GK79KXBF4F", info.TeamID)
+	require.Equal(t, "10.15.0", info.RuntimeVersion)
+	require.Equal(t, time.Date(2019, 11, 2, 10, 20, 30, 0, time.Local), info.Timestamp)
+	require.False(t, info.AdHoc)
+}
+
+func TestParseInfo_adhoc(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "info_adhoc.txt"))
+	require.NoError(t, err)
+
+	info, err := ParseInfo(string(data))
+	require.NoError(t, err)
+	require.True(t, info.AdHoc)
+	require.False(t, info.Runtime())
+	require.Equal(t, []string{"adhoc", "linker-signed"}, info.FlagNames)
+	require.Empty(t, info.TeamID)
+	require.Empty(t, info.Identity())
+	require.True(t, info.Timestamp.IsZero())
+}
+
+func TestParseInfo_empty(t *testing.T) {
+	_, err := ParseInfo("foo: code object is not signed at all\n")
+	require.Error(t, err)
+}
+
+func TestVerify_binary(t *testing.T) {
+	f, err := ioutil.TempFile("", "gon-verify")
+	require.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	result, err := Verify(context.Background(), &Options{
+		Path:    f.Name(),
+		Logger:  hclog.L(),
+		BaseCmd: childCmd(t, "success"),
+	})
+	require.NoError(t, err)
+	require.True(t, result.Passed())
+	require.Len(t, result.Checks, 3)
+	require.False(t, result.Checks[0].Skipped)
+	require.True(t, result.Checks[1].Skipped)
+	require.True(t, result.Checks[2].Skipped)
+	require.Equal(t, "GK79KXBF4F", result.Info.TeamID)
+}
+
+func TestVerify_dmgRejected(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon-verify")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "foo.dmg")
+	require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+
+	result, err := Verify(context.Background(), &Options{
+		Path:    path,
+		Logger:  hclog.L(),
+		BaseCmd: childCmd(t, "gatekeeper-fail"),
+	})
+	require.NoError(t, err)
+	require.False(t, result.Passed())
+	require.Equal(t, "gatekeeper", result.Checks[1].Name)
+	require.False(t, result.Checks[1].Passed)
+	require.Equal(t, "foo.dmg: rejected", result.Checks[1].Output)
+	require.True(t, result.Checks[2].Passed)
+}
+
+func TestVerify_zip(t *testing.T) {
+	td, err := ioutil.TempDir("", "gon-verify")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "foo.zip")
+	require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+
+	_, err = Verify(context.Background(), &Options{
+		Path:    path,
+		BaseCmd: childCmd(t, "success"),
+	})
+	require.Error(t, err)
+}
+
+// testCmdSuccess mimicks all tools succeeding.
+func testCmdSuccess() int {
+	if os.Args[0] == "codesign" && os.Args[1] == "-d" {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "info_developer_id.txt"))
+		if err != nil {
+			panic(err)
+		}
+
+		os.Stderr.Write(data)
+	}
+
+	return 0
+}
+
+// testCmdGatekeeperFail mimicks spctl rejecting the file.
+func testCmdGatekeeperFail() int {
+	if os.Args[0] == "spctl" {
+		fmt.Fprintf(os.Stderr, "%s: rejected\n", filepath.Base(os.Args[len(os.Args)-1]))
+		return 3
+	}
+
+	return testCmdSuccess()
+}